@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+const (
+	bucketWeek  string = "week"
+	bucketMonth string = "month"
+
+	// activityBarWidth is the width of the longest bar of the histogram.
+	activityBarWidth = 40
+)
+
+// activityBucket is the number of masked emails whose last message arrived
+// within the period starting at Start.
+type activityBucket struct {
+	Label string    `json:"label"`
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+// activityStats is a histogram of lastMessageAt across masked emails.
+type activityStats struct {
+	Bucket  string           `json:"bucket"`
+	Buckets []activityBucket `json:"buckets"`
+	Never   int              `json:"never"`
+}
+
+// bucketStart truncates t to the start of its week (Monday) or month in UTC.
+func bucketStart(t time.Time, unit string) time.Time {
+	t = t.UTC()
+	if unit == bucketWeek {
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset)
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func bucketNext(start time.Time, unit string) time.Time {
+	if unit == bucketWeek {
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+func bucketLabel(start time.Time, unit string) string {
+	if unit == bucketWeek {
+		year, week := start.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+	return start.Format("2006-01")
+}
+
+// computeActivity buckets the masked emails by lastMessageAt. Buckets cover
+// the whole range from the oldest to the newest activity, including empty
+// ones, so gaps are visible. Deleted masked emails are left out.
+func computeActivity(emails []*pkg.MaskedEmail, unit string) *activityStats {
+	a := &activityStats{Bucket: unit, Buckets: []activityBucket{}}
+
+	counts := map[time.Time]int{}
+	var first, last time.Time
+	for _, email := range emails {
+		if email.State == pkg.MaskedEmailStateDeleted {
+			continue
+		}
+
+		if email.LastMessageAt == nil {
+			a.Never++
+			continue
+		}
+
+		start := bucketStart(*email.LastMessageAt, unit)
+		counts[start]++
+		if first.IsZero() || start.Before(first) {
+			first = start
+		}
+		if start.After(last) {
+			last = start
+		}
+	}
+
+	if first.IsZero() {
+		return a
+	}
+
+	for start := first; !start.After(last); start = bucketNext(start, unit) {
+		a.Buckets = append(a.Buckets, activityBucket{
+			Label: bucketLabel(start, unit),
+			Start: start,
+			Count: counts[start],
+		})
+	}
+
+	return a
+}
+
+func printActivity(out io.Writer, a *activityStats) {
+	max := 0
+	for _, b := range a.Buckets {
+		if b.Count > max {
+			max = b.Count
+		}
+	}
+
+	w := newTabWriter(out, 1)
+	fmt.Fprintf(w, "Last Email (%s)\tMasked Emails\t\n", a.Bucket)
+	for _, b := range a.Buckets {
+		bar := 0
+		if max > 0 {
+			bar = (b.Count*activityBarWidth + max - 1) / max
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", b.Label, b.Count, strings.Repeat("#", bar))
+	}
+	fmt.Fprintf(w, "never\t%d\t\n", a.Never)
+	w.Flush()
+}
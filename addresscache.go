@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+const (
+	// addressCacheDir is below the state directory, so state bundles (which
+	// only take the files directly in it) leave the cache out.
+	addressCacheDir string = "completion"
+
+	// addressCacheTTL is how long completion uses the cached addresses
+	// before fetching them again.
+	addressCacheTTL = 10 * time.Minute
+
+	// addressCacheTimeout keeps a slow or unreachable server from blocking
+	// the shell, the stale cache is used instead.
+	addressCacheTimeout = 5 * time.Second
+)
+
+// addressCompletion maps the commands that take a masked email to the flag
+// holding it, "" for a positional argument.
+var addressCompletion = map[string]string{
+	actionTypeEnable:  "",
+	actionTypeDisable: "",
+	actionTypeDelete:  "",
+	actionTypeDestroy: "",
+	actionTypeShow:    "",
+	actionTypeUpdate:  flagNameEmail,
+	actionTypeRename:  "",
+}
+
+// runCompleteAddresses prints the addresses the given command can act on, one
+// per line, for the completion scripts. It's hidden from the usage and never
+// fails loudly: the scripts discard stderr and fall back to no candidates.
+func runCompleteAddresses(client *pkg.Client, args []string) {
+	if len(args) != 1 {
+		os.Exit(1)
+	}
+	if _, ok := addressCompletion[args[0]]; !ok {
+		os.Exit(1)
+	}
+
+	emails, err := cachedAddresses(client)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error getting masked emails: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, email := range emails {
+		if completesAddress(args[0], email.State) {
+			fmt.Println(email.Email)
+		}
+	}
+}
+
+// completesAddress reports whether an address in the given state is a useful
+// argument for command: enable offers what isn't enabled yet, disable what
+// still receives mail. Deleted addresses are left out, like in list.
+func completesAddress(command string, state pkg.MaskedEmailState) bool {
+	switch command {
+	case actionTypeEnable:
+		return state == pkg.MaskedEmailStateDisabled || state == pkg.MaskedEmailStatePending
+	case actionTypeDisable:
+		return state == pkg.MaskedEmailStateEnabled || state == pkg.MaskedEmailStatePending
+	}
+	return state != pkg.MaskedEmailStateDeleted
+}
+
+func addressCachePath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+
+	name := keyringAccount(*flagProfile)
+	if *flagAccountID != "" {
+		name += "-" + *flagAccountID
+	}
+	return filepath.Join(dir, addressCacheDir, name), nil
+}
+
+// cachedAddresses returns the addresses and states of the account from the
+// cache, fetching them when the cache is older than addressCacheTTL. If
+// fetching fails, a stale cache is still better than nothing.
+func cachedAddresses(client *pkg.Client) ([]*pkg.MaskedEmail, error) {
+	path, err := addressCachePath()
+	if err != nil {
+		return nil, err
+	}
+
+	cached, cacheErr := readAddressCache(path)
+	if info, err := os.Stat(path); cacheErr == nil && err == nil && time.Since(info.ModTime()) < addressCacheTTL {
+		return cached, nil
+	}
+
+	client.SetHTTPClient(&http.Client{Timeout: addressCacheTimeout})
+	session, err := client.Session()
+	if err == nil {
+		var emails []*pkg.MaskedEmail
+		emails, err = client.GetAllMaskedEmails(session, *flagAccountID)
+		if err == nil {
+			if err := writeAddressCache(path, emails); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: could not write completion cache: %v\n", err)
+			}
+			return emails, nil
+		}
+	}
+
+	if cacheErr == nil {
+		return cached, nil
+	}
+	return nil, err
+}
+
+// readAddressCache reads "<address>\t<state>" lines.
+func readAddressCache(path string) ([]*pkg.MaskedEmail, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var emails []*pkg.MaskedEmail
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		address, state, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		emails = append(emails, &pkg.MaskedEmail{Email: address, State: pkg.MaskedEmailState(state)})
+	}
+	return emails, scanner.Err()
+}
+
+func writeAddressCache(path string, emails []*pkg.MaskedEmail) error {
+	var b bytes.Buffer
+	sorted := append([]*pkg.MaskedEmail{}, emails...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Email < sorted[j].Email })
+	for _, email := range sorted {
+		fmt.Fprintf(&b, "%s\t%s\n", email.Email, email.State)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b.Bytes(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+const (
+	authSubcommandSetToken string = "set-token"
+	authSubcommandClear    string = "clear"
+	authSubcommandLogin    string = "login"
+)
+
+// runAuth handles `auth set-token` and `auth clear`, which keep the token of
+// a profile in the system keyring instead of the config file. `auth login`
+// is the same as `login`.
+func runAuth(args []string) {
+	usage := fmt.Sprintf("Usage: %s <%s|%s|%s>", actionTypeAuth, authSubcommandSetToken, authSubcommandClear, authSubcommandLogin)
+	if len(args) > 0 && args[0] == authSubcommandLogin {
+		runLogin(args[1:])
+		return
+	}
+	if len(args) != 1 {
+		log.Fatalln(usage)
+	}
+
+	switch args[0] {
+	case authSubcommandSetToken:
+		setKeyringToken(*flagProfile)
+	case authSubcommandClear:
+		clearKeyringToken(*flagProfile)
+	default:
+		log.Fatalln(usage)
+	}
+}
+
+// setKeyringToken reads a token from stdin, hidden if it's a terminal,
+// verifies it and stores it in the keyring. The profile is changed to fetch
+// it from there and a token in the config file is removed.
+func setKeyringToken(profile string) {
+	token, err := readSecret("Token: ")
+	if err != nil {
+		log.Fatalf("error reading token: %v", err)
+	}
+	if token == "" {
+		log.Fatalln("no token entered")
+	}
+
+	if _, err := verifyToken(token, *flagAppname); err != nil {
+		log.Fatalln(err)
+	}
+
+	account := storeKeyringToken(profile, token)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("error reading config: %v", err)
+	}
+	p := profileConfig{}
+	if existing, err := cfg.profile(profile); err == nil {
+		p = *existing
+	}
+	hadToken := p.Token != ""
+	p.Token = ""
+	p.TokenFrom = &secretSource{Provider: secretProviderKeyring, Account: account}
+	cfg.setProfile(profile, p)
+	if err := cfg.save(); err != nil {
+		log.Fatalf("error writing config: %v", err)
+	}
+
+	fmt.Printf("stored the token of profile %s in the keyring\n", account)
+	if hadToken {
+		fmt.Println("removed the token from the config file")
+	}
+}
+
+// clearKeyringToken removes the token of the profile from the keyring and
+// the profile's reference to it.
+func clearKeyringToken(profile string) {
+	account := keyringAccount(profile)
+	found := deleteKeyringToken(profile)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("error reading config: %v", err)
+	}
+	if p, err := cfg.profile(profile); err == nil && p.TokenFrom != nil && p.TokenFrom.Provider == secretProviderKeyring {
+		p.TokenFrom = nil
+		if err := cfg.save(); err != nil {
+			log.Fatalf("error writing config: %v", err)
+		}
+	}
+
+	if found {
+		fmt.Printf("removed the token of profile %s from the keyring\n", account)
+	} else {
+		fmt.Printf("no token of profile %s in the keyring\n", account)
+	}
+}
+
+// storeKeyringToken stores the token of the profile in the keyring and
+// returns the keyring account it's stored under.
+func storeKeyringToken(profile string, token string) string {
+	k, err := systemKeyring()
+	if err != nil {
+		log.Fatalf("error opening keyring: %v", err)
+	}
+	account := keyringAccount(profile)
+	if err := k.set(account, token); err != nil {
+		log.Fatalf("error storing token in the keyring: %v", err)
+	}
+	return account
+}
+
+// deleteKeyringToken removes the token of the profile from the keyring and
+// reports whether there was one.
+func deleteKeyringToken(profile string) bool {
+	k, err := systemKeyring()
+	if err != nil {
+		log.Fatalf("error opening keyring: %v", err)
+	}
+	err = k.delete(keyringAccount(profile))
+	if err != nil && !errors.Is(err, errKeyringNotFound) {
+		log.Fatalf("error removing token from the keyring: %v", err)
+	}
+	return err == nil
+}
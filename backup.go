@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// backupVersion is the version of the backup format written by export. It's
+// raised on changes readers have to know about, adding fields isn't one.
+const backupVersion = 1
+
+// flags for export command
+var exportCmd = flag.NewFlagSet(actionTypeExport, flag.ExitOnError)
+var flagExportFile = exportCmd.String(flagNameFile, "", "write the backup to this file instead of stdout")
+
+// accountBackup is the backup format of export. The JSON field names are
+// part of the format, don't rename them.
+type accountBackup struct {
+	Version      int                `json:"version"`
+	ExportedAt   time.Time          `json:"exportedAt"`
+	ExportedBy   string             `json:"exportedBy"`
+	AccountID    string             `json:"accountId"`
+	AccountName  string             `json:"accountName"`
+	State        string             `json:"state"`
+	Count        int                `json:"count"`
+	MaskedEmails []*pkg.MaskedEmail `json:"maskedEmails"`
+}
+
+// runExport writes all masked emails of the account, in all states and with
+// all fields, as a versioned JSON backup.
+func runExport(client *pkg.Client, args []string) {
+	parseCommandFlags(exportCmd, args)
+	if exportCmd.NArg() > 0 {
+		log.Fatalf("Usage: %s [-%s <path>]", actionTypeExport, flagNameFile)
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	emails, state, err := client.GetAllMaskedEmailsAndState(session, *flagAccountID)
+	if err != nil {
+		log.Fatalf("error fetching masked emails: %v", err)
+	}
+	// oldest first, so backups of the same account diff well
+	sort.SliceStable(emails, func(i, j int) bool {
+		if !emails[i].CreatedAt.Equal(emails[j].CreatedAt) {
+			return emails[i].CreatedAt.Before(emails[j].CreatedAt)
+		}
+		return emails[i].ID < emails[j].ID
+	})
+
+	accID := accountIDOrDefault(session)
+	backup := accountBackup{
+		Version:      backupVersion,
+		ExportedAt:   time.Now().UTC().Truncate(time.Second),
+		ExportedBy:   defaultAppname + " " + buildVersion,
+		AccountID:    accID,
+		AccountName:  session.Accounts[accID].Name,
+		State:        state,
+		Count:        len(emails),
+		MaskedEmails: emails,
+	}
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		log.Fatalf("error encoding backup: %v", err)
+	}
+	data = append(data, '\n')
+
+	if *flagExportFile == "" {
+		if _, err := os.Stdout.Write(data); err != nil {
+			log.Fatalf("error writing backup: %v", err)
+		}
+		return
+	}
+
+	// an export failing halfway must not replace the previous backup
+	tmp := *flagExportFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		log.Fatalf("error writing backup: %v", err)
+	}
+	if err := os.Rename(tmp, *flagExportFile); err != nil {
+		os.Remove(tmp)
+		log.Fatalf("error writing backup: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d masked email(s) of %s to %s\n", len(emails), accID, *flagExportFile)
+}
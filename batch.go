@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// itemResult is the outcome of a single item of a bulk command (bulk
+// enable/disable/delete, import, apply, ...). Every item gets a record, so
+// partial failures are always reported.
+type itemResult struct {
+	Address string `json:"address"`
+	Action  string `json:"action"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+	// AccountID is set if the item was found in another account than the
+	// default one (-account-all).
+	AccountID string `json:"accountId,omitempty"`
+	// Domain and Description are those of the masked email before the
+	// change, set by disable, delete and destroy so the result tells what stops
+	// receiving mail.
+	Domain      string `json:"forDomain,omitempty"`
+	Description string `json:"description,omitempty"`
+	// MaskedEmail is the masked email after the change, only set for JSON
+	// output.
+	MaskedEmail *pkg.MaskedEmail `json:"maskedEmail,omitempty"`
+}
+
+func newItemResult(address string, action string, err error) itemResult {
+	r := itemResult{Address: address, Action: action, OK: err == nil}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// pastTense maps result actions to the wording used in human output,
+// e.g. "disabled masked email: x@example.com".
+var pastTense = map[string]string{
+	actionTypeCreate:  "created",
+	actionTypeEnable:  "enabled",
+	actionTypeDisable: "disabled",
+	actionTypeDelete:  "deleted",
+	actionTypeDestroy: "destroyed",
+	actionTypeUpdate:  "updated",
+	importActionSkip:  "skipped",
+}
+
+func (r itemResult) String() string {
+	if !r.OK {
+		return fmt.Sprintf("failed to %s masked email %s: %s", r.Action, r.Address, r.Error)
+	}
+
+	done, ok := pastTense[r.Action]
+	if !ok {
+		done = r.Action + "ed"
+	}
+	s := fmt.Sprintf("%s masked email: %s", done, r.Address)
+	if r.AccountID != "" {
+		s += fmt.Sprintf(" (account %s)", r.AccountID)
+	}
+	// the context is new, keep it out of the original format
+	if compatMode(compatV1) {
+		return s
+	}
+	if r.Domain != "" {
+		s += " for " + r.Domain
+	}
+	if r.Description != "" {
+		s += " (" + r.Description + ")"
+	}
+	return s
+}
+
+// withContext returns the result with the domain and description of the
+// masked email as it was before a disable, delete or destroy.
+func (r itemResult) withContext(email *pkg.MaskedEmail) itemResult {
+	if r.Action == actionTypeDisable || r.Action == actionTypeDelete || r.Action == actionTypeDestroy {
+		r.Domain = strings.TrimSpace(email.Domain)
+		r.Description = strings.TrimSpace(email.Description)
+	}
+	return r
+}
+
+// printResults writes all results, either as a JSON array or one line per
+// item with failures going to stderr. It returns the number of failed items.
+func printResults(w io.Writer, results []itemResult, asJSON bool) int {
+	failed := 0
+	for _, r := range results {
+		if !r.OK {
+			failed++
+		}
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+		return failed
+	}
+
+	for _, r := range results {
+		if r.OK {
+			fmt.Fprintln(w, r)
+		} else {
+			fmt.Fprintln(os.Stderr, r)
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d items failed\n", failed, len(results))
+	}
+
+	return failed
+}
+
+// exitOnFailedResults prints the results and exits non-zero if any item
+// failed, so partial failures are never silent.
+func exitOnFailedResults(results []itemResult, asJSON bool) {
+	if printResults(os.Stdout, results, asJSON) > 0 {
+		os.Exit(1)
+	}
+}
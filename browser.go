@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const envBrowserVarName string = "BROWSER"
+
+// openBrowser opens url in the user's browser.
+//
+// $BROWSER is honored first; like xdg-utils it may hold a list of commands
+// separated like $PATH (";" on Windows, where ":" is part of drive letters,
+// ":" elsewhere), each of which may contain a "%s" placeholder for the URL.
+// Without $BROWSER the platform opener is used, unless the session looks
+// headless (SSH without a display), in which case nothing is started.
+//
+// If printOnly is set or no browser could be started, the URL is printed to
+// stderr instead so the user can open it manually.
+func openBrowser(url string, printOnly bool) {
+	if !printOnly {
+		if startBrowser(url) {
+			return
+		}
+		fmt.Fprintln(os.Stderr, "could not open a browser, open this URL manually:")
+	}
+
+	fmt.Fprintln(os.Stderr, url)
+}
+
+// startBrowser tries $BROWSER or the platform opener like openBrowser, but
+// leaves it to the caller to tell the user. It reports whether a browser was
+// started.
+func startBrowser(url string) bool {
+	if browsers := os.Getenv(envBrowserVarName); browsers != "" {
+		for _, browser := range strings.Split(browsers, string(os.PathListSeparator)) {
+			if runBrowser(browser, url) == nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	return !isHeadless() && runBrowser(platformOpener(), url) == nil
+}
+
+// runBrowser runs a single browser command, substituting "%s" with the URL
+// or appending it as the last argument.
+func runBrowser(command string, url string) error {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return fmt.Errorf("empty browser command")
+	}
+
+	substituted := false
+	for i, f := range fields {
+		if strings.Contains(f, "%s") {
+			fields[i] = strings.ReplaceAll(f, "%s", url)
+			substituted = true
+		}
+	}
+	if !substituted {
+		fields = append(fields, url)
+	}
+
+	// attach to the terminal so text-mode browsers (lynx, w3m) work too
+	cmd := exec.Command(fields[0], fields[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func platformOpener() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "open"
+	case "windows":
+		return "rundll32 url.dll,FileProtocolHandler"
+	default:
+		return "xdg-open"
+	}
+}
+
+// isHeadless reports whether there is likely no graphical session to open a
+// browser in, e.g. when connected over SSH or on a Linux box without X11 or
+// Wayland.
+func isHeadless() bool {
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		return os.Getenv("SSH_CONNECTION") != "" || os.Getenv("SSH_TTY") != ""
+	}
+
+	return os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == ""
+}
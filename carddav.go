@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// addressBookQuery asks a CardDAV server for the vCards of all contacts in a collection (RFC 6352 section 8.6).
+const addressBookQuery = `<?xml version="1.0" encoding="utf-8"?>
+<C:addressbook-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:carddav">
+  <D:prop><C:address-data/></D:prop>
+</C:addressbook-query>
+`
+
+// cardDAVBook is an address book collection on a CardDAV server, like
+// Fastmail's own at carddav.fastmail.com.
+type cardDAVBook struct {
+	collection *url.URL
+	username   string
+	password   string
+	httpClient *http.Client
+
+	// hrefs are the resources of the contacts read, by UID
+	hrefs map[string]string
+}
+
+func newCardDAVBook(cfg addressBookConfig, password string) (addressBook, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid address book url %q", cfg.URL)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	return &cardDAVBook{
+		collection: u,
+		username:   cfg.Username,
+		password:   password,
+		httpClient: &http.Client{Timeout: *flagTimeout},
+		hrefs:      map[string]string{},
+	}, nil
+}
+
+// davMultistatus is the part of a WebDAV multistatus response used here.
+type davMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				AddressData string `xml:"urn:ietf:params:xml:ns:carddav address-data"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+func (b *cardDAVBook) do(method string, target *url.URL, body []byte, header http.Header) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if b.username != "" || b.password != "" {
+		req.SetBasicAuth(b.username, b.password)
+	}
+
+	res, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("%s %s: %s", method, target.Path, res.Status)
+	}
+	return res, data, nil
+}
+
+func (b *cardDAVBook) contacts() (map[string]contact, error) {
+	header := http.Header{"Depth": {"1"}, "Content-Type": {"application/xml; charset=utf-8"}}
+	_, data, err := b.do("REPORT", b.collection, []byte(addressBookQuery), header)
+	if err != nil {
+		return nil, err
+	}
+
+	var ms davMultistatus
+	if err := xml.Unmarshal(data, &ms); err != nil {
+		return nil, fmt.Errorf("invalid multistatus response: %w", err)
+	}
+
+	contacts := map[string]contact{}
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") || ps.Prop.AddressData == "" {
+				continue
+			}
+			c := parseVCard(ps.Prop.AddressData)
+			if !strings.HasPrefix(c.UID, contactUIDPrefix) {
+				continue
+			}
+			contacts[c.UID] = c
+			b.hrefs[c.UID] = r.Href
+		}
+	}
+	return contacts, nil
+}
+
+func (b *cardDAVBook) put(c contact) error {
+	target := b.collection.ResolveReference(&url.URL{Path: c.UID + ".vcf"})
+	if href, ok := b.hrefs[c.UID]; ok {
+		target = b.collection.ResolveReference(&url.URL{Path: href})
+	}
+
+	header := http.Header{"Content-Type": {"text/vcard; charset=utf-8"}}
+	_, _, err := b.do(http.MethodPut, target, []byte(formatVCard(c)), header)
+	return err
+}
+
+func (b *cardDAVBook) remove(uid string) error {
+	href, ok := b.hrefs[uid]
+	if !ok {
+		href = path.Join(b.collection.Path, uid+".vcf")
+	}
+	_, _, err := b.do(http.MethodDelete, b.collection.ResolveReference(&url.URL{Path: href}), nil, nil)
+	return err
+}
+
+// formatVCard writes the contact as a vCard 3.0, which all CardDAV servers
+// accept.
+func formatVCard(c contact) string {
+	var sb strings.Builder
+	sb.WriteString("BEGIN:VCARD\r\nVERSION:3.0\r\n")
+	fmt.Fprintf(&sb, "UID:%s\r\n", escapeVCard(c.UID))
+	fmt.Fprintf(&sb, "FN:%s\r\n", escapeVCard(c.Name))
+	fmt.Fprintf(&sb, "N:;%s;;;\r\n", escapeVCard(c.Name))
+	fmt.Fprintf(&sb, "EMAIL;TYPE=INTERNET:%s\r\n", escapeVCard(c.Email))
+	if c.Note != "" {
+		fmt.Fprintf(&sb, "NOTE:%s\r\n", escapeVCard(c.Note))
+	}
+	fmt.Fprintf(&sb, "CATEGORIES:%s\r\n", escapeVCard(contactCategory))
+	sb.WriteString("END:VCARD\r\n")
+	return sb.String()
+}
+
+var vCardEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`)
+
+func escapeVCard(s string) string {
+	return vCardEscaper.Replace(s)
+}
+
+var vCardUnescaper = strings.NewReplacer(`\\`, `\`, `\,`, ",", `\;`, ";", `\n`, "\n", `\N`, "\n")
+
+// parseVCard reads the properties of a contact from a vCard, unfolding
+// continued lines. Only the first EMAIL is kept.
+func parseVCard(card string) contact {
+	card = strings.ReplaceAll(card, "\r\n", "\n")
+	card = strings.ReplaceAll(strings.ReplaceAll(card, "\n ", ""), "\n\t", "")
+
+	var c contact
+	for _, line := range strings.Split(card, "\n") {
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		name := strings.ToUpper(strings.SplitN(line[:i], ";", 2)[0])
+		// drop a group like in "item1.EMAIL"
+		name = name[strings.LastIndex(name, ".")+1:]
+		value := vCardUnescaper.Replace(line[i+1:])
+
+		switch name {
+		case "UID":
+			c.UID = value
+		case "FN":
+			c.Name = value
+		case "EMAIL":
+			if c.Email == "" {
+				c.Email = value
+			}
+		case "NOTE":
+			c.Note = value
+		}
+	}
+	return c
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+const (
+	// stateCheckpointDir holds, per profile and account, the server states
+	// list has seen, so -changed-since can ask for the changes since one.
+	stateCheckpointDir string = "list-states"
+
+	// maxStateCheckpoints bounds the checkpoint file, one a day covers a
+	// window of months.
+	maxStateCheckpoints = 90
+)
+
+// stateCheckpoint is the state string of the server at a point in time.
+type stateCheckpoint struct {
+	Time  time.Time `json:"time"`
+	State string    `json:"state"`
+}
+
+// parseWindow parses the window of -changed-since, a number with one of the
+// units of -where durations (7d, 2w) or a Go duration (36h, 1h30m).
+func parseWindow(s string) (time.Duration, error) {
+	if i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' }); i > 0 {
+		if unit, ok := whereDurationUnits[s[i:]]; ok {
+			n, err := strconv.Atoi(s[:i])
+			if err == nil {
+				return time.Duration(n) * unit, nil
+			}
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%q is not a positive duration like 7d, 2w or 36h", s)
+	}
+	return d, nil
+}
+
+func stateCheckpointPath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+
+	name := keyringAccount(*flagProfile)
+	if *flagAccountID != "" {
+		name += "-" + *flagAccountID
+	}
+	return filepath.Join(dir, stateCheckpointDir, name+".json"), nil
+}
+
+func readStateCheckpoints() ([]stateCheckpoint, error) {
+	path, err := stateCheckpointPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var checkpoints []stateCheckpoint
+	if err := json.Unmarshal(data, &checkpoints); err != nil {
+		return nil, err
+	}
+	return checkpoints, nil
+}
+
+// recordStateCheckpoint remembers the state list just fetched. A state
+// already recorded keeps its older time, since nothing changed since then.
+// The checkpoints only save work, so failures are ignored.
+func recordStateCheckpoint(state string, now time.Time) {
+	if state == "" {
+		return
+	}
+
+	checkpoints, err := readStateCheckpoints()
+	if err != nil {
+		return
+	}
+	if n := len(checkpoints); n > 0 && checkpoints[n-1].State == state {
+		return
+	}
+
+	checkpoints = append(checkpoints, stateCheckpoint{Time: now.UTC(), State: state})
+	if len(checkpoints) > maxStateCheckpoints {
+		checkpoints = checkpoints[len(checkpoints)-maxStateCheckpoints:]
+	}
+
+	path, err := stateCheckpointPath()
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(checkpoints)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+// changedSinceFilter returns whether a masked email was created, received
+// mail or changed since cutoff. Created and active come from the timestamps.
+// Other changes, like a disable or a new description, are only known with a
+// checkpoint from before the cutoff: the server is asked for the changes
+// since its state, which may include some from shortly before the cutoff.
+func changedSinceFilter(client *pkg.Client, session pkg.Session, cutoff time.Time) func(email *pkg.MaskedEmail) bool {
+	changed := changedIDsSince(client, session, cutoff)
+
+	return func(email *pkg.MaskedEmail) bool {
+		if !email.CreatedAt.Before(cutoff) {
+			return true
+		}
+		if email.LastMessageAt != nil && !email.LastMessageAt.Before(cutoff) {
+			return true
+		}
+		return changed[email.ID]
+	}
+}
+
+// changedIDsSince returns the IDs of the masked emails created or updated
+// since the newest checkpoint before cutoff, or nil if there is none or the
+// server can't tell the changes.
+func changedIDsSince(client *pkg.Client, session pkg.Session, cutoff time.Time) map[string]bool {
+	checkpoints, err := readStateCheckpoints()
+	if err != nil {
+		return nil
+	}
+
+	since := ""
+	for _, cp := range checkpoints {
+		if cp.Time.After(cutoff) {
+			break
+		}
+		since = cp.State
+	}
+	if since == "" {
+		return nil
+	}
+
+	changed := map[string]bool{}
+	for {
+		res, err := client.MaskedEmailChanges(session, *flagAccountID, since)
+		var methodErr *pkg.MethodError
+		if errors.As(err, &methodErr) && (methodErr.Type == "unknownMethod" || methodErr.Type == "cannotCalculateChanges") {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: asking for changes: %v, only using the timestamps\n", err)
+			return nil
+		}
+
+		for _, id := range append(res.Created, res.Updated...) {
+			changed[id] = true
+		}
+		since = res.NewState
+		if !res.HasMoreChanges {
+			return changed
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const envClipboardVarName string = "MASKEDEMAIL_CLIPBOARD"
+
+// copyToClipboard puts text on the system clipboard and tells so on stderr.
+// Failing to copy only warns, the command itself succeeded.
+//
+// $MASKEDEMAIL_CLIPBOARD may name a command that gets the text on stdin.
+// Otherwise pbcopy (macOS), clip (Windows), or wl-copy, xclip or xsel
+// (Linux/BSD, whichever is installed for the session) are used. Over SSH
+// without a display the terminal is asked to set its clipboard (OSC 52).
+func copyToClipboard(text string) {
+	if err := writeClipboard(text); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not copy to the clipboard: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "copied %s to the clipboard\n", text)
+}
+
+func writeClipboard(text string) error {
+	if command := os.Getenv(envClipboardVarName); command != "" {
+		args, err := splitArgs(command)
+		if err != nil {
+			return fmt.Errorf("%s: %v", envClipboardVarName, err)
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("%s is empty", envClipboardVarName)
+		}
+		return runClipboard(args, text)
+	}
+
+	candidates := clipboardCommands()
+	for _, args := range candidates {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+		return runClipboard(args, text)
+	}
+
+	if isHeadless() && isTerminal(os.Stderr) {
+		// most terminal emulators pass this on to the local clipboard
+		fmt.Fprintf(os.Stderr, "\x1b]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(text)))
+		return nil
+	}
+
+	if len(candidates) == 0 {
+		return errors.New("no clipboard available")
+	}
+	names := make([]string, len(candidates))
+	for i, args := range candidates {
+		names[i] = args[0]
+	}
+	return fmt.Errorf("none of %s found", strings.Join(names, ", "))
+}
+
+// clipboardCommands returns the clipboard commands to try on this platform.
+func clipboardCommands() [][]string {
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{{"pbcopy"}}
+	case "windows":
+		return [][]string{{"clip"}}
+	}
+
+	var commands [][]string
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		commands = append(commands, []string{"wl-copy"})
+	}
+	if os.Getenv("DISPLAY") != "" {
+		commands = append(commands,
+			[]string{"xclip", "-selection", "clipboard"},
+			[]string{"xsel", "--clipboard", "--input"})
+	}
+	return commands
+}
+
+func runClipboard(args []string, text string) error {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
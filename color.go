@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+const (
+	colorAuto   string = "auto"
+	colorAlways string = "always"
+	colorNever  string = "never"
+)
+
+// The escape sequences all have the same length, so every cell of a table
+// grows by the same number of bytes and tabwriter still aligns the columns.
+const (
+	ansiBold   = "\x1b[01m"
+	ansiPlain  = "\x1b[39m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiRed    = "\x1b[31m"
+	ansiGrey   = "\x1b[90m"
+	ansiReset  = "\x1b[0m"
+)
+
+// stateColors are the colors of the state column.
+var stateColors = map[pkg.MaskedEmailState]string{
+	pkg.MaskedEmailStateEnabled:  ansiGreen,
+	pkg.MaskedEmailStatePending:  ansiYellow,
+	pkg.MaskedEmailStateDisabled: ansiRed,
+	pkg.MaskedEmailStateDeleted:  ansiGrey,
+}
+
+// validateColor exits if -color is unknown.
+func validateColor() {
+	switch *flagColor {
+	case colorAuto, colorAlways, colorNever:
+	default:
+		log.Fatalf("unsupported -%s %q (%s|%s|%s)", flagNameColor, *flagColor, colorAuto, colorAlways, colorNever)
+	}
+}
+
+// useColor reports whether text output is colored: always or never as told
+// by -color, and for auto when stdout is a terminal, NO_COLOR isn't set and
+// the output isn't kept in the original format by -compat 1.
+func useColor() bool {
+	switch *flagColor {
+	case colorAlways:
+		return true
+	case colorNever:
+		return false
+	}
+
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" || compatMode(compatV1) {
+		return false
+	}
+	return isTerminal(os.Stdout)
+}
+
+// paintedRows colors the tab separated rows written to it before passing
+// them on to a tabwriter: the header bold and the state column by state.
+// Every other cell gets the plain color, see the escape sequences.
+type paintedRows struct {
+	w io.Writer
+	// header is set while the next row is the header
+	header bool
+	// stateColumn is the index of the state column, -1 for none
+	stateColumn int
+	buf         bytes.Buffer
+}
+
+// newTableWriter returns w, or w coloring the rows if color is enabled.
+func newTableWriter(w io.Writer, header bool, stateColumn int) io.Writer {
+	if !useColor() {
+		return w
+	}
+	return &paintedRows{w: w, header: header, stateColumn: stateColumn}
+}
+
+func (p *paintedRows) Write(b []byte) (int, error) {
+	p.buf.Write(b)
+	for {
+		i := bytes.IndexByte(p.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		line := string(p.buf.Next(i + 1))
+		if _, err := io.WriteString(p.w, p.paint(strings.TrimSuffix(line, "\n"))+"\n"); err != nil {
+			return len(b), err
+		}
+	}
+}
+
+func (p *paintedRows) paint(line string) string {
+	cells := strings.Split(line, "\t")
+	for i, cell := range cells {
+		color := ansiPlain
+		if p.header {
+			color = ansiBold
+		} else if c, ok := stateColors[pkg.MaskedEmailState(cell)]; ok && i == p.stateColumn {
+			color = c
+		}
+		cells[i] = color + cell + ansiReset
+	}
+	p.header = false
+	return strings.Join(cells, "\t")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// listColumn is a field list -columns can show.
+type listColumn struct {
+	// header is the title of the column in the text table, CSV uses the
+	// column name
+	header string
+	// value renders the field, machine is set for CSV, which always has
+	// RFC3339 timestamps
+	value func(email *pkg.MaskedEmail, now time.Time, machine bool) string
+}
+
+// listColumns are the columns by name, named like the API properties.
+var listColumns = map[string]listColumn{
+	"email": {"Masked Email", func(e *pkg.MaskedEmail, _ time.Time, _ bool) string {
+		return e.Email
+	}},
+	"forDomain": {"For Domain", func(e *pkg.MaskedEmail, _ time.Time, _ bool) string {
+		return strings.TrimSpace(e.Domain)
+	}},
+	"description": {"Description", func(e *pkg.MaskedEmail, _ time.Time, _ bool) string {
+		return strings.TrimSpace(e.Description)
+	}},
+	"state": {"State", func(e *pkg.MaskedEmail, _ time.Time, _ bool) string {
+		return string(e.State)
+	}},
+	"id": {"ID", func(e *pkg.MaskedEmail, _ time.Time, _ bool) string {
+		return e.ID
+	}},
+	"url": {"URL", func(e *pkg.MaskedEmail, _ time.Time, _ bool) string {
+		if e.URL == nil {
+			return ""
+		}
+		return strings.TrimSpace(*e.URL)
+	}},
+	"createdBy": {"Created By", func(e *pkg.MaskedEmail, _ time.Time, _ bool) string {
+		return e.CreatedBy
+	}},
+	"createdAt": {"Created At", func(e *pkg.MaskedEmail, _ time.Time, machine bool) string {
+		if machine {
+			return formatTimestamp(&e.CreatedAt)
+		}
+		return formatDisplayTime(&e.CreatedAt)
+	}},
+	"lastMessageAt": {"Last Email At", func(e *pkg.MaskedEmail, _ time.Time, machine bool) string {
+		if machine {
+			return formatTimestamp(e.LastMessageAt)
+		}
+		return formatDisplayTime(e.LastMessageAt)
+	}},
+	"daysSinceLastEmail": {"Days Since Last Email", func(e *pkg.MaskedEmail, now time.Time, _ bool) string {
+		return formatDays(daysSince(e.LastMessageAt, now))
+	}},
+	"ageDays": {"Age (days)", func(e *pkg.MaskedEmail, now time.Time, _ bool) string {
+		return formatDays(daysSince(&e.CreatedAt, now))
+	}},
+}
+
+// columnAliases are shorter names accepted by -columns.
+var columnAliases = map[string]string{
+	"domain": "forDomain",
+	"desc":   "description",
+}
+
+// parseColumns parses a comma separated list of column names, matched
+// ignoring case.
+func parseColumns(list string) ([]string, error) {
+	names := []string{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if alias, ok := columnAliases[strings.ToLower(name)]; ok {
+			name = alias
+		}
+		found := ""
+		for known := range listColumns {
+			if strings.EqualFold(known, name) {
+				found = known
+			}
+		}
+		if found == "" {
+			return nil, fmt.Errorf("unknown column %q (%s)", name, strings.Join(columnNames(), ", "))
+		}
+		names = append(names, found)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no columns")
+	}
+	return names, nil
+}
+
+// columnNames returns the names of all columns, sorted.
+func columnNames() []string {
+	names := make([]string, 0, len(listColumns))
+	for name := range listColumns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeColumns writes the given columns of the masked emails as an aligned
+// table, with a header line if header is set. Empty values are shown as "-",
+// so the columns stay apart.
+func writeColumns(out io.Writer, emails []*pkg.MaskedEmail, columns []string, header bool, now time.Time) error {
+	tw := newTabWriter(out, 1)
+	stateColumn := -1
+	for i, name := range columns {
+		if name == "state" {
+			stateColumn = i
+		}
+	}
+	w := newTableWriter(tw, header, stateColumn)
+
+	cells := make([]string, len(columns))
+	if header {
+		for i, name := range columns {
+			cells[i] = listColumns[name].header
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+
+	for _, email := range emails {
+		for i, name := range columns {
+			cells[i] = orDash(listColumns[name].value(email, now, false))
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+
+	return tw.Flush()
+}
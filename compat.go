@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log"
+)
+
+const (
+	envCompatVarName string = "MASKEDEMAIL_COMPAT"
+
+	// compatLatest means no compatibility mode, output may gain colors,
+	// footers, columns etc. over time.
+	compatLatest int = 0
+	// compatV1 freezes the text output as it was when compat levels were
+	// introduced: plain tab-aligned tables, no colors, no extra columns.
+	compatV1 int = 1
+)
+
+// validateCompat exits if the requested compat level is unknown.
+func validateCompat(level int) {
+	if level != compatLatest && level != compatV1 {
+		log.Fatalf("unsupported -%s level %d (supported: %d, %d)", flagNameCompat, level, compatLatest, compatV1)
+	}
+}
+
+// compatMode reports whether text output has to stay exactly as it was at
+// the given compat level. Every change to existing text output must be
+// skipped when this returns true for the levels it would break.
+func compatMode(level int) bool {
+	return *flagCompat == level
+}
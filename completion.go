@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	shellBash string = "bash"
+	shellZsh  string = "zsh"
+	shellFish string = "fish"
+	// shellPowerShell covers Windows PowerShell and PowerShell 7 (pwsh)
+	shellPowerShell string = "powershell"
+
+	completionSubcommandInstall string = "install"
+)
+
+// completionCommand describes a subcommand for the generated completion
+// scripts. flags may be nil for commands without any flags of their own,
+// words are the fixed arguments of the command, e.g. its subcommands.
+type completionCommand struct {
+	name  string
+	desc  string
+	flags *flag.FlagSet
+	words []string
+}
+
+var completionCommands = []completionCommand{
+	{actionTypeCreate, "create a new masked email", createCmd, nil},
+	{actionTypeList, "list masked emails", listCmd, nil},
+	{actionTypeSearch, "find masked emails by domain, description, state or text", searchCmd, nil},
+	{actionTypeEnable, "enable a masked email", enableCmd, nil},
+	{actionTypeDisable, "disable a masked email", disableCmd, nil},
+	{actionTypeDelete, "delete a masked email", deleteCmd, nil},
+	{actionTypeDestroy, "permanently destroy a masked email", destroyCmd, nil},
+	{actionTypeShow, "show all fields of a masked email", showCmd, nil},
+	{actionTypeExists, "exit 0 if an active masked email for the address or domain exists", nil, nil},
+	{actionTypeFindForDomain, "print the best existing masked email for a domain", nil, nil},
+	{actionTypeUpdate, "update the domain or description of a masked email", updateCmd, nil},
+	{actionTypeRename, "change the description of a masked email", nil, nil},
+	{actionTypePrune, "disable or delete masked emails unused for a while", pruneCmd, nil},
+	{actionTypeOpen, "open the url of a masked email in the browser", openCmd, nil},
+	{actionTypeAnnotate, "show or set the local note of a masked email", annotateCmd, nil},
+	{actionTypeTag, "add, remove or list #tags in descriptions", nil, []string{tagSubcommandAdd, tagSubcommandRemove, tagSubcommandList}},
+	{actionTypeTransfer, "move a masked email to another account (recreates it)", transferCmd, nil},
+	{actionTypeCount, "print the number of masked emails matching the list filters", countCmd, nil},
+	{actionTypeDupes, "list domains with more than one active masked email", nil, nil},
+	{actionTypeStats, "show aggregate numbers about the masked emails", statsCmd, nil},
+	{actionTypeDigest, "summarize new, active and changed masked emails of a period", digestCmd, nil},
+	{actionTypeWatch, "print changes to masked emails as they happen", watchCmd, nil},
+	{actionTypeTUI, "browse and change masked emails in a full-screen terminal UI", tuiCmd, nil},
+	{actionTypeSession, "show the accounts available for the token", nil, nil},
+	{actionTypeInit, "write a validated config profile non-interactively", initCmd, nil},
+	{actionTypeLogin, "prompt for a token, verify it and store it", loginCmd, nil},
+	{actionTypeLogout, "remove the stored token of the profile", logoutCmd, nil},
+	{actionTypeAuth, "store or remove the token in the system keyring", nil, []string{authSubcommandSetToken, authSubcommandClear, authSubcommandLogin}},
+	{actionTypeSyncContacts, "write the active masked emails as contacts to an address book", syncContactsCmd, nil},
+	{actionTypeDebugBundle, "write a redacted bundle for bug reports", debugBundleCmd, nil},
+	{actionTypeState, "export or import the local state and config as a bundle", stateCmd, []string{stateSubcommandExport, stateSubcommandImport}},
+	{actionTypeExport, "back up all masked emails of the account as JSON", exportCmd, nil},
+	{actionTypeImport, "recreate the masked emails of a backup or CSV", importCmd, nil},
+	{actionTypeRestore, "bring selected masked emails back to their state in a backup", restoreCmd, nil},
+	{actionTypeVersion, "show version information", nil, nil},
+	{actionTypeCompletion, "generate or install shell completion scripts", completionCmd, append(append([]string{}, completionShells...), completionSubcommandInstall)},
+}
+
+var completionShells = []string{shellBash, shellZsh, shellFish, shellPowerShell}
+
+// flags for completion command
+var completionCmd = flag.NewFlagSet(actionTypeCompletion, flag.ExitOnError)
+var flagCompletionShell = completionCmd.String(flagNameShell, "", "shell to install completions for (default: detected from $SHELL)")
+var flagCompletionYes = completionCmd.Bool(flagNameYes, false, "don't ask for confirmation before writing the script")
+
+// runCompletion handles `completion <shell>` and `completion install`.
+func runCompletion(args []string) {
+	if len(args) == 0 {
+		log.Fatalf("Usage: %s <%s|%s>", actionTypeCompletion, strings.Join(completionShells, "|"), completionSubcommandInstall)
+	}
+
+	if args[0] == completionSubcommandInstall {
+		parseCommandFlags(completionCmd, args[1:])
+		installCompletion(*flagCompletionShell, *flagCompletionYes)
+		return
+	}
+
+	script, err := completionScript(args[0])
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Print(script)
+}
+
+// completionScript returns the completion script for the given shell.
+func completionScript(shell string) (string, error) {
+	switch shell {
+	case shellBash:
+		return bashCompletion(), nil
+	case shellZsh:
+		return zshCompletion(), nil
+	case shellFish:
+		return fishCompletion(), nil
+	case shellPowerShell:
+		return powerShellCompletion(), nil
+	}
+
+	return "", fmt.Errorf("unsupported shell %q (supported: %s)", shell, strings.Join(completionShells, ", "))
+}
+
+// completionInstallPath returns where the completion script for the given
+// shell is picked up automatically, relative to the user's home directory
+// and XDG directories.
+func completionInstallPath(shell string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(home, ".config")
+	}
+
+	switch shell {
+	case shellBash:
+		return filepath.Join(dataHome, "bash-completion", "completions", defaultAppname), nil
+	case shellZsh:
+		return filepath.Join(home, ".zfunc", "_"+defaultAppname), nil
+	case shellFish:
+		return filepath.Join(configHome, "fish", "completions", defaultAppname+".fish"), nil
+	case shellPowerShell:
+		// PowerShell has no completion directory, the profile sources it
+		return filepath.Join(configHome, "powershell", defaultAppname+".ps1"), nil
+	}
+
+	return "", fmt.Errorf("unsupported shell %q (supported: %s)", shell, strings.Join(completionShells, ", "))
+}
+
+func installCompletion(shell string, skipConfirm bool) {
+	if shell == "" {
+		shell = filepath.Base(os.Getenv("SHELL"))
+		if shell == "." || shell == "" {
+			log.Fatalf("could not detect shell from $SHELL, pass -%s", flagNameShell)
+		}
+	}
+	if shell == "pwsh" {
+		shell = shellPowerShell
+	}
+
+	script, err := completionScript(shell)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	path, err := completionInstallPath(shell)
+	if err != nil {
+		log.Fatalf("determining install location: %v", err)
+	}
+
+	if !skipConfirm && !confirm(fmt.Sprintf("write %s completion script to %s?", shell, path)) {
+		fmt.Println("aborted")
+		os.Exit(1)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		log.Fatalf("creating completion directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(script), 0o644); err != nil {
+		log.Fatalf("writing completion script: %v", err)
+	}
+
+	fmt.Printf("installed %s completion to %s\n", shell, path)
+	if shell == shellZsh {
+		fmt.Printf("make sure %s is in your fpath before compinit, e.g. add to ~/.zshrc:\n", filepath.Dir(path))
+		fmt.Println("  fpath=(~/.zfunc $fpath)")
+		fmt.Println("  autoload -Uz compinit && compinit")
+	}
+	if shell == shellPowerShell {
+		fmt.Println("load it from your profile, e.g. add to $PROFILE:")
+		fmt.Printf("  . '%s'\n", path)
+	}
+	fmt.Println("restart your shell for the completion to take effect")
+}
+
+// flagNames returns the names of all flags in the set prefixed with a dash.
+// If valuesOnly is true, boolean flags are left out.
+func flagNames(set *flag.FlagSet, valuesOnly bool) []string {
+	var names []string
+	if set == nil {
+		return names
+	}
+
+	set.VisitAll(func(f *flag.Flag) {
+		if valuesOnly && isBoolFlag(f) {
+			return
+		}
+		names = append(names, "-"+f.Name)
+	})
+	sort.Strings(names)
+
+	return names
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func commandNames() []string {
+	var names []string
+	for _, c := range completionCommands {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+func bashCompletion() string {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "# bash completion for %s\n\n", defaultAppname)
+	fmt.Fprintln(&b, "_maskedemail_cli() {")
+	fmt.Fprintln(&b, "\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\"")
+	fmt.Fprintln(&b, "\tlocal cmd=\"\" i=1")
+	fmt.Fprintln(&b, "\twhile [ $i -lt $COMP_CWORD ]; do")
+	fmt.Fprintln(&b, "\t\tcase \"${COMP_WORDS[i]}\" in")
+	if valueFlags := flagNames(flag.CommandLine, true); len(valueFlags) > 0 {
+		fmt.Fprintf(&b, "\t\t\t%s) i=$((i+1)) ;;\n", strings.Join(valueFlags, "|"))
+	}
+	fmt.Fprintln(&b, "\t\t\t-*) ;;")
+	fmt.Fprintln(&b, "\t\t\t*) cmd=\"${COMP_WORDS[i]}\"; break ;;")
+	fmt.Fprintln(&b, "\t\tesac")
+	fmt.Fprintln(&b, "\t\ti=$((i+1))")
+	fmt.Fprintln(&b, "\tdone")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "\tcase \"$cmd\" in")
+	fmt.Fprintf(&b, "\t\t\"\") COMPREPLY=($(compgen -W \"%s %s\" -- \"$cur\")) ;;\n",
+		strings.Join(commandNames(), " "), strings.Join(flagNames(flag.CommandLine, false), " "))
+	for _, c := range completionCommands {
+		words := append(flagNames(c.flags, false), c.words...)
+		addressFlag, addresses := addressCompletion[c.name]
+		switch {
+		case addresses && addressFlag != "":
+			fmt.Fprintf(&b, "\t\t%s) if [ \"${COMP_WORDS[COMP_CWORD-1]}\" = \"-%s\" ]; then COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")); else COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")); fi ;;\n",
+				c.name, addressFlag, "$("+addressesCommand(c.name)+")", strings.Join(words, " "))
+		case addresses:
+			fmt.Fprintf(&b, "\t\t%s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", c.name, strings.Join(append(words, "$("+addressesCommand(c.name)+")"), " "))
+		case len(words) > 0:
+			fmt.Fprintf(&b, "\t\t%s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", c.name, strings.Join(words, " "))
+		}
+	}
+	fmt.Fprintln(&b, "\tesac")
+	fmt.Fprintln(&b, "}")
+	fmt.Fprintln(&b)
+	fmt.Fprintf(&b, "complete -F _maskedemail_cli %s\n", defaultAppname)
+
+	return b.String()
+}
+
+func zshCompletion() string {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "#compdef %s\n\n", defaultAppname)
+	fmt.Fprintln(&b, "_maskedemail_cli() {")
+	fmt.Fprintln(&b, "\tlocal cmd=\"\" i=2")
+	fmt.Fprintln(&b, "\twhile (( i < CURRENT )); do")
+	fmt.Fprintln(&b, "\t\tcase \"${words[i]}\" in")
+	if valueFlags := flagNames(flag.CommandLine, true); len(valueFlags) > 0 {
+		fmt.Fprintf(&b, "\t\t\t%s) (( i++ )) ;;\n", strings.Join(valueFlags, "|"))
+	}
+	fmt.Fprintln(&b, "\t\t\t-*) ;;")
+	fmt.Fprintln(&b, "\t\t\t*) cmd=\"${words[i]}\"; break ;;")
+	fmt.Fprintln(&b, "\t\tesac")
+	fmt.Fprintln(&b, "\t\t(( i++ ))")
+	fmt.Fprintln(&b, "\tdone")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "\tcase \"$cmd\" in")
+	fmt.Fprintf(&b, "\t\t\"\") compadd -- %s %s ;;\n",
+		strings.Join(commandNames(), " "), strings.Join(flagNames(flag.CommandLine, false), " "))
+	for _, c := range completionCommands {
+		words := append(flagNames(c.flags, false), c.words...)
+		addressFlag, addresses := addressCompletion[c.name]
+		switch {
+		case addresses && addressFlag != "":
+			fmt.Fprintf(&b, "\t\t%s) if [ \"${words[CURRENT-1]}\" = \"-%s\" ]; then compadd -- %s; else compadd -- %s; fi ;;\n",
+				c.name, addressFlag, "$("+addressesCommand(c.name)+")", strings.Join(words, " "))
+		case addresses:
+			fmt.Fprintf(&b, "\t\t%s) compadd -- %s ;;\n", c.name, strings.Join(append(words, "$("+addressesCommand(c.name)+")"), " "))
+		case len(words) > 0:
+			fmt.Fprintf(&b, "\t\t%s) compadd -- %s ;;\n", c.name, strings.Join(words, " "))
+		}
+	}
+	fmt.Fprintln(&b, "\tesac")
+	fmt.Fprintln(&b, "}")
+	fmt.Fprintln(&b)
+	fmt.Fprintf(&b, "if [ \"$funcstack[1]\" = \"_%s\" ]; then\n", defaultAppname)
+	fmt.Fprintln(&b, "\t_maskedemail_cli \"$@\"")
+	fmt.Fprintln(&b, "else")
+	fmt.Fprintf(&b, "\tcompdef _maskedemail_cli %s\n", defaultAppname)
+	fmt.Fprintln(&b, "fi")
+
+	return b.String()
+}
+
+func fishCompletion() string {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "# fish completion for %s\n\n", defaultAppname)
+	fmt.Fprintf(&b, "complete -c %s -f\n", defaultAppname)
+
+	fishFlags(&b, "__fish_use_subcommand", flag.CommandLine)
+	for _, c := range completionCommands {
+		fmt.Fprintf(&b, "complete -c %s -n __fish_use_subcommand -a %s -d %s\n",
+			defaultAppname, c.name, fishQuote(c.desc))
+	}
+
+	for _, c := range completionCommands {
+		condition := "'__fish_seen_subcommand_from " + c.name + "'"
+		fishFlags(&b, condition, c.flags)
+		if len(c.words) > 0 {
+			fmt.Fprintf(&b, "complete -c %s -n %s -a '%s'\n",
+				defaultAppname, condition, strings.Join(c.words, " "))
+		}
+		if addressFlag, ok := addressCompletion[c.name]; ok {
+			option := ""
+			if addressFlag != "" {
+				option = " -o " + addressFlag + " -r"
+			}
+			fmt.Fprintf(&b, "complete -c %s -n %s%s -a '(%s)'\n",
+				defaultAppname, condition, option, addressesCommand(c.name))
+		}
+	}
+
+	return b.String()
+}
+
+func fishFlags(b *bytes.Buffer, condition string, set *flag.FlagSet) {
+	if set == nil {
+		return
+	}
+
+	set.VisitAll(func(f *flag.Flag) {
+		requiresValue := ""
+		if !isBoolFlag(f) {
+			requiresValue = " -r"
+		}
+		fmt.Fprintf(b, "complete -c %s -n %s -o %s%s -d %s\n",
+			defaultAppname, condition, f.Name, requiresValue, fishQuote(f.Usage))
+	})
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+func powerShellCompletion() string {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "# powershell completion for %s\n\n", defaultAppname)
+	fmt.Fprintf(&b, "Register-ArgumentCompleter -Native -CommandName %s, %s -ScriptBlock {\n",
+		powerShellQuote(defaultAppname), powerShellQuote(defaultAppname+".exe"))
+	fmt.Fprintln(&b, "\tparam($wordToComplete, $commandAst, $cursorPosition)")
+	fmt.Fprintln(&b)
+	fmt.Fprintf(&b, "\t$valueFlags = %s\n", powerShellArray(flagNames(flag.CommandLine, true)))
+	fmt.Fprintln(&b, "\t$words = @{")
+	fmt.Fprintf(&b, "\t\t'' = %s\n", powerShellArray(append(commandNames(), flagNames(flag.CommandLine, false)...)))
+	for _, c := range completionCommands {
+		words := append(flagNames(c.flags, false), c.words...)
+		if len(words) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\t\t%s = %s\n", powerShellQuote(c.name), powerShellArray(words))
+	}
+	fmt.Fprintln(&b, "\t}")
+	fmt.Fprintln(&b, "\t$addressFlags = @{")
+	for _, c := range completionCommands {
+		if addressFlag, ok := addressCompletion[c.name]; ok {
+			if addressFlag != "" {
+				addressFlag = "-" + addressFlag
+			}
+			fmt.Fprintf(&b, "\t\t%s = %s\n", powerShellQuote(c.name), powerShellQuote(addressFlag))
+		}
+	}
+	fmt.Fprintln(&b, "\t}")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "\t$cmd = ''")
+	fmt.Fprintln(&b, "\t$elements = $commandAst.CommandElements")
+	fmt.Fprintln(&b, "\tfor ($i = 1; $i -lt $elements.Count; $i++) {")
+	fmt.Fprintln(&b, "\t\tif ($elements[$i].Extent.EndOffset -ge $cursorPosition) { break }")
+	fmt.Fprintln(&b, "\t\t$text = $elements[$i].ToString()")
+	fmt.Fprintln(&b, "\t\tif ($valueFlags -contains $text) { $i++; continue }")
+	fmt.Fprintln(&b, "\t\tif ($text.StartsWith('-')) { continue }")
+	fmt.Fprintln(&b, "\t\t$cmd = $text")
+	fmt.Fprintln(&b, "\t\tbreak")
+	fmt.Fprintln(&b, "\t}")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "\t$prev = ''")
+	fmt.Fprintln(&b, "\tforeach ($element in $elements) {")
+	fmt.Fprintln(&b, "\t\tif ($element.Extent.EndOffset -lt $cursorPosition) { $prev = $element.ToString() }")
+	fmt.Fprintln(&b, "\t}")
+	fmt.Fprintln(&b, "\t$candidates = $words[$cmd]")
+	fmt.Fprintln(&b, "\tif ($addressFlags.ContainsKey($cmd)) {")
+	fmt.Fprintln(&b, "\t\tif ($addressFlags[$cmd] -eq '') {")
+	fmt.Fprintf(&b, "\t\t\t$candidates = @($candidates) + @(& %s %s $cmd 2>$null)\n", powerShellQuote(defaultAppname), actionTypeCompleteAddresses)
+	fmt.Fprintln(&b, "\t\t} elseif ($addressFlags[$cmd] -eq $prev) {")
+	fmt.Fprintf(&b, "\t\t\t$candidates = @(& %s %s $cmd 2>$null)\n", powerShellQuote(defaultAppname), actionTypeCompleteAddresses)
+	fmt.Fprintln(&b, "\t\t}")
+	fmt.Fprintln(&b, "\t}")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "\t$candidates | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {")
+	fmt.Fprintln(&b, "\t\t[System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)")
+	fmt.Fprintln(&b, "\t}")
+	fmt.Fprintln(&b, "}")
+
+	return b.String()
+}
+
+// addressesCommand returns the shell command listing the masked emails the
+// given command can act on, see runCompleteAddresses.
+func addressesCommand(command string) string {
+	return defaultAppname + " " + actionTypeCompleteAddresses + " " + command + " 2>/dev/null"
+}
+
+func powerShellArray(words []string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = powerShellQuote(w)
+	}
+	return "@(" + strings.Join(quoted, ", ") + ")"
+}
+
+func powerShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	envConfigVarName string = "MASKEDEMAIL_CONFIG"
+
+	configFileName string = "config.json"
+)
+
+// profileConfig holds the settings that can differ between profiles.
+type profileConfig struct {
+	Token     string `json:"token,omitempty"`
+	AccountID string `json:"accountId,omitempty"`
+	Appname   string `json:"appname,omitempty"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+	// TokenFrom fetches the token from a secret store instead of Token.
+	TokenFrom *secretSource `json:"tokenFrom,omitempty"`
+	// AddressBook is where sync-contacts writes the masked emails to.
+	AddressBook *addressBookConfig `json:"addressBook,omitempty"`
+}
+
+// config is the persisted configuration. The top-level settings are the
+// default profile, named profiles live in Profiles.
+type config struct {
+	profileConfig
+	Profiles map[string]*profileConfig `json:"profiles,omitempty"`
+	// Views are named list arguments, e.g. "stale": "-where ... -sort idle",
+	// used with list -view.
+	Views map[string]string `json:"views,omitempty"`
+	// Output is the default -output of the commands supporting it.
+	Output string `json:"output,omitempty"`
+	// Flags are default global flags, e.g. "-timeout 1m -compat 1".
+	Flags string `json:"flags,omitempty"`
+	// CommandFlags are default flags per command, e.g.
+	// "list": "-all-fields -sort idle".
+	CommandFlags map[string]string `json:"commandFlags,omitempty"`
+}
+
+// profile returns the settings of the named profile, or the top-level
+// settings for the empty name.
+func (cfg *config) profile(name string) (*profileConfig, error) {
+	if name == "" {
+		return &cfg.profileConfig, nil
+	}
+
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		if len(cfg.Profiles) == 0 {
+			return nil, fmt.Errorf("profile %q not found in config, it has no profiles", name)
+		}
+		names := make([]string, 0, len(cfg.Profiles))
+		for n := range cfg.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("profile %q not found in config (%s)", name, strings.Join(names, ", "))
+	}
+
+	return p, nil
+}
+
+// setProfile stores the settings under the named profile, or as the
+// top-level settings for the empty name.
+func (cfg *config) setProfile(name string, p profileConfig) {
+	if name == "" {
+		cfg.profileConfig = p
+		return
+	}
+
+	if cfg.Profiles == nil {
+		cfg.Profiles = map[string]*profileConfig{}
+	}
+	cfg.Profiles[name] = &p
+}
+
+// configPath returns $MASKEDEMAIL_CONFIG or config.json in the user's config
+// directory (e.g. ~/.config/maskedemail-cli/config.json on Linux).
+func configPath() (string, error) {
+	if path := os.Getenv(envConfigVarName); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, defaultAppname, configFileName), nil
+}
+
+// loadConfig reads the config file. A missing file yields an empty config.
+func loadConfig() (*config, error) {
+	cfg := &config{}
+
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// save writes the config file, readable only by the user since it may hold
+// the token.
+func (cfg *config) save() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// applyConfigFlags sets the default global flags of the config. They take
+// precedence over env variables, which are the defaults of the flags, but
+// not over the command line, which is parsed again afterwards. It returns the
+// names of the flags set by the config or the command line.
+func applyConfigFlags(cfg *config) (map[string]bool, error) {
+	explicit := map[string]bool{}
+
+	if cfg.Flags != "" {
+		defaults, err := splitArgs(cfg.Flags)
+		if err != nil {
+			return nil, err
+		}
+
+		// share the values of the global flags, so parsing sets them
+		set := flag.NewFlagSet("flags", flag.ContinueOnError)
+		set.SetOutput(io.Discard)
+		flag.VisitAll(func(f *flag.Flag) {
+			set.Var(f.Value, f.Name, f.Usage)
+		})
+
+		if err := set.Parse(defaults); err != nil {
+			return nil, err
+		}
+		if set.NArg() > 0 {
+			return nil, fmt.Errorf("unexpected argument %q, only global flags are allowed", set.Arg(0))
+		}
+		set.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+		flag.CommandLine.Parse(os.Args[1:])
+	}
+
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	return explicit, nil
+}
+
+// commandFlagArgs returns the default flags of the command from the config.
+// It exits if they aren't flags of the command, since the config would break
+// every invocation of it otherwise.
+func commandFlagArgs(set *flag.FlagSet) []string {
+	value := userConfig.CommandFlags[set.Name()]
+	if value == "" {
+		return nil
+	}
+
+	defaults, err := splitArgs(value)
+	if err != nil {
+		log.Fatalf("error in commandFlags.%s of config: %v", set.Name(), err)
+	}
+
+	// share the values of the command flags and parse without exiting, the
+	// command's own set only takes the defaults once they're known to be valid
+	check := flag.NewFlagSet(set.Name(), flag.ContinueOnError)
+	check.SetOutput(io.Discard)
+	set.VisitAll(func(f *flag.Flag) {
+		check.Var(f.Value, f.Name, f.Usage)
+	})
+
+	if err := check.Parse(defaults); err != nil {
+		log.Fatalf("error in commandFlags.%s of config: %v", set.Name(), err)
+	}
+	if check.NArg() > 0 {
+		log.Fatalf("error in commandFlags.%s of config: unexpected argument %q, only flags of %s are allowed", set.Name(), check.Arg(0), set.Name())
+	}
+
+	return defaults
+}
+
+// envInt reads an integer environment variable, returning def if it's unset
+// or invalid.
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: ignoring invalid %s=%q\n", name, value)
+		return def
+	}
+
+	return i
+}
+
+// envBool reads a boolean environment variable ("1", "true", ...), returning
+// def if it's unset or invalid.
+func envBool(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: ignoring invalid %s=%q\n", name, value)
+		return def
+	}
+
+	return b
+}
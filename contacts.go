@@ -0,0 +1,204 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+const (
+	addressBookProviderCardDAV string = "carddav"
+
+	// contactUIDPrefix marks the contacts written by sync-contacts, all
+	// others in the address book are never touched.
+	contactUIDPrefix string = "maskedemail-"
+	contactCategory  string = "Masked email"
+)
+
+// addressBookConfig tells sync-contacts where to write contacts to, e.g.
+// {"url": "https://carddav.fastmail.com/dav/addressbooks/user/me@fastmail.com/Default/",
+// "username": "me@fastmail.com", "passwordFrom": {"provider": "exec", "command": "pass show fastmail/carddav"}}.
+type addressBookConfig struct {
+	// Provider is the kind of address book, "carddav" if unset.
+	Provider string `json:"provider,omitempty"`
+	// URL is the address book collection.
+	URL      string `json:"url"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// PasswordFrom fetches the password from a secret store instead.
+	PasswordFrom *secretSource `json:"passwordFrom,omitempty"`
+}
+
+// contact is the address book entry of a masked email.
+type contact struct {
+	UID   string
+	Name  string
+	Email string
+	Note  string
+}
+
+// addressBook is a store of contacts sync-contacts can write to.
+type addressBook interface {
+	// contacts returns the contacts written by sync-contacts before, by
+	// UID.
+	contacts() (map[string]contact, error)
+	// put creates the contact or replaces the one with its UID.
+	put(c contact) error
+	remove(uid string) error
+}
+
+// addressBookProviders creates the address book of each provider from its
+// config, with the password already fetched.
+var addressBookProviders = map[string]func(cfg addressBookConfig, password string) (addressBook, error){
+	addressBookProviderCardDAV: newCardDAVBook,
+}
+
+// flags for sync-contacts command
+var syncContactsCmd = flag.NewFlagSet(actionTypeSyncContacts, flag.ExitOnError)
+var flagSyncContactsPrune = syncContactsCmd.Bool(flagNamePrune, false, "also remove the contacts of masked emails that were deleted")
+var flagSyncContactsDryRun = syncContactsCmd.Bool(flagNameDryRun, false, "print what would change in the address book without changing it")
+
+// contactFor returns the contact of a masked email, named by its
+// description, or its domain if it has none.
+func contactFor(email *pkg.MaskedEmail) contact {
+	c := contact{UID: contactUIDPrefix + email.ID, Email: email.Email}
+
+	domain := strings.TrimSpace(email.Domain)
+	c.Name = strings.TrimSpace(email.Description)
+	if c.Name == "" {
+		c.Name = domain
+	}
+	if c.Name == "" {
+		c.Name = email.Email
+	}
+	if domain != "" {
+		c.Note = "Masked email for " + domain
+	}
+	return c
+}
+
+// openAddressBook returns the address book configured for the profile.
+func openAddressBook() (addressBook, error) {
+	p, err := userConfig.profile(*flagProfile)
+	if err != nil {
+		return nil, err
+	}
+	if p.AddressBook == nil || p.AddressBook.URL == "" {
+		return nil, fmt.Errorf("no address book configured for profile %s, add \"addressBook\": {\"url\": ...} to the config", keyringAccount(*flagProfile))
+	}
+	cfg := *p.AddressBook
+
+	provider := cfg.Provider
+	if provider == "" {
+		provider = addressBookProviderCardDAV
+	}
+	newBook, ok := addressBookProviders[provider]
+	if !ok {
+		names := make([]string, 0, len(addressBookProviders))
+		for name := range addressBookProviders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown address book provider %q (%s)", provider, strings.Join(names, ", "))
+	}
+
+	password := cfg.Password
+	if cfg.PasswordFrom != nil {
+		password, err = fetchSecret(*cfg.PasswordFrom, *flagTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("fetching address book password: %w", err)
+		}
+	}
+
+	return newBook(cfg, password)
+}
+
+// runSyncContacts writes every active masked email as a contact into the
+// configured address book, so mail clients autocomplete the addresses.
+// Contacts that are up to date are left alone.
+func runSyncContacts(client *pkg.Client, args []string) {
+	parseCommandFlags(syncContactsCmd, args)
+	if syncContactsCmd.NArg() > 0 {
+		log.Fatalf("Usage: %s [-%s] [-%s]", actionTypeSyncContacts, flagNamePrune, flagNameDryRun)
+	}
+
+	book, err := openAddressBook()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	emails, err := client.GetAllMaskedEmails(session, *flagAccountID)
+	if err != nil {
+		log.Fatalf("error fetching masked emails: %v", err)
+	}
+
+	existing, err := book.contacts()
+	if err != nil {
+		log.Fatalf("error reading address book: %v", err)
+	}
+
+	var created, updated, unchanged, removed int
+	change := func(verb string, address string, apply func() error) {
+		if *flagSyncContactsDryRun {
+			fmt.Printf("dry run: would %s %s\n", verb, address)
+			return
+		}
+		if err := apply(); err != nil {
+			log.Fatalf("error writing contact of %s: %v", address, err)
+		}
+	}
+
+	kept := map[string]bool{} // UIDs of masked emails that weren't deleted
+	for _, email := range emails {
+		c := contactFor(email)
+		if email.State != pkg.MaskedEmailStateDeleted {
+			kept[c.UID] = true
+		}
+		if !email.State.IsActive() {
+			continue
+		}
+
+		old, ok := existing[c.UID]
+		switch {
+		case ok && old == c:
+			unchanged++
+		case ok:
+			change("update", c.Email, func() error { return book.put(c) })
+			updated++
+		default:
+			change("create", c.Email, func() error { return book.put(c) })
+			created++
+		}
+	}
+
+	if *flagSyncContactsPrune {
+		uids := make([]string, 0, len(existing))
+		for uid := range existing {
+			if !kept[uid] {
+				uids = append(uids, uid)
+			}
+		}
+		sort.Strings(uids)
+		for _, uid := range uids {
+			uid := uid
+			change("remove", existing[uid].Email, func() error { return book.remove(uid) })
+			removed++
+		}
+	}
+
+	prefix := ""
+	if *flagSyncContactsDryRun {
+		prefix = "dry run: "
+	}
+	fmt.Fprintf(os.Stderr, "%scontacts: %d created, %d updated, %d unchanged, %d removed\n", prefix, created, updated, unchanged, removed)
+}
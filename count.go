@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+var countCmd = flag.NewFlagSet(actionTypeCount, flag.ExitOnError)
+
+// countFilterFlags are the filters of list that count takes as well.
+var countFilterFlags = []string{
+	flagNameShowDeleted,
+	flagNameState,
+	flagNameDomain,
+	flagNameDesc,
+	flagNameTag,
+	flagNameRegex,
+	flagNameWhere,
+	flagNameChangedSince,
+	flagNameCreatedSince,
+	flagNameCreatedBefore,
+	flagNameLastEmailBefore,
+	flagNameNeverUsed,
+}
+
+func init() {
+	// share the values with list, so both filter the same way
+	for _, name := range countFilterFlags {
+		f := listCmd.Lookup(name)
+		countCmd.Var(f.Value, f.Name, f.Usage)
+	}
+}
+
+// runCount prints the number of masked emails matching the list filters and
+// nothing else, for shell scripts and monitoring checks.
+func runCount(client *pkg.Client, args []string) {
+	*flagListState = nil
+	parseCommandFlags(countCmd, args)
+	if countCmd.NArg() > 0 {
+		log.Fatalf("Usage: %s [filters], the filters are those of %s", actionTypeCount, actionTypeList)
+	}
+
+	now := time.Now()
+	window := compileListFilters(now)
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	maskedEmails, err := client.GetAllMaskedEmails(session, *flagAccountID)
+	if err != nil {
+		log.Fatalf("error fetching masked emails: %v", err)
+	}
+	if window > 0 {
+		listChangedSince = changedSinceFilter(client, session, now.Add(-window))
+	}
+
+	count := 0
+	for _, email := range maskedEmails {
+		if listed(email) {
+			count++
+		}
+	}
+	fmt.Println(count)
+}
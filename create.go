@@ -0,0 +1,457 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// flags for create command
+var createCmd = flag.NewFlagSet(actionTypeCreate, flag.ExitOnError)
+var flagCreateDomain = createCmd.String(flagNameDomain, "", "domain for the masked email (optional)")
+var flagCreateDescription = createCmd.String(flagNameDesc, "", "description for the masked email (optional)")
+var flagCreateEnabled = createCmd.Bool(flagNameEnabled, true, "is masked email enabled (true|false)")
+var flagCreateIgnorePolicy = createCmd.Bool(flagNameIgnorePolicy, false, "create even if it exceeds the -"+flagNameMaxPerDomain+" policy")
+var flagCreateIdempotencyKey = createCmd.String(flagNameIdempotencyKey, "", "return the masked email previously created with this key instead of creating a new one (optional)")
+var flagCreateFromMessage = createCmd.Bool(flagNameFromMessage, false, "read an email message from stdin and create the masked email for its sender, -"+flagNameDomain+" and -"+flagNameDesc+" override what it derives")
+var flagCreateCopy = createCmd.Bool(flagNameCopy, false, "copy the address of the masked email to the clipboard")
+var flagCreateQR = createCmd.Bool(flagNameQR, false, "also print the address as a QR code, to scan it on a phone")
+var flagCreateDryRun = createCmd.Bool(flagNameDryRun, false, "validate and print the JMAP request instead of sending it")
+var flagCreateCount = createCmd.Int(flagNameCount, 1, "number of masked emails to create with the same domain and description")
+var flagCreateURL = createCmd.String(flagNameURL, "", "web address the masked email is for, stored as its url, its host becomes the domain unless -"+flagNameDomain+" is passed")
+var flagCreateRegistrable = createCmd.Bool(flagNameRegistrable, false, "with -"+flagNameURL+", use the registrable domain (example.com) instead of the host (shop.example.com)")
+var flagCreateReuse = createCmd.Bool(flagNameReuse, false, "return an enabled masked email for the domain if there is one instead of creating another")
+var flagCreateDescTemplate = createCmd.String(flagNameDescTemplate, "", "build the description from this Go text/template, e.g. 'Signup for {{.Domain}} on {{.Date}}', with .Domain, .URL, .Date, .Time and .Hostname")
+
+// descTemplateData are the variables of -desc-template.
+type descTemplateData struct {
+	Domain string
+	URL    string
+	// Date and Time are the local time of the creation, as 2006-01-02 and
+	// 15:04.
+	Date     string
+	Time     string
+	Hostname string
+}
+
+// expandDescTemplate renders -desc-template into a description.
+func expandDescTemplate(text string, domain string, pageURL string, now time.Time) (string, error) {
+	tmpl, err := template.New(flagNameDescTemplate).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	hostname, _ := os.Hostname()
+	data := descTemplateData{
+		Domain:   domain,
+		URL:      pageURL,
+		Date:     now.Format("2006-01-02"),
+		Time:     now.Format("15:04"),
+		Hostname: hostname,
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func runCreate(client *pkg.Client, args []string) {
+	// parse command-specific args
+	parseCommandFlags(createCmd, args)
+
+	domain := strings.TrimSpace(*flagCreateDomain)
+	description := strings.TrimSpace(*flagCreateDescription)
+
+	count := *flagCreateCount
+	if count < 1 {
+		log.Fatalf("-%s must be at least 1", flagNameCount)
+	}
+	if count > 1 {
+		for _, name := range []string{flagNameIdempotencyKey, flagNameCopy, flagNameQR, flagNameReuse} {
+			if isFlagPassed(*createCmd, name) {
+				log.Fatalf("-%s can't be combined with -%s", name, flagNameCount)
+			}
+		}
+	}
+
+	if *flagCreateFromMessage {
+		if isTerminal(os.Stdin) {
+			log.Fatalf("-%s reads the message from stdin, pipe it in", flagNameFromMessage)
+		}
+		origin, err := readMessageOrigin(os.Stdin)
+		if err != nil {
+			log.Fatalf("error reading message: %v", err)
+		}
+		if !isFlagPassed(*createCmd, flagNameDomain) && *flagCreateURL == "" {
+			domain = origin.domain
+		}
+		if !isFlagPassed(*createCmd, flagNameDesc) {
+			description = origin.description()
+		}
+	}
+	pageURL := ""
+	if *flagCreateURL != "" {
+		host, err := pkg.DomainFromURL(*flagCreateURL)
+		if err != nil {
+			log.Fatalf("-%s: %v", flagNameURL, err)
+		}
+		pageURL, _ = pkg.PageURL(*flagCreateURL)
+		if !isFlagPassed(*createCmd, flagNameDomain) {
+			domain = host
+			if *flagCreateRegistrable {
+				domain = pkg.RegistrableDomain(host)
+			}
+		}
+	}
+	if *flagCreateDescTemplate != "" {
+		if isFlagPassed(*createCmd, flagNameDesc) {
+			log.Fatalf("-%s can't be combined with -%s", flagNameDescTemplate, flagNameDesc)
+		}
+		expanded, err := expandDescTemplate(*flagCreateDescTemplate, domain, pageURL, time.Now())
+		if err != nil {
+			log.Fatalf("invalid -%s: %v", flagNameDescTemplate, err)
+		}
+		description = expanded
+	}
+	if *flagCreateReuse && domain == "" {
+		log.Fatalf("-%s needs a domain, pass -%s", flagNameReuse, flagNameDomain)
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	accID := accountIDOrDefault(session)
+	idempotencyKey := strings.TrimSpace(*flagCreateIdempotencyKey)
+
+	// a retried create with the same key returns the earlier result
+	if idempotencyKey != "" {
+		previous, err := findIdempotentCreate(accID, idempotencyKey)
+		if err != nil {
+			log.Fatalf("error reading journal: %v", err)
+		}
+		if previous != nil {
+			email, err := idempotentMaskedEmail(client, session, accID, previous, idempotencyKey)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			if *flagCreateDryRun {
+				fmt.Fprintf(os.Stderr, "dry run: would return %s created earlier with this idempotency key\n", previous.Email)
+				return
+			}
+			if *flagCreateCopy {
+				copyToClipboard(previous.Email)
+			}
+			if *flagCreateQR {
+				defer printQR(previous.Email)
+			}
+			if jsonOutput() || outputTemplate != nil {
+				if outputTemplate != nil {
+					printTemplate(email)
+				} else {
+					printJSON(email)
+				}
+				return
+			}
+			fmt.Println(previous.Email)
+			return
+		}
+	}
+
+	checkPolicy := *flagMaxPerDomain > 0 && domain != "" && !*flagCreateIgnorePolicy
+	if *flagCreateReuse || checkPolicy {
+		maskedEmails, err := client.GetAllMaskedEmails(session, *flagAccountID)
+		if err != nil {
+			log.Fatalf("error fetching masked emails: %v", err)
+		}
+		if *flagCreateReuse {
+			if existing := findReusable(maskedEmails, domain); existing != nil {
+				reuseMaskedEmail(existing)
+				return
+			}
+		}
+		if checkPolicy {
+			if err := checkDomainPolicy(maskedEmails, domain, *flagMaxPerDomain, count); err != nil {
+				log.Fatalln(err)
+			}
+		}
+	}
+
+	if *flagCreateDryRun {
+		request, err := client.CreateMaskedEmailRequest(session, *flagAccountID, domain, *flagCreateEnabled, description, pageURL)
+		if count > 1 {
+			request, err = client.CreateMaskedEmailsRequest(session, *flagAccountID, domain, *flagCreateEnabled, description, pageURL, count)
+		}
+		if err != nil {
+			log.Fatalf("error building request: %v", err)
+		}
+
+		out, err := json.MarshalIndent(request, "", "  ")
+		if err != nil {
+			log.Fatalf("error encoding request: %v", err)
+		}
+
+		fmt.Fprintf(os.Stderr, "dry run: would send to %s\n", session.ApiEndpoint())
+		fmt.Println(string(out))
+		return
+	}
+
+	if count > 1 {
+		createMany(client, session, accID, domain, description, pageURL, count)
+		return
+	}
+	if *flagCreateReuse {
+		defer fmt.Fprintf(os.Stderr, "created a new masked email for %s\n", domain)
+	}
+
+	startedAt := time.Now()
+	createRes, err := client.CreateMaskedEmail(session, *flagAccountID, domain, *flagCreateEnabled, description, pageURL)
+	if err != nil && isAmbiguousError(err) {
+		// the request may have reached the server, find out instead of
+		// leaving the user to guess (and possibly create a duplicate)
+		createRes, err = recoverAmbiguousCreate(client, session, *flagAccountID, startedAt, domain, description, err)
+	}
+	if err != nil {
+		log.Fatalf("error creating masked email: %v", err)
+	}
+
+	appendJournal(journalEntry{
+		Action:         actionTypeCreate,
+		AccountID:      accID,
+		ID:             createRes.ID,
+		Email:          createRes.Email,
+		Domain:         domain,
+		Description:    description,
+		IdempotencyKey: idempotencyKey,
+	})
+
+	if *flagCreateCopy {
+		copyToClipboard(createRes.Email)
+	}
+	if *flagCreateQR {
+		defer printQR(createRes.Email)
+	}
+
+	// success output
+	if jsonOutput() {
+		printJSON(completeCreated(createRes, domain, description, pageURL, *flagCreateEnabled))
+		return
+	}
+	if outputTemplate != nil {
+		printTemplate(completeCreated(createRes, domain, description, pageURL, *flagCreateEnabled))
+		return
+	}
+	fmt.Println(createRes.Email)
+}
+
+// findReusable returns the most recently created enabled masked email for
+// the domain, compared normalized, or nil if there is none.
+func findReusable(emails []*pkg.MaskedEmail, domain string) *pkg.MaskedEmail {
+	domain = pkg.NormalizeDomain(domain)
+
+	var found *pkg.MaskedEmail
+	for _, email := range emails {
+		if email.State != pkg.MaskedEmailStateEnabled || pkg.NormalizeDomain(email.Domain) != domain {
+			continue
+		}
+		if found == nil || email.CreatedAt.After(found.CreatedAt) {
+			found = email
+		}
+	}
+	return found
+}
+
+// reuseMaskedEmail prints the masked email found by -reuse like a created
+// one, telling on stderr that it was reused.
+func reuseMaskedEmail(email *pkg.MaskedEmail) {
+	if *flagCreateDryRun {
+		fmt.Fprintf(os.Stderr, "dry run: would reuse %s for %s\n", email.Email, strings.TrimSpace(email.Domain))
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "reused the existing masked email for %s\n", strings.TrimSpace(email.Domain))
+	if *flagCreateCopy {
+		copyToClipboard(email.Email)
+	}
+	if *flagCreateQR {
+		defer printQR(email.Email)
+	}
+
+	switch {
+	case jsonOutput():
+		printJSON(email)
+	case outputTemplate != nil:
+		printTemplate(email)
+	default:
+		fmt.Println(email.Email)
+	}
+}
+
+// idempotentMaskedEmail returns the masked email the journal entry says was
+// created with the idempotency key. It fails if the masked email was deleted
+// or destroyed since, handing it out again would give automation a dead
+// address.
+func idempotentMaskedEmail(client *pkg.Client, session *pkg.SessionResource, accID string, previous *journalEntry, key string) (*pkg.MaskedEmail, error) {
+	var emails []*pkg.MaskedEmail
+	var err error
+	// older journal entries have no ID
+	if previous.ID != "" {
+		emails, err = client.GetMaskedEmails(session, accID, []string{previous.ID})
+	} else {
+		emails, err = client.GetAllMaskedEmails(session, accID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching masked email: %w", err)
+	}
+
+	var email *pkg.MaskedEmail
+	for _, e := range emails {
+		if e.ID == previous.ID || (previous.ID == "" && e.Email == previous.Email) {
+			email = e
+		}
+	}
+	if email == nil {
+		return nil, fmt.Errorf("%s, created earlier with idempotency key %q, no longer exists, pass a new key to create another one", previous.Email, key)
+	}
+	if email.State == pkg.MaskedEmailStateDeleted {
+		return nil, fmt.Errorf("%s, created earlier with idempotency key %q, was deleted, pass a new key to create another one", previous.Email, key)
+	}
+	return email, nil
+}
+
+// createMany creates count masked emails at once for -count and prints each
+// address, or all of them as a JSON array. Rejected creates are reported
+// after the created ones and make the command fail.
+func createMany(client *pkg.Client, session *pkg.SessionResource, accID string, domain string, description string, pageURL string, count int) {
+	created, notCreated, err := client.CreateMaskedEmails(session, *flagAccountID, domain, *flagCreateEnabled, description, pageURL, count)
+
+	for i, email := range created {
+		created[i] = completeCreated(email, domain, description, pageURL, *flagCreateEnabled)
+		appendJournal(journalEntry{
+			Action:      actionTypeCreate,
+			AccountID:   accID,
+			ID:          email.ID,
+			Email:       email.Email,
+			Domain:      domain,
+			Description: description,
+		})
+	}
+
+	switch {
+	case jsonOutput():
+		printJSON(created)
+	case outputTemplate != nil:
+		printTemplate(created...)
+	default:
+		for _, email := range created {
+			fmt.Println(email.Email)
+		}
+	}
+
+	for _, setErr := range notCreated {
+		setErr := setErr
+		fmt.Fprintf(os.Stderr, "error creating masked email: %v\n", &setErr)
+	}
+	if err != nil {
+		log.Fatalf("error creating masked emails, %d more may or may not have been created, check with `%s`: %v", count-len(created)-len(notCreated), actionTypeList, err)
+	}
+	if len(notCreated) > 0 {
+		log.Fatalf("created %d of %d masked emails", len(created), count)
+	}
+}
+
+// completeCreated fills in the properties the server omits from a create
+// response because they were set by the request.
+func completeCreated(email *pkg.MaskedEmail, domain string, description string, url string, enabled bool) *pkg.MaskedEmail {
+	if email.Domain == "" {
+		email.Domain = domain
+	}
+	if email.Description == "" {
+		email.Description = description
+	}
+	if email.URL == nil && url != "" {
+		email.URL = &url
+	}
+	if email.State == "" {
+		email.State = pkg.MaskedEmailStatePending
+		if enabled {
+			email.State = pkg.MaskedEmailStateEnabled
+		}
+	}
+	return email
+}
+
+// recentCreateWindow is how far back recoverAmbiguousCreate looks for a
+// masked email created by a request that timed out.
+const recentCreateWindow = time.Minute
+
+// isAmbiguousError reports whether a request failed in a way that leaves it
+// unknown if the server processed it, e.g. a timeout or a dropped connection.
+func isAmbiguousError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// recoverAmbiguousCreate looks for a masked email with the same domain and
+// description created since startedAt (minus a minute for clock skew). It
+// returns that masked email if the create went through, or an error stating
+// whether it's safe to retry.
+func recoverAmbiguousCreate(
+	client *pkg.Client,
+	session pkg.Session,
+	accID string,
+	startedAt time.Time,
+	domain string,
+	description string,
+	createErr error,
+) (*pkg.MaskedEmail, error) {
+	fmt.Fprintf(os.Stderr, "create request failed ambiguously (%v), checking whether it succeeded...\n", createErr)
+
+	maskedEmails, err := client.GetAllMaskedEmails(session, accID)
+	if err != nil {
+		return nil, fmt.Errorf("%v; could not verify whether the masked email was created (%v), check with `%s` before retrying", createErr, err, actionTypeList)
+	}
+
+	since := startedAt.Add(-recentCreateWindow)
+	var matches []*pkg.MaskedEmail
+	for _, email := range maskedEmails {
+		if email.CreatedAt.Before(since) {
+			continue
+		}
+		if strings.TrimSpace(email.Domain) == domain && strings.TrimSpace(email.Description) == description {
+			matches = append(matches, email)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%v; the masked email was not created, it's safe to retry", createErr)
+	case 1:
+		fmt.Fprintln(os.Stderr, "the masked email was created despite the error")
+		return matches[0], nil
+	default:
+		var emails []string
+		for _, email := range matches {
+			emails = append(emails, email.Email)
+		}
+		return nil, fmt.Errorf("%v; found %d matching masked emails created in the last %v, check which one is yours: %s",
+			createErr, len(matches), recentCreateWindow, strings.Join(emails, ", "))
+	}
+}
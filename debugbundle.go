@@ -0,0 +1,311 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+const (
+	lastFailureFileName string = "last-failure.json"
+
+	// debugBundleJournalEntries is how many of the newest journal entries
+	// go into a debug bundle.
+	debugBundleJournalEntries = 50
+
+	// maxFailureBodySize limits how much of a failed response is kept.
+	maxFailureBodySize int64 = 64 << 10
+)
+
+// flags for debug-bundle command
+var debugBundleCmd = flag.NewFlagSet(actionTypeDebugBundle, flag.ExitOnError)
+var flagDebugBundleFile = debugBundleCmd.String(flagNameFile, "", "write the bundle to this file instead of stdout")
+
+// failedExchange is the last HTTP request that failed, at the HTTP or the
+// JMAP level, with the response. The authorization header is never kept.
+type failedExchange struct {
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method"`
+	URL      string    `json:"url"`
+	Request  string    `json:"request,omitempty"`
+	Status   string    `json:"status,omitempty"`
+	Response string    `json:"response,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// failureTransport wraps next to remember the last failed exchange in the
+// state directory, for debug-bundle.
+type failureTransport struct {
+	next http.RoundTripper
+}
+
+func (t *failureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	exchange := failedExchange{Time: time.Now(), Method: req.Method, URL: req.URL.String(), Request: string(reqBody)}
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		exchange.Error = err.Error()
+		recordFailure(exchange)
+		return nil, err
+	}
+
+	// streams like the event source are left alone, they don't end
+	isJSON := strings.Contains(res.Header.Get("Content-Type"), "json")
+	if res.StatusCode < 400 && !isJSON {
+		return res, nil
+	}
+
+	limit := maxFailureBodySize
+	if isJSON {
+		limit = -1
+	}
+	resBody, err := readBody(res.Body, limit)
+	res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(resBody))
+	if err != nil {
+		return res, nil
+	}
+
+	if res.StatusCode >= 400 || jmapFailed(resBody) {
+		exchange.Status = res.Status
+		exchange.Response = string(resBody)
+		recordFailure(exchange)
+	}
+	return res, nil
+}
+
+// readBody reads all of r, or at most limit bytes if limit isn't negative.
+func readBody(r io.Reader, limit int64) ([]byte, error) {
+	if limit >= 0 {
+		r = io.LimitReader(r, limit)
+	}
+	return io.ReadAll(r)
+}
+
+// jmapFailed tells whether a JMAP response has a method error or objects
+// that couldn't be created, updated or destroyed.
+func jmapFailed(body []byte) bool {
+	// don't parse the large responses of MaskedEmail/get a second time
+	if !bytes.Contains(body, []byte(`"error"`)) && !bytes.Contains(body, []byte(`"notCreated"`)) &&
+		!bytes.Contains(body, []byte(`"notUpdated"`)) && !bytes.Contains(body, []byte(`"notDestroyed"`)) {
+		return false
+	}
+
+	var res struct {
+		MethodResponses [][]json.RawMessage `json:"methodResponses"`
+	}
+	if json.Unmarshal(body, &res) != nil {
+		return false
+	}
+
+	for _, mr := range res.MethodResponses {
+		if len(mr) < 2 {
+			continue
+		}
+		var name string
+		if json.Unmarshal(mr[0], &name) == nil && name == "error" {
+			return true
+		}
+		var set struct {
+			NotCreated   map[string]json.RawMessage `json:"notCreated"`
+			NotUpdated   map[string]json.RawMessage `json:"notUpdated"`
+			NotDestroyed map[string]json.RawMessage `json:"notDestroyed"`
+		}
+		if json.Unmarshal(mr[1], &set) == nil && len(set.NotCreated)+len(set.NotUpdated)+len(set.NotDestroyed) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func lastFailurePath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, lastFailureFileName), nil
+}
+
+// recordFailure replaces the remembered failed exchange. It's only an aid
+// for bug reports, so failures to write it are ignored.
+func recordFailure(exchange failedExchange) {
+	path, err := lastFailurePath()
+	if err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(exchange, "", "  ")
+	if err != nil {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+var (
+	redactTokenPattern   = regexp.MustCompile(`(?i)bearer\s+[^\s"]+|fmu1-[a-z0-9-]+`)
+	redactAddressPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@([A-Za-z0-9-]+\.[A-Za-z0-9.-]+)`)
+	redactFieldPattern   = regexp.MustCompile(`"(description|url|forDomain)"(\s*:\s*)"(?:[^"\\]|\\.)*"`)
+)
+
+// redact removes what identifies the user or grants access from text that
+// goes into a debug bundle: tokens, the local part of email addresses, and
+// descriptions, URLs and domains in JSON.
+func redact(s string) string {
+	s = redactTokenPattern.ReplaceAllString(s, "[REDACTED]")
+	s = redactAddressPattern.ReplaceAllString(s, "redacted@$1")
+	return redactFieldPattern.ReplaceAllString(s, `"$1"$2"***"`)
+}
+
+// commandAction reduces the command line of a journal entry to the program
+// and the action, e.g. "maskedemail-cli create".
+func commandAction(e journalEntry) string {
+	if e.Command == "" {
+		return ""
+	}
+	return strings.Fields(e.Command)[0] + " " + e.Action
+}
+
+// runDebugBundle writes a .tar.gz with what helps to triage a bug report,
+// with secrets and personal data redacted: version and platform, the
+// session, the newest journal entries, the last failed request and the
+// config without tokens.
+func runDebugBundle(client *pkg.Client, args []string) {
+	parseCommandFlags(debugBundleCmd, args)
+
+	var out io.Writer = os.Stdout
+	if *flagDebugBundleFile == "" {
+		if isTerminal(os.Stdout) {
+			log.Fatalf("refusing to write the bundle to a terminal, pass -%s or redirect stdout", flagNameFile)
+		}
+	} else {
+		f, err := os.OpenFile(*flagDebugBundleFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+		if err != nil {
+			log.Fatalf("error creating bundle: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	var names []string
+	add := func(name string, data []byte) {
+		data = []byte(redact(string(data)))
+		hdr := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: now, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			log.Fatalf("error writing bundle: %v", err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			log.Fatalf("error writing bundle: %v", err)
+		}
+		names = append(names, name)
+	}
+	addJSON := func(name string, v interface{}) {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			log.Fatalf("error encoding %s: %v", name, err)
+		}
+		add(name, append(data, '\n'))
+	}
+
+	addJSON("version.json", map[string]string{
+		"version":   buildVersion,
+		"commit":    buildCommit,
+		"goVersion": runtime.Version(),
+		"platform":  runtime.GOOS + "/" + runtime.GOARCH,
+		"profile":   *flagProfile,
+		"compat":    fmt.Sprint(*flagCompat),
+		"readOnly":  fmt.Sprint(*flagReadOnly),
+	})
+
+	// a broken session is often what the report is about, so it's noted
+	// instead of failing the bundle
+	if session, err := client.Session(); err != nil {
+		add("session-error.txt", []byte(err.Error()+"\n"))
+	} else {
+		addJSON("session.json", session)
+	}
+
+	entries, err := readJournal()
+	if err != nil {
+		add("journal-error.txt", []byte(err.Error()+"\n"))
+	} else if len(entries) > 0 {
+		if len(entries) > debugBundleJournalEntries {
+			entries = entries[len(entries)-debugBundleJournalEntries:]
+		}
+		var journal bytes.Buffer
+		for _, e := range entries {
+			// the arguments hold descriptions and domains, the action is
+			// enough to triage
+			e.Command = commandAction(e)
+			data, err := json.Marshal(e)
+			if err != nil {
+				log.Fatalf("error encoding journal: %v", err)
+			}
+			journal.Write(append(data, '\n'))
+		}
+		add(journalFileName, journal.Bytes())
+	}
+
+	if path, err := lastFailurePath(); err == nil {
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Fatalf("error reading %s: %v", lastFailureFileName, err)
+		}
+		if err == nil {
+			add(lastFailureFileName, data)
+		}
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		add("config-error.txt", []byte(err.Error()+"\n"))
+	} else {
+		cfg.withoutSecrets()
+		addJSON(configFileName, cfg)
+	}
+
+	if err := tw.Close(); err != nil {
+		log.Fatalf("error writing bundle: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		log.Fatalf("error writing bundle: %v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "wrote %s, please look through it before attaching it to a report\n", strings.Join(names, ", "))
+}
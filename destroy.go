@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// flags for destroy command
+var destroyCmd = flag.NewFlagSet(actionTypeDestroy, flag.ExitOnError)
+var flagDestroyYes = destroyCmd.Bool(flagNameYes, false, "don't ask for confirmation")
+
+// runDestroy permanently removes a masked email with the destroy argument of
+// MaskedEmail/set. A deleted masked email can still be enabled again, a
+// destroyed one is gone, so the address has to be typed to confirm.
+func runDestroy(client *pkg.Client, args []string) {
+	parseCommandFlags(destroyCmd, args)
+
+	maskedemail := maskedEmailArg(destroyCmd.Arg(0), fmt.Sprintf("%s [-%s] <maskedemail>", actionTypeDestroy, flagNameYes))
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	email, err := client.LookupMaskedEmail(session, *flagAccountID, maskedemail)
+	if err != nil {
+		log.Fatalf("error destroying masked email: %v", err)
+	}
+
+	if !*flagDestroyYes {
+		if !isTerminal(os.Stdin) {
+			log.Fatalf("refusing to destroy %s without confirmation, pass -%s", maskedemail, flagNameYes)
+		}
+		fmt.Fprintf(os.Stderr, "This permanently destroys %s, it can't be enabled again.\n", maskedemail)
+		if ask("Type the address to confirm", "") != maskedemail {
+			log.Fatalln("aborted")
+		}
+	}
+
+	if _, err := client.DestroyMaskedEmail(session, *flagAccountID, email.ID); err != nil {
+		log.Fatalf("error destroying masked email: %v", err)
+	}
+
+	appendJournal(journalEntry{Action: actionTypeDestroy, AccountID: accountIDOrDefault(session), ID: email.ID, Email: maskedemail})
+
+	r := newItemResult(maskedemail, actionTypeDestroy, nil).withContext(email)
+	if jsonOutput() {
+		printResults(os.Stdout, []itemResult{r}, true)
+		return
+	}
+
+	fmt.Println(r)
+}
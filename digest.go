@@ -0,0 +1,189 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+const formatHTML string = "html"
+
+// flags for digest command
+var digestCmd = flag.NewFlagSet(actionTypeDigest, flag.ExitOnError)
+var flagDigestSince = digestCmd.String(flagNameSince, "7d", "period to summarize, a window like 7d or 2w, an RFC3339 timestamp or a 2006-01-02 date")
+var flagDigestFormat = digestCmd.String(flagNameFormat, formatText, "output format ("+formatText+"|"+formatHTML+")")
+
+// digestItem is a masked email in a section of the digest.
+type digestItem struct {
+	Email       string
+	Domain      string
+	Description string
+	State       string
+}
+
+// digestReport is what happened to the masked emails of an account since a
+// point in time.
+type digestReport struct {
+	Since time.Time
+	// New are the masked emails created since.
+	New []digestItem
+	// Active are older masked emails that received mail since.
+	Active []digestItem
+	// Changed are the masked emails whose state or properties changed since,
+	// as told by the journal and the server, with their current state.
+	Changed []digestItem
+}
+
+func newDigestItem(email *pkg.MaskedEmail) digestItem {
+	return digestItem{
+		Email:       email.Email,
+		Domain:      strings.TrimSpace(email.Domain),
+		Description: strings.TrimSpace(email.Description),
+		State:       string(email.State),
+	}
+}
+
+// runDigest prints a compact summary of the masked emails created, active
+// and changed since -since, to pipe into mail or a chat webhook.
+func runDigest(client *pkg.Client, args []string) {
+	parseCommandFlags(digestCmd, args)
+
+	if *flagDigestFormat != formatText && *flagDigestFormat != formatHTML {
+		log.Fatalf("unsupported format %q (%s|%s)", *flagDigestFormat, formatText, formatHTML)
+	}
+
+	now := time.Now()
+	since, err := parseDigestSince(*flagDigestSince, now)
+	if err != nil {
+		log.Fatalf("invalid -%s: %v", flagNameSince, err)
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	emails, state, err := client.GetAllMaskedEmailsAndState(session, *flagAccountID)
+	if err != nil {
+		log.Fatalf("error fetching masked emails: %v", err)
+	}
+
+	entries, err := readJournal()
+	if err != nil {
+		log.Fatalf("error reading journal: %v", err)
+	}
+
+	report := computeDigest(emails, entries, changedIDsSince(client, session, since), accountIDOrDefault(session), since)
+	recordStateCheckpoint(state, now)
+
+	if *flagDigestFormat == formatHTML {
+		if err := digestHTML.Execute(os.Stdout, report); err != nil {
+			log.Fatalf("error rendering digest: %v", err)
+		}
+		return
+	}
+	printDigest(os.Stdout, report, now)
+}
+
+// parseDigestSince takes a window like 7d as well as the points in time of
+// parseSince.
+func parseDigestSince(s string, now time.Time) (time.Time, error) {
+	if d, err := parseWindow(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return parseSince(s)
+}
+
+// computeDigest sorts the masked emails into the sections of the digest.
+// changedIDs are the IDs the server reports as changed, nil if unknown.
+// Each masked email shows up in one section only, the first that applies.
+func computeDigest(emails []*pkg.MaskedEmail, entries []journalEntry, changedIDs map[string]bool, accID string, since time.Time) digestReport {
+	report := digestReport{Since: since}
+
+	// state changes made by the CLI, also of masked emails destroyed since
+	journaled := map[string]string{} // address to the last action on it
+	for _, e := range entries {
+		if e.Time.Before(since) || (e.AccountID != "" && e.AccountID != accID) {
+			continue
+		}
+		if _, ok := stateCommands[e.Action]; ok || e.Action == actionTypeDestroy {
+			journaled[e.Email] = e.Action
+		}
+	}
+
+	for _, email := range emails {
+		switch {
+		case !email.CreatedAt.Before(since):
+			report.New = append(report.New, newDigestItem(email))
+		case email.LastMessageAt != nil && !email.LastMessageAt.Before(since):
+			report.Active = append(report.Active, newDigestItem(email))
+		case journaled[email.Email] != "" || changedIDs[email.ID]:
+			report.Changed = append(report.Changed, newDigestItem(email))
+		}
+		delete(journaled, email.Email)
+	}
+
+	// what's left in the journal is gone from the server
+	for address, action := range journaled {
+		if action == actionTypeDestroy {
+			report.Changed = append(report.Changed, digestItem{Email: address, State: changeDestroyed})
+		}
+	}
+
+	for _, items := range [][]digestItem{report.New, report.Active, report.Changed} {
+		sort.Slice(items, func(i, j int) bool { return items[i].Email < items[j].Email })
+	}
+	return report
+}
+
+func printDigest(out io.Writer, report digestReport, now time.Time) {
+	fmt.Fprintf(out, "Masked emails since %s (%d days)\n", formatDisplayTime(&report.Since), int(now.Sub(report.Since).Hours()/24))
+
+	sections := []struct {
+		title string
+		items []digestItem
+	}{
+		{"New", report.New},
+		{"Received mail", report.Active},
+		{"Changed", report.Changed},
+	}
+	for _, section := range sections {
+		fmt.Fprintf(out, "\n%s (%d)\n", section.title, len(section.items))
+		if len(section.items) == 0 {
+			fmt.Fprintln(out, "  none")
+			continue
+		}
+
+		w := newTabWriter(out, 1)
+		for _, item := range section.items {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", item.Email, orDash(item.Domain), orDash(item.Description), item.State)
+		}
+		w.Flush()
+	}
+}
+
+// digestHTML renders the digest as an HTML fragment, without a document
+// around it, to embed in a mail or message.
+var digestHTML = template.Must(template.New("digest").Funcs(template.FuncMap{
+	"section": func(title string, items []digestItem) interface{} {
+		return struct {
+			Title string
+			Items []digestItem
+		}{title, items}
+	},
+}).Parse(`<h3>Masked emails since {{.Since.UTC.Format "2006-01-02 15:04 MST"}}</h3>
+{{template "section" (section "New" .New)}}{{template "section" (section "Received mail" .Active)}}{{template "section" (section "Changed" .Changed)}}
+{{- define "section"}}<h4>{{.Title}} ({{len .Items}})</h4>
+{{if .Items}}<ul>
+{{range .Items}}<li><code>{{.Email}}</code> {{.Domain}}{{if .Description}} ({{.Description}}){{end}}: {{.State}}</li>
+{{end}}</ul>
+{{else}}<p>none</p>
+{{end}}{{end}}`))
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// duplicateGroup are the active masked emails of a normalized domain. The
+// JSON field names are part of the output contract, don't rename them.
+type duplicateGroup struct {
+	Domain       string             `json:"domain"`
+	MaskedEmails []*pkg.MaskedEmail `json:"maskedEmails"`
+}
+
+// findDuplicates returns the normalized domains with more than one active
+// masked email, the most masked emails first. Within a domain the oldest
+// masked email comes first, usually the one to keep.
+func findDuplicates(emails []*pkg.MaskedEmail) []duplicateGroup {
+	byDomain := map[string][]*pkg.MaskedEmail{}
+	for _, email := range emails {
+		domain := pkg.NormalizeDomain(email.Domain)
+		if domain == "" || !email.State.IsActive() {
+			continue
+		}
+		byDomain[domain] = append(byDomain[domain], email)
+	}
+
+	groups := []duplicateGroup{}
+	for domain, emails := range byDomain {
+		if len(emails) < 2 {
+			continue
+		}
+		sort.SliceStable(emails, func(i, j int) bool {
+			return emails[i].CreatedAt.Before(emails[j].CreatedAt)
+		})
+		groups = append(groups, duplicateGroup{domain, emails})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i].MaskedEmails) != len(groups[j].MaskedEmails) {
+			return len(groups[i].MaskedEmails) > len(groups[j].MaskedEmails)
+		}
+		return groups[i].Domain < groups[j].Domain
+	})
+	return groups
+}
+
+// runDupes lists the domains with more than one active masked email, so they
+// can be consolidated.
+func runDupes(client *pkg.Client, args []string) {
+	if len(args) > 0 {
+		log.Fatalf("Usage: %s", actionTypeDupes)
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	maskedEmails, err := client.GetAllMaskedEmails(session, *flagAccountID)
+	if err != nil {
+		log.Fatalf("error fetching masked emails: %v", err)
+	}
+
+	groups := findDuplicates(maskedEmails)
+	if jsonOutput() {
+		printJSON(groups)
+		return
+	}
+	if len(groups) == 0 {
+		fmt.Fprintln(os.Stderr, "no domain has more than one active masked email")
+		return
+	}
+	writeDuplicates(os.Stdout, groups)
+}
+
+// writeDuplicates writes each domain with a line per masked email, aligned
+// across all domains so they can be compared side by side.
+func writeDuplicates(out io.Writer, groups []duplicateGroup) {
+	tw := newTabWriter(out, 1)
+	for _, group := range groups {
+		fmt.Fprintf(tw, "%s (%d)\n", group.Domain, len(group.MaskedEmails))
+		w := newTableWriter(tw, false, 2)
+		for _, email := range group.MaskedEmails {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n",
+				email.Email,
+				strings.TrimSpace(email.Description),
+				email.State,
+				formatDisplayTime(&email.CreatedAt),
+				formatDisplayTime(email.LastMessageAt),
+			)
+		}
+	}
+	tw.Flush()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// exitCodeExistsError is the exit code of exists when it can't tell, like
+// grep, so scripts don't take a failed lookup for a missing masked email.
+const exitCodeExistsError = 2
+
+// runExists exits 0 if an active masked email with the address, or for the
+// domain, exists and 1 otherwise, without printing anything. Domains compare
+// normalized, so "https://www.example.com/" matches "example.com".
+func runExists(client *pkg.Client, args []string) {
+	warnFlagLikeArgs(actionTypeExists, args)
+	if len(args) != 1 || strings.TrimSpace(args[0]) == "" {
+		existsFailed("Usage: %s <maskedemail|domain>", actionTypeExists)
+	}
+	arg := strings.TrimSpace(args[0])
+
+	var match func(email *pkg.MaskedEmail) bool
+	if strings.Contains(arg, "@") {
+		address, err := pkg.ParseMaskedAddress(arg)
+		if err != nil {
+			existsFailed("%v", err)
+		}
+		match = func(email *pkg.MaskedEmail) bool { return email.Email == address.Address }
+	} else {
+		domain := pkg.NormalizeDomain(arg)
+		match = func(email *pkg.MaskedEmail) bool { return pkg.NormalizeDomain(email.Domain) == domain }
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		existsFailed("initializing session: %v", err)
+	}
+
+	emails, err := client.GetAllMaskedEmails(session, *flagAccountID)
+	if err != nil {
+		existsFailed("error getting masked emails: %v", err)
+	}
+
+	for _, email := range emails {
+		if email.State.IsActive() && match(email) {
+			os.Exit(0)
+		}
+	}
+	os.Exit(1)
+}
+
+// existsFailed prints the error and exits with exitCodeExistsError.
+func existsFailed(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	os.Exit(exitCodeExistsError)
+}
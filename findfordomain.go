@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// findForDomainOrder ranks the states for find-for-domain, enabled masked
+// emails are preferred as they receive mail right away.
+var findForDomainOrder = map[pkg.MaskedEmailState]int{
+	pkg.MaskedEmailStateEnabled:  0,
+	pkg.MaskedEmailStatePending:  1,
+	pkg.MaskedEmailStateDisabled: 2,
+}
+
+// bestForDomain returns the existing masked email to use for the domain,
+// compared normalized, or nil if there is none. Enabled ones are preferred,
+// then the most recently used, then the most recently created. Deleted ones
+// are never returned.
+func bestForDomain(emails []*pkg.MaskedEmail, domain string) *pkg.MaskedEmail {
+	domain = pkg.NormalizeDomain(domain)
+
+	candidates := []*pkg.MaskedEmail{}
+	for _, email := range emails {
+		if _, ok := findForDomainOrder[email.State]; !ok || pkg.NormalizeDomain(email.Domain) != domain {
+			continue
+		}
+		candidates = append(candidates, email)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if findForDomainOrder[a.State] != findForDomainOrder[b.State] {
+			return findForDomainOrder[a.State] < findForDomainOrder[b.State]
+		}
+		aUsed := a.LastMessageAt != nil && !a.LastMessageAt.IsZero()
+		bUsed := b.LastMessageAt != nil && !b.LastMessageAt.IsZero()
+		if aUsed != bUsed {
+			return aUsed
+		}
+		if aUsed && !a.LastMessageAt.Equal(*b.LastMessageAt) {
+			return a.LastMessageAt.After(*b.LastMessageAt)
+		}
+		return a.CreatedAt.After(b.CreatedAt)
+	})
+	return candidates[0]
+}
+
+// runFindForDomain prints only the address of the best existing masked email
+// for the domain, for filling in signup forms from the shell. It exits 1 if
+// there is none.
+func runFindForDomain(client *pkg.Client, args []string) {
+	warnFlagLikeArgs(actionTypeFindForDomain, args)
+	if len(args) != 1 || pkg.NormalizeDomain(args[0]) == "" {
+		log.Fatalf("Usage: %s <domain>", actionTypeFindForDomain)
+	}
+	domain := strings.TrimSpace(args[0])
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	maskedEmails, err := client.GetAllMaskedEmails(session, *flagAccountID)
+	if err != nil {
+		log.Fatalf("error fetching masked emails: %v", err)
+	}
+
+	email := bestForDomain(maskedEmails, domain)
+	if email == nil {
+		fmt.Fprintf(os.Stderr, "no masked email for %s\n", pkg.NormalizeDomain(domain))
+		os.Exit(1)
+	}
+
+	if outputTemplate != nil {
+		printTemplate(email)
+		return
+	}
+	if jsonOutput() {
+		printJSON(email)
+		return
+	}
+	fmt.Println(email.Email)
+}
@@ -1,5 +1,3 @@
 module github.com/dvcrn/maskedemail-cli
 
 go 1.18
-
-require github.com/mitchellh/mapstructure v1.4.3
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// importActionSkip is the result action of masked emails import leaves out.
+const importActionSkip = "skip"
+
+// flags for import command
+var importCmd = flag.NewFlagSet(actionTypeImport, flag.ExitOnError)
+var flagImportFile = importCmd.String(flagNameFile, "", "backup of export or CSV of list -format csv to import, - for stdin (required)")
+var flagImportDryRun = importCmd.Bool(flagNameDryRun, false, "only show what would be created")
+
+// readImport reads the masked emails of a backup written by export, or of a
+// CSV file with a header row like the one of list -format csv. Of the CSV
+// only the email, forDomain (or domain), description (or desc), state and
+// url columns are read, forDomain is required.
+func readImport(r io.Reader) ([]*pkg.MaskedEmail, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var backup accountBackup
+		if err := json.Unmarshal(data, &backup); err != nil {
+			return nil, fmt.Errorf("parsing backup: %w", err)
+		}
+		if backup.Version < 1 || backup.Version > backupVersion {
+			return nil, fmt.Errorf("unsupported backup version %d, this version of %s reads up to %d", backup.Version, defaultAppname, backupVersion)
+		}
+		return backup.MaskedEmails, nil
+	}
+
+	cr := csv.NewReader(bytes.NewReader(data))
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parsing CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	index := map[string]int{}
+	for i, name := range records[0] {
+		name = strings.TrimSpace(name)
+		if alias, ok := columnAliases[strings.ToLower(name)]; ok {
+			name = alias
+		}
+		index[strings.ToLower(name)] = i
+	}
+	if _, ok := index["fordomain"]; !ok {
+		return nil, fmt.Errorf("parsing CSV: the header has no forDomain or domain column")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := index[strings.ToLower(name)]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	emails := []*pkg.MaskedEmail{}
+	for line, record := range records[1:] {
+		email := &pkg.MaskedEmail{
+			Email:       field(record, "email"),
+			Domain:      field(record, "forDomain"),
+			Description: field(record, "description"),
+			State:       pkg.MaskedEmailState(strings.ToLower(field(record, "state"))),
+		}
+		if email.State == "" {
+			email.State = pkg.MaskedEmailStateEnabled
+		}
+		if _, err := parseStates(string(email.State)); err != nil {
+			return nil, fmt.Errorf("parsing CSV: line %d: %v", line+2, err)
+		}
+		if url := field(record, "url"); url != "" {
+			email.URL = &url
+		}
+		emails = append(emails, email)
+	}
+	return emails, nil
+}
+
+// importKey identifies a masked email for import by its normalized domain
+// and description, the address changes when it's recreated.
+func importKey(email *pkg.MaskedEmail) string {
+	return pkg.NormalizeDomain(email.Domain) + "\x00" + strings.TrimSpace(email.Description)
+}
+
+// runImport recreates the masked emails of a backup or CSV in the account,
+// with their domain, description, url and state. Deleted ones and those
+// existing already, with the same address or the same domain and
+// description, are skipped, so an import can be run again after a failure.
+func runImport(client *pkg.Client, args []string) {
+	parseCommandFlags(importCmd, args)
+	if importCmd.NArg() > 0 || *flagImportFile == "" {
+		log.Fatalf("Usage: %s -%s <backup.json|aliases.csv|%s> [-%s]", actionTypeImport, flagNameFile, stdinArg, flagNameDryRun)
+	}
+
+	in := os.Stdin
+	if *flagImportFile != stdinArg {
+		f, err := os.Open(*flagImportFile)
+		if err != nil {
+			log.Fatalf("error opening import: %v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	imported, err := readImport(in)
+	if err != nil {
+		log.Fatalf("error reading %s: %v", *flagImportFile, err)
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+	accID := accountIDOrDefault(session)
+
+	existing, err := client.GetAllMaskedEmails(session, *flagAccountID)
+	if err != nil {
+		log.Fatalf("error fetching masked emails: %v", err)
+	}
+	byAddress := map[string]*pkg.MaskedEmail{}
+	byKey := map[string]*pkg.MaskedEmail{}
+	for _, email := range existing {
+		// looked up lowercased, the server's casing may differ from the file's
+		byAddress[strings.ToLower(email.Email)] = email
+		if email.State != pkg.MaskedEmailStateDeleted {
+			byKey[importKey(email)] = email
+		}
+	}
+
+	results := []itemResult{}
+	toDisable := map[string]pkg.MaskedEmailState{} // by created masked email ID
+	created := 0
+	for _, email := range imported {
+		domain := strings.TrimSpace(email.Domain)
+		description := strings.TrimSpace(email.Description)
+		skip := newItemResult(email.Email, importActionSkip, nil)
+		skip.Domain, skip.Description = domain, description
+
+		if email.State == pkg.MaskedEmailStateDeleted {
+			continue
+		}
+		if found, ok := byAddress[strings.ToLower(strings.TrimSpace(email.Email))]; ok && email.Email != "" {
+			skip.MaskedEmail = found
+			results = append(results, skip)
+			continue
+		}
+		if found, ok := byKey[importKey(email)]; ok {
+			skip.MaskedEmail = found
+			results = append(results, skip)
+			continue
+		}
+
+		if *flagImportDryRun {
+			fmt.Fprintf(os.Stderr, "dry run: would create a %s masked email for %s (%s)\n", email.State, domain, description)
+			continue
+		}
+
+		createdEmail, err := recreateMaskedEmail(client, session, accID, email)
+		if err != nil {
+			r := newItemResult(email.Email, actionTypeCreate, err)
+			r.Domain, r.Description = domain, description
+			results = append(results, r)
+			continue
+		}
+		byKey[importKey(createdEmail)] = createdEmail
+
+		r := newItemResult(createdEmail.Email, actionTypeCreate, nil)
+		r.Domain, r.Description = domain, description
+		r.MaskedEmail = createdEmail
+		results = append(results, r)
+		created++
+		if email.State == pkg.MaskedEmailStateDisabled {
+			toDisable[createdEmail.ID] = pkg.MaskedEmailStateDisabled
+		}
+	}
+	applyStates(client, session, accID, results, toDisable)
+
+	if !jsonOutput() {
+		for i := range results {
+			results[i].MaskedEmail = nil
+		}
+	}
+	exitOnFailedResults(results, jsonOutput())
+	if !jsonOutput() {
+		fmt.Fprintf(os.Stderr, "created %d, skipped %d existing masked email(s)\n", created, countResults(results, importActionSkip))
+	}
+}
+
+// recreateMaskedEmail creates a masked email with the domain, description
+// and url of one from a backup, enabled unless it was pending, and journals
+// it. The address is a new one, addresses can't be recreated.
+func recreateMaskedEmail(client *pkg.Client, session pkg.Session, accID string, email *pkg.MaskedEmail) (*pkg.MaskedEmail, error) {
+	domain := strings.TrimSpace(email.Domain)
+	description := strings.TrimSpace(email.Description)
+	url := ""
+	if email.URL != nil {
+		url = *email.URL
+	}
+	enabled := email.State != pkg.MaskedEmailStatePending
+
+	startedAt := time.Now()
+	created, err := client.CreateMaskedEmail(session, accID, domain, enabled, description, url)
+	if err != nil && isAmbiguousError(err) {
+		created, err = recoverAmbiguousCreate(client, session, accID, startedAt, domain, description, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	created = completeCreated(created, domain, description, url, enabled)
+
+	appendJournal(journalEntry{
+		Action:      actionTypeCreate,
+		AccountID:   accID,
+		ID:          created.ID,
+		Email:       created.Email,
+		Domain:      domain,
+		Description: description,
+	})
+	return created, nil
+}
+
+// applyStates sets the masked emails of the results to the states given by
+// their ID, with one request per state. A failed change replaces the result
+// with the failure, a created masked email keeps saying so.
+func applyStates(client *pkg.Client, session pkg.Session, accID string, results []itemResult, states map[string]pkg.MaskedEmailState) {
+	byState := map[pkg.MaskedEmailState][]string{}
+	for id, state := range states {
+		byState[state] = append(byState[state], id)
+	}
+
+	for state, ids := range byState {
+		action := actionTypeEnable
+		if state == pkg.MaskedEmailStateDisabled {
+			action = actionTypeDisable
+		}
+
+		_, notUpdated, err := client.SetMaskedEmailStates(session, accID, ids, state)
+		for i := range results {
+			email := results[i].MaskedEmail
+			if email == nil || states[email.ID] != state {
+				continue
+			}
+			failed := err
+			if setErr, ok := notUpdated[email.ID]; ok {
+				failed = &setErr
+			}
+			if failed != nil {
+				if results[i].Action == actionTypeCreate {
+					failed = fmt.Errorf("created, but %s failed: %v", stateCommands[action].gerund, failed)
+				}
+				results[i] = newItemResult(email.Email, action, failed)
+				continue
+			}
+			email.State = state
+			appendJournal(journalEntry{Action: action, AccountID: accID, ID: email.ID, Email: email.Email})
+		}
+	}
+}
+
+// countResults returns the number of successful results of the action.
+func countResults(results []itemResult, action string) int {
+	n := 0
+	for _, r := range results {
+		if r.OK && r.Action == action {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+)
+
+// exitCodeInterrupted is the exit code after SIGINT/SIGTERM, the shell
+// convention of 128 + SIGINT.
+const exitCodeInterrupted = 130
+
+var (
+	// interrupted is set to 1 once a signal was received.
+	interrupted int32
+
+	// criticalMu is held while writing local state (e.g. the journal), so an
+	// interrupt waits for the write to finish instead of leaving half a line.
+	criticalMu sync.Mutex
+
+	// cleanupMu guards cleanups, the hooks run before exiting on a signal,
+	// keyed by registration order.
+	cleanupMu   sync.Mutex
+	cleanups    = map[int]func(){}
+	lastCleanup int
+)
+
+// handleInterrupts traps SIGINT and SIGTERM. On a signal the returned
+// context is cancelled, aborting in-flight requests, pending local writes are
+// finished, the hooks registered with onInterrupt run (restoring the terminal,
+// flushing buffered tables) and the process exits with exitCodeInterrupted.
+// A second signal kills the process right away.
+func handleInterrupts() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	// errors caused by the cancellation are not the real outcome, don't let
+	// a log.Fatal race the interrupt with a misleading message and exit code
+	log.SetOutput(interruptibleWriter{os.Stderr})
+
+	go func() {
+		<-signals
+		signal.Reset(os.Interrupt, syscall.SIGTERM)
+
+		atomic.StoreInt32(&interrupted, 1)
+		cancel()
+
+		criticalMu.Lock()
+		runCleanups()
+
+		fmt.Fprintln(os.Stderr, "interrupted")
+		if action != actionTypeUnknown && isMutatingCommand(action, args[1:]) {
+			fmt.Fprintf(os.Stderr, "a change in flight may or may not have been applied, check with `%s`\n", actionTypeList)
+		}
+		os.Exit(exitCodeInterrupted)
+	}()
+
+	return ctx
+}
+
+// onInterrupt registers f to run before the process exits on a signal, for
+// state a deferred call would restore on the normal path. os.Exit skips
+// deferred calls, so without it e.g. a hidden prompt would leave the terminal
+// echo off. The returned function unregisters f again.
+func onInterrupt(f func()) (remove func()) {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+
+	lastCleanup++
+	id := lastCleanup
+	cleanups[id] = f
+
+	return func() {
+		cleanupMu.Lock()
+		defer cleanupMu.Unlock()
+
+		delete(cleanups, id)
+	}
+}
+
+// runCleanups runs the registered hooks, the most recent first.
+func runCleanups() {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+
+	for id := lastCleanup; id > 0; id-- {
+		if f, ok := cleanups[id]; ok {
+			f()
+		}
+	}
+}
+
+// uninterrupted runs f without an interrupt cutting it short.
+func uninterrupted(f func()) {
+	criticalMu.Lock()
+	defer criticalMu.Unlock()
+
+	f()
+}
+
+// interruptibleWriter passes writes through, but blocks forever once
+// interrupted, so the interrupt handler decides how to exit.
+type interruptibleWriter struct {
+	w io.Writer
+}
+
+func (iw interruptibleWriter) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&interrupted) == 1 {
+		select {}
+	}
+	return iw.w.Write(p)
+}
+
+// tableWriter is a tabwriter that flushes its buffered rows when the process
+// is interrupted, so a partial table is still printed instead of being lost.
+type tableWriter struct {
+	mu     sync.Mutex
+	tw     *tabwriter.Writer
+	remove func()
+}
+
+func newTabWriter(out io.Writer, padding int) *tableWriter {
+	return &tableWriter{tw: tabwriter.NewWriter(out, 1, 1, padding, ' ', 0)}
+}
+
+func (w *tableWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.remove == nil {
+		w.remove = onInterrupt(w.flushPending)
+	}
+	return w.tw.Write(p)
+}
+
+func (w *tableWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.remove != nil {
+		w.remove()
+		w.remove = nil
+	}
+	return w.tw.Flush()
+}
+
+// flushPending flushes from the interrupt handler. A write blocked on the
+// output holds the lock, skip the flush then rather than hang the exit.
+func (w *tableWriter) flushPending() {
+	if w.mu.TryLock() {
+		w.tw.Flush()
+		w.mu.Unlock()
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+const (
+	envStateDirVarName string = "MASKEDEMAIL_STATE_DIR"
+
+	journalFileName string = "journal.jsonl"
+
+	// sessionCacheDir is below the state directory and, like the address
+	// cache, left out of state bundles.
+	sessionCacheDir string = "sessions"
+	// sessionCacheTTL is how long a fetched session is used for, it's
+	// fetched again earlier if the server reports a change.
+	sessionCacheTTL = time.Hour
+)
+
+// journalEntry is a single mutation performed by the CLI. The journal is an
+// append-only JSON Lines file in the state directory.
+type journalEntry struct {
+	Time           time.Time `json:"time"`
+	Action         string    `json:"action"`
+	AccountID      string    `json:"accountId,omitempty"`
+	ID             string    `json:"id,omitempty"`
+	Email          string    `json:"email"`
+	Domain         string    `json:"forDomain,omitempty"`
+	Description    string    `json:"description,omitempty"`
+	IdempotencyKey string    `json:"idempotencyKey,omitempty"`
+	// where and how the change was made, which Fastmail doesn't record
+	Host    string `json:"host,omitempty"`
+	Profile string `json:"profile,omitempty"`
+	Command string `json:"command,omitempty"`
+}
+
+// stateDir returns the directory for local state (journal, notes, ...):
+// $MASKEDEMAIL_STATE_DIR, or maskedemail-cli inside $XDG_STATE_HOME
+// (default ~/.local/state).
+func stateDir() (string, error) {
+	if dir := os.Getenv(envStateDirVarName); dir != "" {
+		return dir, nil
+	}
+
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, defaultAppname), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".local", "state", defaultAppname), nil
+}
+
+func journalPath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, journalFileName), nil
+}
+
+// appendJournal records a mutation in the journal. Failing to write the
+// journal never fails the command itself, only a warning is printed.
+func appendJournal(entry journalEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	if entry.Host == "" {
+		entry.Host, _ = os.Hostname()
+	}
+	if entry.Profile == "" {
+		entry.Profile = *flagProfile
+	}
+	if entry.Command == "" {
+		entry.Command = commandLine(os.Args)
+	}
+
+	var err error
+	uninterrupted(func() { err = writeJournalEntry(entry) })
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not write journal: %v\n", err)
+	}
+}
+
+func writeJournalEntry(entry journalEntry) error {
+	path, err := journalPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// readJournal returns all journal entries, oldest first. A missing journal
+// is not an error.
+func readJournal() ([]journalEntry, error) {
+	path, err := journalPath()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []journalEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// skip lines truncated by an interrupted write
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// commandLine formats the arguments for the journal, quoted for a shell and
+// with the value of -token left out.
+func commandLine(args []string) string {
+	words := make([]string, 0, len(args))
+	redactNext := false
+	for i, arg := range args {
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		isToken := i > 0 && strings.HasPrefix(arg, "-") && name == flagNameToken
+
+		switch {
+		case i == 0:
+			arg = filepath.Base(arg)
+		case redactNext:
+			arg = "***"
+		case isToken && strings.Contains(arg, "="):
+			arg = arg[:strings.Index(arg, "=")+1] + "***"
+		}
+		redactNext = isToken && !strings.Contains(arg, "=")
+
+		words = append(words, shellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// shellQuote single-quotes a word if a shell would otherwise split or expand
+// it.
+func shellQuote(word string) string {
+	if word != "" && strings.Trim(word, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.,:/@=+%") == "" {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// findCreate returns the journal entry of the create of a masked email, if
+// it was created by this CLI with this state directory.
+func findCreate(email *pkg.MaskedEmail) (*journalEntry, error) {
+	entries, err := readJournal()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.Action != actionTypeCreate {
+			continue
+		}
+		if e.ID == email.ID || (e.ID == "" && e.Email == email.Email) {
+			return &e, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// findIdempotentCreate returns the journal entry of a create made with the
+// given idempotency key in the given account, if any.
+func findIdempotentCreate(accID string, key string) (*journalEntry, error) {
+	entries, err := readJournal()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.Action == actionTypeCreate && e.IdempotencyKey == key && e.AccountID == accID {
+			return &e, nil
+		}
+	}
+
+	return nil, nil
+}
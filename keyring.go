@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// keyringService is the service the token is stored under in the system
+	// keyring, each profile is an account of it.
+	keyringService string = defaultAppname
+
+	defaultKeyringAccount string = "default"
+)
+
+var errKeyringNotFound = errors.New("no token in the keyring")
+
+// keyring stores secrets in the keyring of the operating system: the macOS
+// Keychain, the Windows Credential Manager or the Secret Service on Linux and
+// BSD. get and delete return errKeyringNotFound for a missing account.
+type keyring interface {
+	get(account string) (string, error)
+	set(account string, secret string) error
+	delete(account string) error
+}
+
+// keyringAccount returns the keyring account of a profile.
+func keyringAccount(profile string) string {
+	if profile == "" {
+		return defaultKeyringAccount
+	}
+	return profile
+}
+
+// keyringProvider fetches the token from the system keyring.
+type keyringProvider struct {
+	account string
+}
+
+func newKeyringProvider(src secretSource) (secretProvider, error) {
+	account := strings.TrimSpace(src.Account)
+	if account == "" {
+		account = defaultKeyringAccount
+	}
+	return &keyringProvider{account: account}, nil
+}
+
+func (p *keyringProvider) fetchSecret(ctx context.Context) (string, error) {
+	k, err := systemKeyring()
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := k.get(p.account)
+	if errors.Is(err, errKeyringNotFound) {
+		return "", fmt.Errorf("no token for %q in the keyring, store one with `%s %s %s`", p.account, defaultAppname, actionTypeAuth, authSubcommandSetToken)
+	}
+	return secret, err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// exitCodeItemNotFound is the exit code of security(1) for a missing item.
+const exitCodeItemNotFound = 44
+
+// securityKeyring uses the login Keychain through security(1).
+type securityKeyring struct{}
+
+func systemKeyring() (keyring, error) {
+	return securityKeyring{}, nil
+}
+
+func (securityKeyring) get(account string) (string, error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command("security", "find-generic-password", "-s", keyringService, "-a", account, "-w")
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return "", securityError(err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func (securityKeyring) set(account string, secret string) error {
+	if strings.ContainsAny(secret, "'\n") {
+		return errors.New("the token contains characters the keychain can't take")
+	}
+
+	// the interactive mode reads the command from stdin, so the secret
+	// doesn't show up in the process list
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s '%s' -a '%s' -w '%s'\n", keyringService, account, secret))
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return securityError(err)
+	}
+	return nil
+}
+
+func (securityKeyring) delete(account string) error {
+	cmd := exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", account)
+	if err := cmd.Run(); err != nil {
+		return securityError(err)
+	}
+	return nil
+}
+
+func securityError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == exitCodeItemNotFound {
+		return errKeyringNotFound
+	}
+	return fmt.Errorf("security: %v", err)
+}
@@ -0,0 +1,64 @@
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// secretToolKeyring uses the Secret Service (GNOME Keyring, KWallet) through
+// secret-tool from libsecret.
+type secretToolKeyring struct{}
+
+func systemKeyring() (keyring, error) {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return nil, errors.New("secret-tool not found, install libsecret-tools (or your distribution's package of it)")
+	}
+	return secretToolKeyring{}, nil
+}
+
+func (secretToolKeyring) get(account string) (string, error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", keyringService, "account", account)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+
+	// lookup exits with 1 and prints nothing for a missing secret
+	var exitErr *exec.ExitError
+	if stdout.Len() == 0 && (err == nil || errors.As(err, &exitErr)) {
+		return "", errKeyringNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("secret-tool: %v", err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func (secretToolKeyring) set(account string, secret string) error {
+	cmd := exec.Command("secret-tool", "store", "--label", fmt.Sprintf("%s (%s)", keyringService, account), "service", keyringService, "account", account)
+	cmd.Stdin = strings.NewReader(secret)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("secret-tool: %v", err)
+	}
+	return nil
+}
+
+func (k secretToolKeyring) delete(account string) error {
+	if _, err := k.get(account); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("secret-tool", "clear", "service", keyringService, "account", account)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("secret-tool: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,631 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+const (
+	sortKeyIdle      string = "idle"
+	sortKeyAge       string = "age"
+	sortKeyCreated   string = "created"
+	sortKeyLastEmail string = "last-email"
+	sortKeyDomain    string = "domain"
+	sortKeyEmail     string = "email"
+	sortKeyState     string = "state"
+
+	groupByDomain string = "domain"
+)
+
+// sortKeys are the values of list -sort.
+var sortKeys = []string{sortKeyCreated, sortKeyLastEmail, sortKeyDomain, sortKeyEmail, sortKeyState, sortKeyIdle, sortKeyAge}
+
+// stateOrder ranks the states for -sort state, from active to deleted.
+var stateOrder = map[pkg.MaskedEmailState]int{
+	pkg.MaskedEmailStatePending:  0,
+	pkg.MaskedEmailStateEnabled:  1,
+	pkg.MaskedEmailStateDisabled: 2,
+	pkg.MaskedEmailStateDeleted:  3,
+}
+
+// flags for list command
+var listCmd = flag.NewFlagSet(actionTypeList, flag.ExitOnError)
+var flagShowDeleted = listCmd.Bool(flagNameShowDeleted, false, "show deleted masked emails (true|false) (default false)")
+var flagShowAllFields = listCmd.Bool(flagNameShowAllFields, false, "show all masked email fields (true|false) (default false)")
+var flagListDesc = listCmd.String(flagNameDesc, "", "only show masked emails whose description contains this text, ignoring case")
+var flagListTag = listCmd.String(flagNameTag, "", "only show masked emails with this #tag in the description")
+var flagListFormat = listCmd.String(flagNameFormat, formatText, "output format ("+formatText+"|"+formatCSV+"|"+formatJSON+"|"+formatJSONL+"), "+formatJSONL+" prints one JSON object per line")
+var flagListWhere = listCmd.String(flagNameWhere, "", "only show masked emails matching this expression, e.g. 'state == \"enabled\" && lastMessageAt == null'")
+var flagListView = listCmd.String(flagNameView, "", "apply the list arguments saved under this name in the views of the config")
+var flagListChangedSince = listCmd.String(flagNameChangedSince, "", "only show masked emails created, active or changed within this window, e.g. 7d")
+var flagListState = newStringsFlag(listCmd, flagNameState, "only show masked emails in these states, comma separated or repeated (default: all but deleted)")
+var flagListDomain = listCmd.String(flagNameDomain, "", "only show masked emails for this domain or its subdomains, or matching a pattern like '*.example.*'")
+var flagListCreatedSince = listCmd.String(flagNameCreatedSince, "", "only show masked emails created at or after this time, e.g. 2024-01-01")
+var flagListCreatedBefore = listCmd.String(flagNameCreatedBefore, "", "only show masked emails created before this time, e.g. 2024-07-01")
+var flagListLastEmailBefore = listCmd.String(flagNameLastEmailBefore, "", "only show masked emails whose last email arrived before this time, e.g. 2023-01-01")
+var flagListRegex = listCmd.String(flagNameRegex, "", "only show masked emails whose domain or description matches this regular expression, e.g. '(?i)newsletter|promo'")
+var flagListNeverUsed = listCmd.Bool(flagNameNeverUsed, false, "only show masked emails that never received an email, with -"+flagNameLastEmailBefore+" show those too")
+var flagListSort = listCmd.String(flagNameSort, "", "sort by "+strings.Join(sortKeys, "|")+", "+sortKeyIdle+" and "+sortKeyAge+" put the longest unused and oldest first")
+var flagListColumns = listCmd.String(flagNameColumns, "", "comma separated columns to show instead of the default ones, e.g. email,domain,state,lastMessageAt")
+var flagListNoHeader = listCmd.Bool(flagNameNoHeader, false, "leave out the header line of the table or CSV")
+var flagListPlain = listCmd.Bool(flagNamePlain, false, "print only the addresses, one per line")
+var flagListGroupBy = listCmd.String(flagNameGroupBy, "", "group the masked emails under their "+groupByDomain+", largest groups first")
+var flagListReverse = listCmd.Bool(flagNameReverse, false, "reverse the order")
+
+// stringsFlag is a flag that can be repeated, each value is appended.
+type stringsFlag []string
+
+// newStringsFlag defines a stringsFlag in the flag set.
+func newStringsFlag(set *flag.FlagSet, name string, usage string) *stringsFlag {
+	f := &stringsFlag{}
+	set.Var(f, name, usage)
+	return f
+}
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// daysSince returns the number of full days between the timestamp and now.
+// ok is false if the timestamp is unset, e.g. for a masked email that never
+// received mail.
+func daysSince(t *time.Time, now time.Time) (days int, ok bool) {
+	if t == nil || t.IsZero() {
+		return 0, false
+	}
+
+	return int(now.Sub(*t).Hours() / 24), true
+}
+
+// formatTimestamp renders a timestamp in the API's RFC3339 UTC format, or
+// an empty string if it's unset. Machine formats always use it, whatever the
+// display settings, so parsers don't break; see formatDisplayTime for text.
+func formatTimestamp(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// formatDays renders a daysSince result for the table.
+func formatDays(days int, ok bool) string {
+	if !ok {
+		return "never"
+	}
+	return strconv.Itoa(days)
+}
+
+// sortDays returns a sort value for daysSince where a missing timestamp
+// counts as longer ago than any other.
+func sortDays(t *time.Time, now time.Time) int {
+	days, ok := daysSince(t, now)
+	if !ok {
+		return math.MaxInt32
+	}
+	return days
+}
+
+// sortMaskedEmails sorts by one of the sortKeys, keeping the server order
+// of equal ones. created and last-email are oldest first, with never used
+// masked emails first for last-email.
+func sortMaskedEmails(emails []*pkg.MaskedEmail, key string, now time.Time) {
+	switch key {
+	case sortKeyCreated:
+		sort.SliceStable(emails, func(i, j int) bool {
+			return emails[i].CreatedAt.Before(emails[j].CreatedAt)
+		})
+	case sortKeyLastEmail:
+		sort.SliceStable(emails, func(i, j int) bool {
+			a, b := emails[i].LastMessageAt, emails[j].LastMessageAt
+			if b == nil || b.IsZero() {
+				return false
+			}
+			return a == nil || a.IsZero() || a.Before(*b)
+		})
+	case sortKeyDomain:
+		sort.SliceStable(emails, func(i, j int) bool {
+			return pkg.NormalizeDomain(emails[i].Domain) < pkg.NormalizeDomain(emails[j].Domain)
+		})
+	case sortKeyEmail:
+		sort.SliceStable(emails, func(i, j int) bool {
+			return emails[i].Email < emails[j].Email
+		})
+	case sortKeyState:
+		sort.SliceStable(emails, func(i, j int) bool {
+			return stateOrder[emails[i].State] < stateOrder[emails[j].State]
+		})
+	case sortKeyIdle:
+		sort.SliceStable(emails, func(i, j int) bool {
+			return sortDays(emails[i].LastMessageAt, now) > sortDays(emails[j].LastMessageAt, now)
+		})
+	case sortKeyAge:
+		sort.SliceStable(emails, func(i, j int) bool {
+			return sortDays(&emails[i].CreatedAt, now) > sortDays(&emails[j].CreatedAt, now)
+		})
+	}
+}
+
+// listWhere is the compiled -where expression, nil if none is given.
+var listWhere *whereFilter
+
+// listColumnNames are the -columns, nil if none are given.
+var listColumnNames []string
+
+// listStates are the states list shows, from -state and -show-deleted.
+var listStates map[pkg.MaskedEmailState]bool
+
+// listDomain is the -domain filter, nil if none is given.
+var listDomain func(domain string) bool
+
+// domainMatcher returns a filter for domains matching the pattern, compared
+// normalized. A pattern with *, ? or [ is a glob over the whole domain, any
+// other matches the domain and its subdomains, so "example.com" includes
+// "shop.example.com" but not "myexample.com".
+func domainMatcher(pattern string) (func(domain string) bool, error) {
+	pattern = pkg.NormalizeDomain(pattern)
+	if pattern == "" {
+		return nil, errors.New("empty domain")
+	}
+
+	if strings.ContainsAny(pattern, "*?[") {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q", pattern)
+		}
+		return func(domain string) bool {
+			ok, _ := path.Match(pattern, pkg.NormalizeDomain(domain))
+			return ok
+		}, nil
+	}
+
+	return func(domain string) bool {
+		domain = pkg.NormalizeDomain(domain)
+		return domain == pattern || strings.HasSuffix(domain, "."+pattern)
+	}, nil
+}
+
+// listCreatedSince and listCreatedBefore are the -created-since and
+// -created-before bounds, zero if not given.
+var listCreatedSince, listCreatedBefore time.Time
+
+// listLastEmailBefore is the -last-email-before bound, zero if not given.
+var listLastEmailBefore time.Time
+
+// unusedSince reports whether the masked email is stale by -last-email-before
+// and -never-used. Passing both shows the masked emails matching either.
+func unusedSince(email *pkg.MaskedEmail) bool {
+	used := email.LastMessageAt != nil && !email.LastMessageAt.IsZero()
+	if *flagListNeverUsed && !used {
+		return true
+	}
+	if !listLastEmailBefore.IsZero() {
+		return used && email.LastMessageAt.Before(listLastEmailBefore)
+	}
+	return !*flagListNeverUsed
+}
+
+// listRegex is the compiled -regex, nil if none is given.
+var listRegex *regexp.Regexp
+
+// listChangedSince is the -changed-since filter, nil if none is given.
+var listChangedSince func(email *pkg.MaskedEmail) bool
+
+// listed reports whether the masked email passes the filters of the list
+// flags.
+func listed(email *pkg.MaskedEmail) bool {
+	if !listStates[email.State] {
+		return false
+	}
+
+	if listDomain != nil && !listDomain(email.Domain) {
+		return false
+	}
+
+	if *flagListDesc != "" && !strings.Contains(strings.ToLower(email.Description), strings.ToLower(*flagListDesc)) {
+		return false
+	}
+
+	if *flagListTag != "" && !hasTag(email.Description, *flagListTag) {
+		return false
+	}
+
+	if listRegex != nil && !regexMatches(email, listRegex) {
+		return false
+	}
+
+	if !listCreatedSince.IsZero() && email.CreatedAt.Before(listCreatedSince) {
+		return false
+	}
+	if !listCreatedBefore.IsZero() && !email.CreatedAt.Before(listCreatedBefore) {
+		return false
+	}
+
+	if !unusedSince(email) {
+		return false
+	}
+
+	if listChangedSince != nil && !listChangedSince(email) {
+		return false
+	}
+
+	if listWhere != nil {
+		ok, err := listWhere.match(email)
+		if err != nil {
+			log.Fatalf("invalid -%s for %s: %v", flagNameWhere, email.Email, err)
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// csvHeader are the columns of `list -format csv`, named like the API
+// properties.
+var csvHeader = []string{"email", "forDomain", "description", "state", "id", "url", "createdBy", "createdAt", "lastMessageAt"}
+
+// writeCSV writes the given columns of the masked emails as RFC 4180 CSV
+// (CRLF line endings, fields quoted as needed), with a header row if header
+// is set.
+func writeCSV(out io.Writer, emails []*pkg.MaskedEmail, columns []string, header bool, now time.Time) error {
+	w := csv.NewWriter(out)
+	w.UseCRLF = true
+
+	if header {
+		if err := w.Write(columns); err != nil {
+			return err
+		}
+	}
+
+	for _, email := range emails {
+		record := make([]string, len(columns))
+		for i, name := range columns {
+			record[i] = listColumns[name].value(email, now, true)
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// compileListFilters checks the filter flags of list and sets up listed. It
+// returns the -changed-since window, 0 if none is given.
+func compileListFilters(now time.Time) time.Duration {
+	states, err := parseStates(flagListState.String())
+	if err != nil {
+		log.Fatalf("invalid -%s: %v", flagNameState, err)
+	}
+	// deleted masked emails are only shown when asked for
+	if *flagShowDeleted {
+		states[pkg.MaskedEmailStateDeleted] = true
+	}
+	listStates = states
+	if *flagListDomain != "" {
+		match, err := domainMatcher(*flagListDomain)
+		if err != nil {
+			log.Fatalf("invalid -%s: %v", flagNameDomain, err)
+		}
+		listDomain = match
+	}
+	if *flagListRegex != "" {
+		if listRegex, err = compileRegex(*flagListRegex); err != nil {
+			log.Fatalf("invalid -%s: %v", flagNameRegex, err)
+		}
+	}
+
+	if *flagListWhere != "" {
+		filter, err := compileWhere(*flagListWhere, now)
+		if err != nil {
+			log.Fatalf("invalid -%s: %v", flagNameWhere, err)
+		}
+		listWhere = filter
+	}
+	if *flagListCreatedSince != "" {
+		if listCreatedSince, err = parseSince(*flagListCreatedSince); err != nil {
+			log.Fatalf("invalid -%s: %v", flagNameCreatedSince, err)
+		}
+	}
+	if *flagListCreatedBefore != "" {
+		if listCreatedBefore, err = parseSince(*flagListCreatedBefore); err != nil {
+			log.Fatalf("invalid -%s: %v", flagNameCreatedBefore, err)
+		}
+	}
+	if *flagListLastEmailBefore != "" {
+		if listLastEmailBefore, err = parseSince(*flagListLastEmailBefore); err != nil {
+			log.Fatalf("invalid -%s: %v", flagNameLastEmailBefore, err)
+		}
+	}
+	var window time.Duration
+	if *flagListChangedSince != "" {
+		window, err = parseWindow(*flagListChangedSince)
+		if err != nil {
+			log.Fatalf("invalid -%s: %v", flagNameChangedSince, err)
+		}
+	}
+	return window
+}
+func runList(client *pkg.Client, args []string) {
+	// parse command-specific args, the config may set a default view
+	listCmd.Parse(append(commandFlagArgs(listCmd), args...))
+	if *flagListView != "" {
+		view, err := viewArgs(*flagListView)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		// the command line comes last, so its flags win over the view
+		args = append(view, args...)
+	}
+	// -state appends, start over as everything is parsed again
+	*flagListState = nil
+	parseCommandFlags(listCmd, args)
+
+	if *flagListSort != "" {
+		known := false
+		for _, key := range sortKeys {
+			known = known || key == *flagListSort
+		}
+		if !known {
+			log.Fatalf("unsupported sort key %q (%s)", *flagListSort, strings.Join(sortKeys, "|"))
+		}
+	}
+	if jsonOutput() && *flagListFormat != formatJSONL {
+		*flagListFormat = formatJSON
+	}
+	switch *flagListFormat {
+	case formatText, formatCSV, formatJSON, formatJSONL:
+	default:
+		log.Fatalf("unsupported format %q (%s|%s|%s|%s)", *flagListFormat, formatText, formatCSV, formatJSON, formatJSONL)
+	}
+	if outputTemplate != nil && *flagListFormat != formatText {
+		log.Fatalf("-%s can't be combined with -%s %s", flagNameTemplate, flagNameFormat, *flagListFormat)
+	}
+	if *flagListPlain {
+		switch {
+		case outputTemplate != nil:
+			log.Fatalf("-%s can't be combined with -%s", flagNamePlain, flagNameTemplate)
+		case *flagListFormat != formatText:
+			log.Fatalf("-%s can't be combined with -%s %s", flagNamePlain, flagNameFormat, *flagListFormat)
+		case *flagShowAllFields || *flagListColumns != "":
+			log.Fatalf("-%s can't be combined with -%s or -%s", flagNamePlain, flagNameShowAllFields, flagNameColumns)
+		}
+	}
+	if *flagListGroupBy != "" {
+		switch {
+		case *flagListGroupBy != groupByDomain:
+			log.Fatalf("unsupported -%s %q (%s)", flagNameGroupBy, *flagListGroupBy, groupByDomain)
+		case outputTemplate != nil || *flagListFormat != formatText:
+			log.Fatalf("-%s only supports the text table", flagNameGroupBy)
+		case *flagListPlain || *flagShowAllFields || *flagListColumns != "":
+			log.Fatalf("-%s can't be combined with -%s, -%s or -%s", flagNameGroupBy, flagNamePlain, flagNameShowAllFields, flagNameColumns)
+		}
+	}
+	if *flagListColumns != "" {
+		switch {
+		case outputTemplate != nil:
+			log.Fatalf("-%s can't be combined with -%s", flagNameColumns, flagNameTemplate)
+		case *flagListFormat == formatJSON || *flagListFormat == formatJSONL:
+			log.Fatalf("-%s can't be combined with -%s %s", flagNameColumns, flagNameFormat, *flagListFormat)
+		case *flagShowAllFields:
+			log.Fatalf("-%s can't be combined with -%s", flagNameColumns, flagNameShowAllFields)
+		}
+		columns, err := parseColumns(*flagListColumns)
+		if err != nil {
+			log.Fatalf("invalid -%s: %v", flagNameColumns, err)
+		}
+		listColumnNames = columns
+	}
+
+	now := time.Now()
+	window := compileListFilters(now)
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	if window > 0 {
+		listChangedSince = changedSinceFilter(client, session, now.Add(-window))
+	}
+
+	// JSON Lines are written while the response is read, only sorting has
+	// to wait for all masked emails
+	if *flagListFormat == formatJSONL && *flagListSort == "" && !*flagListReverse {
+		streamJSONLines(client, session, now)
+		return
+	}
+
+	maskedEmails, state, err := client.GetAllMaskedEmailsAndState(session, *flagAccountID)
+	if err != nil {
+		log.Fatalf("err while creating maskedemail: %v", err)
+	}
+	recordStateCheckpoint(state, now)
+
+	sortMaskedEmails(maskedEmails, *flagListSort, now)
+	if *flagListReverse {
+		for i, j := 0, len(maskedEmails)-1; i < j; i, j = i+1, j-1 {
+			maskedEmails[i], maskedEmails[j] = maskedEmails[j], maskedEmails[i]
+		}
+	}
+
+	filtered := []*pkg.MaskedEmail{}
+	for _, email := range maskedEmails {
+		if listed(email) {
+			filtered = append(filtered, email)
+		}
+	}
+
+	if outputTemplate != nil {
+		printTemplate(filtered...)
+		return
+	}
+
+	switch *flagListFormat {
+	case formatJSON:
+		printJSON(filtered)
+		return
+	case formatJSONL:
+		printJSONLines(filtered)
+		return
+	case formatCSV:
+		columns := csvHeader
+		if listColumnNames != nil {
+			columns = listColumnNames
+		}
+		if err := writeCSV(os.Stdout, filtered, columns, !*flagListNoHeader, now); err != nil {
+			log.Fatalf("error writing csv: %v", err)
+		}
+		return
+	}
+
+	if *flagListGroupBy != "" {
+		writeGroups(os.Stdout, filtered)
+		return
+	}
+	if *flagListPlain {
+		for _, email := range filtered {
+			fmt.Println(email.Email)
+		}
+		return
+	}
+	if listColumnNames != nil {
+		if err := writeColumns(os.Stdout, filtered, listColumnNames, !*flagListNoHeader, now); err != nil {
+			log.Fatalf("error writing table: %v", err)
+		}
+		return
+	}
+	writeTable(os.Stdout, filtered, *flagShowAllFields, !*flagListNoHeader, now)
+}
+
+// streamJSONLines writes the listed masked emails as JSON Lines, each one as
+// soon as it's decoded from the response, so large accounts don't have to be
+// held in memory and consumers can start right away.
+func streamJSONLines(client *pkg.Client, session *pkg.SessionResource, now time.Time) {
+	enc := json.NewEncoder(os.Stdout)
+	state, err := client.EachMaskedEmail(session, *flagAccountID, func(email *pkg.MaskedEmail) error {
+		if !listed(email) {
+			return nil
+		}
+		return enc.Encode(email)
+	})
+	if err != nil {
+		log.Fatalf("error listing masked emails: %v", err)
+	}
+	recordStateCheckpoint(state, now)
+}
+
+// writeGroups writes the masked emails grouped by their normalized domain,
+// each group under a line with the domain and the count. The largest groups
+// come first, the masked emails keep their order within a group.
+func writeGroups(out io.Writer, emails []*pkg.MaskedEmail) {
+	const noDomain = "(no domain)"
+
+	groups := map[string][]*pkg.MaskedEmail{}
+	domains := []string{}
+	for _, email := range emails {
+		domain := pkg.NormalizeDomain(email.Domain)
+		if domain == "" {
+			domain = noDomain
+		}
+		if _, ok := groups[domain]; !ok {
+			domains = append(domains, domain)
+		}
+		groups[domain] = append(groups[domain], email)
+	}
+	sort.SliceStable(domains, func(i, j int) bool {
+		if len(groups[domains[i]]) != len(groups[domains[j]]) {
+			return len(groups[domains[i]]) > len(groups[domains[j]])
+		}
+		return domains[i] < domains[j]
+	})
+
+	tw := newTabWriter(out, 1)
+	for _, domain := range domains {
+		fmt.Fprintf(tw, "%s (%d)\n", domain, len(groups[domain]))
+		w := newTableWriter(tw, false, 2)
+		for _, email := range groups[domain] {
+			fmt.Fprintf(w, "  %s\t%s\t%s\n", email.Email, strings.TrimSpace(email.Description), email.State)
+		}
+	}
+	tw.Flush()
+}
+
+// writeTable writes the masked emails as the aligned table of list. allFields
+// adds the ID, timestamp and URL columns, header the header line.
+func writeTable(out io.Writer, emails []*pkg.MaskedEmail, allFields bool, header bool, now time.Time) {
+	// the computed columns are new, keep them out of the original format
+	showDays := allFields && !compatMode(compatV1)
+
+	tw := newTabWriter(out, 1)
+	w := newTableWriter(tw, header, 3)
+
+	// display header line
+	if header && showDays {
+		fmt.Fprintln(w, "Masked Email\tFor Domain\tDescription\tState\tID\tCreated At\tLast Email At\tDays Since Last Email\tAge (days)\tURL")
+	} else if header && allFields {
+		fmt.Fprintln(w, "Masked Email\tFor Domain\tDescription\tState\tID\tCreated At\tLast Email At")
+	} else if header {
+		fmt.Fprintln(w, "Masked Email\tFor Domain\tDescription\tState")
+	}
+
+	// display each masked email
+	for _, email := range emails {
+		url := ""
+		if email.URL != nil {
+			url = *email.URL
+		}
+
+		// HACK: trim space here is for hack to deal with possible empty strings
+		if showDays {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				email.Email,
+				strings.TrimSpace(email.Domain),
+				strings.TrimSpace(email.Description),
+				email.State,
+				email.ID,
+				formatDisplayTime(&email.CreatedAt),
+				formatDisplayTime(email.LastMessageAt),
+				formatDays(daysSince(email.LastMessageAt, now)),
+				formatDays(daysSince(&email.CreatedAt, now)),
+				orDash(strings.TrimSpace(url)))
+		} else if allFields {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				email.Email,
+				strings.TrimSpace(email.Domain),
+				strings.TrimSpace(email.Description),
+				email.State,
+				email.ID,
+				formatDisplayTime(&email.CreatedAt),
+				formatDisplayTime(email.LastMessageAt))
+		} else {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+				email.Email,
+				strings.TrimSpace(email.Domain),
+				strings.TrimSpace(email.Description),
+				email.State)
+		}
+	}
+	tw.Flush()
+}
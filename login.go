@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// flags for login command
+var loginCmd = flag.NewFlagSet(actionTypeLogin, flag.ExitOnError)
+var flagLoginKeyring = loginCmd.Bool(flagNameKeyring, false, "store the token in the system keyring instead of the config file")
+var flagLoginAccount = loginCmd.String(flagNameAccount, "", "account id to use (default: ask if the token has access to several)")
+var flagLoginOAuth = loginCmd.Bool(flagNameOAuth, false, "authorize in the browser with OAuth instead of pasting an API token, the tokens are kept in the keyring and refreshed automatically")
+var flagLoginDevice = loginCmd.Bool(flagNameDevice, false, "with -"+flagNameOAuth+", approve on another device, for machines without a browser")
+var flagLoginPrintURL = loginCmd.Bool(flagNamePrintURL, false, "with -"+flagNameOAuth+", only print the url to approve the access instead of also opening a browser (for headless/SSH sessions)")
+var flagLoginClientID = loginCmd.String(flagNameClientID, os.Getenv(envOAuthClientIDVarName), "OAuth client id registered with Fastmail (or "+envOAuthClientIDVarName+" env)")
+
+// flags for logout command
+var logoutCmd = flag.NewFlagSet(actionTypeLogout, flag.ExitOnError)
+
+// runLogin prompts for a token, verifies it and stores it in the profile of
+// the config file, or in the keyring with -keyring. With -oauth the token is
+// obtained by authorizing in the browser instead. Settings of the profile
+// other than the credentials are kept.
+func runLogin(args []string) {
+	parseCommandFlags(loginCmd, args)
+
+	var token string
+	var creds *oauthCredentials
+	if *flagLoginOAuth {
+		creds = loginOAuth()
+		token = creds.AccessToken
+	} else {
+		if *flagLoginDevice || *flagLoginPrintURL {
+			log.Fatalf("-%s and -%s need -%s", flagNameDevice, flagNamePrintURL, flagNameOAuth)
+		}
+		token = promptToken()
+	}
+
+	session, err := verifyToken(token, *flagAppname)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	accID := *flagLoginAccount
+	if accID == "" {
+		accID = selectAccount(session)
+	} else if !session.AccountHasCapability(accID, pkg.MaskedEmailCapabilityURI) {
+		log.Fatalf("account %s not found or has no access to Masked Email", accID)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("error reading config: %v", err)
+	}
+	p := profileConfig{}
+	if existing, err := cfg.profile(*flagProfile); err == nil {
+		p = *existing
+	}
+	p.AccountID = accID
+
+	stored := ""
+	if creds != nil {
+		account := storeOAuthCredentials(*flagProfile, creds)
+		p.Token = ""
+		p.TokenFrom = &secretSource{Provider: secretProviderOAuth, Account: account}
+		stored = "the keyring"
+	} else if *flagLoginKeyring {
+		account := storeKeyringToken(*flagProfile, token)
+		p.Token = ""
+		p.TokenFrom = &secretSource{Provider: secretProviderKeyring, Account: account}
+		stored = "the keyring"
+	} else {
+		p.Token = token
+		p.TokenFrom = nil
+		stored, _ = configPath()
+	}
+	cfg.setProfile(*flagProfile, p)
+
+	if err := cfg.save(); err != nil {
+		log.Fatalf("error writing config: %v", err)
+	}
+
+	fmt.Printf("logged in to account %s (%s) of profile %s, token stored in %s\n",
+		session.Accounts[accID].Name, accID, keyringAccount(*flagProfile), stored)
+}
+
+// promptToken asks for an API token with hidden input.
+func promptToken() string {
+	fmt.Fprintf(os.Stderr, "Create a Fastmail API token at %s\n", tokenSettingsURL)
+	fmt.Fprintln(os.Stderr, "The only scope needed is \"Masked Email\", the input is hidden.")
+
+	token, err := readSecret("Token: ")
+	if err != nil {
+		log.Fatalf("error reading token: %v", err)
+	}
+	if token == "" {
+		log.Fatalln("no token entered")
+	}
+	return token
+}
+
+// loginOAuth obtains OAuth credentials in the browser, or on another device
+// with -device.
+func loginOAuth() *oauthCredentials {
+	if *flagLoginClientID == "" {
+		log.Fatalf("-%s needs a client id registered with Fastmail, pass -%s or set %s", flagNameOAuth, flagNameClientID, envOAuthClientIDVarName)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *flagTimeout)
+	meta, err := discoverOAuth(ctx, oauthIssuer())
+	cancel()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var creds *oauthCredentials
+	if *flagLoginDevice {
+		creds, err = oauthDeviceLogin(meta, *flagLoginClientID)
+	} else {
+		creds, err = oauthLogin(meta, *flagLoginClientID, *flagLoginPrintURL)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return creds
+}
+
+// runLogout removes the stored credentials of the profile: the token in the
+// config file and the token or OAuth credentials in the keyring. Tokens
+// fetched from other secret providers are left to their store.
+func runLogout(args []string) {
+	parseCommandFlags(logoutCmd, args)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("error reading config: %v", err)
+	}
+	p, err := cfg.profile(*flagProfile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	removed := p.Token != ""
+	p.Token = ""
+	if p.TokenFrom != nil && (p.TokenFrom.Provider == secretProviderKeyring || p.TokenFrom.Provider == secretProviderOAuth) {
+		deleteKeyringToken(*flagProfile)
+		p.TokenFrom = nil
+		removed = true
+	}
+
+	if removed {
+		if err := cfg.save(); err != nil {
+			log.Fatalf("error writing config: %v", err)
+		}
+		fmt.Printf("logged out of profile %s\n", keyringAccount(*flagProfile))
+	} else {
+		fmt.Printf("no token stored for profile %s\n", keyringAccount(*flagProfile))
+	}
+
+	if p.TokenFrom != nil {
+		fmt.Fprintf(os.Stderr, "warning: profile %s still fetches its token with the %s provider, remove it there\n", keyringAccount(*flagProfile), p.TokenFrom.Provider)
+	}
+	if os.Getenv(envTokenVarName) != "" {
+		fmt.Fprintf(os.Stderr, "warning: %s is still set in the environment\n", envTokenVarName)
+	}
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
-	"text/tabwriter"
+	"time"
 
 	"github.com/dvcrn/maskedemail-cli/pkg"
 )
@@ -16,13 +21,31 @@ type actionType string
 
 const (
 	defaultAppname          string = "maskedemail-cli"
+	defaultClientID         string = "35c941ae"
 
 	envTokenVarName 		string = "MASKEDEMAIL_TOKEN"
 	envAppVarName 			string = "MASKEDEMAIL_APPNAME"
 	envAccountIdVarName 	string = "MASKEDEMAIL_ACCOUNTID"
+	envMaxPerDomainVarName	string = "MASKEDEMAIL_MAX_PER_DOMAIN"
+	envReadOnlyVarName		string = "MASKEDEMAIL_READ_ONLY"
+	envConfirmThresholdVarName	string = "MASKEDEMAIL_CONFIRM_THRESHOLD"
+	envSessionURLVarName	string = "MASKEDEMAIL_SESSION_URL"
+	envProfileVarName		string = "MASKEDEMAIL_PROFILE"
+	envRelativeTimeVarName	string = "MASKEDEMAIL_RELATIVE_TIME"
 
 	flagNameToken           string = "token"
 	flagNameAccountID       string = "accountid"
+	flagNameProfile         string = "profile"
+	flagNameDumpJMAP        string = "dump-jmap"
+	flagNameTimeout         string = "timeout"
+	flagNameCompat          string = "compat"
+	flagNameProfilePerf     string = "profile-perf"
+	flagNameReadOnly        string = "read-only"
+	flagNameConfirmThreshold	string = "confirm-threshold"
+	flagNameSort			string = "sort"
+	flagNameOutput			string = "output"
+	flagNameJSON			string = "json"
+	flagNameTemplate		string = "template"
 
 	flagNameEmail			string = "email"
 	flagNameDomain			string = "domain"
@@ -30,6 +53,68 @@ const (
 	flagNameEnabled			string = "enabled"
 	flagNameShowDeleted		string = "show-deleted"
 	flagNameShowAllFields   string = "all-fields"
+	flagNameShell			string = "shell"
+	flagNameYes				string = "yes"
+	flagNamePrintURL		string = "print-url"
+	flagNameIdempotencyKey	string = "idempotency-key"
+	flagNameMaxPerDomain	string = "max-per-domain"
+	flagNameIgnorePolicy	string = "ignore-policy"
+	flagNameClear			string = "clear"
+	flagNameExport			string = "export"
+	flagNameTag				string = "tag"
+	flagNameFormat			string = "format"
+	flagNameTokenFromEnv	string = "token-from-env"
+	flagNameAccount			string = "account"
+	flagNameDryRun			string = "dry-run"
+	flagNamePrune			string = "prune"
+	flagNameActivity		string = "activity"
+	flagNameBucket			string = "bucket"
+	flagNamePrefix			string = "prefix"
+	flagNameTo				string = "to"
+	flagNameState			string = "state"
+	flagNameWhere			string = "where"
+	flagNameView			string = "view"
+	flagNameAccountAll		string = "account-all"
+	flagNameCopy			string = "copy"
+	flagNameFile			string = "file"
+	flagNameQR				string = "qr"
+	flagNameStrict			string = "strict"
+	flagNameFromMessage		string = "from-message"
+	flagNameInterval		string = "interval"
+	flagNameMaxInterval		string = "max-interval"
+	flagNameKeyring			string = "keyring"
+	flagNameTokenFile		string = "token-file"
+	flagNameTokenStdin		string = "token-stdin"
+	flagNameTokenCmd		string = "token-cmd"
+	flagNameOAuth			string = "oauth"
+	flagNameDevice			string = "device"
+	flagNameClientID		string = "client-id"
+	flagNameFromJournal		string = "from-journal"
+	flagNameForce			string = "force"
+	flagNameForceShort		string = "f"
+	flagNameChangedSince	string = "changed-since"
+	flagNameCreatedSince	string = "created-since"
+	flagNameCreatedBefore	string = "created-before"
+	flagNameLastEmailBefore	string = "last-email-before"
+	flagNameNeverUsed		string = "never-used"
+	flagNameMatch			string = "match"
+	flagNameRegex			string = "regex"
+	flagNameFrom			string = "from"
+	flagNameUnusedFor		string = "unused-for"
+	flagNameAction			string = "action"
+	flagNameReverse			string = "reverse"
+	flagNameColumns			string = "columns"
+	flagNameNoHeader		string = "no-header"
+	flagNamePlain			string = "plain"
+	flagNameRelativeTime	string = "relative-time"
+	flagNameColor			string = "color"
+	flagNameGroupBy			string = "group-by"
+	flagNameCount			string = "count"
+	flagNameSince			string = "since"
+	flagNameReuse			string = "reuse"
+	flagNameURL				string = "url"
+	flagNameRegistrable		string = "registrable"
+	flagNameDescTemplate	string = "desc-template"
 
 	actionTypeUnknown		= ""
 	actionTypeCreate        = "create"
@@ -37,9 +122,39 @@ const (
 	actionTypeDisable       = "disable"
 	actionTypeEnable        = "enable"
 	actionTypeDelete        = "delete"
+	actionTypeDestroy       = "destroy"
 	actionTypeUpdate        = "update"
+	actionTypeRename        = "rename"
+	actionTypePrune         = "prune"
 	actionTypeList          = "list"
+	actionTypeCount         = "count"
+	actionTypeDupes         = "dupes"
 	actionTypeVersion       = "version"
+	actionTypeCompletion    = "completion"
+	actionTypeOpen          = "open"
+	actionTypeAnnotate      = "annotate"
+	actionTypeTag           = "tag"
+	actionTypeStats         = "stats"
+	actionTypeDigest        = "digest"
+	actionTypeInit          = "init"
+	actionTypeTransfer      = "transfer"
+	actionTypeShow          = "show"
+	actionTypeExists        = "exists"
+	actionTypeFindForDomain = "find-for-domain"
+	actionTypeSearch        = "search"
+	actionTypeState         = "state"
+	actionTypeExport        = "export"
+	actionTypeImport        = "import"
+	actionTypeRestore       = "restore"
+	actionTypeDebugBundle   = "debug-bundle"
+	actionTypeSyncContacts  = "sync-contacts"
+	actionTypeAuth          = "auth"
+	actionTypeWatch         = "watch"
+	actionTypeLogin         = "login"
+	actionTypeLogout        = "logout"
+	actionTypeTUI           = "tui"
+	// actionTypeCompleteAddresses is called by the completion scripts
+	actionTypeCompleteAddresses = "__complete-addresses"
 
 )
 
@@ -52,29 +167,48 @@ var buildCommit string = "n/a"
 // default / highest level flags
 var flagAppname = flag.String("appname", os.Getenv(envAppVarName), "the appname to identify the creator (or "+envAppVarName+" env) (default: "+defaultAppname+")")
 var flagToken = flag.String(flagNameToken, "", "the token to authenticate with (or "+envTokenVarName+" env)")
+var flagTokenFile = flag.String(flagNameTokenFile, "", "read the token from this file")
+var flagTokenStdin = flag.Bool(flagNameTokenStdin, false, "read the token from the first line of stdin")
+var flagTokenCmd = flag.String(flagNameTokenCmd, "", "run this command and use its output as the token, e.g. \"op read op://Private/Fastmail/token\"")
 var flagAccountID = flag.String(flagNameAccountID, os.Getenv(envAccountIdVarName), "fastmail account id (or "+envAccountIdVarName+" env)")
-
-// flags for list command
-var listCmd = flag.NewFlagSet(actionTypeList, flag.ExitOnError)
-var flagShowDeleted = listCmd.Bool(flagNameShowDeleted, false, "show deleted masked emails (true|false) (default false)")
-var flagShowAllFields = listCmd.Bool(flagNameShowAllFields, false, "show all masked email fields (true|false) (default false)")
-
-// flags for create command
-var createCmd = flag.NewFlagSet(actionTypeCreate, flag.ExitOnError)
-var flagCreateDomain = createCmd.String(flagNameDomain, "", "domain for the masked email (optional)")
-var flagCreateDescription = createCmd.String(flagNameDesc, "", "description for the masked email (optional)")
-var flagCreateEnabled = createCmd.Bool(flagNameEnabled, true, "is masked email enabled (true|false)")
+var flagProfile = flag.String(flagNameProfile, os.Getenv(envProfileVarName), "config profile to use (or "+envProfileVarName+" env) (default: top-level settings of the config file)")
+var flagDumpJMAP = flag.Bool(flagNameDumpJMAP, false, "print the JMAP requests and responses to stderr (authorization redacted)")
+var flagTimeout = flag.Duration(flagNameTimeout, 30*time.Second, "timeout for each request to the Fastmail API")
+var flagCompat = flag.Int(flagNameCompat, envInt(envCompatVarName, compatLatest), "keep the text output of the given compat level, 1 for the original format (or "+envCompatVarName+" env)")
+var flagProfilePerf = flag.Bool(flagNameProfilePerf, false, "report where time was spent (session fetch, api calls, rendering) to stderr")
+var flagReadOnly = flag.Bool(flagNameReadOnly, envBool(envReadOnlyVarName, false), "refuse to run any command that modifies masked emails (or "+envReadOnlyVarName+" env, or readOnly in config)")
+var flagConfirmThreshold = flag.Int(flagNameConfirmThreshold, envInt(envConfirmThresholdVarName, 5), "bulk operations destroying more masked emails than this require typing the count to confirm (or "+envConfirmThresholdVarName+" env)")
+var flagOutput = flag.String(flagNameOutput, formatText, "output format of the command (text|json)")
+var flagJSON = flag.Bool(flagNameJSON, false, "shorthand for -"+flagNameOutput+" "+formatJSON)
+var flagTemplate = flag.String(flagNameTemplate, "", "render each masked email with this Go text/template, e.g. '{{.Email}} {{.Domain}}' (list, search, create, show)")
+var flagAccountAll = flag.Bool(flagNameAccountAll, false, "enable, disable, delete: look for addresses missing in the default account in all other accounts of the token")
+var flagStrict = flag.Bool(flagNameStrict, envBool(envStrictVarName, false), "fail instead of warning about misplaced flags, ambiguous matches and changes that change nothing (or "+envStrictVarName+" env)")
+var flagRelativeTime = flag.Bool(flagNameRelativeTime, envBool(envRelativeTimeVarName, false), "show timestamps in text output relative to now, e.g. \"3 days ago\" (or "+envRelativeTimeVarName+" env)")
+var flagColor = flag.String(flagNameColor, colorAuto, "color the tables of text output ("+colorAuto+"|"+colorAlways+"|"+colorNever+"), auto colors terminals unless NO_COLOR is set")
+var flagMaxPerDomain = flag.Int(flagNameMaxPerDomain, envInt(envMaxPerDomainVarName, 0), "maximum active masked emails per domain enforced on create, 0 for no limit (or "+envMaxPerDomainVarName+" env)")
 
 // flags for update command
 var updateCmd = flag.NewFlagSet(actionTypeUpdate, flag.ExitOnError)
-var flagUpdateEmail = updateCmd.String(flagNameEmail, "", "masked email to update (required)")
+var flagUpdateEmail = updateCmd.String(flagNameEmail, "", "masked email or its ID to update (required)")
 var flagUpdateDomain = updateCmd.String(flagNameDomain, "", "domain for the masked email (optional, only updated if argument passed)")
 var flagUpdateDescription = updateCmd.String(flagNameDesc, "", "description for the masked email (optional, only updated if argument passed)")
+var flagUpdateURL = updateCmd.String(flagNameURL, "", "web address the masked email is for, empty to remove it (optional, only updated if argument passed)")
+var flagUpdatePrefix = updateCmd.String(flagNamePrefix, "", "new email prefix (a-z, 0-9, _), if the server allows changing it (optional)")
+
+// flags for open command
+var openCmd = flag.NewFlagSet(actionTypeOpen, flag.ExitOnError)
+var flagOpenPrintURL = openCmd.Bool(flagNamePrintURL, false, "print the url instead of opening a browser (for headless/SSH sessions)")
+
+// flags for annotate command
+var annotateCmd = flag.NewFlagSet(actionTypeAnnotate, flag.ExitOnError)
+var flagAnnotateClear = annotateCmd.Bool(flagNameClear, false, "remove the note of the masked email")
+var flagAnnotateExport = annotateCmd.Bool(flagNameExport, false, "print all notes as JSON")
 
 var args        []string
 var action      actionType = actionTypeUnknown
 var commandArg  string
 var envToken    string
+var userConfig  *config = &config{}
 
 func isFlagPassed(set flag.FlagSet, name string) bool {
     found := false
@@ -88,9 +222,154 @@ func isFlagPassed(set flag.FlagSet, name string) bool {
     return found
 }
 
-func init() {
+// newClient returns an API client, talking to the session endpoint in
+// $MASKEDEMAIL_SESSION_URL instead of Fastmail if set (for tests).
+func newClient(token string, appname string) *pkg.Client {
+	client := pkg.NewClient(token, appname, defaultClientID)
+	if url := os.Getenv(envSessionURLVarName); url != "" {
+		client.SetSessionEndpoint(url)
+	}
+	return client
+}
+
+// sessionAccount is an account as printed by `session` in JSON output.
+type sessionAccount struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Primary bool   `json:"primary"`
+	Enabled bool   `json:"enabled"`
+}
+
+// accountIDOrDefault returns the account passed via flag/env or the primary
+// masked email account of the session.
+func accountIDOrDefault(session pkg.Session) string {
+	if *flagAccountID != "" {
+		return *flagAccountID
+	}
+	return session.DefaultAccountForCapability(pkg.MaskedEmailCapabilityURI)
+}
+
+// validateAccountAll exits if -account-all is used with a command that
+// doesn't support it or together with -accountid.
+func validateAccountAll(action actionType) {
+	if !*flagAccountAll {
+		return
+	}
+	if _, ok := stateCommands[string(action)]; action != actionTypeUnknown && !ok {
+		log.Fatalf("%s doesn't support -%s", action, flagNameAccountAll)
+	}
+	if isFlagPassed(*flag.CommandLine, flagNameAccountID) {
+		log.Fatalf("-%s can't be combined with -%s", flagNameAccountAll, flagNameAccountID)
+	}
+}
+
+// dryRunFlagSets are the flag sets of the modifying commands that take
+// -dry-run.
+var dryRunFlagSets = map[actionType]*flag.FlagSet{
+	actionTypeCreate:  createCmd,
+	actionTypeEnable:  enableCmd,
+	actionTypeDisable: disableCmd,
+	actionTypeDelete:  deleteCmd,
+	actionTypePrune:   pruneCmd,
+	actionTypeImport:  importCmd,
+	actionTypeRestore: restoreCmd,
+}
+
+// isMutatingCommand reports whether the command modifies masked emails on
+// the server. Local-only changes (notes, config) and dry runs don't count.
+func isMutatingCommand(action actionType, args []string) bool {
+	if set, ok := dryRunFlagSets[action]; ok {
+		// the commandFlags of the config come first, like parseCommandFlags
+		// applies them; errors in them are reported by the command
+		defaults, _ := splitArgs(userConfig.CommandFlags[set.Name()])
+		if dryRunRequested(set, append(defaults, args...)) {
+			return false
+		}
+	}
+
+	switch action {
+	case actionTypeCreate, actionTypeEnable, actionTypeDisable, actionTypeDelete, actionTypeDestroy, actionTypeUpdate, actionTypeRename, actionTypePrune, actionTypeTransfer, actionTypeImport, actionTypeRestore:
+		return true
+	case actionTypeTag:
+		return len(args) > 0 && args[0] != tagSubcommandList
+	}
+	return false
+}
+
+// dryRunRequested reports whether the flags in args, as set would parse
+// them, ask for a dry run. args aren't parsed yet, the command does that.
+func dryRunRequested(set *flag.FlagSet, args []string) bool {
+	dryRun := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || len(arg) < 2 || arg[0] != '-' {
+			break
+		}
+
+		name, value, hasValue := strings.TrimLeft(arg, "-"), "", false
+		if j := strings.Index(name, "="); j >= 0 {
+			name, value, hasValue = name[:j], name[j+1:], true
+		}
+		f := set.Lookup(name)
+		if f == nil {
+			break
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			if name == flagNameDryRun {
+				v, err := strconv.ParseBool(value)
+				dryRun = !hasValue || (err == nil && v)
+			}
+			continue
+		}
+		// the value is the next argument
+		if !hasValue {
+			i++
+		}
+	}
+	return dryRun
+}
+
+// maskedEmailArg validates a masked email address argument and returns it in
+// its normalized form. An empty argument prints the usage line and exits.
+func maskedEmailArg(arg string, usage string) string {
+	if strings.TrimSpace(arg) == "" {
+		log.Fatalln("Usage: " + usage)
+	}
+
+	address, err := pkg.ParseMaskedAddress(arg)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	return address.Address
+}
+
+// maskedEmailRefArg is maskedEmailArg for commands that take the JMAP ID of
+// a masked email as well, which is returned as is.
+func maskedEmailRefArg(arg string, usage string) string {
+	if pkg.IsMaskedEmailID(arg) {
+		return strings.TrimSpace(arg)
+	}
+	return maskedEmailArg(arg, usage)
+}
+
+// setup parses the global flags, the config and the command. It runs from
+// main rather than init, so the tests of this package can parse their own
+// flags.
+func setup() {
 	flag.Parse()
 
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("error reading config: %v", err)
+	}
+	userConfig = cfg
+
+	explicitFlags, err := applyConfigFlags(cfg)
+	if err != nil {
+		log.Fatalf("error in flags of config: %v", err)
+	}
+
 	// get all args after the global args
 	args = flag.Args()
 
@@ -103,56 +382,160 @@ func init() {
 		fmt.Println("Commands:")
 
 		// create
-		fmt.Printf("  %s %s [-%s \"<domain>\"] [-%s \"<description>\"] [-%s=true|false (default true)]\n",
-					defaultAppname, actionTypeCreate, flagNameDomain, flagNameDesc, flagNameEnabled)
+		fmt.Printf("  %s %s [-%s \"<domain>\"|-%s <url> [-%s]] [-%s \"<description>\"|-%s \"<template>\"] [-%s=true|false (default true)] [-%s <key>] [-%s] [-%s] [-%s] [-%s] [-%s] [-%s <n>] [-%s]\n",
+					defaultAppname, actionTypeCreate, flagNameDomain, flagNameURL, flagNameRegistrable, flagNameDesc, flagNameDescTemplate, flagNameEnabled, flagNameIdempotencyKey, flagNameIgnorePolicy, flagNameFromMessage, flagNameCopy, flagNameQR, flagNameDryRun, flagNameCount, flagNameReuse)
 
 		// list
-		fmt.Printf("  %s %s [-%s] [-%s]\n",
-					defaultAppname, actionTypeList, flagNameShowDeleted, flagNameShowAllFields)
+		fmt.Printf("  %s %s [-%s] [-%s <states>] [-%s <domain>] [-%s <text>] [-%s <regex>] [-%s] [-%s <columns>] [-%s] [-%s] [-%s domain] [-%s <tag>] [-%s <expression>] [-%s <window>] [-%s <time>] [-%s <time>] [-%s <time>] [-%s] [-%s <name>] [-%s %s] [-%s] [-%s %s|%s|%s|%s]\n",
+					defaultAppname, actionTypeList, flagNameShowDeleted, flagNameState, flagNameDomain, flagNameDesc, flagNameRegex, flagNameShowAllFields, flagNameColumns, flagNameNoHeader, flagNamePlain, flagNameGroupBy, flagNameTag, flagNameWhere, flagNameChangedSince, flagNameCreatedSince, flagNameCreatedBefore, flagNameLastEmailBefore, flagNameNeverUsed, flagNameView, flagNameSort, strings.Join(sortKeys, "|"), flagNameReverse, flagNameFormat, formatText, formatCSV, formatJSON, formatJSONL)
+
+		// search
+		fmt.Printf("  %s %s [-%s <text>] [-%s <text>] [-%s <regex>] [-%s <state>,...] [-%s] [<term>...]\n",
+					defaultAppname, actionTypeSearch, flagNameDomain, flagNameDesc, flagNameRegex, flagNameState, flagNameShowAllFields)
 
 		// enable
-		fmt.Printf("  %s %s <maskedemail>\n",
-					defaultAppname, actionTypeEnable)
+		fmt.Printf("  %s %s <maskedemail|id>...|%s|-%s <text>|-%s <regex> [-%s] [-%s]\n",
+					defaultAppname, actionTypeEnable, stdinArg, flagNameMatch, flagNameRegex, flagNameDryRun, flagNameYes)
+		fmt.Printf("  %s %s -%s <since>\n",
+					defaultAppname, actionTypeEnable, flagNameFromJournal)
 
 		// disable
-		fmt.Printf("  %s %s <maskedemail>\n",
-					defaultAppname, actionTypeDisable)
+		fmt.Printf("  %s %s <maskedemail|id>...|%s|-%s <text>|-%s <regex> [-%s] [-%s]\n",
+					defaultAppname, actionTypeDisable, stdinArg, flagNameMatch, flagNameRegex, flagNameDryRun, flagNameYes)
 
 		// delete
-		fmt.Printf("  %s %s <maskedemail>\n",
-					defaultAppname, actionTypeDelete)
+		fmt.Printf("  %s %s [-%s|-%s] <maskedemail|id>...|%s|-%s <text>|-%s <regex> [-%s]\n",
+					defaultAppname, actionTypeDelete, flagNameForce, flagNameYes, stdinArg, flagNameMatch, flagNameRegex, flagNameDryRun)
+
+		// destroy
+		fmt.Printf("  %s %s [-%s] <maskedemail>\n",
+					defaultAppname, actionTypeDestroy, flagNameYes)
+
+		// show
+		fmt.Printf("  %s %s [-%s] [-%s] <maskedemail|id>\n",
+					defaultAppname, actionTypeShow, flagNameCopy, flagNameQR)
+
+		// exists
+		fmt.Printf("  %s %s <maskedemail|domain>\n",
+					defaultAppname, actionTypeExists)
+
+		// find-for-domain
+		fmt.Printf("  %s %s <domain>\n",
+					defaultAppname, actionTypeFindForDomain)
 
 		// update
-		fmt.Printf("  %s %s -%s <maskedemail> [-%s \"<domain>\"] [-%s \"<description>\"]\n",
-					defaultAppname, actionTypeUpdate, flagNameEmail, flagNameDomain, flagNameDesc)
+		fmt.Printf("  %s %s -%s <maskedemail|id> [-%s \"<domain>\"] [-%s \"<description>\"] [-%s <url>] [-%s <prefix>]\n",
+					defaultAppname, actionTypeUpdate, flagNameEmail, flagNameDomain, flagNameDesc, flagNameURL, flagNamePrefix)
+
+		// rename
+		fmt.Printf("  %s %s <maskedemail> <new description>\n",
+					defaultAppname, actionTypeRename)
+
+		// prune
+		fmt.Printf("  %s %s -%s <window> [-%s %s|%s] [-%s] [-%s]\n",
+					defaultAppname, actionTypePrune, flagNameUnusedFor, flagNameAction, actionTypeDisable, actionTypeDelete, flagNameDryRun, flagNameYes)
+
+		// open
+		fmt.Printf("  %s %s [-%s] <maskedemail>\n",
+					defaultAppname, actionTypeOpen, flagNamePrintURL)
+
+		// annotate
+		fmt.Printf("  %s %s [-%s] <maskedemail> [\"<note>\"]\n",
+					defaultAppname, actionTypeAnnotate, flagNameClear)
+		fmt.Printf("  %s %s -%s\n",
+					defaultAppname, actionTypeAnnotate, flagNameExport)
+
+		// tag
+		fmt.Printf("  %s %s <%s|%s> <maskedemail> <tag>...\n",
+					defaultAppname, actionTypeTag, tagSubcommandAdd, tagSubcommandRemove)
+		fmt.Printf("  %s %s %s [<maskedemail>]\n",
+					defaultAppname, actionTypeTag, tagSubcommandList)
+
+		// transfer
+		fmt.Printf("  %s %s -%s <accountid> [-%s] <maskedemail>\n",
+					defaultAppname, actionTypeTransfer, flagNameTo, flagNameYes)
+
+		// count
+		fmt.Printf("  %s %s [-%s <states>] [-%s <domain>] [-%s <text>] [-%s <regex>] [-%s <expression>] [-%s] [list filters...]\n",
+					defaultAppname, actionTypeCount, flagNameState, flagNameDomain, flagNameDesc, flagNameRegex, flagNameWhere, flagNameShowDeleted)
+
+		// dupes
+		fmt.Printf("  %s %s\n",
+					defaultAppname, actionTypeDupes)
+
+		// stats
+		fmt.Printf("  %s %s [-%s text|json|prometheus] [-%s [-%s week|month]]\n",
+					defaultAppname, actionTypeStats, flagNameFormat, flagNameActivity, flagNameBucket)
+
+		// digest
+		fmt.Printf("  %s %s [-%s <window>] [-%s %s|%s]\n",
+					defaultAppname, actionTypeDigest, flagNameSince, flagNameFormat, formatText, formatHTML)
+
+		// watch
+		fmt.Printf("  %s %s [-%s <duration>] [-%s <duration>]\n",
+					defaultAppname, actionTypeWatch, flagNameInterval, flagNameMaxInterval)
+
+		// tui
+		fmt.Printf("  %s %s [-%s]\n",
+					defaultAppname, actionTypeTUI, flagNameShowDeleted)
 
 		// session
 		fmt.Printf("  %s %s\n",
 					defaultAppname, actionTypeSession)
 
+		// init
+		fmt.Printf("  %s [-%s <name>] %s [-%s] [-%s <id>]\n",
+					defaultAppname, flagNameProfile, actionTypeInit, flagNameTokenFromEnv, flagNameAccount)
+
+		// login, logout
+		fmt.Printf("  %s [-%s <name>] %s [-%s] [-%s <id>]\n",
+					defaultAppname, flagNameProfile, actionTypeLogin, flagNameKeyring, flagNameAccount)
+		fmt.Printf("  %s [-%s <name>] %s -%s [-%s] [-%s <id>] [-%s <id>]\n",
+					defaultAppname, flagNameProfile, actionTypeLogin, flagNameOAuth, flagNameDevice, flagNameClientID, flagNameAccount)
+		fmt.Printf("  %s [-%s <name>] %s\n",
+					defaultAppname, flagNameProfile, actionTypeLogout)
+
+		// auth
+		fmt.Printf("  %s [-%s <name>] %s <%s|%s|%s>\n",
+					defaultAppname, flagNameProfile, actionTypeAuth, authSubcommandSetToken, authSubcommandClear, authSubcommandLogin)
+
+		// state
+		fmt.Printf("  %s %s %s [-%s <path>]\n",
+					defaultAppname, actionTypeState, stateSubcommandExport, flagNameFile)
+		fmt.Printf("  %s %s %s <path>|%s\n",
+					defaultAppname, actionTypeState, stateSubcommandImport, stdinArg)
+
+		// export
+		fmt.Printf("  %s %s [-%s <path>]\n",
+					defaultAppname, actionTypeExport, flagNameFile)
+
+		// import
+		fmt.Printf("  %s %s -%s <backup.json|aliases.csv|%s> [-%s]\n",
+					defaultAppname, actionTypeImport, flagNameFile, stdinArg, flagNameDryRun)
+
+		// restore
+		fmt.Printf("  %s %s -%s <backup.json|%s> -%s <maskedemail>...|-%s <domain> [-%s]\n",
+					defaultAppname, actionTypeRestore, flagNameFrom, stdinArg, flagNameEmail, flagNameDomain, flagNameDryRun)
+
+		// sync-contacts
+		fmt.Printf("  %s %s [-%s] [-%s]\n",
+					defaultAppname, actionTypeSyncContacts, flagNamePrune, flagNameDryRun)
+
+		// debug-bundle
+		fmt.Printf("  %s %s [-%s <path>]\n",
+					defaultAppname, actionTypeDebugBundle, flagNameFile)
+
 		// version
 		fmt.Printf("  %s %s\n",
 					defaultAppname, actionTypeVersion)
-	}
-
-	// Check global arguments:
 
-	// CLI parameter have precedence over ENV variables
-	if *flagToken == "" {
-		envToken = os.Getenv(envTokenVarName)
-		if envToken != "" {
-			*flagToken = envToken
-		} else {
-			flag.Usage()
-			os.Exit(1)
-		}
+		// completion
+		fmt.Printf("  %s %s <%s>\n",
+					defaultAppname, actionTypeCompletion, strings.Join(completionShells, "|"))
+		fmt.Printf("  %s %s %s [-%s <shell>] [-%s]\n",
+					defaultAppname, actionTypeCompletion, completionSubcommandInstall, flagNameShell, flagNameYes)
 	}
 
-	if *flagAppname == "" {
-		*flagAppname = defaultAppname
-	}
-
-
 	// determine command/subcommand
 	commandArg = ""
 	if len(args) > 0 {
@@ -179,24 +562,285 @@ func init() {
 	case actionTypeDelete:
 		action = actionTypeDelete
 
+	case actionTypeDestroy:
+		action = actionTypeDestroy
+
 	case actionTypeList:
 		action = actionTypeList
 
 	case actionTypeUpdate:
 		action = actionTypeUpdate
+
+	case actionTypeRename:
+		action = actionTypeRename
+
+	case actionTypePrune:
+		action = actionTypePrune
+
+	case actionTypeCompletion:
+		action = actionTypeCompletion
+
+	case actionTypeOpen:
+		action = actionTypeOpen
+
+	case actionTypeAnnotate:
+		action = actionTypeAnnotate
+
+	case actionTypeTag:
+		action = actionTypeTag
+
+	case actionTypeCount:
+		action = actionTypeCount
+
+	case actionTypeDupes:
+		action = actionTypeDupes
+
+	case actionTypeStats:
+		action = actionTypeStats
+
+	case actionTypeDigest:
+		action = actionTypeDigest
+
+	case actionTypeWatch:
+		action = actionTypeWatch
+
+	case actionTypeInit:
+		action = actionTypeInit
+
+	case actionTypeTransfer:
+		action = actionTypeTransfer
+
+	case actionTypeShow:
+		action = actionTypeShow
+
+	case actionTypeExists:
+		action = actionTypeExists
+
+	case actionTypeFindForDomain:
+		action = actionTypeFindForDomain
+
+	case actionTypeSearch:
+		action = actionTypeSearch
+
+	case actionTypeState:
+		action = actionTypeState
+
+	case actionTypeExport:
+		action = actionTypeExport
+
+	case actionTypeImport:
+		action = actionTypeImport
+
+	case actionTypeRestore:
+		action = actionTypeRestore
+
+	case actionTypeDebugBundle:
+		action = actionTypeDebugBundle
+
+	case actionTypeSyncContacts:
+		action = actionTypeSyncContacts
+
+	case actionTypeAuth:
+		action = actionTypeAuth
+
+	case actionTypeLogin:
+		action = actionTypeLogin
+
+	case actionTypeLogout:
+		action = actionTypeLogout
+
+	case actionTypeTUI:
+		action = actionTypeTUI
+
+	case actionTypeCompleteAddresses:
+		action = actionTypeCompleteAddresses
+	}
+
+	// Check global arguments:
+
+	if !explicitFlags[flagNameOutput] && !explicitFlags[flagNameJSON] && !explicitFlags[flagNameTemplate] && jsonOutputCommands[action] && cfg.Output != "" {
+		*flagOutput = cfg.Output
+	}
+
+	validateCompat(*flagCompat)
+	validateOutput(action)
+	validateColor()
+	validateAccountAll(action)
+
+	// completion scripts are generated offline and don't need a token,
+	// neither does moving the local state
+	if action == actionTypeCompletion || action == actionTypeState {
+		return
+	}
+
+	if token, err := tokenFromFlags(explicitFlags); err != nil {
+		log.Fatalf("error reading token: %v", err)
+	} else if token != "" {
+		*flagToken = token
+	}
+
+	// init, auth, login and logout take the token from their own arguments
+	if action == actionTypeInit || action == actionTypeAuth || action == actionTypeLogin || action == actionTypeLogout {
+		if *flagAppname == "" {
+			*flagAppname = defaultAppname
+		}
+		return
+	}
+
+	profile, err := cfg.profile(*flagProfile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// CLI parameters have precedence over the config file, which has
+	// precedence over ENV variables
+	if !explicitFlags["appname"] && profile.Appname != "" {
+		*flagAppname = profile.Appname
+	}
+	if *flagAppname == "" {
+		*flagAppname = defaultAppname
+	}
+
+	if *flagToken == "" {
+		envToken = os.Getenv(envTokenVarName)
+		if profile.Token != "" {
+			*flagToken = profile.Token
+		} else if profile.TokenFrom != nil {
+			*flagToken, err = fetchSecret(*profile.TokenFrom, *flagTimeout)
+			if err != nil {
+				log.Fatalf("error fetching token: %v", err)
+			}
+		} else if envToken != "" {
+			*flagToken = envToken
+		} else if isTerminal(os.Stdin) && action != actionTypeUnknown && action != actionTypeCompleteAddresses {
+			// first run: offer to set up a token interactively
+			profile, err = runOnboarding(*flagProfile, *flagAppname)
+			if err != nil {
+				log.Fatalf("setup failed: %v", err)
+			}
+			*flagToken = profile.Token
+		} else {
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+
+	if !explicitFlags[flagNameAccountID] && profile.AccountID != "" {
+		*flagAccountID = profile.AccountID
+	}
+
+	if profile.ReadOnly {
+		*flagReadOnly = true
+	}
+	if *flagReadOnly && action != actionTypeUnknown && isMutatingCommand(action, args[1:]) {
+		log.Fatalf("refusing to run %s: read-only mode is enabled", action)
 	}
 }
 
 func main() {
+	setup()
 
-	client := pkg.NewClient(*flagToken, *flagAppname, "35c941ae")
+	client := newClient(*flagToken, *flagAppname)
+	// `session` shows the accounts as they are now
+	if dir, err := stateDir(); err == nil && action != actionTypeSession {
+		client.SetSessionCache(filepath.Join(dir, sessionCacheDir), sessionCacheTTL)
+	}
+	client.SetReadOnly(*flagReadOnly)
+	client.SetContext(handleInterrupts())
+
+	httpClient := &http.Client{Timeout: *flagTimeout, Transport: &failureTransport{next: http.DefaultTransport}}
+	if *flagProfilePerf {
+		perf := newPerfRecorder()
+		httpClient.Transport = perf.transport(httpClient.Transport)
+		defer perf.report()
+	}
+	client.SetHTTPClient(httpClient)
+	if *flagDumpJMAP {
+		client.DumpTo(os.Stderr)
+	}
 
 	switch action {
 
 	case actionTypeVersion:
+		if jsonOutput() {
+			printJSON(map[string]string{"version": buildVersion, "commit": buildCommit})
+			return
+		}
 		fmt.Printf("version: %s\n", buildVersion)
 		fmt.Printf("commit: %s\n", buildCommit)
 
+	case actionTypeCompletion:
+		runCompletion(args[1:])
+
+	case actionTypeTag:
+		runTag(client, args[1:])
+
+	case actionTypeCount:
+		runCount(client, args[1:])
+
+	case actionTypeDupes:
+		runDupes(client, args[1:])
+
+	case actionTypeStats:
+		runStats(client, args[1:])
+
+	case actionTypeDigest:
+		runDigest(client, args[1:])
+
+	case actionTypeWatch:
+		runWatch(client, args[1:])
+
+	case actionTypeInit:
+		runInit(args[1:])
+
+	case actionTypeTransfer:
+		runTransfer(client, args[1:])
+
+	case actionTypeShow:
+		runShow(client, args[1:])
+
+	case actionTypeExists:
+		runExists(client, args[1:])
+
+	case actionTypeFindForDomain:
+		runFindForDomain(client, args[1:])
+
+	case actionTypeSearch:
+		runSearch(client, args[1:])
+
+	case actionTypeState:
+		runState(args[1:])
+
+	case actionTypeExport:
+		runExport(client, args[1:])
+
+	case actionTypeImport:
+		runImport(client, args[1:])
+
+	case actionTypeRestore:
+		runRestore(client, args[1:])
+
+	case actionTypeDebugBundle:
+		runDebugBundle(client, args[1:])
+
+	case actionTypeSyncContacts:
+		runSyncContacts(client, args[1:])
+
+	case actionTypeAuth:
+		runAuth(args[1:])
+
+	case actionTypeLogin:
+		runLogin(args[1:])
+
+	case actionTypeLogout:
+		runLogout(args[1:])
+
+	case actionTypeTUI:
+		runTUI(client, args[1:])
+
+	case actionTypeCompleteAddresses:
+		runCompleteAddresses(client, args[1:])
+
 	case actionTypeSession:
 		session, err := client.Session()
 		if err != nil {
@@ -220,6 +864,20 @@ func main() {
 				return accIDs[i] < accIDs[j]
 			},
 		)
+		if jsonOutput() {
+			accounts := []sessionAccount{}
+			for _, accID := range accIDs {
+				accounts = append(accounts, sessionAccount{
+					ID:      accID,
+					Name:    session.Accounts[accID].Name,
+					Primary: primaryAccountID == accID,
+					Enabled: session.AccountHasCapability(accID, pkg.MaskedEmailCapabilityURI),
+				})
+			}
+			printJSON(accounts)
+			return
+		}
+
 		for _, accID := range accIDs {
 			isPrimary := primaryAccountID == accID
 			isEnabled := session.AccountHasCapability(accID, pkg.MaskedEmailCapabilityURI)
@@ -234,30 +892,51 @@ func main() {
 		}
 
 	case actionTypeCreate:
-		// parse command-specific args
-		createCmd.Parse(args[1:])
+		runCreate(client, args[1:])
 
-		domain := strings.TrimSpace(*flagCreateDomain)
-		description := strings.TrimSpace(*flagCreateDescription)
+	case actionTypeDisable:
+		runSetState(client, actionTypeDisable, args[1:])
 
-		session, err := client.Session()
-		if err != nil {
-			log.Fatalf("initializing session: %v", err)
-		}
+	case actionTypeEnable:
+		runSetState(client, actionTypeEnable, args[1:])
 
-		createRes, err := client.CreateMaskedEmail(session, *flagAccountID, domain, *flagCreateEnabled, description)
-		if err != nil {
-			log.Fatalf("error creating masked email: %v", err)
-		}
+	case actionTypeDelete:
+		runSetState(client, actionTypeDelete, args[1:])
 
-		// success output
-		fmt.Println(createRes.Email)
+	case actionTypeDestroy:
+		runDestroy(client, args[1:])
 
-	case actionTypeDisable:
-		maskedemail := strings.TrimSpace(args[1])
+	case actionTypeList:
+		runList(client, args[1:])
+
+	case actionTypeUpdate:
+		// parse command-specific args
+		parseCommandFlags(updateCmd, args[1:])
 
-		if maskedemail == "" {
-			log.Fatalln("Usage: disable <maskedemail>")
+		maskedemail := strings.TrimSpace(*flagUpdateEmail)
+		domain := strings.TrimSpace(*flagUpdateDomain)
+		description := strings.TrimSpace(*flagUpdateDescription)
+
+		// email arg is required
+		if !isFlagPassed(*updateCmd, flagNameEmail) || (maskedemail == "") {
+			updateCmd.Usage()
+			os.Exit(1)
+		}
+		maskedemail = maskedEmailRefArg(maskedemail, "")
+		if !isFlagPassed(*updateCmd, flagNameDomain) && !isFlagPassed(*updateCmd, flagNameDesc) && !isFlagPassed(*updateCmd, flagNameURL) && !isFlagPassed(*updateCmd, flagNamePrefix) {
+			warnStrict("nothing to update for %s, pass -%s, -%s, -%s or -%s", maskedemail, flagNameDomain, flagNameDesc, flagNameURL, flagNamePrefix)
+		}
+		pageURL := strings.TrimSpace(*flagUpdateURL)
+		if pageURL != "" {
+			var err error
+			if pageURL, err = pkg.PageURL(pageURL); err != nil {
+				log.Fatalf("-%s: %v", flagNameURL, err)
+			}
+		}
+		if isFlagPassed(*updateCmd, flagNamePrefix) {
+			if err := pkg.ValidateEmailPrefix(*flagUpdatePrefix); err != nil {
+				log.Fatalln(err)
+			}
 		}
 
 		session, err := client.Session()
@@ -265,137 +944,133 @@ func main() {
 			log.Fatalf("initializing session: %v", err)
 		}
 
-		_, err = client.DisableMaskedEmail(session, *flagAccountID, maskedemail)
-		if err != nil {
-			log.Fatalf("error disabling masked email: %v", err)
+		fields := pkg.NewUpdateFields(isFlagPassed(*updateCmd, flagNameDomain),
+									  domain,
+									  isFlagPassed(*updateCmd, flagNameDesc),
+									  description)
+		if isFlagPassed(*updateCmd, flagNameURL) {
+			fields.SetURL(pageURL)
 		}
-
-		// success output
-		fmt.Printf("disabled masked email: %s\n", maskedemail)
-
-	case actionTypeEnable:
-		maskedemail := strings.TrimSpace(args[1])
-
-		if maskedemail == "" {
-			log.Fatalln("Usage: enable <maskedemail>")
+		if isFlagPassed(*updateCmd, flagNamePrefix) {
+			fields.SetEmailPrefix(*flagUpdatePrefix)
 		}
 
-		session, err := client.Session()
+		email, err := client.ResolveMaskedEmail(session, *flagAccountID, maskedemail)
 		if err != nil {
-			log.Fatalf("initializing session: %v", err)
+			log.Fatalf("error updating masked email: %v", err)
 		}
+		maskedemail = email.Email
 
-		_, err = client.EnableMaskedEmail(session, *flagAccountID, maskedemail)
+		_, err = client.UpdateMaskedEmail(session, *flagAccountID, email.ID, fields)
+		var setErr *pkg.SetError
+		if errors.As(err, &setErr) && setErr.Type == "invalidProperties" && setErr.HasProperty("emailPrefix") {
+			log.Fatalf("error updating masked email: the server doesn't allow changing the prefix of an existing masked email, emailPrefix is immutable (%v)", setErr)
+		}
 		if err != nil {
-			log.Fatalf("error enabling masked email: %v", err)
+			log.Fatalf("error updating masked email: %v", err)
 		}
 
-		// success output
-		fmt.Printf("enabled masked email: %s\n", maskedemail)
-
-	case actionTypeDelete:
-		maskedemail := strings.TrimSpace(args[1])
-
-		if maskedemail == "" {
-			log.Fatalln("Usage: delete <maskedemail>")
+		appendJournal(journalEntry{
+			Action:      actionTypeUpdate,
+			AccountID:   accountIDOrDefault(session),
+			Email:       maskedemail,
+			Domain:      domain,
+			Description: description,
+		})
+
+		if jsonOutput() {
+			printResults(os.Stdout, []itemResult{resultWithMaskedEmail(client, session, maskedemail, actionTypeUpdate)}, true)
+			return
 		}
 
-		session, err := client.Session()
-		if err != nil {
-			log.Fatalf("initializing session: %v", err)
-		}
+		fmt.Printf("updated %s\n", maskedemail)
 
-		_, err = client.DeleteMaskedEmail(session, *flagAccountID, maskedemail)
-		if err != nil {
-			log.Fatalf("error deleting masked email: %v", err)
-		}
+	case actionTypeRename:
+		runRename(client, args[1:])
 
-		// success output
-		fmt.Printf("deleted masked email: %s\n", maskedemail)
+	case actionTypePrune:
+		runPrune(client, args[1:])
 
-	case actionTypeList:
+	case actionTypeOpen:
 		// parse command-specific args
-		listCmd.Parse(args[1:])
+		parseCommandFlags(openCmd, args[1:])
+
+		maskedemail := maskedEmailArg(openCmd.Arg(0), "open [-print-url] <maskedemail>")
 
 		session, err := client.Session()
 		if err != nil {
 			log.Fatalf("initializing session: %v", err)
 		}
 
-		maskedEmails, err := client.GetAllMaskedEmails(session, *flagAccountID)
+		email, err := client.LookupMaskedEmail(session, *flagAccountID, maskedemail)
 		if err != nil {
-			log.Fatalf("err while creating maskedemail: %v", err)
+			log.Fatalf("error looking up masked email: %v", err)
 		}
 
-		w := tabwriter.NewWriter(os.Stdout, 1, 1, 1, ' ', 0)
-
-		// display header line
-		if *flagShowAllFields {
-			fmt.Fprintln(w, "Masked Email\tFor Domain\tDescription\tState\tID\tCreated At\tLast Email At")
-		} else {
-			fmt.Fprintln(w, "Masked Email\tFor Domain\tDescription\tState")
+		if email.URL == nil || strings.TrimSpace(*email.URL) == "" {
+			log.Fatalf("masked email %s has no url", maskedemail)
 		}
 
-		// display each masked email
-		for _, email := range maskedEmails {
-			// skip deleted masked emails unless flag to show is passed
-			if email.State == "deleted" && !*flagShowDeleted {
-				continue
-			}
-
-			// HACK: trim space here is for hack to deal with possible empty strings
-			if *flagShowAllFields {
-				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
-					email.Email,
-					strings.TrimSpace(email.Domain),
-					strings.TrimSpace(email.Description),
-					email.State,
-					email.ID,
-					email.CreatedAt,
-					email.LastMessageAt)
-			} else {
-				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
-					email.Email,
-					strings.TrimSpace(email.Domain),
-					strings.TrimSpace(email.Description),
-					email.State)
-			}
-		}
-		w.Flush()
+		openBrowser(*email.URL, *flagOpenPrintURL)
 
-	case actionTypeUpdate:
+	case actionTypeAnnotate:
 		// parse command-specific args
-		updateCmd.Parse(args[1:])
+		parseCommandFlags(annotateCmd, args[1:])
 
-		maskedemail := strings.TrimSpace(*flagUpdateEmail)
-		domain := strings.TrimSpace(*flagUpdateDomain)
-		description := strings.TrimSpace(*flagUpdateDescription)
+		notes, err := loadNotes()
+		if err != nil {
+			log.Fatalf("error reading notes: %v", err)
+		}
 
-		// email arg is required
-		if !isFlagPassed(*updateCmd, flagNameEmail) || (maskedemail == "") {
-			updateCmd.Usage()
-			os.Exit(1)
+		if *flagAnnotateExport {
+			out, err := json.MarshalIndent(notes, "", "  ")
+			if err != nil {
+				log.Fatalf("error exporting notes: %v", err)
+			}
+			fmt.Println(string(out))
+			return
 		}
 
+		maskedemail := maskedEmailArg(annotateCmd.Arg(0), "annotate [-clear] <maskedemail> [\"<note>\"]")
+
 		session, err := client.Session()
 		if err != nil {
 			log.Fatalf("initializing session: %v", err)
 		}
 
-		fields := pkg.NewUpdateFields(isFlagPassed(*updateCmd, flagNameDomain),
-									  domain,
-									  isFlagPassed(*updateCmd, flagNameDesc),
-									  description)
-
-		_, err = client.UpdateInfo(session, *flagAccountID, maskedemail, fields)
+		emailID, err := client.LookupMaskedEmailID(session, *flagAccountID, maskedemail)
 		if err != nil {
-			log.Fatalf("error updating masked email: %v", err)
+			log.Fatalf("error looking up masked email: %v", err)
 		}
 
-		fmt.Printf("updated %s\n", maskedemail)
+		text := strings.TrimSpace(strings.Join(annotateCmd.Args()[1:], " "))
+
+		switch {
+		case *flagAnnotateClear:
+			delete(notes, emailID)
+		case text != "":
+			notes[emailID] = note{Email: maskedemail, Text: text, UpdatedAt: time.Now().UTC()}
+		default:
+			// no new note given, show the current one
+			if n, ok := notes[emailID]; ok {
+				fmt.Println(n.Text)
+			}
+			return
+		}
+
+		if err := notes.save(); err != nil {
+			log.Fatalf("error saving notes: %v", err)
+		}
+
+		if *flagAnnotateClear {
+			fmt.Printf("cleared note of %s\n", maskedemail)
+		} else {
+			fmt.Printf("annotated %s\n", maskedemail)
+		}
 
 	default:
-		fmt.Println("action not found\n")
+		fmt.Println("action not found")
+		fmt.Println("")
 		flag.Usage()
 		os.Exit(1)
 	}
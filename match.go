@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// matchCandidates is the number of candidates listed when -match is
+// ambiguous.
+const matchCandidates = 10
+
+// fuzzyKey lowercases s and drops everything but letters and digits, so
+// "Net-Flix" and "netflix.com" compare alike.
+func fuzzyKey(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
+// isSubsequence reports whether the runes of sub appear in s in order.
+func isSubsequence(s string, sub string) bool {
+	rs := []rune(s)
+	i := 0
+	for _, r := range sub {
+		for i < len(rs) && rs[i] != r {
+			i++
+		}
+		if i == len(rs) {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// matchRank tells how well the text matches the masked email's domain or
+// description, lower is better: 0 is the exact domain, 1 the text contained
+// in either, 2 its letters in order. -1 is no match.
+func matchRank(email *pkg.MaskedEmail, text string) int {
+	if pkg.NormalizeDomain(email.Domain) == pkg.NormalizeDomain(text) {
+		return 0
+	}
+
+	key := fuzzyKey(text)
+	fields := []string{fuzzyKey(pkg.NormalizeDomain(email.Domain)), fuzzyKey(email.Description)}
+	for _, field := range fields {
+		if strings.Contains(field, key) {
+			return 1
+		}
+	}
+	for _, field := range fields {
+		if isSubsequence(field, key) {
+			return 2
+		}
+	}
+	return -1
+}
+
+// resolveMatch returns the one masked email that matches the text best by
+// domain or description, leaving out deleted ones. It fails listing the
+// candidates if several match equally well.
+func resolveMatch(emails []*pkg.MaskedEmail, text string) (*pkg.MaskedEmail, error) {
+	if fuzzyKey(text) == "" {
+		return nil, fmt.Errorf("nothing to match in %q", text)
+	}
+
+	best := -1
+	var found []*pkg.MaskedEmail
+	for _, email := range emails {
+		if email.State == pkg.MaskedEmailStateDeleted {
+			continue
+		}
+		rank := matchRank(email, text)
+		switch {
+		case rank < 0:
+		case best < 0 || rank < best:
+			best = rank
+			found = []*pkg.MaskedEmail{email}
+		case rank == best:
+			found = append(found, email)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no masked email matches %q", text)
+	case 1:
+		return found[0], nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d masked emails match %q, pass the address or a more specific text:", len(found), text)
+	for i, email := range found {
+		if i == matchCandidates {
+			fmt.Fprintf(&b, "\n  ... and %d more", len(found)-matchCandidates)
+			break
+		}
+		fmt.Fprintf(&b, "\n  %s  %s  %s  %s", email.Email, strings.TrimSpace(email.Domain), strings.TrimSpace(email.Description), email.State)
+	}
+	return nil, fmt.Errorf("%s", b.String())
+}
+
+// compileRegex compiles the pattern of -regex, in the RE2 syntax of Go.
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if strings.TrimSpace(pattern) == "" {
+		return nil, fmt.Errorf("empty regular expression")
+	}
+	return regexp.Compile(pattern)
+}
+
+// regexMatches reports whether the masked email's domain or description
+// matches the regular expression. Like for -match, the random address isn't
+// looked at.
+func regexMatches(email *pkg.MaskedEmail, re *regexp.Regexp) bool {
+	return re.MatchString(email.Domain) || re.MatchString(email.Description)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// maxSubjectLength limits how much of a subject goes into a description.
+const maxSubjectLength = 80
+
+// replyPrefix matches the "Re:" and "Fwd:" prefixes of a subject.
+var replyPrefix = regexp.MustCompile(`(?i)^\s*(re|fwd?|aw|wg)\s*:\s*`)
+
+// messageOrigin is what create -from-message takes from a message.
+type messageOrigin struct {
+	domain  string
+	subject string
+}
+
+// description references the subject of the message, if it has one.
+func (o messageOrigin) description() string {
+	if o.subject == "" {
+		return "from a message of " + o.domain
+	}
+	return "re: " + o.subject
+}
+
+// readMessageOrigin parses the headers of an RFC 822 message and returns the
+// registrable domain of its sender and its subject, decoded and without
+// reply prefixes.
+func readMessageOrigin(r io.Reader) (messageOrigin, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return messageOrigin{}, fmt.Errorf("parsing message: %v", err)
+	}
+
+	from := msg.Header.Get("From")
+	if from == "" {
+		from = msg.Header.Get("Sender")
+	}
+	if from == "" {
+		return messageOrigin{}, errors.New("the message has no From header")
+	}
+
+	address, err := mail.ParseAddress(from)
+	if err != nil {
+		return messageOrigin{}, fmt.Errorf("parsing sender %q: %v", from, err)
+	}
+	// ParseAddress only accepts addresses with an @
+	domain := pkg.RegistrableDomain(address.Address[strings.LastIndex(address.Address, "@")+1:])
+	if domain == "" {
+		return messageOrigin{}, fmt.Errorf("sender %q has no domain", address.Address)
+	}
+
+	subject := msg.Header.Get("Subject")
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+		subject = decoded
+	}
+	subject = strings.Join(strings.Fields(subject), " ")
+	for replyPrefix.MatchString(subject) {
+		subject = replyPrefix.ReplaceAllString(subject, "")
+	}
+	if runes := []rune(subject); len(runes) > maxSubjectLength {
+		subject = strings.TrimSpace(string(runes[:maxSubjectLength-1])) + "…"
+	}
+
+	return messageOrigin{domain: domain, subject: subject}, nil
+}
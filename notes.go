@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const notesFileName string = "notes.json"
+
+// note is a free-form local annotation of a masked email, for metadata that
+// doesn't fit into the description.
+type note struct {
+	Email     string    `json:"email"`
+	Text      string    `json:"text"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// notesStore maps masked email IDs to their notes. It's kept as a single JSON
+// file in the state directory.
+type notesStore map[string]note
+
+func notesPath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, notesFileName), nil
+}
+
+// loadNotes reads the notes store. A missing file yields an empty store.
+func loadNotes() (notesStore, error) {
+	notes := notesStore{}
+
+	path, err := notesPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return notes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &notes); err != nil {
+		return nil, err
+	}
+
+	return notes, nil
+}
+
+func (notes notesStore) save() error {
+	path, err := notesPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(notes, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temp file first so an interrupted save can't lose all notes
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
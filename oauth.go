@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+const (
+	envOAuthIssuerVarName   string = "MASKEDEMAIL_OAUTH_ISSUER"
+	envOAuthClientIDVarName string = "MASKEDEMAIL_OAUTH_CLIENT_ID"
+
+	secretProviderOAuth string = "oauth"
+
+	defaultOAuthIssuer string = "https://api.fastmail.com"
+
+	// oauthLoginTimeout is how long login waits for the user to approve
+	// the access in the browser.
+	oauthLoginTimeout = 5 * time.Minute
+
+	// oauthRefreshMargin refreshes access tokens this long before they
+	// expire, so they don't expire in the middle of a command.
+	oauthRefreshMargin = time.Minute
+)
+
+// oauthScope asks for access to masked emails only.
+var oauthScope = strings.Join([]string{"urn:ietf:params:jmap:core", pkg.MaskedEmailCapabilityURI}, " ")
+
+// oauthMetadata are the endpoints of the authorization server (RFC 8414).
+type oauthMetadata struct {
+	AuthorizationEndpoint       string `json:"authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+}
+
+// oauthCredentials are stored as JSON in the keyring, with what's needed to
+// refresh the access token.
+type oauthCredentials struct {
+	ClientID      string    `json:"clientId"`
+	TokenEndpoint string    `json:"tokenEndpoint"`
+	AccessToken   string    `json:"accessToken"`
+	RefreshToken  string    `json:"refreshToken,omitempty"`
+	Expiry        time.Time `json:"expiry,omitempty"`
+}
+
+// oauthTokenResponse is the answer of the token endpoint, or its error.
+type oauthTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// oauthIssuer returns the authorization server, Fastmail unless
+// $MASKEDEMAIL_OAUTH_ISSUER is set (for tests).
+func oauthIssuer() string {
+	if issuer := os.Getenv(envOAuthIssuerVarName); issuer != "" {
+		return strings.TrimSuffix(issuer, "/")
+	}
+	return defaultOAuthIssuer
+}
+
+// discoverOAuth fetches the metadata of the authorization server.
+func discoverOAuth(ctx context.Context, issuer string) (*oauthMetadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/oauth-authorization-server", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovering OAuth endpoints of %s: %s", issuer, res.Status)
+	}
+
+	var meta oauthMetadata
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxSecretSize)).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("discovering OAuth endpoints of %s: %v", issuer, err)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" {
+		return nil, fmt.Errorf("%s announces no OAuth authorization and token endpoints", issuer)
+	}
+	return &meta, nil
+}
+
+// requestToken posts form to the token endpoint and returns the new
+// credentials. OAuth errors are returned as *oauthError.
+func requestToken(ctx context.Context, endpoint string, clientID string, form url.Values) (*oauthCredentials, error) {
+	form.Set("client_id", clientID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var tr oauthTokenResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxSecretSize)).Decode(&tr); err != nil {
+		return nil, fmt.Errorf("token endpoint: %s", res.Status)
+	}
+	if tr.Error != "" {
+		return nil, &oauthError{Code: tr.Error, Description: tr.ErrorDescription}
+	}
+	if tr.AccessToken == "" {
+		return nil, errors.New("token endpoint returned no access token")
+	}
+
+	creds := &oauthCredentials{
+		ClientID:      clientID,
+		TokenEndpoint: endpoint,
+		AccessToken:   tr.AccessToken,
+		RefreshToken:  tr.RefreshToken,
+	}
+	if tr.ExpiresIn > 0 {
+		creds.Expiry = time.Now().UTC().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return creds, nil
+}
+
+// oauthError is an error response of the token endpoint (RFC 6749 5.2).
+type oauthError struct {
+	Code        string
+	Description string
+}
+
+func (e *oauthError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("authorization failed: %s: %s", e.Code, e.Description)
+	}
+	return "authorization failed: " + e.Code
+}
+
+// randomURLString returns n random bytes, base64url encoded.
+func randomURLString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// oauthLogin runs the authorization code flow with PKCE (RFC 7636): the
+// browser opens the authorization page, which redirects back to a server on
+// the loopback interface with the code. The URL of the page is printed too,
+// with printURL no browser is started.
+func oauthLogin(meta *oauthMetadata, clientID string, printURL bool) (*oauthCredentials, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	redirectURI := "http://" + listener.Addr().String() + "/callback"
+
+	state := randomURLString(16)
+	verifier := randomURLString(32)
+	challenge := sha256.Sum256([]byte(verifier))
+
+	authURL := meta.AuthorizationEndpoint + "?" + url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {oauthScope},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+
+	type callback struct {
+		code string
+		err  error
+	}
+	done := make(chan callback, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		var result callback
+		switch {
+		case q.Get("state") != state:
+			result.err = errors.New("the authorization response doesn't belong to this login")
+		case q.Get("error") != "":
+			result.err = &oauthError{Code: q.Get("error"), Description: q.Get("error_description")}
+		default:
+			result.code = q.Get("code")
+		}
+		if result.err != nil {
+			http.Error(w, result.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintf(w, "%s is logged in, you can close this window.\n", defaultAppname)
+		}
+		select {
+		case done <- result:
+		default:
+		}
+	})}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	// the URL is always shown, whether a browser opens isn't known yet
+	fmt.Fprintf(os.Stderr, "Open this URL to approve the access:\n%s\n", authURL)
+	if !printURL {
+		go startBrowser(authURL)
+	}
+
+	var result callback
+	select {
+	case result = <-done:
+	case <-time.After(oauthLoginTimeout):
+		return nil, fmt.Errorf("no authorization within %s", oauthLoginTimeout)
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *flagTimeout)
+	defer cancel()
+	return requestToken(ctx, meta.TokenEndpoint, clientID, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {result.code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+}
+
+// oauthDeviceLogin runs the device authorization flow (RFC 8628), for
+// machines without a browser: the user approves on another device.
+func oauthDeviceLogin(meta *oauthMetadata, clientID string) (*oauthCredentials, error) {
+	if meta.DeviceAuthorizationEndpoint == "" {
+		return nil, errors.New("the authorization server doesn't support the device flow")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), oauthLoginTimeout)
+	defer cancel()
+
+	form := url.Values{"client_id": {clientID}, "scope": {oauthScope}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.DeviceAuthorizationEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("device authorization: %s", res.Status)
+	}
+
+	var device struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		Interval                int    `json:"interval"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxSecretSize)).Decode(&device); err != nil {
+		return nil, fmt.Errorf("device authorization: %v", err)
+	}
+
+	if device.VerificationURIComplete != "" {
+		fmt.Fprintf(os.Stderr, "Open %s on any device and approve the access.\n", device.VerificationURIComplete)
+	} else {
+		fmt.Fprintf(os.Stderr, "Open %s on any device and enter the code %s.\n", device.VerificationURI, device.UserCode)
+	}
+
+	// RFC 8628 3.5: poll every interval, 5s by default, slower on slow_down
+	interval := time.Duration(device.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("no authorization within %s", oauthLoginTimeout)
+		case <-time.After(interval):
+		}
+
+		creds, err := requestToken(ctx, meta.TokenEndpoint, clientID, url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {device.DeviceCode},
+		})
+		var oerr *oauthError
+		if errors.As(err, &oerr) && oerr.Code == "authorization_pending" {
+			continue
+		}
+		if errors.As(err, &oerr) && oerr.Code == "slow_down" {
+			interval += 5 * time.Second
+			continue
+		}
+		return creds, err
+	}
+}
+
+// storeOAuthCredentials saves the credentials of the profile in the keyring
+// and returns the keyring account.
+func storeOAuthCredentials(profile string, creds *oauthCredentials) string {
+	data, err := json.Marshal(creds)
+	if err != nil {
+		panic(err)
+	}
+	return storeKeyringToken(profile, string(data))
+}
+
+// oauthProvider returns the access token of the credentials in the keyring,
+// refreshing it first if it's about to expire.
+type oauthProvider struct {
+	account string
+}
+
+func newOAuthProvider(src secretSource) (secretProvider, error) {
+	account := strings.TrimSpace(src.Account)
+	if account == "" {
+		account = defaultKeyringAccount
+	}
+	return &oauthProvider{account: account}, nil
+}
+
+func (p *oauthProvider) fetchSecret(ctx context.Context) (string, error) {
+	k, err := systemKeyring()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := k.get(p.account)
+	if errors.Is(err, errKeyringNotFound) {
+		return "", fmt.Errorf("not logged in as %q, log in with `%s %s -%s`", p.account, defaultAppname, actionTypeLogin, flagNameOAuth)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var creds oauthCredentials
+	if err := json.Unmarshal([]byte(data), &creds); err != nil {
+		return "", fmt.Errorf("reading credentials of %q from the keyring: %v", p.account, err)
+	}
+
+	if creds.Expiry.IsZero() || time.Until(creds.Expiry) > oauthRefreshMargin {
+		return creds.AccessToken, nil
+	}
+	if creds.RefreshToken == "" {
+		return "", fmt.Errorf("the access token of %q expired, log in again with `%s %s -%s`", p.account, defaultAppname, actionTypeLogin, flagNameOAuth)
+	}
+
+	refreshed, err := requestToken(ctx, creds.TokenEndpoint, creds.ClientID, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {creds.RefreshToken},
+	})
+	if err != nil {
+		return "", fmt.Errorf("refreshing the access token: %w", err)
+	}
+	// servers may keep the refresh token instead of rotating it
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = creds.RefreshToken
+	}
+
+	updated, err := json.Marshal(refreshed)
+	if err != nil {
+		return "", err
+	}
+	if err := k.set(p.account, string(updated)); err != nil {
+		return "", fmt.Errorf("storing the refreshed access token: %v", err)
+	}
+	return refreshed.AccessToken, nil
+}
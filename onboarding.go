@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+const tokenSettingsURL string = "https://app.fastmail.com/settings/security/tokens"
+
+// verifyToken checks the token against the session endpoint and returns the
+// session if it grants access to at least one masked email account.
+func verifyToken(token string, appname string) (*pkg.SessionResource, error) {
+	client := newClient(token, appname)
+
+	session, err := client.Session()
+	if err != nil {
+		return nil, fmt.Errorf("verifying token: %w", err)
+	}
+
+	if len(maskedEmailAccounts(session)) == 0 {
+		return nil, errors.New("the token has no access to Masked Email, make sure the \"Masked Email\" scope is selected")
+	}
+
+	return session, nil
+}
+
+// maskedEmailAccounts returns the IDs of all accounts with the masked email
+// capability, the primary account first.
+func maskedEmailAccounts(session *pkg.SessionResource) []string {
+	primary := session.DefaultAccountForCapability(pkg.MaskedEmailCapabilityURI)
+
+	var accIDs []string
+	for accID := range session.Accounts {
+		if session.AccountHasCapability(accID, pkg.MaskedEmailCapabilityURI) {
+			accIDs = append(accIDs, accID)
+		}
+	}
+
+	sort.Slice(accIDs, func(i, j int) bool {
+		if accIDs[i] == primary || accIDs[j] == primary {
+			return accIDs[i] == primary
+		}
+		return accIDs[i] < accIDs[j]
+	})
+
+	return accIDs
+}
+
+// selectAccount lets the user pick one of the masked email accounts. With a
+// single account no question is asked.
+func selectAccount(session *pkg.SessionResource) string {
+	accIDs := maskedEmailAccounts(session)
+	if len(accIDs) == 1 {
+		return accIDs[0]
+	}
+
+	fmt.Fprintln(os.Stderr, "The token has access to multiple accounts:")
+	for i, accID := range accIDs {
+		fmt.Fprintf(os.Stderr, "  %d) %s [%s]\n", i+1, session.Accounts[accID].Name, accID)
+	}
+
+	for {
+		answer := ask("Account to use", "1")
+		i, err := strconv.Atoi(answer)
+		if err == nil && i >= 1 && i <= len(accIDs) {
+			return accIDs[i-1]
+		}
+		fmt.Fprintf(os.Stderr, "please enter a number between 1 and %d\n", len(accIDs))
+	}
+}
+
+// runOnboarding interactively sets up a token on first use and writes it to
+// the given profile of the config file. It returns the verified settings.
+func runOnboarding(profile string, appname string) (*profileConfig, error) {
+	fmt.Fprintf(os.Stderr, "Welcome to %s! No API token is configured yet.\n\n", defaultAppname)
+	fmt.Fprintf(os.Stderr, "1. Create a Fastmail API token at %s\n", tokenSettingsURL)
+	fmt.Fprintln(os.Stderr, "   The only scope needed is \"Masked Email\".")
+	fmt.Fprintln(os.Stderr, "2. Paste the token below (the input is hidden).")
+	fmt.Fprintln(os.Stderr)
+
+	token, err := readSecret("Token: ")
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return nil, errors.New("no token entered")
+	}
+
+	session, err := verifyToken(token, appname)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+
+	p := profileConfig{Token: token, AccountID: selectAccount(session)}
+	cfg.setProfile(profile, p)
+
+	if err := cfg.save(); err != nil {
+		return nil, fmt.Errorf("writing config: %w", err)
+	}
+
+	path, _ := configPath()
+	fmt.Fprintf(os.Stderr, "Saved token and account %s to %s\n\n", p.AccountID, path)
+
+	return &p, nil
+}
+
+// flags for init command
+var initCmd = flag.NewFlagSet(actionTypeInit, flag.ExitOnError)
+var flagInitTokenFromEnv = initCmd.Bool(flagNameTokenFromEnv, false, "read the token from the "+envTokenVarName+" env instead of -"+flagNameToken)
+var flagInitAccount = initCmd.String(flagNameAccount, "", "account id to use (default: primary masked email account)")
+
+// runInit non-interactively creates a validated config profile, for
+// provisioning scripts and dotfile managers. It never prompts.
+func runInit(args []string) {
+	parseCommandFlags(initCmd, args)
+
+	token := *flagToken
+	if *flagInitTokenFromEnv {
+		token = os.Getenv(envTokenVarName)
+	}
+	if token == "" {
+		log.Fatalf("no token given, pass -%s or -%s", flagNameToken, flagNameTokenFromEnv)
+	}
+
+	session, err := verifyToken(token, *flagAppname)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	accID := *flagInitAccount
+	if accID == "" {
+		accID = maskedEmailAccounts(session)[0]
+	} else if !session.AccountHasCapability(accID, pkg.MaskedEmailCapabilityURI) {
+		log.Fatalf("account %s not found or has no access to Masked Email", accID)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("error reading config: %v", err)
+	}
+
+	p := profileConfig{Token: token, AccountID: accID}
+	if isFlagPassed(*flag.CommandLine, "appname") {
+		p.Appname = *flagAppname
+	}
+	cfg.setProfile(*flagProfile, p)
+
+	if err := cfg.save(); err != nil {
+		log.Fatalf("error writing config: %v", err)
+	}
+
+	path, _ := configPath()
+	if *flagProfile != "" {
+		fmt.Printf("wrote profile %s (account %s) to %s\n", *flagProfile, accID, path)
+	} else {
+		fmt.Printf("wrote account %s to %s\n", accID, path)
+	}
+}
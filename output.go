@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+const (
+	formatText  string = "text"
+	formatJSON  string = "json"
+	formatJSONL string = "jsonl"
+	formatCSV   string = "csv"
+)
+
+// jsonOutputCommands are the commands supporting -output json. Their JSON
+// schema is part of the output contract for scripts, don't change it.
+var jsonOutputCommands = map[actionType]bool{
+	actionTypeVersion:       true,
+	actionTypeSession:       true,
+	actionTypeCreate:        true,
+	actionTypeList:          true,
+	actionTypeEnable:        true,
+	actionTypeDisable:       true,
+	actionTypeDelete:        true,
+	actionTypeDestroy:       true,
+	actionTypeUpdate:        true,
+	actionTypeRename:        true,
+	actionTypePrune:         true,
+	actionTypeDupes:         true,
+	actionTypeStats:         true,
+	actionTypeTransfer:      true,
+	actionTypeImport:        true,
+	actionTypeRestore:       true,
+	actionTypeShow:          true,
+	actionTypeSearch:        true,
+	actionTypeFindForDomain: true,
+	actionTypeWatch:         true,
+}
+
+// templateOutputCommands are the commands supporting -template.
+var templateOutputCommands = map[actionType]bool{
+	actionTypeCreate:        true,
+	actionTypeList:          true,
+	actionTypeShow:          true,
+	actionTypeSearch:        true,
+	actionTypeFindForDomain: true,
+}
+
+// outputTemplate is the parsed -template, nil if none is given.
+var outputTemplate *template.Template
+
+// templateFuncs are available in -template in addition to the builtins.
+var templateFuncs = template.FuncMap{
+	// rfc3339 formats a time.Time or *time.Time like the API, "" if unset
+	"rfc3339": func(v interface{}) string {
+		switch t := v.(type) {
+		case time.Time:
+			return formatTimestamp(&t)
+		case *time.Time:
+			return formatTimestamp(t)
+		}
+		return fmt.Sprint(v)
+	},
+	// deref returns the value of a nullable field like .URL, "" if unset
+	"deref": func(v interface{}) interface{} {
+		switch p := v.(type) {
+		case *string:
+			if p == nil {
+				return ""
+			}
+			return *p
+		case *time.Time:
+			if p == nil {
+				return ""
+			}
+			return *p
+		}
+		return v
+	},
+}
+
+// validateOutput resolves -json into -output, parses -template and exits if
+// the format is unknown or not supported by the command.
+func validateOutput(action actionType) {
+	if *flagJSON {
+		*flagOutput = formatJSON
+	}
+
+	if *flagTemplate != "" {
+		if *flagOutput != formatText {
+			log.Fatalf("-%s can't be combined with -%s %s", flagNameTemplate, flagNameOutput, *flagOutput)
+		}
+		if action != actionTypeUnknown && !templateOutputCommands[action] {
+			log.Fatalf("%s doesn't support -%s", action, flagNameTemplate)
+		}
+
+		tmpl, err := template.New(flagNameTemplate).Funcs(templateFuncs).Parse(*flagTemplate)
+		if err != nil {
+			log.Fatalf("invalid -%s: %v", flagNameTemplate, err)
+		}
+		outputTemplate = tmpl
+	}
+
+	switch *flagOutput {
+	case formatText:
+	case formatJSON:
+		if action != actionTypeUnknown && !jsonOutputCommands[action] {
+			log.Fatalf("%s doesn't support -%s %s", action, flagNameOutput, formatJSON)
+		}
+	default:
+		log.Fatalf("unsupported output format %q (%s|%s)", *flagOutput, formatText, formatJSON)
+	}
+}
+
+// jsonOutput reports whether the command should print JSON instead of text.
+func jsonOutput() bool {
+	return *flagOutput == formatJSON
+}
+
+// printTemplate renders -template for each masked email to stdout, each
+// followed by a newline unless the template ends with one.
+func printTemplate(emails ...*pkg.MaskedEmail) {
+	newline := !strings.HasSuffix(*flagTemplate, "\n")
+
+	for _, email := range emails {
+		if err := outputTemplate.Execute(os.Stdout, email); err != nil {
+			log.Fatalf("error rendering -%s: %v", flagNameTemplate, err)
+		}
+		if newline {
+			fmt.Println()
+		}
+	}
+}
+
+// printJSONLines writes each masked email as a compact JSON object on a line
+// of its own, JSON Lines, so tools can process them one by one. list only
+// uses it when sorting, otherwise it streams them with streamJSONLines.
+func printJSONLines(emails []*pkg.MaskedEmail) {
+	w := bufio.NewWriter(os.Stdout)
+	enc := json.NewEncoder(w)
+	for _, email := range emails {
+		if err := enc.Encode(email); err != nil {
+			log.Fatalf("error encoding output: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatalf("error writing output: %v", err)
+	}
+}
+
+// printJSON writes v as indented JSON to stdout.
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatalf("error encoding output: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	perfPhaseSession string = "session fetch"
+	perfPhaseAPI     string = "api call"
+)
+
+// perfRecorder collects how long the HTTP requests of a command took, to
+// report where time was spent with -profile-perf.
+type perfRecorder struct {
+	start time.Time
+
+	mu       sync.Mutex
+	phases   map[string]time.Duration
+	requests map[string]int
+}
+
+func newPerfRecorder() *perfRecorder {
+	return &perfRecorder{
+		start:    time.Now(),
+		phases:   map[string]time.Duration{},
+		requests: map[string]int{},
+	}
+}
+
+func (p *perfRecorder) add(phase string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.phases[phase] += d
+	p.requests[phase]++
+}
+
+// transport wraps next so that every request, including reading its
+// response body, is timed.
+func (p *perfRecorder) transport(next http.RoundTripper) http.RoundTripper {
+	return &timingTransport{next: next, rec: p}
+}
+
+// report prints the time spent per phase to stderr. Everything that isn't
+// network time is attributed to rendering and local processing.
+func (p *perfRecorder) report() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	total := time.Since(p.start)
+	network := time.Duration(0)
+
+	w := newTabWriter(os.Stderr, 2)
+	fmt.Fprintln(w, "timing:")
+	for _, phase := range []string{perfPhaseSession, perfPhaseAPI} {
+		if p.requests[phase] == 0 {
+			continue
+		}
+		network += p.phases[phase]
+		fmt.Fprintf(w, "  %s\t%v\t(%d requests)\n", phase, p.phases[phase].Round(time.Millisecond), p.requests[phase])
+	}
+	fmt.Fprintf(w, "  rendering/other\t%v\t\n", (total - network).Round(time.Millisecond))
+	fmt.Fprintf(w, "  total\t%v\t\n", total.Round(time.Millisecond))
+	w.Flush()
+}
+
+type timingTransport struct {
+	next http.RoundTripper
+	rec  *perfRecorder
+}
+
+func (t *timingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	phase := perfPhaseAPI
+	if strings.Contains(req.URL.Path, "session") {
+		phase = perfPhaseSession
+	}
+
+	start := time.Now()
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.rec.add(phase, time.Since(start))
+		return nil, err
+	}
+
+	res.Body = &timedBody{ReadCloser: res.Body, done: func() { t.rec.add(phase, time.Since(start)) }}
+	return res, nil
+}
+
+// timedBody reports the elapsed time once the response body is closed.
+type timedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *timedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// maxEmailPrefixLength is the longest emailPrefix the API accepts.
+const maxEmailPrefixLength = 64
+
+const mailtoScheme = "mailto:"
+
+var (
+	emailPrefixPattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+	localPartPattern   = regexp.MustCompile(`^[a-z0-9_]+(\.[a-z0-9_]+)*$`)
+	domainPattern      = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,}$`)
+)
+
+// MaskedAddress is a masked email address split into its parts.
+//
+// Fastmail generates the local part as dot-separated words ending in digits,
+// e.g. "bright.apple1234". When the masked email is created with an
+// emailPrefix, the prefix comes first: "shop.apple1234". As both forms look
+// alike, Prefix is simply everything before the last dot (the first word for
+// generated addresses) and Generated is the last word.
+type MaskedAddress struct {
+	// Address is the complete, lowercased address.
+	Address string
+	// LocalPart is the part before the "@".
+	LocalPart string
+	// Domain is the part after the "@", e.g. "fastmail.com" or a custom
+	// domain.
+	Domain string
+	// Prefix is the local part before the last dot, empty if there is none.
+	Prefix string
+	// Generated is the last word of the local part.
+	Generated string
+}
+
+// IsMaskedEmailID reports whether ref is the JMAP ID of a masked email rather
+// than its address, in any of the forms of BareAddress. IDs never contain an
+// @.
+func IsMaskedEmailID(ref string) bool {
+	return strings.TrimSpace(ref) != "" && !strings.Contains(BareAddress(ref), "@")
+}
+
+// ParseMaskedAddress validates that s has the format of a masked email
+// address and splits it into its parts. Surrounding whitespace and case are
+// ignored, as are the formatting of addresses copied from mail clients, see
+// BareAddress.
+func ParseMaskedAddress(s string) (*MaskedAddress, error) {
+	address := strings.ToLower(BareAddress(s))
+
+	at := strings.LastIndex(address, "@")
+	if at <= 0 || at == len(address)-1 {
+		return nil, fmt.Errorf("%q is not an email address", s)
+	}
+
+	localPart, domain := address[:at], address[at+1:]
+	if !localPartPattern.MatchString(localPart) {
+		return nil, fmt.Errorf("%q is not a masked email address: unexpected characters before the @", s)
+	}
+	if !domainPattern.MatchString(domain) {
+		return nil, fmt.Errorf("%q is not a masked email address: invalid domain %q", s, domain)
+	}
+
+	parsed := &MaskedAddress{
+		Address:   address,
+		LocalPart: localPart,
+		Domain:    domain,
+		Generated: localPart,
+	}
+	if dot := strings.LastIndex(localPart, "."); dot >= 0 {
+		parsed.Prefix = localPart[:dot]
+		parsed.Generated = localPart[dot+1:]
+	}
+
+	return parsed, nil
+}
+
+// BareAddress extracts the address from the forms mail clients copy it in:
+// a mailto: URI like "mailto:foo@example.com?subject=Hi" or a display name
+// with the address in angle brackets like `"Shop" <foo@example.com>`. Other
+// strings are returned with surrounding whitespace removed.
+func BareAddress(s string) string {
+	s = strings.TrimSpace(s)
+
+	if open := strings.LastIndex(s, "<"); open >= 0 {
+		if end := strings.Index(s[open:], ">"); end > 0 {
+			s = strings.TrimSpace(s[open+1 : open+end])
+		}
+	}
+
+	if len(s) >= len(mailtoScheme) && strings.EqualFold(s[:len(mailtoScheme)], mailtoScheme) {
+		s = s[len(mailtoScheme):]
+		if q := strings.Index(s, "?"); q >= 0 {
+			s = s[:q]
+		}
+		if unescaped, err := url.PathUnescape(s); err == nil {
+			s = unescaped
+		}
+		s = strings.TrimSpace(s)
+	}
+
+	return s
+}
+
+// ValidateEmailPrefix checks a custom emailPrefix against the rules of the
+// API: up to 64 characters out of a-z, 0-9 and _.
+func ValidateEmailPrefix(prefix string) error {
+	if prefix == "" {
+		return fmt.Errorf("email prefix must not be empty")
+	}
+	if len(prefix) > maxEmailPrefixLength {
+		return fmt.Errorf("email prefix %q is longer than %d characters", prefix, maxEmailPrefixLength)
+	}
+	if !emailPrefixPattern.MatchString(prefix) {
+		return fmt.Errorf("email prefix %q may only contain a-z, 0-9 and _", prefix)
+	}
+	return nil
+}
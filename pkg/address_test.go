@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMaskedAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    MaskedAddress
+		wantErr string
+	}{
+		{
+			name:  "generated",
+			input: "bright.apple1234@fastmail.com",
+			want:  MaskedAddress{Address: "bright.apple1234@fastmail.com", LocalPart: "bright.apple1234", Domain: "fastmail.com", Prefix: "bright", Generated: "apple1234"},
+		},
+		{
+			name:  "prefixed",
+			input: "my_shop.apple1234@fastmail.com",
+			want:  MaskedAddress{Address: "my_shop.apple1234@fastmail.com", LocalPart: "my_shop.apple1234", Domain: "fastmail.com", Prefix: "my_shop", Generated: "apple1234"},
+		},
+		{
+			name:  "several dots",
+			input: "a.b.c123@example.co.uk",
+			want:  MaskedAddress{Address: "a.b.c123@example.co.uk", LocalPart: "a.b.c123", Domain: "example.co.uk", Prefix: "a.b", Generated: "c123"},
+		},
+		{
+			name:  "no dot",
+			input: "apple1234@fastmail.com",
+			want:  MaskedAddress{Address: "apple1234@fastmail.com", LocalPart: "apple1234", Domain: "fastmail.com", Generated: "apple1234"},
+		},
+		{
+			name:  "upper case and whitespace",
+			input: "  Shop.Apple1234@FastMail.COM\n",
+			want:  MaskedAddress{Address: "shop.apple1234@fastmail.com", LocalPart: "shop.apple1234", Domain: "fastmail.com", Prefix: "shop", Generated: "apple1234"},
+		},
+		{
+			name:  "mailto with query and escapes",
+			input: "MAILTO:shop.apple1234%40fastmail.com?subject=Hi%20there",
+			want:  MaskedAddress{Address: "shop.apple1234@fastmail.com", LocalPart: "shop.apple1234", Domain: "fastmail.com", Prefix: "shop", Generated: "apple1234"},
+		},
+		{
+			name:  "display name",
+			input: `"Shop, Inc." <Shop.Apple1234@fastmail.com>`,
+			want:  MaskedAddress{Address: "shop.apple1234@fastmail.com", LocalPart: "shop.apple1234", Domain: "fastmail.com", Prefix: "shop", Generated: "apple1234"},
+		},
+		{name: "no at", input: "shop.apple1234", wantErr: "is not an email address"},
+		{name: "empty local part", input: "@fastmail.com", wantErr: "is not an email address"},
+		{name: "empty domain", input: "shop.apple1234@", wantErr: "is not an email address"},
+		{name: "plus", input: "shop+news@fastmail.com", wantErr: "unexpected characters before the @"},
+		{name: "empty word", input: "shop..apple1234@fastmail.com", wantErr: "unexpected characters before the @"},
+		{name: "single label domain", input: "shop.apple1234@fastmail", wantErr: `invalid domain "fastmail"`},
+		{name: "hyphen at label start", input: "shop.apple1234@-fastmail.com", wantErr: `invalid domain "-fastmail.com"`},
+		{name: "digits in tld", input: "shop.apple1234@fastmail.c0m", wantErr: `invalid domain "fastmail.c0m"`},
+		{name: "underscore in domain", input: "shop.apple1234@fast_mail.com", wantErr: `invalid domain "fast_mail.com"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMaskedAddress(tt.input)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseMaskedAddress(%q) error = %v, want %q", tt.input, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseMaskedAddress(%q) error = %v", tt.input, err)
+			}
+			if *got != tt.want {
+				t.Errorf("ParseMaskedAddress(%q) = %+v, want %+v", tt.input, *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBareAddress(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"  shop.apple1234@fastmail.com\t", "shop.apple1234@fastmail.com"},
+		{"Shop.Apple1234@fastmail.com", "Shop.Apple1234@fastmail.com"},
+		{`"Shop" <shop.apple1234@fastmail.com>`, "shop.apple1234@fastmail.com"},
+		{"Shop < shop.apple1234@fastmail.com >", "shop.apple1234@fastmail.com"},
+		{"mailto:shop.apple1234@fastmail.com?subject=Hi&body=x", "shop.apple1234@fastmail.com"},
+		{"Mailto:shop%2Bnews%40fastmail.com", "shop+news@fastmail.com"},
+		{"<mailto:shop.apple1234@fastmail.com>", "shop.apple1234@fastmail.com"},
+		{"mailto:bad%zzescape@fastmail.com", "bad%zzescape@fastmail.com"},
+		{"me1", "me1"},
+	}
+	for _, tt := range tests {
+		if got := BareAddress(tt.input); got != tt.want {
+			t.Errorf("BareAddress(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestIsMaskedEmailID(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"me1", true},
+		{" masked-2f1c ", true},
+		{"shop.apple1234@fastmail.com", false},
+		{`"Shop" <shop.apple1234@fastmail.com>`, false},
+		{"mailto:shop.apple1234%40fastmail.com", false},
+		{"", false},
+		{"   ", false},
+	}
+	for _, tt := range tests {
+		if got := IsMaskedEmailID(tt.input); got != tt.want {
+			t.Errorf("IsMaskedEmailID(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestValidateEmailPrefix(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		wantErr string
+	}{
+		{name: "letters digits underscore", prefix: "my_shop_2024"},
+		{name: "64 characters", prefix: strings.Repeat("a", 64)},
+		{name: "65 characters", prefix: strings.Repeat("a", 65), wantErr: "is longer than 64 characters"},
+		{name: "empty", prefix: "", wantErr: "email prefix must not be empty"},
+		{name: "upper case", prefix: "Shop", wantErr: "may only contain a-z, 0-9 and _"},
+		{name: "hyphen", prefix: "my-shop", wantErr: "may only contain a-z, 0-9 and _"},
+		{name: "dot", prefix: "my.shop", wantErr: "may only contain a-z, 0-9 and _"},
+		{name: "space", prefix: "my shop", wantErr: "may only contain a-z, 0-9 and _"},
+		{name: "non-ascii", prefix: "café", wantErr: "may only contain a-z, 0-9 and _"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateEmailPrefix(tt.prefix)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateEmailPrefix(%q) error = %v", tt.prefix, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateEmailPrefix(%q) error = %v, want %q", tt.prefix, err, tt.wantErr)
+			}
+		})
+	}
+}
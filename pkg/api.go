@@ -2,13 +2,14 @@ package pkg
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-
-	"github.com/mitchellh/mapstructure"
+	"strings"
+	"time"
 )
 
 const (
@@ -22,6 +23,10 @@ const (
 	MaskedEmailCapabilityURI = "https://www.fastmail.com/dev/maskedemail"
 )
 
+// ErrReadOnly is returned for any request that would modify data while the
+// client is in read-only mode.
+var ErrReadOnly = errors.New("refusing to modify masked emails: client is read-only")
+
 // errNoAccountID is returned if an account ID is not explicitly provided and
 // a primary account is not found for the required capability URI.
 var errNoAccountID = errors.New("no account specified and no default account for masked email")
@@ -48,6 +53,10 @@ type UpdateFields struct {
 	domain           string
 	isDescriptionSet bool
 	description      string
+	isEmailPrefixSet bool
+	emailPrefix      string
+	isURLSet         bool
+	url              string
 }
 
 func NewUpdateFields(isDomainSet bool,
@@ -62,67 +71,202 @@ func NewUpdateFields(isDomainSet bool,
 	}
 }
 
+// SetEmailPrefix also changes the emailPrefix. Note that the server may
+// reject this as the prefix is part of the address; UpdateMaskedEmail then
+// returns a *SetError of type invalidProperties for "emailPrefix".
+func (fields *UpdateFields) SetEmailPrefix(prefix string) {
+	fields.isEmailPrefixSet = true
+	fields.emailPrefix = prefix
+}
+
+// SetURL also changes the url, the empty string removes it.
+func (fields *UpdateFields) SetURL(url string) {
+	fields.isURLSet = true
+	fields.url = url
+}
+
 type Client struct {
-	auth     string
-	clientID string
-	appName  string
+	auth       string
+	clientID   string
+	appName    string
+	dump       io.Writer
+	httpClient *http.Client
+	readOnly   bool
+	sessionURL string
+	ctx        context.Context
+
+	sessionCacheDir string
+	sessionCacheTTL time.Duration
 }
 
 func NewClient(token, appName, clientID string) *Client {
 	return &Client{
-		auth:     token,
-		appName:  appName,
-		clientID: clientID,
+		auth:       token,
+		appName:    appName,
+		clientID:   clientID,
+		httpClient: http.DefaultClient,
+		sessionURL: sessionEndpoint,
+		ctx:        context.Background(),
+	}
+}
+
+// SetContext sets the context of all requests, cancelling it aborts requests
+// in flight.
+func (client *Client) SetContext(ctx context.Context) {
+	client.ctx = ctx
+}
+
+// SetSessionEndpoint replaces the Fastmail session endpoint, e.g. to run
+// against a local fake server in tests.
+func (client *Client) SetSessionEndpoint(url string) {
+	client.sessionURL = url
+}
+
+// SetHTTPClient replaces the HTTP client used for all requests, e.g. to
+// configure timeouts or a custom transport.
+func (client *Client) SetHTTPClient(httpClient *http.Client) {
+	client.httpClient = httpClient
+}
+
+// SetReadOnly makes the client refuse to send any mutating method call
+// (MaskedEmail/set), regardless of what the caller asks for.
+func (client *Client) SetReadOnly(readOnly bool) {
+	client.readOnly = readOnly
+}
+
+// DumpTo makes the client pretty-print every JMAP request and response to w.
+// The authorization header is never written. Pass nil to stop dumping.
+func (client *Client) DumpTo(w io.Writer) {
+	client.dump = w
+}
+
+// dumpJSON writes a request or response body to the dump writer, if any.
+func (client *Client) dumpJSON(header string, body []byte, isRequest bool) {
+	if client.dump == nil {
+		return
 	}
+
+	fmt.Fprintln(client.dump, header)
+	if isRequest {
+		fmt.Fprintln(client.dump, "Authorization: Bearer [REDACTED]")
+	}
+
+	var pretty bytes.Buffer
+	if len(body) > 0 && json.Indent(&pretty, body, "", "  ") == nil {
+		fmt.Fprintln(client.dump, pretty.String())
+	} else if len(body) > 0 {
+		fmt.Fprintln(client.dump, string(body))
+	}
+	fmt.Fprintln(client.dump)
 }
 
 // doRequest adds common headers and executes the HTTP request.
 func (client *Client) doRequest(req *http.Request) (*http.Response, error) {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("authorization", fmt.Sprintf("Bearer %s", client.auth))
-	return http.DefaultClient.Do(req)
+	return client.httpClient.Do(req)
 }
 
-func (client *Client) sendRequest(session Session, r *APIRequest) (*APIResponse, error) {
-	reqJson, err := json.Marshal(r)
+func (client *Client) sendRequest(session Session, r *APIRequest) (_ *APIResponse, err error) {
+	// a session that no longer works, e.g. with a moved apiUrl, must not
+	// stay cached
+	defer func() {
+		var methodErr *MethodError
+		if err != nil && !errors.As(err, &methodErr) && !errors.Is(err, ErrReadOnly) {
+			client.dropCachedSession()
+		}
+	}()
+
+	res, err := client.postRequest(session, r)
 	if err != nil {
 		return nil, err
 	}
+	defer res.Body.Close()
 
-	req, err := http.NewRequest("POST", session.ApiEndpoint(), bytes.NewReader(reqJson))
+	buf := &bytes.Buffer{}
+	_, err = buf.ReadFrom(res.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := client.doRequest(req)
+	client.dumpJSON(fmt.Sprintf("< %s", res.Status), buf.Bytes(), false)
+
+	var apiRes APIResponse
+	err = json.Unmarshal(buf.Bytes(), &apiRes)
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
 
-	buf := &bytes.Buffer{}
-	_, err = buf.ReadFrom(res.Body)
+	client.checkSessionState(session, apiRes.SessionState)
+
+	for _, mr := range apiRes.MethodResponsesParsed {
+		if mr.MethodName == "error" {
+			var methodErr MethodError
+			if err := decodePayload(mr.Payload, &methodErr); err != nil {
+				return nil, err
+			}
+			return nil, &methodErr
+		}
+	}
+
+	return &apiRes, nil
+}
+
+// postRequest sends the API request and returns the response for the caller
+// to read and close.
+func (client *Client) postRequest(session Session, r *APIRequest) (*http.Response, error) {
+	if client.readOnly {
+		for _, mc := range r.MethodCalls {
+			if strings.HasSuffix(mc.MethodName, "/set") {
+				return nil, ErrReadOnly
+			}
+		}
+	}
+
+	reqJson, err := json.Marshal(r)
 	if err != nil {
 		return nil, err
 	}
 
-	var apiRes APIResponse
-	err = json.Unmarshal(buf.Bytes(), &apiRes)
+	req, err := http.NewRequestWithContext(client.ctx, "POST", session.ApiEndpoint(), bytes.NewReader(reqJson))
 	if err != nil {
 		return nil, err
 	}
 
-	return &apiRes, nil
+	client.dumpJSON(fmt.Sprintf("> %s %s", req.Method, req.URL), reqJson, true)
+
+	return client.doRequest(req)
+}
+
+// checkSessionState marks the session outdated and drops it from the cache
+// if a response reports a different session state.
+func (client *Client) checkSessionState(session Session, state string) {
+	if s, ok := session.(*SessionResource); ok && s.State != "" && state != "" && state != s.State {
+		s.outdated = true
+		client.dropCachedSession()
+	}
 }
 
 // Session queries the JMAP auto-discovery endpoint for details about the
 // server and available accounts.
 func (client *Client) Session() (*SessionResource, error) {
-	req, err := http.NewRequest(http.MethodGet, sessionEndpoint, nil)
+	return client.SessionContext(client.ctx)
+}
+
+// SessionContext is Session with a context for this request instead of the
+// one set with SetContext.
+func (client *Client) SessionContext(ctx context.Context) (*SessionResource, error) {
+	if session := client.cachedSession(); session != nil {
+		return session, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, client.sessionURL, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	client.dumpJSON(fmt.Sprintf("> %s %s", req.Method, req.URL), nil, true)
+
 	resp, err := client.doRequest(req)
 	if err != nil {
 		return nil, err
@@ -134,10 +278,18 @@ func (client *Client) Session() (*SessionResource, error) {
 		return nil, err
 	}
 
+	client.dumpJSON(fmt.Sprintf("< %s", resp.Status), jsonBody, false)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected session response: %s", resp.Status)
+	}
+
 	var session SessionResource
 	if err := json.Unmarshal(jsonBody, &session); err != nil {
 		return nil, err
 	}
+	session.fetchedAt = time.Now()
+	client.storeSession(&session)
 
 	return &session, nil
 }
@@ -161,54 +313,176 @@ func (client *Client) accIDOrDefault(session Session, accID string) (string, err
 // used.
 //
 // If `enabled` is set to false, will only create a pending email and needs to be confirmed before it's usable.
+//
+// `url` is the web page the masked email is for, e.g. the signup page, it's
+// left unset if empty.
 func (client *Client) CreateMaskedEmail(
 	session Session,
 	accID string,
 	domain string,
 	enabled bool,
 	description string,
+	url string,
 ) (*MaskedEmail, error) {
-	state := ""
-	if enabled {
-		state = "enabled"
+	request, err := client.CreateMaskedEmailRequest(session, accID, domain, enabled, description, url)
+	if err != nil {
+		return nil, err
 	}
 
-	accID, err := client.accIDOrDefault(session, accID)
+	res, err := client.sendRequest(session, request)
 	if err != nil {
 		return nil, err
 	}
 
-	mc := MethodCall{
-		MethodName: "MaskedEmail/set",
-		Payload:    NewMethodCallCreate(accID, client.appName, domain, state, description),
-		Payload2:   "0",
+	var pl MethodResponseMaskedEmailSet
+	err = decodePayload(res.MethodResponsesParsed[0].Payload, &pl)
+	if err != nil {
+		return nil, err
 	}
 
-	request := APIRequest{
-		Using: []string{
-			"urn:ietf:params:jmap:core",
-			MaskedEmailCapabilityURI,
-		},
-		MethodCalls: []MethodCall{mc},
+	if setErr, ok := pl.NotCreated[client.appName]; ok {
+		return nil, &setErr
 	}
 
-	res, err := client.sendRequest(session, &request)
+	created, err := pl.GetCreatedItem()
 	if err != nil {
 		return nil, err
 	}
 
-	var pl MethodResponseMaskedEmailSet
-	err = mapstructure.Decode(res.MethodResponsesParsed[0].Payload, &pl)
-	if err != nil {
-		return nil, err
+	return &created, nil
+}
+
+// CreateMaskedEmailRequest builds the JMAP request that CreateMaskedEmail
+// sends, without sending it.
+func (client *Client) CreateMaskedEmailRequest(
+	session Session,
+	accID string,
+	domain string,
+	enabled bool,
+	description string,
+	url string,
+) (*APIRequest, error) {
+	return client.createRequest(session, accID, domain, enabled, description, url, []string{client.appName})
+}
+
+// CreateMaskedEmails creates count masked emails for the same domain and
+// description, with as few MaskedEmail/set calls as the server's
+// maxObjectsInSet allows. It returns the created masked emails in order and
+// the reasons the server rejected the others. If a request fails, the error
+// is returned along with the results of the requests before it; the masked
+// emails of the failed request may or may not have been created.
+func (client *Client) CreateMaskedEmails(
+	session Session,
+	accID string,
+	domain string,
+	enabled bool,
+	description string,
+	url string,
+	count int,
+) ([]*MaskedEmail, []SetError, error) {
+	chunkSize := maxObjectsInSet(session)
+	if chunkSize <= 0 {
+		chunkSize = count
+	}
+
+	created := []*MaskedEmail{}
+	notCreated := []SetError{}
+	for start := 0; start < count; start += chunkSize {
+		end := start + chunkSize
+		if end > count {
+			end = count
+		}
+
+		creationIDs := make([]string, 0, end-start)
+		for i := start; i < end; i++ {
+			creationIDs = append(creationIDs, fmt.Sprintf("%s-%d", client.appName, i+1))
+		}
+
+		request, err := client.createRequest(session, accID, domain, enabled, description, url, creationIDs)
+		if err != nil {
+			return created, notCreated, err
+		}
+
+		res, err := client.sendRequest(session, request)
+		if err != nil {
+			return created, notCreated, err
+		}
+
+		var pl MethodResponseMaskedEmailSet
+		if err := decodePayload(res.MethodResponsesParsed[0].Payload, &pl); err != nil {
+			return created, notCreated, err
+		}
+
+		for _, creationID := range creationIDs {
+			if setErr, ok := pl.NotCreated[creationID]; ok {
+				notCreated = append(notCreated, setErr)
+			} else if item, ok := pl.Created[creationID]; ok {
+				item := item
+				created = append(created, &item)
+			}
+		}
 	}
 
-	created, err := pl.GetCreatedItem()
+	return created, notCreated, nil
+}
+
+// CreateMaskedEmailsRequest builds the JMAP request creating count masked
+// emails at once, without sending it. CreateMaskedEmails may split it into
+// several requests to stay within the server's maxObjectsInSet.
+func (client *Client) CreateMaskedEmailsRequest(
+	session Session,
+	accID string,
+	domain string,
+	enabled bool,
+	description string,
+	url string,
+	count int,
+) (*APIRequest, error) {
+	creationIDs := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		creationIDs = append(creationIDs, fmt.Sprintf("%s-%d", client.appName, i+1))
+	}
+
+	return client.createRequest(session, accID, domain, enabled, description, url, creationIDs)
+}
+
+// createRequest builds a MaskedEmail/set request creating one masked email
+// per creation ID.
+func (client *Client) createRequest(
+	session Session,
+	accID string,
+	domain string,
+	enabled bool,
+	description string,
+	url string,
+	creationIDs []string,
+) (*APIRequest, error) {
+	state := ""
+	if enabled {
+		state = "enabled"
+	}
+
+	accID, err := client.accIDOrDefault(session, accID)
 	if err != nil {
 		return nil, err
 	}
 
-	return &created, nil
+	payload := MethodCallCreate{AccountID: accID, Create: map[string]CreatePayload{}}
+	for _, creationID := range creationIDs {
+		payload.Create[creationID] = CreatePayload{Domain: domain, State: state, Description: description, URL: url}
+	}
+
+	return &APIRequest{
+		Using: []string{
+			"urn:ietf:params:jmap:core",
+			MaskedEmailCapabilityURI,
+		},
+		MethodCalls: []MethodCall{{
+			MethodName: "MaskedEmail/set",
+			Payload:    payload,
+			Payload2:   "0",
+		}},
+	}, nil
 }
 
 func (client *Client) UpdateMaskedEmail(
@@ -246,41 +520,86 @@ func (client *Client) UpdateMaskedEmail(
 	}
 
 	var pl MethodResponseMaskedEmailSet
-	err = mapstructure.Decode(res.MethodResponsesParsed[0].Payload, &pl)
+	err = decodePayload(res.MethodResponsesParsed[0].Payload, &pl)
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO: fix return value
-	pl.GetCreatedItem()
+	if setErr, ok := pl.NotUpdated[emailID]; ok {
+		return &pl, &setErr
+	}
 
-	return nil, nil
+	return &pl, nil
 }
 
-func (client *Client) LookupMaskedEmailID(
+// ResolveMaskedEmail returns the masked email with the given address or, if
+// ref has no @, the given JMAP ID.
+func (client *Client) ResolveMaskedEmail(
+	session Session,
+	accID string,
+	ref string,
+) (*MaskedEmail, error) {
+	ref = strings.TrimSpace(ref)
+	if IsMaskedEmailID(ref) {
+		emails, err := client.GetMaskedEmails(session, accID, []string{ref})
+		if err != nil {
+			return nil, err
+		}
+		for _, email := range emails {
+			if email.ID == ref {
+				return email, nil
+			}
+		}
+		return nil, fmt.Errorf("maskedemail with id %s not found, and %q is not an email address", ref, ref)
+	}
+
+	address, err := ParseMaskedAddress(ref)
+	if err != nil {
+		return nil, err
+	}
+	return client.LookupMaskedEmail(session, accID, address.Address)
+}
+
+// LookupMaskedEmail returns the masked email with the given address.
+func (client *Client) LookupMaskedEmail(
 	session Session,
 	accID string,
 	email string,
-) (string, error) {
+) (*MaskedEmail, error) {
 	allAliases, err := client.GetAllMaskedEmails(session, accID)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// find the alias to disable
-	var alias *MaskedEmail
 	for _, a := range allAliases {
 		if a.Email == email {
-			alias = a
-			break
+			return a, nil
 		}
 	}
 
-	if alias == nil {
-		return "", errors.New(fmt.Sprintf("maskedemail %s not found", email))
+	return nil, errors.New(fmt.Sprintf("maskedemail %s not found", email))
+}
+
+// LookupMaskedEmailID returns the ID of the masked email with the given
+// address. Only the IDs and addresses are fetched, which for accounts with
+// thousands of masked emails is a fraction of all properties.
+func (client *Client) LookupMaskedEmailID(
+	session Session,
+	accID string,
+	email string,
+) (string, error) {
+	allAliases, _, err := client.getAllMaskedEmails(session, accID, []string{"id", "email"})
+	if err != nil {
+		return "", err
 	}
 
-	return alias.ID, nil
+	for _, a := range allAliases {
+		if a.Email == email {
+			return a.ID, nil
+		}
+	}
+
+	return "", errors.New(fmt.Sprintf("maskedemail %s not found", email))
 }
 
 func (client *Client) EnableMaskedEmail(
@@ -334,6 +653,48 @@ func (client *Client) DeleteMaskedEmail(
 	return client.UpdateMaskedEmail(session, accID, emailID, &fields)
 }
 
+// DestroyMaskedEmail permanently removes the masked email with the given ID
+// with the destroy argument of MaskedEmail/set. Unlike the deleted state this
+// can't be undone. A rejection of the server is returned as a *SetError.
+func (client *Client) DestroyMaskedEmail(
+	session Session,
+	accID string,
+	emailID string,
+) (*MethodResponseMaskedEmailSet, error) {
+	accID, err := client.accIDOrDefault(session, accID)
+	if err != nil {
+		return nil, err
+	}
+
+	apiRequest := APIRequest{
+		Using: []string{
+			"urn:ietf:params:jmap:core",
+			MaskedEmailCapabilityURI,
+		},
+		MethodCalls: []MethodCall{{
+			MethodName: "MaskedEmail/set",
+			Payload:    MethodCallDestroy{AccountID: accID, Destroy: []string{emailID}},
+			Payload2:   "0",
+		}},
+	}
+
+	res, err := client.sendRequest(session, &apiRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	var pl MethodResponseMaskedEmailSet
+	if err := decodePayload(res.MethodResponsesParsed[0].Payload, &pl); err != nil {
+		return nil, err
+	}
+
+	if setErr, ok := pl.NotDestroyed[emailID]; ok {
+		return &pl, &setErr
+	}
+
+	return &pl, nil
+}
+
 func (client *Client) UpdateInfo(
 	session Session,
 	accID string,
@@ -353,6 +714,69 @@ func (client *Client) UpdateInfo(
 func (client *Client) GetAllMaskedEmails(
 	session Session,
 	accID string,
+) ([]*MaskedEmail, error) {
+	emails, _, err := client.GetAllMaskedEmailsAndState(session, accID)
+	return emails, err
+}
+
+// GetAllMaskedEmailsAndState returns all masked emails along with the state
+// string of the server, to ask for the changes since with
+// MaskedEmailChanges.
+func (client *Client) GetAllMaskedEmailsAndState(
+	session Session,
+	accID string,
+) ([]*MaskedEmail, string, error) {
+	return client.getAllMaskedEmails(session, accID, nil)
+}
+
+// getAllMaskedEmails returns all masked emails with only the given
+// properties set, all if properties is empty, and the state string.
+func (client *Client) getAllMaskedEmails(
+	session Session,
+	accID string,
+	properties []string,
+) ([]*MaskedEmail, string, error) {
+	accID, err := client.accIDOrDefault(session, accID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	payload := NewMethodCallGetAll(accID)
+	payload.Properties = properties
+	r := MethodCall{
+		MethodName: "MaskedEmail/get",
+		Payload:    payload,
+		Payload2:   "0",
+	}
+
+	apiRequest := APIRequest{
+		Using: []string{
+			"urn:ietf:params:jmap:core",
+			MaskedEmailCapabilityURI,
+		},
+		MethodCalls: []MethodCall{r},
+	}
+
+	res, err := client.sendRequest(session, &apiRequest)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var pl MethodResponseGetAll
+	err = decodePayload(res.MethodResponsesParsed[0].Payload, &pl)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return pl.List, pl.State, nil
+}
+
+// GetMaskedEmails returns the masked emails with the given IDs. IDs that
+// don't exist are left out.
+func (client *Client) GetMaskedEmails(
+	session Session,
+	accID string,
+	ids []string,
 ) ([]*MaskedEmail, error) {
 	accID, err := client.accIDOrDefault(session, accID)
 	if err != nil {
@@ -361,7 +785,7 @@ func (client *Client) GetAllMaskedEmails(
 
 	r := MethodCall{
 		MethodName: "MaskedEmail/get",
-		Payload:    NewMethodCallGetAll(accID),
+		Payload:    MethodCallGet{AccountID: accID, IDs: ids},
 		Payload2:   "0",
 	}
 
@@ -379,10 +803,155 @@ func (client *Client) GetAllMaskedEmails(
 	}
 
 	var pl MethodResponseGetAll
-	err = mapstructure.Decode(res.MethodResponsesParsed[0].Payload, &pl)
+	err = decodePayload(res.MethodResponsesParsed[0].Payload, &pl)
 	if err != nil {
 		return nil, err
 	}
 
 	return pl.List, nil
 }
+
+// GetMaskedEmail returns the masked email with the given ID.
+func (client *Client) GetMaskedEmail(
+	session Session,
+	accID string,
+	id string,
+) (*MaskedEmail, error) {
+	emails, err := client.GetMaskedEmails(session, accID, []string{id})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, email := range emails {
+		if email.ID == id {
+			return email, nil
+		}
+	}
+
+	return nil, fmt.Errorf("maskedemail with id %s not found", id)
+}
+
+// MaskedEmailChanges returns the IDs of the masked emails changed since the
+// given state. Servers not implementing MaskedEmail/changes return a
+// *MethodError of type "unknownMethod", a state too old to tell the changes
+// one of type "cannotCalculateChanges".
+func (client *Client) MaskedEmailChanges(
+	session Session,
+	accID string,
+	sinceState string,
+) (*MethodResponseChanges, error) {
+	accID, err := client.accIDOrDefault(session, accID)
+	if err != nil {
+		return nil, err
+	}
+
+	apiRequest := APIRequest{
+		Using: []string{
+			"urn:ietf:params:jmap:core",
+			MaskedEmailCapabilityURI,
+		},
+		MethodCalls: []MethodCall{{
+			MethodName: "MaskedEmail/changes",
+			Payload:    MethodCallChanges{AccountID: accID, SinceState: sinceState},
+			Payload2:   "0",
+		}},
+	}
+
+	res, err := client.sendRequest(session, &apiRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	var pl MethodResponseChanges
+	if err := decodePayload(res.MethodResponsesParsed[0].Payload, &pl); err != nil {
+		return nil, err
+	}
+
+	return &pl, nil
+}
+
+// maxObjectsInSet returns the number of objects a single /set call may
+// change, or 0 if there's no known limit.
+func maxObjectsInSet(session Session) int {
+	s, ok := session.(interface {
+		CoreCapability() (*CoreCapability, error)
+	})
+	if !ok {
+		return 0
+	}
+
+	capability, err := s.CoreCapability()
+	if err != nil {
+		return 0
+	}
+	return capability.MaxObjectsInSet
+}
+
+// SetMaskedEmailStates changes the state of several masked emails, given by
+// ID, with as few requests as the server's maxObjectsInSet allows. It returns
+// the IDs that were updated and the reasons the server rejected the others.
+// If a request fails, the error is returned along with the results of the
+// requests before it; the IDs of the failed request may or may not have been
+// updated and later ones weren't sent.
+func (client *Client) SetMaskedEmailStates(
+	session Session,
+	accID string,
+	ids []string,
+	state MaskedEmailState,
+) ([]string, map[string]SetError, error) {
+	accID, err := client.accIDOrDefault(session, accID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chunkSize := maxObjectsInSet(session)
+	if chunkSize <= 0 {
+		chunkSize = len(ids)
+	}
+
+	updated := []string{}
+	notUpdated := map[string]SetError{}
+	for start := 0; start < len(ids); start += chunkSize {
+		end := start + chunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		payload := MethodCallUpdate{AccountID: accID, Update: map[string]UpdatePayload{}}
+		for _, id := range ids[start:end] {
+			payload.Update[id] = UpdatePayload{State: string(state)}
+		}
+
+		apiRequest := APIRequest{
+			Using: []string{
+				"urn:ietf:params:jmap:core",
+				MaskedEmailCapabilityURI,
+			},
+			MethodCalls: []MethodCall{{
+				MethodName: "MaskedEmail/set",
+				Payload:    payload,
+				Payload2:   "0",
+			}},
+		}
+
+		res, err := client.sendRequest(session, &apiRequest)
+		if err != nil {
+			return updated, notUpdated, err
+		}
+
+		var pl MethodResponseMaskedEmailSet
+		if err := decodePayload(res.MethodResponsesParsed[0].Payload, &pl); err != nil {
+			return updated, notUpdated, err
+		}
+
+		for _, id := range ids[start:end] {
+			if setErr, ok := pl.NotUpdated[id]; ok {
+				notUpdated[id] = setErr
+			} else {
+				updated = append(updated, id)
+			}
+		}
+	}
+
+	return updated, notUpdated, nil
+}
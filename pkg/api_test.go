@@ -0,0 +1,393 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// newChunkedTestSession is newTestSession with a server that allows
+// maxObjects objects per /set call, no limit if 0.
+func newChunkedTestSession(url string, maxObjects int) *SessionResource {
+	session := newTestSession(url)
+	if maxObjects > 0 {
+		session.Capabilities = map[string]json.RawMessage{
+			CoreCapabilityURI: json.RawMessage(fmt.Sprintf(`{"maxObjectsInSet":%d}`, maxObjects)),
+		}
+	}
+	return session
+}
+
+// serveMethod starts a server answering every API request with the method
+// response respond returns for the arguments of its first method call. call
+// counts the requests from 1.
+func serveMethod(t *testing.T, respond func(call int, args json.RawMessage) string) *httptest.Server {
+	t.Helper()
+
+	call := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			MethodCalls [][]json.RawMessage `json:"methodCalls"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.MethodCalls) == 0 {
+			t.Errorf("invalid request: %v", err)
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		call++
+		fmt.Fprintf(w, `{"methodResponses":[%s],"sessionState":""}`, respond(call, request.MethodCalls[0][1]))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// sortedKeys returns the keys of a JSON object in order.
+func sortedKeys(object map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestCreateMaskedEmails(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxObjects     int
+		count          int
+		reject         string // creation ID the server doesn't create
+		failAt         int    // request answered with a method error
+		wantChunks     [][]string
+		wantCreated    []string
+		wantNotCreated []string
+		wantErr        string
+	}{
+		{
+			name:        "no limit",
+			count:       3,
+			wantChunks:  [][]string{{"test-1", "test-2", "test-3"}},
+			wantCreated: []string{"test-1", "test-2", "test-3"},
+		},
+		{
+			name:        "exact multiple",
+			maxObjects:  2,
+			count:       4,
+			wantChunks:  [][]string{{"test-1", "test-2"}, {"test-3", "test-4"}},
+			wantCreated: []string{"test-1", "test-2", "test-3", "test-4"},
+		},
+		{
+			name:        "remainder",
+			maxObjects:  2,
+			count:       3,
+			wantChunks:  [][]string{{"test-1", "test-2"}, {"test-3"}},
+			wantCreated: []string{"test-1", "test-2", "test-3"},
+		},
+		{
+			name:           "not created",
+			maxObjects:     2,
+			count:          4,
+			reject:         "test-3",
+			wantChunks:     [][]string{{"test-1", "test-2"}, {"test-3", "test-4"}},
+			wantCreated:    []string{"test-1", "test-2", "test-4"},
+			wantNotCreated: []string{"overQuota"},
+		},
+		{
+			name:        "method error",
+			maxObjects:  2,
+			count:       6,
+			failAt:      2,
+			wantChunks:  [][]string{{"test-1", "test-2"}, {"test-3", "test-4"}},
+			wantCreated: []string{"test-1", "test-2"},
+			wantErr:     "method error serverFail",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var chunks [][]string
+			server := serveMethod(t, func(call int, args json.RawMessage) string {
+				var payload struct {
+					Create map[string]json.RawMessage `json:"create"`
+				}
+				if err := json.Unmarshal(args, &payload); err != nil {
+					t.Errorf("invalid MaskedEmail/set arguments: %v", err)
+				}
+				keys := sortedKeys(payload.Create)
+				chunks = append(chunks, keys)
+
+				if call == tt.failAt {
+					return `["error",{"type":"serverFail"},"0"]`
+				}
+				created := map[string]interface{}{}
+				notCreated := map[string]interface{}{}
+				for _, key := range keys {
+					if key == tt.reject {
+						notCreated[key] = map[string]string{"type": "overQuota"}
+					} else {
+						created[key] = map[string]string{"id": "id-" + key, "email": key + "@fastmail.com", "state": "enabled"}
+					}
+				}
+				b, _ := json.Marshal(map[string]interface{}{"created": created, "notCreated": notCreated})
+				return fmt.Sprintf(`["MaskedEmail/set",%s,"0"]`, b)
+			})
+
+			client := NewClient("token", "test", "")
+			created, notCreated, err := client.CreateMaskedEmails(newChunkedTestSession(server.URL, tt.maxObjects), "", "example.com", true, "", "", tt.count)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("CreateMaskedEmails() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("CreateMaskedEmails() error = %v, want %q", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(chunks, tt.wantChunks) {
+				t.Errorf("requests created %v, want %v", chunks, tt.wantChunks)
+			}
+			gotCreated := []string{}
+			for _, email := range created {
+				gotCreated = append(gotCreated, strings.TrimSuffix(email.Email, "@fastmail.com"))
+			}
+			if !reflect.DeepEqual(gotCreated, tt.wantCreated) {
+				t.Errorf("CreateMaskedEmails() created %v, want %v", gotCreated, tt.wantCreated)
+			}
+			var gotNotCreated []string
+			for _, setErr := range notCreated {
+				gotNotCreated = append(gotNotCreated, setErr.Type)
+			}
+			if !reflect.DeepEqual(gotNotCreated, tt.wantNotCreated) {
+				t.Errorf("CreateMaskedEmails() not created %v, want %v", gotNotCreated, tt.wantNotCreated)
+			}
+		})
+	}
+}
+
+func TestSetMaskedEmailStates(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxObjects     int
+		ids            []string
+		reject         string // ID the server doesn't update
+		failAt         int    // request answered with a method error
+		wantChunks     [][]string
+		wantUpdated    []string
+		wantNotUpdated map[string]string
+		wantErr        string
+	}{
+		{
+			name:        "no limit",
+			ids:         []string{"me1", "me2", "me3"},
+			wantChunks:  [][]string{{"me1", "me2", "me3"}},
+			wantUpdated: []string{"me1", "me2", "me3"},
+		},
+		{
+			name:        "exact multiple",
+			maxObjects:  2,
+			ids:         []string{"me1", "me2", "me3", "me4"},
+			wantChunks:  [][]string{{"me1", "me2"}, {"me3", "me4"}},
+			wantUpdated: []string{"me1", "me2", "me3", "me4"},
+		},
+		{
+			name:        "remainder",
+			maxObjects:  2,
+			ids:         []string{"me1", "me2", "me3"},
+			wantChunks:  [][]string{{"me1", "me2"}, {"me3"}},
+			wantUpdated: []string{"me1", "me2", "me3"},
+		},
+		{
+			name:           "not updated",
+			maxObjects:     2,
+			ids:            []string{"me1", "me2", "me3", "me4"},
+			reject:         "me3",
+			wantChunks:     [][]string{{"me1", "me2"}, {"me3", "me4"}},
+			wantUpdated:    []string{"me1", "me2", "me4"},
+			wantNotUpdated: map[string]string{"me3": "notFound"},
+		},
+		{
+			name:        "method error",
+			maxObjects:  2,
+			ids:         []string{"me1", "me2", "me3", "me4", "me5", "me6"},
+			failAt:      2,
+			wantChunks:  [][]string{{"me1", "me2"}, {"me3", "me4"}},
+			wantUpdated: []string{"me1", "me2"},
+			wantErr:     "method error serverFail",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var chunks [][]string
+			server := serveMethod(t, func(call int, args json.RawMessage) string {
+				var payload struct {
+					Update map[string]json.RawMessage `json:"update"`
+				}
+				if err := json.Unmarshal(args, &payload); err != nil {
+					t.Errorf("invalid MaskedEmail/set arguments: %v", err)
+				}
+				keys := sortedKeys(payload.Update)
+				chunks = append(chunks, keys)
+				for _, key := range keys {
+					if got := string(payload.Update[key]); got != `{"state":"disabled"}` {
+						t.Errorf("update of %s = %s, want the disabled state", key, got)
+					}
+				}
+
+				if call == tt.failAt {
+					return `["error",{"type":"serverFail"},"0"]`
+				}
+				updated := map[string]interface{}{}
+				notUpdated := map[string]interface{}{}
+				for _, key := range keys {
+					if key == tt.reject {
+						notUpdated[key] = map[string]string{"type": "notFound"}
+					} else {
+						updated[key] = nil
+					}
+				}
+				b, _ := json.Marshal(map[string]interface{}{"updated": updated, "notUpdated": notUpdated})
+				return fmt.Sprintf(`["MaskedEmail/set",%s,"0"]`, b)
+			})
+
+			client := NewClient("token", "test", "")
+			updated, notUpdated, err := client.SetMaskedEmailStates(newChunkedTestSession(server.URL, tt.maxObjects), "", tt.ids, MaskedEmailStateDisabled)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("SetMaskedEmailStates() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("SetMaskedEmailStates() error = %v, want %q", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(chunks, tt.wantChunks) {
+				t.Errorf("requests updated %v, want %v", chunks, tt.wantChunks)
+			}
+			if !reflect.DeepEqual(updated, tt.wantUpdated) {
+				t.Errorf("SetMaskedEmailStates() updated %v, want %v", updated, tt.wantUpdated)
+			}
+			var gotNotUpdated map[string]string
+			for id, setErr := range notUpdated {
+				if gotNotUpdated == nil {
+					gotNotUpdated = map[string]string{}
+				}
+				gotNotUpdated[id] = setErr.Type
+			}
+			if !reflect.DeepEqual(gotNotUpdated, tt.wantNotUpdated) {
+				t.Errorf("SetMaskedEmailStates() not updated %v, want %v", gotNotUpdated, tt.wantNotUpdated)
+			}
+		})
+	}
+}
+
+func TestMaskedEmailChangesPaging(t *testing.T) {
+	// the changes since each state, older states can't be calculated
+	pages := map[string]string{
+		"s0": `{"oldState":"s0","newState":"s1","hasMoreChanges":true,"created":["me1"],"updated":[],"destroyed":[]}`,
+		"s1": `{"oldState":"s1","newState":"s2","hasMoreChanges":true,"created":[],"updated":["me2"],"destroyed":[]}`,
+		"s2": `{"oldState":"s2","newState":"s3","hasMoreChanges":false,"created":[],"updated":[],"destroyed":["me3"]}`,
+		"s3": `{"oldState":"s3","newState":"s3","hasMoreChanges":false,"created":[],"updated":[],"destroyed":[]}`,
+	}
+
+	tests := []struct {
+		name         string
+		since        string
+		wantRequests []string
+		wantChanged  []string
+		wantState    string
+		wantErr      string
+	}{
+		{
+			name:         "up to date",
+			since:        "s3",
+			wantRequests: []string{"s3"},
+			wantChanged:  []string{},
+			wantState:    "s3",
+		},
+		{
+			name:         "last page",
+			since:        "s2",
+			wantRequests: []string{"s2"},
+			wantChanged:  []string{"destroyed me3"},
+			wantState:    "s3",
+		},
+		{
+			name:         "several pages",
+			since:        "s0",
+			wantRequests: []string{"s0", "s1", "s2"},
+			wantChanged:  []string{"created me1", "updated me2", "destroyed me3"},
+			wantState:    "s3",
+		},
+		{
+			name:         "state too old",
+			since:        "old",
+			wantRequests: []string{"old"},
+			wantChanged:  []string{},
+			wantErr:      "cannotCalculateChanges",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []string
+			server := serveMethod(t, func(call int, args json.RawMessage) string {
+				var payload MethodCallChanges
+				if err := json.Unmarshal(args, &payload); err != nil {
+					t.Errorf("invalid MaskedEmail/changes arguments: %v", err)
+				}
+				requests = append(requests, payload.SinceState)
+
+				page, ok := pages[payload.SinceState]
+				if !ok {
+					return `["error",{"type":"cannotCalculateChanges"},"0"]`
+				}
+				return fmt.Sprintf(`["MaskedEmail/changes",%s,"0"]`, page)
+			})
+
+			// follow the pages like the callers do
+			client := NewClient("token", "test", "")
+			session := newTestSession(server.URL)
+			since := tt.since
+			changed := []string{}
+			var err error
+			for {
+				var res *MethodResponseChanges
+				if res, err = client.MaskedEmailChanges(session, "", since); err != nil {
+					break
+				}
+				for _, id := range res.Created {
+					changed = append(changed, "created "+id)
+				}
+				for _, id := range res.Updated {
+					changed = append(changed, "updated "+id)
+				}
+				for _, id := range res.Destroyed {
+					changed = append(changed, "destroyed "+id)
+				}
+				since = res.NewState
+				if !res.HasMoreChanges {
+					break
+				}
+			}
+
+			var methodErr *MethodError
+			if tt.wantErr != "" {
+				if !errors.As(err, &methodErr) || methodErr.Type != tt.wantErr {
+					t.Fatalf("MaskedEmailChanges() error = %v, want a method error %s", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("MaskedEmailChanges() error = %v", err)
+			} else if since != tt.wantState {
+				t.Errorf("MaskedEmailChanges() ended at state %q, want %q", since, tt.wantState)
+			}
+
+			if !reflect.DeepEqual(requests, tt.wantRequests) {
+				t.Errorf("requested the changes since %v, want %v", requests, tt.wantRequests)
+			}
+			if !reflect.DeepEqual(changed, tt.wantChanged) {
+				t.Errorf("MaskedEmailChanges() changed %v, want %v", changed, tt.wantChanged)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package pkg
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// multiLabelSuffixes are common public suffixes with two labels. Domains
+// under them keep three labels as their registrable domain, so
+// "shop.example.co.uk" groups as "example.co.uk" and not "co.uk". This is a
+// heuristic, not the full public suffix list.
+var multiLabelSuffixes = map[string]bool{
+	"co.uk": true, "org.uk": true, "ac.uk": true, "gov.uk": true, "me.uk": true,
+	"com.au": true, "net.au": true, "org.au": true,
+	"co.nz": true, "org.nz": true,
+	"co.jp": true, "ne.jp": true, "or.jp": true,
+	"co.kr": true, "co.in": true, "co.za": true, "co.il": true,
+	"com.br": true, "com.mx": true, "com.ar": true, "com.tr": true,
+	"com.cn": true, "com.hk": true, "com.sg": true, "com.tw": true,
+}
+
+// NormalizeDomain reduces a forDomain value to a bare lowercase host so
+// "https://www.Example.com/signup" and "example.com" compare equal.
+func NormalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if domain == "" {
+		return ""
+	}
+
+	if strings.Contains(domain, "://") {
+		if u, err := url.Parse(domain); err == nil && u.Host != "" {
+			domain = u.Host
+		}
+	}
+
+	// drop path and port leftovers of scheme-less values like "example.com/x"
+	if i := strings.IndexAny(domain, "/?#"); i >= 0 {
+		domain = domain[:i]
+	}
+	if i := strings.LastIndex(domain, ":"); i >= 0 {
+		domain = domain[:i]
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(domain, "www."), ".")
+}
+
+// schemePattern matches the scheme of a URL, but not a host with a port
+// like "localhost:8080".
+var schemePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*:[^0-9]`)
+
+// PageURL validates a web address, such as the signup page of a masked email,
+// and returns it with https:// in front if it has no scheme, like browsers
+// assume. Other schemes than http and https are refused.
+func PageURL(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if !schemePattern.MatchString(rawURL) {
+		rawURL = "https://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid url %q: %v", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid url %q: only http and https urls have a domain", rawURL)
+	}
+	if NormalizeDomain(u.Hostname()) == "" {
+		return "", fmt.Errorf("invalid url %q: no host", rawURL)
+	}
+	return rawURL, nil
+}
+
+// DomainFromURL returns the host of a web address as a forDomain value, e.g.
+// "shop.example.com" for "https://www.shop.example.com/signup?x=1". The
+// address is validated like with PageURL.
+func DomainFromURL(rawURL string) (string, error) {
+	pageURL, err := PageURL(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	u, _ := url.Parse(pageURL)
+	return NormalizeDomain(u.Hostname()), nil
+}
+
+// RegistrableDomain normalizes the domain and strips subdomains, so
+// "https://login.example.com" becomes "example.com".
+func RegistrableDomain(domain string) string {
+	domain = NormalizeDomain(domain)
+
+	labels := strings.Split(domain, ".")
+	keep := 2
+	if len(labels) > 2 && multiLabelSuffixes[strings.Join(labels[len(labels)-2:], ".")] {
+		keep = 3
+	}
+	if len(labels) <= keep {
+		return domain
+	}
+
+	return strings.Join(labels[len(labels)-keep:], ".")
+}
+
+// DomainIndex groups masked emails by the registrable domain of their
+// forDomain. Masked emails without a domain are left out.
+func DomainIndex(emails []*MaskedEmail) map[string][]*MaskedEmail {
+	index := map[string][]*MaskedEmail{}
+
+	for _, email := range emails {
+		domain := RegistrableDomain(email.Domain)
+		if domain == "" {
+			continue
+		}
+		index[domain] = append(index[domain], email)
+	}
+
+	return index
+}
@@ -0,0 +1,142 @@
+package pkg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{"Example.COM", "example.com"},
+		{"https://www.Example.com/signup", "example.com"},
+		{"https://shop.example.com:8443/x?y=1", "shop.example.com"},
+		{"example.com/signup?x=1", "example.com"},
+		{"mail.example.com#top", "mail.example.com"},
+		{"example.com:8443", "example.com"},
+		{"localhost:8080", "localhost"},
+		{"www.example.com.", "example.com"},
+		{"wwwexample.com", "wwwexample.com"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeDomain(tt.input); got != tt.want {
+			t.Errorf("NormalizeDomain(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestRegistrableDomain(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", ""},
+		{"localhost", "localhost"},
+		{"example.com", "example.com"},
+		{"login.example.com", "example.com"},
+		{"https://www.login.example.com/path", "example.com"},
+		{"shop.example.co.uk", "example.co.uk"},
+		{"www.example.co.uk", "example.co.uk"},
+		{"example.co.uk", "example.co.uk"},
+		{"co.uk", "co.uk"},
+		{"a.b.shop.example.com.au", "example.com.au"},
+		// only the listed suffixes keep a third label
+		{"shop.example.uk", "example.uk"},
+	}
+	for _, tt := range tests {
+		if got := RegistrableDomain(tt.input); got != tt.want {
+			t.Errorf("RegistrableDomain(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestPageURL(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr string
+	}{
+		{input: "example.com/signup", want: "https://example.com/signup"},
+		{input: "  http://example.com  ", want: "http://example.com"},
+		{input: "HTTPS://Example.com/x", want: "HTTPS://Example.com/x"},
+		// a port isn't taken for a scheme
+		{input: "localhost:8080/signup", want: "https://localhost:8080/signup"},
+		{input: "ftp://example.com", wantErr: "only http and https urls have a domain"},
+		{input: "mailto:shop@example.com", wantErr: "only http and https urls have a domain"},
+		{input: "javascript:alert(1)", wantErr: "only http and https urls have a domain"},
+		{input: "https://", wantErr: "no host"},
+		{input: "", wantErr: "no host"},
+		{input: "https://exa mple.com", wantErr: "invalid url"},
+	}
+	for _, tt := range tests {
+		got, err := PageURL(tt.input)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("PageURL(%q) error = %v, want %q", tt.input, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("PageURL(%q) error = %v", tt.input, err)
+		} else if got != tt.want {
+			t.Errorf("PageURL(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestDomainFromURL(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr string
+	}{
+		{input: "https://www.shop.example.com/signup?x=1", want: "shop.example.com"},
+		{input: "Shop.Example.com:8443/x", want: "shop.example.com"},
+		{input: "localhost:8080", want: "localhost"},
+		{input: "ftp://example.com", wantErr: "only http and https urls have a domain"},
+	}
+	for _, tt := range tests {
+		got, err := DomainFromURL(tt.input)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("DomainFromURL(%q) error = %v, want %q", tt.input, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("DomainFromURL(%q) error = %v", tt.input, err)
+		} else if got != tt.want {
+			t.Errorf("DomainFromURL(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestDomainIndex(t *testing.T) {
+	emails := []*MaskedEmail{
+		{ID: "me1", Domain: "https://login.example.com"},
+		{ID: "me2", Domain: "example.com"},
+		{ID: "me3", Domain: "shop.example.co.uk"},
+		{ID: "me4", Domain: ""},
+		{ID: "me5", Domain: "   "},
+		{ID: "me6", Domain: "WWW.Example.com"},
+	}
+
+	got := map[string][]string{}
+	for domain, grouped := range DomainIndex(emails) {
+		for _, email := range grouped {
+			got[domain] = append(got[domain], email.ID)
+		}
+	}
+	want := map[string][]string{
+		"example.com":   {"me1", "me2", "me6"},
+		"example.co.uk": {"me3"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DomainIndex() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,215 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Manager manages the masked emails of one account on top of a Client. It
+// keeps the session until it's stale, resolves the account, caches the list
+// of masked emails and retries requests failing on the network, so
+// applications only need a few lines:
+//
+//	client := pkg.NewClient(token, "myapp", "myapp")
+//	m := pkg.NewManager(client)
+//	email, err := m.Create("example.com", "newsletter")
+//
+// A Manager is safe for concurrent use. Its fields are read on each call and
+// should be set before the first one.
+type Manager struct {
+	// AccountID is the account to manage, the primary Masked Email account
+	// of the token if empty.
+	AccountID string
+
+	// CacheTTL is how long List and Find use the fetched masked emails
+	// before fetching them again. Changes made through the Manager update
+	// the cache right away, changes made elsewhere show after CacheTTL.
+	CacheTTL time.Duration
+
+	// Retries is how often a request failing on the network is retried,
+	// with a backoff doubling from RetryDelay. Creating is never retried,
+	// as the masked email may have been created by the failed request.
+	Retries    int
+	RetryDelay time.Duration
+
+	// SessionTTL is how long the session is used before fetching it again.
+	// It's fetched earlier if the server reports that it changed.
+	SessionTTL time.Duration
+
+	client *Client
+
+	mu        sync.Mutex
+	session   *SessionResource
+	emails    []*MaskedEmail
+	fetchedAt time.Time
+}
+
+// NewManager returns a Manager for the primary account of the client's
+// token, caching masked emails for a minute, the session for an hour, and
+// retrying twice.
+func NewManager(client *Client) *Manager {
+	return &Manager{
+		CacheTTL:   time.Minute,
+		Retries:    2,
+		RetryDelay: 500 * time.Millisecond,
+		SessionTTL: time.Hour,
+		client:     client,
+	}
+}
+
+// Create creates an enabled masked email for the domain.
+func (m *Manager) Create(domain string, description string) (*MaskedEmail, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, err := m.sessionLocked()
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := m.client.CreateMaskedEmail(session, m.AccountID, domain, true, description, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if m.emails != nil {
+		m.emails = append(m.emails, created)
+	}
+	return created, nil
+}
+
+// List returns all masked emails of the account, including deleted ones.
+func (m *Manager) List() ([]*MaskedEmail, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	emails, err := m.listLocked()
+	if err != nil {
+		return nil, err
+	}
+	return append([]*MaskedEmail{}, emails...), nil
+}
+
+// Find returns the masked email with the given address or ID. Addresses are
+// accepted in any form ParseMaskedAddress takes.
+func (m *Manager) Find(addressOrID string) (*MaskedEmail, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.findLocked(addressOrID)
+}
+
+// SetState enables, disables or deletes the masked email with the given
+// address or ID and returns it as it is afterwards.
+func (m *Manager) SetState(addressOrID string, state MaskedEmailState) (*MaskedEmail, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	email, err := m.findLocked(addressOrID)
+	if err != nil {
+		return nil, err
+	}
+	if email.State == state {
+		return email, nil
+	}
+
+	err = m.retry(func(session *SessionResource) error {
+		_, notUpdated, err := m.client.SetMaskedEmailStates(session, m.AccountID, []string{email.ID}, state)
+		if setErr, ok := notUpdated[email.ID]; ok {
+			return &setErr
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	email.State = state
+	return email, nil
+}
+
+func (m *Manager) findLocked(addressOrID string) (*MaskedEmail, error) {
+	emails, err := m.listLocked()
+	if err != nil {
+		return nil, err
+	}
+
+	// addresses are case-insensitive like in ParseMaskedAddress
+	address := BareAddress(addressOrID)
+	for _, email := range emails {
+		if email.ID == addressOrID || strings.EqualFold(email.Email, address) {
+			return email, nil
+		}
+	}
+	return nil, fmt.Errorf("maskedemail %s not found", addressOrID)
+}
+
+func (m *Manager) listLocked() ([]*MaskedEmail, error) {
+	if m.emails != nil && time.Since(m.fetchedAt) < m.CacheTTL {
+		return m.emails, nil
+	}
+
+	var emails []*MaskedEmail
+	err := m.retry(func(session *SessionResource) error {
+		var err error
+		emails, err = m.client.GetAllMaskedEmails(session, m.AccountID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.emails = emails
+	m.fetchedAt = time.Now()
+	return emails, nil
+}
+
+// sessionLocked returns the session, fetching it on first use, when it's
+// stale and after a request failed.
+func (m *Manager) sessionLocked() (*SessionResource, error) {
+	if m.session != nil && !m.session.IsStale(m.SessionTTL) {
+		return m.session, nil
+	}
+
+	session, err := m.client.Session()
+	if err != nil {
+		return nil, err
+	}
+	if m.AccountID != "" && !session.AccountHasCapability(m.AccountID, MaskedEmailCapabilityURI) {
+		return nil, fmt.Errorf("account %s not found or has no access to Masked Email", m.AccountID)
+	}
+
+	m.session = session
+	return session, nil
+}
+
+// retry runs a request until it succeeds, fails for a reason other than the
+// network, or Retries are used up. The session is fetched again before each
+// retry, in case the API endpoint moved.
+func (m *Manager) retry(request func(session *SessionResource) error) error {
+	delay := m.RetryDelay
+	for attempt := 0; ; attempt++ {
+		session, err := m.sessionLocked()
+		if err == nil {
+			err = request(session)
+		}
+		if err == nil || attempt >= m.Retries || !isNetworkError(err) {
+			return err
+		}
+
+		m.session = nil
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// isNetworkError reports whether err is a failure to reach the server, as
+// opposed to an error returned by it.
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,287 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// managerServer is a JMAP server with masked emails in memory that counts
+// the requests it answers.
+type managerServer struct {
+	mu       sync.Mutex
+	emails   []*MaskedEmail
+	sessions int
+	gets     int
+	sets     int
+}
+
+// newManagerTest starts a managerServer with two masked emails and returns
+// it with a Manager using it. The first failPosts API requests fail on the
+// network before reaching the server.
+func newManagerTest(t *testing.T, failPosts int) (*managerServer, *Manager) {
+	t.Helper()
+
+	s := &managerServer{emails: []*MaskedEmail{
+		{ID: "me1", Email: "alpha.one123@fastmail.com", State: MaskedEmailStateEnabled},
+		{ID: "me2", Email: "beta.two456@fastmail.com", State: MaskedEmailStateDisabled},
+	}}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+
+	client := NewClient("token", "test", "")
+	client.SetSessionEndpoint(server.URL + "/session")
+	client.SetHTTPClient(&http.Client{Transport: &failingTransport{fail: failPosts}})
+
+	m := NewManager(client)
+	m.RetryDelay = time.Millisecond
+	return s, m
+}
+
+// failingTransport fails the first fail POST requests with a network error.
+type failingTransport struct {
+	fail int
+}
+
+func (tr *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodPost && tr.fail > 0 {
+		tr.fail--
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")}
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (s *managerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Method == http.MethodGet {
+		s.sessions++
+		fmt.Fprintf(w, `{"apiUrl":"http://%s/api","state":"s1","primaryAccounts":{%q:"u1"},"accounts":{"u1":{"accountCapabilities":{%q:{}}}}}`,
+			r.Host, MaskedEmailCapabilityURI, MaskedEmailCapabilityURI)
+		return
+	}
+
+	var request struct {
+		MethodCalls [][]json.RawMessage `json:"methodCalls"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.MethodCalls) == 0 {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	var name string
+	json.Unmarshal(request.MethodCalls[0][0], &name)
+	var response interface{}
+	switch name {
+	case "MaskedEmail/get":
+		s.gets++
+		response = []interface{}{name, map[string]interface{}{"list": s.emails, "state": "1"}, "0"}
+	case "MaskedEmail/set":
+		s.sets++
+		var payload struct {
+			Create map[string]CreatePayload `json:"create"`
+			Update map[string]UpdatePayload `json:"update"`
+		}
+		json.Unmarshal(request.MethodCalls[0][1], &payload)
+
+		created := map[string]*MaskedEmail{}
+		for key, create := range payload.Create {
+			email := &MaskedEmail{
+				ID:     fmt.Sprintf("me%d", len(s.emails)+1),
+				Email:  fmt.Sprintf("new.mask%d@fastmail.com", len(s.emails)+1),
+				State:  MaskedEmailState(create.State),
+				Domain: create.Domain,
+			}
+			s.emails = append(s.emails, email)
+			created[key] = email
+		}
+		updated := map[string]interface{}{}
+		for id, update := range payload.Update {
+			for _, email := range s.emails {
+				if email.ID == id {
+					email.State = MaskedEmailState(update.State)
+					updated[id] = nil
+				}
+			}
+		}
+		response = []interface{}{name, map[string]interface{}{"created": created, "updated": updated}, "0"}
+	default:
+		response = []interface{}{"error", map[string]string{"type": "unknownMethod"}, "0"}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"methodResponses": []interface{}{response}, "sessionState": "s1"})
+}
+
+func TestManagerFind(t *testing.T) {
+	tests := []struct {
+		name    string
+		ref     string
+		wantID  string
+		wantErr string
+	}{
+		{"id", "me1", "me1", ""},
+		{"address", "alpha.one123@fastmail.com", "me1", ""},
+		{"upper case", "Alpha.One123@Fastmail.com", "me1", ""},
+		{"angle brackets", "<ALPHA.ONE123@fastmail.com>", "me1", ""},
+		{"mailto", "mailto:beta.two456@fastmail.com?subject=Hi", "me2", ""},
+		{"unknown", "gamma.three789@fastmail.com", "", "maskedemail gamma.three789@fastmail.com not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, m := newManagerTest(t, 0)
+
+			email, err := m.Find(tt.ref)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Find(%q) error = %v, want %q", tt.ref, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Find(%q) error = %v", tt.ref, err)
+			}
+			if email.ID != tt.wantID {
+				t.Errorf("Find(%q) = %s, want %s", tt.ref, email.ID, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestManagerCacheTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		wait     time.Duration
+		wantGets int
+	}{
+		{"cached", time.Hour, 0, 1},
+		{"expired", 10 * time.Millisecond, 20 * time.Millisecond, 2},
+		{"no cache", 0, 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, m := newManagerTest(t, 0)
+			m.CacheTTL = tt.ttl
+
+			if _, err := m.List(); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(tt.wait)
+			if _, err := m.Find("me2"); err != nil {
+				t.Fatal(err)
+			}
+			if s.gets != tt.wantGets {
+				t.Errorf("fetched the masked emails %d times, want %d", s.gets, tt.wantGets)
+			}
+		})
+	}
+}
+
+func TestManagerSetStateUpdatesCache(t *testing.T) {
+	s, m := newManagerTest(t, 0)
+
+	email, err := m.SetState("alpha.one123@fastmail.com", MaskedEmailStateDisabled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email.State != MaskedEmailStateDisabled {
+		t.Errorf("SetState() state = %s, want disabled", email.State)
+	}
+
+	// the cached copy changed along, without fetching again
+	email, err = m.Find("me1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email.State != MaskedEmailStateDisabled {
+		t.Errorf("Find() after SetState() state = %s, want disabled", email.State)
+	}
+
+	// the state it already has isn't sent again
+	if _, err := m.SetState("me2", MaskedEmailStateDisabled); err != nil {
+		t.Fatal(err)
+	}
+	if s.gets != 1 || s.sets != 1 {
+		t.Errorf("sent %d gets and %d sets, want 1 and 1", s.gets, s.sets)
+	}
+}
+
+func TestManagerRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failPosts    int
+		wantErr      bool
+		wantGets     int
+		wantSessions int
+	}{
+		{"no failure", 0, false, 1, 1},
+		{"retried", 2, false, 1, 3},
+		{"retries used up", 3, true, 0, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, m := newManagerTest(t, tt.failPosts)
+
+			_, err := m.List()
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("List() error = %v, want an error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !isNetworkError(err) {
+				t.Errorf("List() error = %v, want the network error", err)
+			}
+			// the session is fetched again before each retry
+			if s.gets != tt.wantGets || s.sessions != tt.wantSessions {
+				t.Errorf("sent %d gets and %d session requests, want %d and %d", s.gets, s.sessions, tt.wantGets, tt.wantSessions)
+			}
+		})
+	}
+}
+
+func TestManagerCreate(t *testing.T) {
+	s, m := newManagerTest(t, 0)
+
+	if _, err := m.List(); err != nil {
+		t.Fatal(err)
+	}
+	created, err := m.Create("example.com", "newsletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Domain != "example.com" || created.State != MaskedEmailStateEnabled {
+		t.Errorf("Create() = %s for %s, want enabled for example.com", created.State, created.Domain)
+	}
+
+	// it's added to the cache
+	if _, err := m.Find(strings.ToUpper(created.Email)); err != nil {
+		t.Errorf("Find() after Create() error = %v", err)
+	}
+	if s.gets != 1 {
+		t.Errorf("fetched the masked emails %d times, want 1", s.gets)
+	}
+}
+
+func TestManagerCreateNotRetried(t *testing.T) {
+	s, m := newManagerTest(t, 1)
+
+	if _, err := m.Create("example.com", ""); err == nil || !isNetworkError(err) {
+		t.Fatalf("Create() error = %v, want the network error", err)
+	}
+	if s.sets != 0 || s.sessions != 1 {
+		t.Errorf("sent %d sets and %d session requests, want no retry", s.sets, s.sessions)
+	}
+
+	// the next call works again
+	if _, err := m.Create("example.com", ""); err != nil {
+		t.Fatal(err)
+	}
+	if s.sets != 1 {
+		t.Errorf("sent %d sets, want 1", s.sets)
+	}
+}
@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// MaskedEmail is a masked email as returned by the API. Timestamps are
+// parsed, and properties the API may return as null are pointers.
+//
+// https://www.fastmail.com/developer/maskedemail/
+type MaskedEmail struct {
+	ID          string
+	Email       string
+	State       MaskedEmailState
+	Domain      string
+	Description string
+	CreatedBy   string
+	CreatedAt   time.Time
+	// LastMessageAt is nil if the masked email never received mail.
+	LastMessageAt *time.Time
+	// URL is nil if the masked email has no url.
+	URL *string
+}
+
+// IsActive reports whether the masked email receives or will receive mail.
+func (s MaskedEmailState) IsActive() bool {
+	return s == MaskedEmailStateEnabled || s == MaskedEmailStatePending
+}
+
+// maskedEmailJSON is the wire format of MaskedEmail.
+type maskedEmailJSON struct {
+	ID            string           `json:"id"`
+	Email         string           `json:"email"`
+	State         MaskedEmailState `json:"state"`
+	Domain        string           `json:"forDomain"`
+	Description   string           `json:"description"`
+	CreatedBy     string           `json:"createdBy"`
+	CreatedAt     *time.Time       `json:"createdAt"`
+	LastMessageAt *time.Time       `json:"lastMessageAt"`
+	URL           *string          `json:"url"`
+}
+
+// MarshalJSON encodes the masked email with the API's property names.
+// Timestamps are written in UTC, unset ones as null.
+func (m MaskedEmail) MarshalJSON() ([]byte, error) {
+	wire := maskedEmailJSON{
+		ID:            m.ID,
+		Email:         m.Email,
+		State:         m.State,
+		Domain:        m.Domain,
+		Description:   m.Description,
+		CreatedBy:     m.CreatedBy,
+		LastMessageAt: utcOrNil(m.LastMessageAt),
+		URL:           m.URL,
+	}
+	if !m.CreatedAt.IsZero() {
+		wire.CreatedAt = utcOrNil(&m.CreatedAt)
+	}
+
+	return json.Marshal(wire)
+}
+
+// UnmarshalJSON decodes a masked email in the API's format.
+func (m *MaskedEmail) UnmarshalJSON(b []byte) error {
+	var wire maskedEmailJSON
+	if err := json.Unmarshal(b, &wire); err != nil {
+		return err
+	}
+
+	*m = MaskedEmail{
+		ID:            wire.ID,
+		Email:         wire.Email,
+		State:         wire.State,
+		Domain:        wire.Domain,
+		Description:   wire.Description,
+		CreatedBy:     wire.CreatedBy,
+		LastMessageAt: wire.LastMessageAt,
+		URL:           wire.URL,
+	}
+	if wire.CreatedAt != nil {
+		m.CreatedAt = *wire.CreatedAt
+	}
+
+	return nil
+}
+
+func utcOrNil(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+// decodePayload decodes a method response payload, as kept raw by
+// APIResponse, parsing the RFC3339 timestamps of the API into time.Time.
+func decodePayload(payload interface{}, out interface{}) error {
+	raw, ok := payload.(json.RawMessage)
+	if !ok {
+		return fmt.Errorf("unexpected method response payload %T", payload)
+	}
+	return json.Unmarshal(raw, out)
+}
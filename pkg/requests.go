@@ -51,6 +51,7 @@ type CreatePayload struct {
 	Domain      string `json:"forDomain"`
 	State       string `json:"state,omitempty"`
 	Description string `json:"description"`
+	URL         string `json:"url,omitempty"`
 }
 
 type MethodCallCreate struct {
@@ -62,6 +63,9 @@ type UpdatePayload struct {
 	State       string `json:"state,omitempty"`
 	Domain      string `json:"forDomain,omitempty"`
 	Description string `json:"description,omitempty"`
+	EmailPrefix string `json:"emailPrefix,omitempty"`
+	// URL is a JSON string, or null to clear the url, omitted if unchanged
+	URL json.RawMessage `json:"url,omitempty"`
 }
 
 // NewMethodCallCreate creates a new method call to create a new maskedemail.
@@ -83,12 +87,14 @@ func NewMethodCallCreate(accID, appName, domain string, state string, descriptio
 	return mesp
 }
 
+// MaskedEmailState is the state of a masked email.
 type MaskedEmailState string
 
 const (
+	MaskedEmailStatePending  MaskedEmailState = "pending"
 	MaskedEmailStateEnabled  MaskedEmailState = "enabled"
-	MaskedEmailStateDisabled                  = "disabled"
-	MaskedEmailStateDeleted                   = "deleted"
+	MaskedEmailStateDisabled MaskedEmailState = "disabled"
+	MaskedEmailStateDeleted  MaskedEmailState = "deleted"
 )
 
 type MethodCallUpdate struct {
@@ -130,11 +136,26 @@ func NewMethodCallUpdate(accID, alias string, fields *UpdateFields) MethodCallUp
 		}
 	}
 
+	var emailPrefix string = "";
+	if fields.isEmailPrefixSet {
+		emailPrefix = fields.emailPrefix
+	}
+
+	var url json.RawMessage
+	if fields.isURLSet {
+		url = json.RawMessage("null")
+		if fields.url != "" {
+			url, _ = json.Marshal(fields.url)
+		}
+	}
+
 	mesp.Update = map[string]UpdatePayload{
 		alias: {
 			State: string(state),
 			Domain: string(domain),
 			Description: string(description),
+			EmailPrefix: emailPrefix,
+			URL: url,
 		},
 	}
 
@@ -181,6 +202,9 @@ func NewMethodCallUpdate(accID, alias string, fields *UpdateFields) MethodCallUp
 */
 type MethodCallGetAll struct {
 	AccountID string `json:"accountId,omitempty"`
+	// Properties limits the properties returned, all if empty. The id is
+	// always returned.
+	Properties []string `json:"properties,omitempty"`
 }
 
 func NewMethodCallGetAll(accID string) MethodCallGetAll {
@@ -189,3 +213,23 @@ func NewMethodCallGetAll(accID string) MethodCallGetAll {
 
 	return mesp
 }
+
+// MethodCallGet is a method call to get specific maskedemails by ID.
+type MethodCallGet struct {
+	AccountID string   `json:"accountId,omitempty"`
+	IDs       []string `json:"ids"`
+}
+
+// MethodCallChanges is a method call to get the IDs of the maskedemails
+// changed since a state.
+type MethodCallChanges struct {
+	AccountID  string `json:"accountId,omitempty"`
+	SinceState string `json:"sinceState"`
+}
+
+// MethodCallDestroy is a method call to permanently remove maskedemails by
+// ID, unlike an update to the deleted state.
+type MethodCallDestroy struct {
+	AccountID string   `json:"accountId,omitempty"`
+	Destroy   []string `json:"destroy"`
+}
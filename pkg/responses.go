@@ -3,8 +3,16 @@ package pkg
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// MethodResponse is one response of an API request. Payload holds the
+// arguments as a json.RawMessage, they're only decoded into the type the
+// caller asks for.
 type MethodResponse struct {
 	MethodName string
 	Payload    interface{}
@@ -19,18 +27,35 @@ type APIResponse struct {
 }
 
 func (gr *APIResponse) UnmarshalJSON(b []byte) error {
-	type apiResponse2 APIResponse
-	if err := json.Unmarshal(b, (*apiResponse2)(gr)); err != nil {
+	// the arguments stay raw, decoding them into generic maps first is
+	// what made large accounts slow
+	var wire struct {
+		LatestClientVersion string              `json:"latestClientVersion,omitempty"`
+		MethodResponses     [][]json.RawMessage `json:"methodResponses,omitempty"`
+		SessionState        string              `json:"sessionState,omitempty"`
+	}
+	if err := json.Unmarshal(b, &wire); err != nil {
 		return err
 	}
 
-	responses := []MethodResponse{}
-	for _, res := range gr.MethodResponses {
-		r := MethodResponse{}
-		r.MethodName = res[0].(string)
-		r.Payload = res[1]
-		r.Payload2 = res[2].(string)
+	gr.LatestClientVersion = wire.LatestClientVersion
+	gr.SessionState = wire.SessionState
+	gr.MethodResponses = make([][]interface{}, 0, len(wire.MethodResponses))
+	responses := make([]MethodResponse, 0, len(wire.MethodResponses))
+	for _, res := range wire.MethodResponses {
+		if len(res) != 3 {
+			return fmt.Errorf("invalid method response with %d elements", len(res))
+		}
+
+		r := MethodResponse{Payload: res[1]}
+		if err := json.Unmarshal(res[0], &r.MethodName); err != nil {
+			return fmt.Errorf("invalid method name: %w", err)
+		}
+		if err := json.Unmarshal(res[2], &r.Payload2); err != nil {
+			return fmt.Errorf("invalid method call id: %w", err)
+		}
 
+		gr.MethodResponses = append(gr.MethodResponses, []interface{}{r.MethodName, r.Payload, r.Payload2})
 		responses = append(responses, r)
 	}
 
@@ -38,25 +63,66 @@ func (gr *APIResponse) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-type MaskedEmail struct {
-	CreatedAt     string `mapstructure:"createdAt" json:"createdAt"`
-	CreatedBy     string `mapstructure:"createdBy" json:"createdBy"`
-	Description   string `mapstructure:"description" json:"description"`
-	Email         string `mapstructure:"email" json:"email"`
-	ID            string `mapstructure:"id" json:"id"`
-	LastMessageAt string `mapstructure:"lastMessageAt" json:"lastMessageAt"`
-	State         string `mapstructure:"state" json:"state"`
-	URL           string `mapstructure:"url" json:"url"`
-	Domain        string `mapstructure:"forDomain" json:"forDomain"`
+type MethodResponseMaskedEmailSet struct {
+	AccountID string                 `json:"accountId"`
+	Created   map[string]MaskedEmail `json:"created"`
+	Updated   map[string]interface{} `json:"updated"`
+	Destroyed []interface{}          `json:"destroyed"`
+	NewState  interface{}            `json:"newState"`
+	OldState  interface{}            `json:"oldState"`
+
+	NotCreated   map[string]SetError `json:"notCreated"`
+	NotUpdated   map[string]SetError `json:"notUpdated"`
+	NotDestroyed map[string]SetError `json:"notDestroyed"`
 }
 
-type MethodResponseMaskedEmailSet struct {
-	AccountID string                 `mapstructure:"accountId"`
-	Created   map[string]MaskedEmail `mapstructure:"created"`
-	Updated   map[string]interface{} `mapstructure:"updated"`
-	Destroyed []interface{}          `mapstructure:"destroyed"`
-	NewState  interface{}            `mapstructure:"newState"`
-	OldState  interface{}            `mapstructure:"oldState"`
+// SetError is the reason the server rejected a create, update or destroy of
+// a /set call.
+//
+// https://jmap.io/spec-core.html#set
+type SetError struct {
+	// Type is e.g. "invalidProperties", "notFound" or "overQuota".
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	// Properties lists the offending properties for "invalidProperties".
+	Properties []string `json:"properties"`
+}
+
+func (e *SetError) Error() string {
+	msg := e.Type
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	if len(e.Properties) > 0 {
+		msg += fmt.Sprintf(" (properties: %s)", strings.Join(e.Properties, ", "))
+	}
+	return msg
+}
+
+// HasProperty reports whether the error concerns the given property.
+func (e *SetError) HasProperty(name string) bool {
+	for _, p := range e.Properties {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// MethodError is returned when the server rejects a whole method call, e.g.
+// for an unknown account or invalid arguments.
+//
+// https://jmap.io/spec-core.html#errors
+type MethodError struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+func (e *MethodError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("method error %s: %s", e.Type, e.Description)
+	}
+	return "method error " + e.Type
 }
 
 func (cr *MethodResponseMaskedEmailSet) GetCreatedItem() (MaskedEmail, error) {
@@ -68,10 +134,24 @@ func (cr *MethodResponseMaskedEmailSet) GetCreatedItem() (MaskedEmail, error) {
 }
 
 type MethodResponseGetAll struct {
-	AccountID string         `mapstructure:"accountId"`
-	NotFound  []interface{}  `mapstructure:"notFound"`
-	State     string         `mapstructure:"state"`
-	List      []*MaskedEmail `mapstructure:"list"`
+	AccountID string         `json:"accountId"`
+	NotFound  []interface{}  `json:"notFound"`
+	State     string         `json:"state"`
+	List      []*MaskedEmail `json:"list"`
+}
+
+// MethodResponseChanges lists the IDs of the maskedemails created, updated
+// and destroyed between OldState and NewState.
+//
+// https://jmap.io/spec-core.html#changes
+type MethodResponseChanges struct {
+	AccountID      string   `json:"accountId"`
+	OldState       string   `json:"oldState"`
+	NewState       string   `json:"newState"`
+	HasMoreChanges bool     `json:"hasMoreChanges"`
+	Created        []string `json:"created"`
+	Updated        []string `json:"updated"`
+	Destroyed      []string `json:"destroyed"`
 }
 
 // Account is a collection of data in the JMAP API.
@@ -105,6 +185,16 @@ type SessionResource struct {
 	PrimaryAccounts map[string]string `json:"primaryAccounts"`
 	// ApiUrl is the URL to use for JMAP API requests.
 	ApiUrl string `json:"apiUrl"`
+	// EventSourceUrl is the URL template to receive push notifications with
+	// server-sent events, see EventSourceURL.
+	EventSourceUrl string `json:"eventSourceUrl"`
+	// State changes whenever the session resource changes, e.g. accounts or
+	// capabilities are added. API responses carry the current one.
+	State string `json:"state"`
+
+	fetchedAt time.Time
+	// outdated is set when an API response reported a different state
+	outdated bool
 }
 
 var _ Session = &SessionResource{}
@@ -113,6 +203,45 @@ func (s *SessionResource) ApiEndpoint() string {
 	return s.ApiUrl
 }
 
+// SessionState returns the state of the session resource.
+func (s *SessionResource) SessionState() string {
+	return s.State
+}
+
+// FetchedAt returns when the client fetched the session, the zero time for
+// sessions not fetched with Client.Session.
+func (s *SessionResource) FetchedAt() time.Time {
+	return s.fetchedAt
+}
+
+// IsStale reports whether the session should be fetched again: it's older
+// than maxAge, its age is unknown, or an API response since reported a
+// different session state.
+func (s *SessionResource) IsStale(maxAge time.Duration) bool {
+	return s.outdated || s.fetchedAt.IsZero() || time.Since(s.fetchedAt) > maxAge
+}
+
+// EventSourceURL expands the eventSourceUrl template for the given data
+// types ("*" for all), whether the server should close the connection after
+// the first state change, and the ping interval (0 for none).
+//
+// https://jmap.io/spec-core.html#event-source
+func (s *SessionResource) EventSourceURL(types []string, closeAfterState bool, ping time.Duration) (string, error) {
+	if s.EventSourceUrl == "" {
+		return "", errors.New("the server doesn't announce an eventSourceUrl")
+	}
+
+	closeAfter := "no"
+	if closeAfterState {
+		closeAfter = "state"
+	}
+	return strings.NewReplacer(
+		"{types}", url.QueryEscape(strings.Join(types, ",")),
+		"{closeafter}", closeAfter,
+		"{ping}", strconv.Itoa(int(ping/time.Second)),
+	).Replace(s.EventSourceUrl), nil
+}
+
 func (s *SessionResource) DefaultAccountForCapability(capabilityURI string) string {
 	return s.PrimaryAccounts[capabilityURI]
 }
@@ -121,3 +250,32 @@ func (s *SessionResource) AccountHasCapability(accID string, capabilityURI strin
 	_, ok := s.Accounts[accID].Capabilities[capabilityURI]
 	return ok
 }
+
+// CoreCapabilityURI is the capability URI of the JMAP core.
+const CoreCapabilityURI = "urn:ietf:params:jmap:core"
+
+// CoreCapability is the server capability object of the JMAP core. Only the
+// limits the client cares about are decoded.
+//
+// https://jmap.io/spec-core.html#the-jmap-session-resource
+type CoreCapability struct {
+	// MaxObjectsInSet is the maximum number of objects a single /set call
+	// may create, update or destroy. It's 0 if the server doesn't announce a
+	// limit.
+	MaxObjectsInSet int `json:"maxObjectsInSet"`
+}
+
+// CoreCapability decodes the core capability of the server.
+func (s *SessionResource) CoreCapability() (*CoreCapability, error) {
+	var capability CoreCapability
+
+	raw, ok := s.Capabilities[CoreCapabilityURI]
+	if !ok || len(raw) == 0 {
+		return &capability, nil
+	}
+	if err := json.Unmarshal(raw, &capability); err != nil {
+		return nil, err
+	}
+
+	return &capability, nil
+}
@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cachedSession is the file format of the session cache.
+type cachedSession struct {
+	FetchedAt time.Time        `json:"fetchedAt"`
+	Session   *SessionResource `json:"session"`
+}
+
+// SetSessionCache makes Session reuse a session stored in dir while it's
+// younger than ttl, instead of fetching it on every run, and store fetched
+// sessions there. Sessions are stored per endpoint and token, the token
+// itself is never written. A cached session is dropped as soon as an API
+// response reports that the session changed or a request fails. Pass an
+// empty dir to disable the cache.
+func (client *Client) SetSessionCache(dir string, ttl time.Duration) {
+	client.sessionCacheDir = dir
+	client.sessionCacheTTL = ttl
+}
+
+func (client *Client) sessionCachePath() string {
+	if client.sessionCacheDir == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(client.sessionURL + "\n" + client.auth))
+	return filepath.Join(client.sessionCacheDir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// cachedSession returns the cached session, nil if there is none or it's
+// too old.
+func (client *Client) cachedSession() *SessionResource {
+	path := client.sessionCachePath()
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var cached cachedSession
+	if err := json.Unmarshal(data, &cached); err != nil || cached.Session == nil || cached.Session.ApiUrl == "" {
+		return nil
+	}
+	if time.Since(cached.FetchedAt) > client.sessionCacheTTL || cached.FetchedAt.After(time.Now()) {
+		return nil
+	}
+
+	cached.Session.fetchedAt = cached.FetchedAt
+	return cached.Session
+}
+
+// storeSession writes the session to the cache. The cache only saves a
+// request, so failures are ignored.
+func (client *Client) storeSession(session *SessionResource) {
+	path := client.sessionCachePath()
+	if path == "" {
+		return
+	}
+
+	data, err := json.Marshal(cachedSession{FetchedAt: session.fetchedAt, Session: session})
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return
+	}
+	os.Rename(tmp, path)
+
+	// sessions of rotated tokens are never read again
+	entries, _ := os.ReadDir(client.sessionCacheDir)
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > client.sessionCacheTTL {
+			os.Remove(filepath.Join(client.sessionCacheDir, entry.Name()))
+		}
+	}
+}
+
+// dropCachedSession removes the cached session, so the next run fetches it
+// again.
+func (client *Client) dropCachedSession() {
+	if path := client.sessionCachePath(); path != "" {
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,209 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// EachMaskedEmail calls fn for every masked email of the account while the
+// response is still being read, instead of decoding all of them first like
+// GetAllMaskedEmailsAndState. It returns the state string of the server. An
+// error returned by fn stops reading and is returned as is.
+func (client *Client) EachMaskedEmail(
+	session Session,
+	accID string,
+	fn func(email *MaskedEmail) error,
+) (state string, err error) {
+	var fnErr error
+	// like sendRequest, a session that no longer works must not stay cached
+	defer func() {
+		var methodErr *MethodError
+		if err != nil && err != fnErr && !errors.As(err, &methodErr) && !errors.Is(err, ErrReadOnly) {
+			client.dropCachedSession()
+		}
+	}()
+
+	accID, err = client.accIDOrDefault(session, accID)
+	if err != nil {
+		return "", err
+	}
+
+	apiRequest := APIRequest{
+		Using: []string{
+			"urn:ietf:params:jmap:core",
+			MaskedEmailCapabilityURI,
+		},
+		MethodCalls: []MethodCall{{
+			MethodName: "MaskedEmail/get",
+			Payload:    NewMethodCallGetAll(accID),
+			Payload2:   "0",
+		}},
+	}
+
+	res, err := client.postRequest(session, &apiRequest)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var body io.Reader = res.Body
+	// the dump shows whole responses, so there's nothing to stream then
+	if client.dump != nil {
+		buf := &bytes.Buffer{}
+		if _, err := buf.ReadFrom(res.Body); err != nil {
+			return "", err
+		}
+		client.dumpJSON(fmt.Sprintf("< %s", res.Status), buf.Bytes(), false)
+		body = buf
+	}
+
+	state, sessionState, err := decodeGetStream(body, func(email *MaskedEmail) error {
+		fnErr = fn(email)
+		return fnErr
+	})
+	if err != nil {
+		return "", err
+	}
+	client.checkSessionState(session, sessionState)
+
+	return state, nil
+}
+
+// decodeGetStream reads an API response to MaskedEmail/get token by token,
+// calling fn for each masked email of the list as soon as it's decoded. It
+// returns the state of the method response and the session state.
+func decodeGetStream(r io.Reader, fn func(email *MaskedEmail) error) (state string, sessionState string, err error) {
+	dec := json.NewDecoder(r)
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return "", "", err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return "", "", err
+		}
+
+		switch key {
+		case "methodResponses":
+			if err := expectDelim(dec, '['); err != nil {
+				return "", "", err
+			}
+			for dec.More() {
+				if state, err = decodeGetInvocation(dec, fn); err != nil {
+					return "", "", err
+				}
+			}
+			if err := expectDelim(dec, ']'); err != nil {
+				return "", "", err
+			}
+		case "sessionState":
+			if err := dec.Decode(&sessionState); err != nil {
+				return "", "", err
+			}
+		default:
+			if err := skipValue(dec); err != nil {
+				return "", "", err
+			}
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return "", "", err
+	}
+
+	return state, sessionState, nil
+}
+
+// decodeGetInvocation reads one [name, arguments, call id] method response,
+// returning its state or the *MethodError the server answered with.
+func decodeGetInvocation(dec *json.Decoder, fn func(email *MaskedEmail) error) (string, error) {
+	if err := expectDelim(dec, '['); err != nil {
+		return "", err
+	}
+
+	var name string
+	if err := dec.Decode(&name); err != nil {
+		return "", fmt.Errorf("invalid method name: %w", err)
+	}
+	if name == "error" {
+		var methodErr MethodError
+		if err := dec.Decode(&methodErr); err != nil {
+			return "", err
+		}
+		return "", &methodErr
+	}
+
+	state := ""
+	if err := expectDelim(dec, '{'); err != nil {
+		return "", err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+
+		switch key {
+		case "list":
+			if err := expectDelim(dec, '['); err != nil {
+				return "", err
+			}
+			for dec.More() {
+				var email MaskedEmail
+				if err := dec.Decode(&email); err != nil {
+					return "", err
+				}
+				if err := fn(&email); err != nil {
+					return "", err
+				}
+			}
+			if err := expectDelim(dec, ']'); err != nil {
+				return "", err
+			}
+		case "state":
+			if err := dec.Decode(&state); err != nil {
+				return "", err
+			}
+		default:
+			if err := skipValue(dec); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return "", err
+	}
+
+	// the method call id
+	for dec.More() {
+		if err := skipValue(dec); err != nil {
+			return "", err
+		}
+	}
+	if err := expectDelim(dec, ']'); err != nil {
+		return "", err
+	}
+
+	return state, nil
+}
+
+// expectDelim reads the next token and fails unless it's the delimiter.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("invalid response: expected %s, got %v", delim, token)
+	}
+	return nil
+}
+
+// skipValue reads and discards the next value.
+func skipValue(dec *json.Decoder) error {
+	var skip json.RawMessage
+	return dec.Decode(&skip)
+}
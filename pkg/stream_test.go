@@ -0,0 +1,102 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestSession returns a session for the server at url with the masked
+// email capability in account "u1".
+func newTestSession(url string) *SessionResource {
+	return &SessionResource{
+		ApiUrl:          url,
+		PrimaryAccounts: map[string]string{MaskedEmailCapabilityURI: "u1"},
+	}
+}
+
+func TestEachMaskedEmailStreams(t *testing.T) {
+	received := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"methodResponses":[["MaskedEmail/get",{"accountId":"u1","list":[{"id":"me1","email":"a@fastmail.com","state":"enabled"}`)
+		w.(http.Flusher).Flush()
+
+		// the rest is only sent once the client got the first masked email
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Error("the first masked email wasn't decoded before the response was complete")
+		}
+		fmt.Fprint(w, `,{"id":"me2","email":"b@fastmail.com","state":"disabled"}],"state":"s1","notFound":[]},"0"]],"sessionState":"x"}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("token", "test", "")
+	var ids []string
+	state, err := client.EachMaskedEmail(newTestSession(server.URL), "", func(email *MaskedEmail) error {
+		ids = append(ids, email.ID)
+		received <- email.ID
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachMaskedEmail() error = %v", err)
+	}
+	if state != "s1" {
+		t.Errorf("EachMaskedEmail() state = %q, want %q", state, "s1")
+	}
+	if fmt.Sprint(ids) != "[me1 me2]" {
+		t.Errorf("EachMaskedEmail() got %v, want [me1 me2]", ids)
+	}
+}
+
+func TestEachMaskedEmailErrors(t *testing.T) {
+	stop := errors.New("stop")
+
+	tests := []struct {
+		name     string
+		response string
+		fnErr    error
+		wantErr  string
+	}{
+		{
+			name:     "method error",
+			response: `{"methodResponses":[["error",{"type":"accountNotFound"},"0"]]}`,
+			wantErr:  "method error accountNotFound",
+		},
+		{
+			name:     "callback error",
+			response: `{"methodResponses":[["MaskedEmail/get",{"list":[{"id":"me1"},{"id":"me2"}]},"0"]]}`,
+			fnErr:    stop,
+			wantErr:  "stop",
+		},
+		{
+			name:     "truncated",
+			response: `{"methodResponses":[["MaskedEmail/get",{"list":[{"id":"me1"}`,
+			wantErr:  "unexpected end of JSON input",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.response)
+			}))
+			defer server.Close()
+
+			calls := 0
+			client := NewClient("token", "test", "")
+			_, err := client.EachMaskedEmail(newTestSession(server.URL), "", func(email *MaskedEmail) error {
+				calls++
+				return tt.fnErr
+			})
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("EachMaskedEmail() error = %v, want %q", err, tt.wantErr)
+			}
+			if tt.fnErr != nil && calls != 1 {
+				t.Errorf("callback called %d times after failing, want 1", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// checkDomainPolicy enforces the maximum number of active masked emails per
+// registrable domain, so subdomains count towards their parent, before count
+// more are created. A limit of 0 or less disables the policy.
+func checkDomainPolicy(emails []*pkg.MaskedEmail, domain string, limit int, count int) error {
+	domain = pkg.RegistrableDomain(domain)
+	if limit <= 0 || domain == "" {
+		return nil
+	}
+
+	var existing []string
+	for _, email := range pkg.DomainIndex(emails)[domain] {
+		if email.State.IsActive() {
+			existing = append(existing, email.Email)
+		}
+	}
+
+	if len(existing)+count <= limit {
+		return nil
+	}
+
+	if count > 1 {
+		return fmt.Errorf(
+			"policy allows %d active masked email(s) per domain, %s already has %d, %d more would exceed it (pass -%s to create anyway)",
+			limit, domain, len(existing), count, flagNameIgnorePolicy,
+		)
+	}
+	return fmt.Errorf(
+		"policy allows %d active masked email(s) per domain, %s already has %d: %s (pass -%s to create anyway)",
+		limit, domain, len(existing), strings.Join(existing, ", "), flagNameIgnorePolicy,
+	)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+const formatPrometheus string = "prometheus"
+
+// prometheusStates are always exported, also with a count of 0, so the
+// series don't disappear when the last masked email leaves a state.
+var prometheusStates = []pkg.MaskedEmailState{
+	pkg.MaskedEmailStatePending,
+	pkg.MaskedEmailStateEnabled,
+	pkg.MaskedEmailStateDisabled,
+	pkg.MaskedEmailStateDeleted,
+}
+
+// printPrometheus writes the stats in the Prometheus text exposition format,
+// for the textfile collector of node_exporter. The metric names are part of
+// the output contract for dashboards and alerts, don't rename them.
+func printPrometheus(out io.Writer, accID string, s accountStats) {
+	account := prometheusLabel(accID)
+
+	counts := map[string]int{}
+	for _, state := range prometheusStates {
+		counts[string(state)] = 0
+	}
+	for state, n := range s.ByState {
+		counts[state] = n
+	}
+	states := make([]string, 0, len(counts))
+	for state := range counts {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+
+	fmt.Fprintln(out, "# HELP maskedemail_total Number of masked emails by state.")
+	fmt.Fprintln(out, "# TYPE maskedemail_total gauge")
+	for _, state := range states {
+		fmt.Fprintf(out, "maskedemail_total{account=\"%s\",state=\"%s\"} %d\n", account, prometheusLabel(state), counts[state])
+	}
+}
+
+// prometheusLabel escapes a label value.
+func prometheusLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// stdinReader is shared by all prompts so buffered input isn't lost between
+// consecutive questions.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// confirm asks a yes/no question on stderr and returns true only if the user
+// answers with "y" or "yes". Anything else, including EOF, counts as "no".
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+
+	answer, err := stdinReader.ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Fprintln(os.Stderr)
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// isTerminal reports whether f is connected to a terminal rather than a pipe
+// or file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// ask prints a question on stderr and returns the trimmed answer, or def if
+// the answer is empty.
+func ask(question string, def string) string {
+	if def != "" {
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: ", question)
+	}
+
+	answer, _ := stdinReader.ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return def
+	}
+	return answer
+}
+
+// readSecret prompts for a secret without echoing it when stdin is a
+// terminal. Echo is toggled with stty, so on systems without it the input
+// stays visible.
+func readSecret(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+
+	if isTerminal(os.Stdin) && stty("-echo") == nil {
+		restore := func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}
+		remove := onInterrupt(restore)
+		defer func() {
+			remove()
+			restore()
+		}()
+	}
+
+	secret, err := stdinReader.ReadString('\n')
+	if err != nil && secret == "" {
+		return "", err
+	}
+
+	return strings.TrimSpace(secret), nil
+}
+
+func stty(args ...string) error {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+// confirmMassDestruction guards operations destroying many masked emails.
+// Up to the -confirm-threshold a plain y/N confirmation is enough; above it
+// the user has to type the number of affected masked emails, like
+// infrastructure tooling does for destructive plans. Without a terminal the
+// operation is refused, callers have to offer an explicit override flag.
+func confirmMassDestruction(count int, what string) bool {
+	if !isTerminal(os.Stdin) {
+		return false
+	}
+
+	if count <= *flagConfirmThreshold {
+		return confirm(fmt.Sprintf("really %s %d masked email(s)?", what, count))
+	}
+
+	fmt.Fprintf(os.Stderr, "This will %s %d masked emails.\n", what, count)
+	answer := ask(fmt.Sprintf("Type %d to confirm", count), "")
+	return answer == strconv.Itoa(count)
+}
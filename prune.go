@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// flags for prune command
+var pruneCmd = flag.NewFlagSet(actionTypePrune, flag.ExitOnError)
+var flagPruneUnusedFor = pruneCmd.String(flagNameUnusedFor, "", "prune masked emails that received no email within this window, e.g. 365d")
+var flagPruneAction = pruneCmd.String(flagNameAction, actionTypeDisable, "what to do with them ("+actionTypeDisable+"|"+actionTypeDelete+")")
+var flagPruneDryRun = pruneCmd.Bool(flagNameDryRun, false, "only show the masked emails that would be pruned")
+var flagPruneYes = pruneCmd.Bool(flagNameYes, false, "don't ask for confirmation")
+
+// pruneCandidate reports whether the masked email went unused since cutoff:
+// its last email arrived before it, or it never got one and was created
+// before it. Masked emails already in the target state are skipped.
+func pruneCandidate(email *pkg.MaskedEmail, state pkg.MaskedEmailState, cutoff time.Time) bool {
+	if email.State == state || email.State == pkg.MaskedEmailStateDeleted {
+		return false
+	}
+	if email.LastMessageAt != nil && !email.LastMessageAt.IsZero() {
+		return email.LastMessageAt.Before(cutoff)
+	}
+	return email.CreatedAt.Before(cutoff)
+}
+
+// runPrune disables or deletes the masked emails that went unused for a
+// while, after showing them and asking for confirmation.
+func runPrune(client *pkg.Client, args []string) {
+	parseCommandFlags(pruneCmd, args)
+	if pruneCmd.NArg() > 0 || *flagPruneUnusedFor == "" {
+		log.Fatalf("Usage: %s -%s <window> [-%s %s|%s] [-%s] [-%s]", actionTypePrune, flagNameUnusedFor, flagNameAction, actionTypeDisable, actionTypeDelete, flagNameDryRun, flagNameYes)
+	}
+	window, err := parseWindow(*flagPruneUnusedFor)
+	if err != nil {
+		log.Fatalf("invalid -%s: %v", flagNameUnusedFor, err)
+	}
+	action := *flagPruneAction
+	if action != actionTypeDisable && action != actionTypeDelete {
+		log.Fatalf("unsupported -%s %q (%s|%s)", flagNameAction, action, actionTypeDisable, actionTypeDelete)
+	}
+	cmd := stateCommands[action]
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	emails, err := client.GetAllMaskedEmails(session, *flagAccountID)
+	if err != nil {
+		log.Fatalf("error fetching masked emails: %v", err)
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-window)
+	candidates := []*pkg.MaskedEmail{}
+	for _, email := range emails {
+		if pruneCandidate(email, cmd.state, cutoff) {
+			candidates = append(candidates, email)
+		}
+	}
+	if len(candidates) == 0 {
+		fmt.Fprintf(os.Stderr, "no masked emails unused for %s\n", *flagPruneUnusedFor)
+		return
+	}
+	sortMaskedEmails(candidates, sortKeyIdle, now)
+
+	// the candidates go to stderr when stdout carries the results
+	out := os.Stdout
+	if jsonOutput() {
+		out = os.Stderr
+	}
+	writeTable(out, candidates, true, true, now)
+
+	if *flagPruneDryRun {
+		fmt.Fprintf(os.Stderr, "dry run: would %s %d masked email(s)\n", action, len(candidates))
+		return
+	}
+	if !*flagPruneYes {
+		if !isTerminal(os.Stdin) {
+			log.Fatalf("refusing to %s %d masked email(s) without confirmation, pass -%s", action, len(candidates), flagNameYes)
+		}
+		if !confirmMassDestruction(len(candidates), action) {
+			log.Fatalln("aborted")
+		}
+	}
+
+	addresses := make([]string, len(candidates))
+	for i, email := range candidates {
+		addresses[i] = email.Email
+	}
+	runSetStates(client, session, action, addresses, false, true)
+}
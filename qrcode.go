@@ -0,0 +1,531 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// A minimal QR code encoder (ISO/IEC 18004) for printing addresses in the
+// terminal: byte mode, error correction level M, versions 1 to 10, which is
+// plenty for an email address.
+
+// qrBlockSpec is the error correction layout of a version at level M: the
+// data codewords are split into blocks of group1Len (group1 times) and
+// group1Len+1 (group2 times), each getting ecLen error correction codewords.
+type qrBlockSpec struct {
+	ecLen     int
+	group1    int
+	group1Len int
+	group2    int
+}
+
+// qrVersionsM are the block layouts of versions 1 to 10 at level M.
+var qrVersionsM = []qrBlockSpec{
+	{10, 1, 16, 0},
+	{16, 1, 28, 0},
+	{26, 1, 44, 0},
+	{18, 2, 32, 0},
+	{24, 2, 43, 0},
+	{16, 4, 27, 0},
+	{18, 4, 31, 0},
+	{22, 2, 38, 2},
+	{22, 3, 36, 2},
+	{26, 4, 43, 1},
+}
+
+// qrAlignment are the alignment pattern center coordinates of versions 1 to
+// 10.
+var qrAlignment = [][]int{
+	{},
+	{6, 18},
+	{6, 22},
+	{6, 26},
+	{6, 30},
+	{6, 34},
+	{6, 22, 38},
+	{6, 24, 42},
+	{6, 26, 46},
+	{6, 28, 50},
+}
+
+func (spec qrBlockSpec) dataLen() int {
+	return spec.group1*spec.group1Len + spec.group2*(spec.group1Len+1)
+}
+
+// qrCode is an encoded symbol, modules[y][x] is true for dark modules.
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+// encodeQR encodes text in the smallest version that fits.
+func encodeQR(text string) (*qrCode, error) {
+	data := []byte(text)
+
+	for i, spec := range qrVersionsM {
+		version := i + 1
+		countBits := 8
+		if version >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) > 8*spec.dataLen() {
+			continue
+		}
+
+		codewords := qrDataCodewords(data, countBits, spec.dataLen())
+		qr := newQRCode(version, spec, qrAddErrorCorrection(codewords, spec))
+		qr.applyBestMask()
+		return qr, nil
+	}
+
+	return nil, errors.New("text is too long for a QR code")
+}
+
+// qrDataCodewords builds the byte mode bit stream with terminator and
+// padding.
+func qrDataCodewords(data []byte, countBits int, capacity int) []byte {
+	var bits []bool
+	appendBits := func(value int, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, (value>>i)&1 == 1)
+		}
+	}
+
+	appendBits(0x4, 4) // byte mode
+	appendBits(len(data), countBits)
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+
+	// terminator of up to four zero bits, then fill up the byte
+	for i := 0; i < 4 && len(bits) < capacity*8; i++ {
+		bits = append(bits, false)
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+
+	codewords := make([]byte, 0, capacity)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for j := 0; j < 8; j++ {
+			if bits[i+j] {
+				b |= 1 << (7 - j)
+			}
+		}
+		codewords = append(codewords, b)
+	}
+	for pad := byte(0xEC); len(codewords) < capacity; pad ^= 0xEC ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+
+	return codewords
+}
+
+// qrAddErrorCorrection splits the data into blocks, computes their error
+// correction codewords and interleaves everything.
+func qrAddErrorCorrection(data []byte, spec qrBlockSpec) []byte {
+	divisor := qrReedSolomonDivisor(spec.ecLen)
+
+	var blocks, ecBlocks [][]byte
+	offset := 0
+	for i := 0; i < spec.group1+spec.group2; i++ {
+		n := spec.group1Len
+		if i >= spec.group1 {
+			n++
+		}
+		block := data[offset : offset+n]
+		offset += n
+		blocks = append(blocks, block)
+		ecBlocks = append(ecBlocks, qrReedSolomonRemainder(block, divisor))
+	}
+
+	var result []byte
+	for i := 0; i <= spec.group1Len; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < spec.ecLen; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+
+	return result
+}
+
+// qrMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func qrMultiply(x byte, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+func qrReedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = qrMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = qrMultiply(root, 0x02)
+	}
+
+	return result
+}
+
+func qrReedSolomonRemainder(data []byte, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= qrMultiply(d, factor)
+		}
+	}
+	return result
+}
+
+// newQRCode places the function patterns and codewords of a symbol, which is
+// still unmasked and without format bits.
+func newQRCode(version int, spec qrBlockSpec, codewords []byte) *qrCode {
+	size := 17 + 4*version
+	qr := &qrCode{size: size}
+	qr.modules = make([][]bool, size)
+	qr.function = make([][]bool, size)
+	for y := range qr.modules {
+		qr.modules[y] = make([]bool, size)
+		qr.function[y] = make([]bool, size)
+	}
+
+	qr.drawFunctionPatterns(version)
+	qr.drawCodewords(codewords)
+
+	return qr
+}
+
+// applyBestMask masks the symbol with the mask of the lowest penalty, as the
+// standard asks.
+func (qr *qrCode) applyBestMask() {
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormatBits(mask)
+		if penalty := qr.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		qr.applyMask(mask) // masking twice undoes it
+	}
+	qr.applyMask(best)
+	qr.drawFormatBits(best)
+}
+
+func (qr *qrCode) set(x int, y int, dark bool) {
+	qr.modules[y][x] = dark
+	qr.function[y][x] = true
+}
+
+func (qr *qrCode) drawFunctionPatterns(version int) {
+	size := qr.size
+
+	for i := 0; i < size; i++ {
+		qr.set(6, i, i%2 == 0)
+		qr.set(i, 6, i%2 == 0)
+	}
+
+	// finder patterns with their separators
+	for _, center := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := center[0]+dx, center[1]+dy
+				if x < 0 || x >= size || y < 0 || y >= size {
+					continue
+				}
+				dist := qrMax(qrAbs(dx), qrAbs(dy))
+				qr.set(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	positions := qrAlignment[version-1]
+	last := len(positions) - 1
+	for i, cx := range positions {
+		for j, cy := range positions {
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue // overlaps a finder pattern
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					qr.set(cx+dx, cy+dy, qrMax(qrAbs(dx), qrAbs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// reserve the format areas, drawn once the mask is known
+	qr.drawFormatBits(0)
+
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := (bits>>i)&1 == 1
+			a, b := size-11+i%3, i/3
+			qr.set(a, b, dark)
+			qr.set(b, a, dark)
+		}
+	}
+}
+
+// drawFormatBits draws both copies of the format information for level M
+// and the mask.
+func (qr *qrCode) drawFormatBits(mask int) {
+	data := 0<<3 | mask // level M is 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		qr.set(8, i, bit(i))
+	}
+	qr.set(8, 7, bit(6))
+	qr.set(8, 8, bit(7))
+	qr.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.set(14-i, 8, bit(i))
+	}
+
+	size := qr.size
+	for i := 0; i < 8; i++ {
+		qr.set(size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.set(8, size-15+i, bit(i))
+	}
+	qr.set(8, size-8, true) // the dark module
+}
+
+// drawCodewords places the bits in the zigzag order of the standard,
+// leaving the remainder bits light.
+func (qr *qrCode) drawCodewords(codewords []byte) {
+	i := 0
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < qr.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if upward {
+					y = qr.size - 1 - vert
+				}
+				if qr.function[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				qr.modules[y][x] = (codewords[i>>3]>>(7-i&7))&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+func (qr *qrCode) applyMask(mask int) {
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if qr.function[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert {
+				qr.modules[y][x] = !qr.modules[y][x]
+			}
+		}
+	}
+}
+
+// qrFinderLike are the 1:1:3:1:1 patterns with four light modules on one
+// side that the penalty rule 3 punishes.
+var qrFinderLike = [][]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+// penalty scores the symbol by the four rules of the standard, lower is
+// easier to scan.
+func (qr *qrCode) penalty() int {
+	size := qr.size
+	at := func(x, y int, transposed bool) bool {
+		if transposed {
+			return qr.modules[x][y]
+		}
+		return qr.modules[y][x]
+	}
+
+	penalty := 0
+	for _, transposed := range []bool{false, true} {
+		for y := 0; y < size; y++ {
+			// rule 1: runs of five or more modules of the same color
+			run := 1
+			for x := 1; x < size; x++ {
+				if at(x, y, transposed) == at(x-1, y, transposed) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+			if run >= 5 {
+				penalty += run - 2
+			}
+
+			// rule 3: finder-like patterns
+			for x := 0; x+11 <= size; x++ {
+				for _, pattern := range qrFinderLike {
+					match := true
+					for k, dark := range pattern {
+						if at(x+k, y, transposed) != dark {
+							match = false
+							break
+						}
+					}
+					if match {
+						penalty += 40
+					}
+				}
+			}
+		}
+	}
+
+	// rule 2: 2x2 blocks of the same color
+	dark := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if qr.modules[y][x] {
+				dark++
+			}
+			if x+1 < size && y+1 < size {
+				c := qr.modules[y][x]
+				if qr.modules[y][x+1] == c && qr.modules[y+1][x] == c && qr.modules[y+1][x+1] == c {
+					penalty += 3
+				}
+			}
+		}
+	}
+
+	// rule 4: balance of dark and light modules
+	percent := dark * 100 / (size * size)
+	penalty += qrAbs(percent-50) / 5 * 10
+
+	return penalty
+}
+
+// qrQuietZone is the light border around the symbol, in modules.
+const qrQuietZone = 2
+
+// render writes the symbol with half blocks, two module rows per line. The
+// light modules are drawn, so it shows correctly on terminals with a dark
+// background; scanners read the inverted symbol on light ones too.
+func (qr *qrCode) render(w io.Writer) {
+	light := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= qr.size || y >= qr.size {
+			return true
+		}
+		return !qr.modules[y][x]
+	}
+
+	var b strings.Builder
+	for y := -qrQuietZone; y < qr.size+qrQuietZone; y += 2 {
+		for x := -qrQuietZone; x < qr.size+qrQuietZone; x++ {
+			top := light(x, y)
+			bottom := y+1 >= qr.size+qrQuietZone || light(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	io.WriteString(w, b.String())
+}
+
+// printQR renders the address as a QR code to be scanned with a phone. It
+// goes to stdout after the text output, or to stderr if stdout carries JSON
+// or template output for scripts.
+func printQR(address string) {
+	qr, err := encodeQR(address)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not render QR code: %v\n", err)
+		return
+	}
+
+	w := os.Stdout
+	if jsonOutput() || outputTemplate != nil {
+		w = os.Stderr
+	}
+	qr.render(w)
+}
+
+func qrAbs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func qrMax(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// The reference symbols were made with the independent encoder of
+// rsc.io/qr/coding for the same text, version, level M and mask.
+func TestQRCodeModules(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		version int
+		mask    int
+		want    string
+	}{
+		{
+			// alignment patterns and version information
+			name:    "version 7",
+			text:    strings.Repeat("masked.", 16),
+			version: 7,
+			mask:    3,
+			want: `
+			#######.#.###...#..#.....#..###.#...#.#######
+			#.....#.#.#...###..#........####.#.#..#.....#
+			#.###.#....##..#...#..###.#....##..#..#.###.#
+			#.###.#.###...#.#.#.###.#.#..#.#...##.#.###.#
+			#.###.#..##.#############.##...######.#.###.#
+			#.....#....###..#####...##.#..#.#.....#.....#
+			#######.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#######
+			........#..#.#.####.#...###.#......#.........
+			#.##.###...#..#..##.######.##....##.#.#..#.##
+			..###...##.....#..####.##.....##...###......#
+			.#######..#....##.####.#...#.########..#..###
+			##..#...###....###.#.###.#......#####.#..#...
+			.#.#..##..#.#.##.###..#.##.#.###.....#.#...##
+			...##..##.##..#.###.#######....###...##...##.
+			.###.###...##.###.###..#..######...######.#..
+			###.#..#.#.##....#.#..####.###..#.#...######.
+			#..########..##....##...####....##.###.#.##.#
+			..####.###.#...#..#.....#..#.#.#.###...#...##
+			...#..#.......####.##.###.##...#.####.##.#...
+			.##..#.#..#...#..#####...#..###...#.##..#...#
+			###.#####..#.#..##..###########..#.######.#..
+			#..##...#.#####.#...#...#######.#..##...#.#.#
+			#.###.#.####.#.#.#..#.#.#.######..#.#.#.#..##
+			...##...######...#.##...#.#..####.#.#...##..#
+			....######.#..#.#.#.#####.#....#..########...
+			.#...#.##.#.#...#..##.#..####..#.#.#...#.....
+			...##.#.##.#.#.....#.#.####...###........#...
+			##.###.##..####.##..#####..##.#.#..##.#.###..
+			#..#..#...######..#.##...#.#..###..#.##.###.#
+			###.......#.###..#.##......###....#.##.######
+			#..#####......#.####.#..#.#....####.#...####.
+			#..#.#.###.##.#.#.##.##.######......#...#..##
+			.#.##.#.#.#..........##.##.###...##.##....#.#
+			##.#.........#..#..#####.....###...###......#
+			....#.##..#..##...#....##.....######.###..###
+			.####..##....#.##....###.#...#.######.####...
+			#..##.#.#.#.#.##.#########.#.##.....#####..#.
+			........#...#...#.#.#...#.#.....##.##...##.#.
+			#######.#.###....##.#.#.#..##.#....##.#.###..
+			#.....#.#.#.#.###..##...######..#####...####.
+			#.###.#..##.#...#...########...####.#######.#
+			#.###.#.###..#####....#.#..#.#..###.#..#.#..#
+			#.###.#.#..###...####..#..##.....#####....##.
+			#.....#..####..#.#...#.##...###..##..###.#..#
+			#######.#.##.#....#..###..#####....####.#.#..
+			`,
+		},
+		{
+			// two groups of blocks with different lengths
+			name:    "version 8",
+			text:    strings.Repeat("fastmail", 17),
+			version: 8,
+			mask:    5,
+			want: `
+			#######..####.##.#.##.#####.#####.#.##..#.#######
+			#.....#.##..#..#..##..##.#....#####.#####.#.....#
+			#.###.#.###.....######.###.##.#.#.##...##.#.###.#
+			#.###.#.#....#...#.####.#.#######.####.#..#.###.#
+			#.###.#......#.#..#..######..##.#..#.#....#.###.#
+			#.....#...#.##.#..##.##...#.#..#..#.###...#.....#
+			#######.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#######
+			........##.###.##.#..##...#.#####....##..........
+			#.....#.#..##..####.#######...##...##..####..###.
+			.###......##..###.#########..###.##########.##...
+			..#...##.#.#.##...#.##..#.#......###..##...##..##
+			###..#.##....###..##.#.#...##.#.##.....#..#.##.##
+			...##.#.##..#.#.#..###..#.#.#.#.###.#.##..#.##.#.
+			#..#.#..####..###..#####.#.#####.#.###.#.##.##...
+			#.....#.###.#....#..###.####.#.####...#....#.#.##
+			.#.#.#.#.##..#.##..#.#...#..##....#..#.#.###.##..
+			#...#.#.#..##.#.###.##.##...#..#..####.##.##.####
+			.##.#...#....####.##.#.###..##.#.#...#.#.####..#.
+			##..#.#######..##.####...#...###....##..##.##.#.#
+			#.#..#..###..#..##.###...##.#...##.#....#.#.##.#.
+			##..#.##.##.#...#.#######.#..###.###########..#.#
+			...#.#.###....###..##..###.####.##############...
+			...#########.#...#.#.#######...####.##########.##
+			.#..#...#.#....#..#..##...#.##.##.#..#.##...##..#
+			###.#.#.#.#..####..#.##.#.#.#.#.#.#.##..#.#.##..#
+			#...#...##.....#..##.##...#..###.#..##..#...#....
+			##.#######.#..###.....#####.#....####.#.#####..##
+			.##......#.#..#....####...##..#..#.....#.#.#.####
+			..#.#.##.###...###.#.##.###..#.#...###.####.###.#
+			.#...#..#..##.#.##....##.#.#.##..#.###.#...#.##..
+			...##.#..#.##.....#.#..#..###...##.###.##.#.#.#.#
+			....##.####.#.##.##....#..##..#.##.....##...##..#
+			.#..###.##.##.##..#.##.#...#.###.#.###.#....###..
+			...#.#.#.....#..##.##..###.##.#####.###..####.##.
+			#..####..##..#.#.###.#.###.#.##..###..##.##...###
+			.##..#..##..##.#....#.#.###.##..#....#.##..###.##
+			.#....###.#..#..##.#.###..#.###.#.#.####.......##
+			###....#....##.##.##..###.##.###.#...#...###.#.#.
+			.#...###.##.........#.#.##..#...#.###.##.###.####
+			.###.....#.#.......###.##.....#..#.#..##....####.
+			###...###.#.##....###.#####..###.#.###.########.#
+			........#.#.##.....#..#...##.##.##.#.#..#...#.#..
+			#######...###.#####..##.#.##.#..##.##..##.#.#.#.#
+			#.....#..#..#.#.###...#...#.#####....####...##.##
+			#.###.#......##..##...#####...##...##...########.
+			#.###.#..##......#.#......#..###.#######..#...#.#
+			#.###.#..##.###.##..##.###.......###..#..#.#.....
+			#.....#...#.####.#.###.##....#..###...##.##..#..#
+			#######.##.#.#...###..###.#..#..#.#.#.###...##..#
+			`,
+		},
+		{
+			// two groups of blocks and the 16 bit character count
+			name:    "version 10",
+			text:    strings.Repeat("0123456789", 20),
+			version: 10,
+			mask:    6,
+			want: `
+			#######.#.#..#...##.#..####..###############.###..#######
+			#.....#.##.##..#..#...###..#.#.####.#....#..##.#..#.....#
+			#.###.#.###.#..##.#..#####....#..###.##.....####..#.###.#
+			#.###.#...##..#..#....##..#.##..##..###.###.#..#..#.###.#
+			#.###.#.##.###.#.####.###.########....#.####...#..#.###.#
+			#.....#..##.#.#.#.##..##..#...#..#..#####....##...#.....#
+			#######.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#######
+			.........#..##.#....##..#.#...#..#.#.####.#...#..........
+			#..######....#...##..###..######.#..#####..#.###.#..#.###
+			###....#.#.#..#..#...##.#.#.#.#.#.#.#.#.#.#.#.#.#.#..###.
+			..#.#.#.#.#...#.#.##..#.##........#.##.....##.##.#.#..#.#
+			#...#..###.....##..#...#...##.#####..#.#..###.#.##.#.##.#
+			###..###.#.###.#...#...##..###.##.###.###.###.#...###..##
+			#..###.#.###.##.####...#...#..#.#..#####..#.#...####.....
+			##..#.#.####...#.#.#..#..#####.....##.####.#...#..##..##.
+			#..#....#.#.###..###..##.#.#...#...#...#.###.##.####..#.#
+			#####.#..#...#.#.###......##..#.##.##.##.##.##....##.....
+			.##......#.#.#......##.###...##.#.##....###...###....####
+			.#.#..##......#.##.##.....###..##..##..#...#...##...#.###
+			#.####..#.#...#..##..#########.....#.######..#..#.###.###
+			#.#...##..#.##.##......#..###..#..#.#..###.#...#..####.#.
+			...#.#..###...####..#.#.#..###.##.###.###.###.#.#.##..##.
+			.##.###....##.##..#....##.#####...##.#.##..##.#..#.#.##.#
+			#.##.#.#..#....##.#.#...#..#.##..##....#.####.#.#..#.####
+			.###..#.....#...#####.#....##.#..##############..####...#
+			#...##.#......#..##.....###.#.##.....##.#.##....###...#..
+			.##.#####...#.....##.#.########.#..##.#.##.#....######.#.
+			..#.#...#..####.#.#.....###...##.###..##..##....#...#.#.#
+			###.#.#.#...#..#.##..##...#.#.#.#.####.#....#..##.#.#..##
+			#...#...#####.#.###..#.##.#...###.#....#.####.#.#...#.###
+			##.#######....###.#..####.#####.#..##..##..##...######.##
+			.##..#.######..###.......#.#.#...#.#..#####....##..#..#.#
+			.#######..##.#...##..######..###.#..#####..#.#...#...#.#.
+			...#.#..##.##.#.###.##..#.#.#.#.#.#.#.#.#.#.#.#..#..#...#
+			.######..#.#....##..###.###.#####.#.##.#....#.##..#...##.
+			#.##...#....####.##..####.#.#..##.....##..#####.###.###..
+			..#.######...#.#.##...#..####.###.###.###.###.###.#....##
+			..###..#.#####..##.####.##.###..#..######.#....###....#..
+			.##.###.#..#..#...#.###..#.#..##....#.#.##.......#.##..#.
+			..##.#...##.##.....###.##..#.#.#..##..##...#...###....###
+			.#.##.#...#....###.##.#..#..###.#####..#.##.##.#.##.#....
+			######.....#.##.##.....##...###...###...###...####..#####
+			..##..#.#..###..#..##.#...#.####...#...#....#....##.#.###
+			####....##.#...#..#..#..#....#.###.#.####.#....##..#..##.
+			#.##..#.##.###...###.###.#....##..#.#..#####..#.###..#...
+			...#.#....###.##.#.###..#...#####.###.###.###.##..#.####.
+			#.#..####..##..#...#########.##...#.##......#.##..#####.#
+			#####...#....#....#..####.#...###....#.#..###.#.###.#####
+			......###.#..#...#..##...############################..##
+			........#####.#.....##..#.#...##.....##.#.##...##...###..
+			#######.####.....###.#.#.##.#.#.....#.####.#...##.#.##.#.
+			#.....#.#.##.###.#..#####.#...##.###.###.###..###...#.#.#
+			#.###.#.#.###.####.....##.#####.#.####.#....#.#######...#
+			#.###.#.##..####.#####...###.####.#....#####..#....#.##..
+			#.###.#..#######.#####.#..####.##..##..#...#...##.#######
+			#.....#..####.##.#...###..##..#....#.######..#..#####.###
+			#######.##.#.#..#.####.#.#..##.#.##.##.##..#.#.##.####...
+			`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// encodeQR must pick the version, the mask is forced below to
+			// compare against the reference
+			qr, err := encodeQR(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := 17 + 4*tt.version; qr.size != want {
+				t.Fatalf("encodeQR() size = %d, want %d", qr.size, want)
+			}
+
+			spec := qrVersionsM[tt.version-1]
+			countBits := 8
+			if tt.version >= 10 {
+				countBits = 16
+			}
+			codewords := qrDataCodewords([]byte(tt.text), countBits, spec.dataLen())
+			qr = newQRCode(tt.version, spec, qrAddErrorCorrection(codewords, spec))
+			qr.applyMask(tt.mask)
+			qr.drawFormatBits(tt.mask)
+
+			want := strings.Fields(tt.want)
+			if len(want) != qr.size {
+				t.Fatalf("reference has %d rows, want %d", len(want), qr.size)
+			}
+			for y, row := range want {
+				var got strings.Builder
+				for x := 0; x < qr.size; x++ {
+					if qr.modules[y][x] {
+						got.WriteByte('#')
+					} else {
+						got.WriteByte('.')
+					}
+				}
+				if got.String() != row {
+					t.Errorf("row %d = %s, want %s", y, got.String(), row)
+				}
+			}
+		})
+	}
+}
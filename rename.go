@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// runRename changes the description of a masked email, the shorthand of
+// update -email <maskedemail> -desc <description>. The words after the
+// address make up the description, so it doesn't need quotes, and an empty
+// one clears it.
+func runRename(client *pkg.Client, args []string) {
+	usage := fmt.Sprintf("%s <maskedemail> <new description>", actionTypeRename)
+	if len(args) < 2 {
+		log.Fatalln("Usage: " + usage)
+	}
+	warnFlagLikeArgs(actionTypeRename, args[:1])
+	maskedemail := maskedEmailArg(args[0], usage)
+	description := strings.TrimSpace(strings.Join(args[1:], " "))
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	if _, err := client.UpdateInfo(session, *flagAccountID, maskedemail, pkg.NewUpdateFields(false, "", true, description)); err != nil {
+		log.Fatalf("error renaming masked email: %v", err)
+	}
+
+	appendJournal(journalEntry{
+		Action:      actionTypeUpdate,
+		AccountID:   accountIDOrDefault(session),
+		Email:       maskedemail,
+		Description: description,
+	})
+
+	if jsonOutput() {
+		printResults(os.Stdout, []itemResult{resultWithMaskedEmail(client, session, maskedemail, actionTypeUpdate)}, true)
+		return
+	}
+
+	fmt.Printf("renamed %s\n", maskedemail)
+}
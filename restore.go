@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// flags for restore command
+var restoreCmd = flag.NewFlagSet(actionTypeRestore, flag.ExitOnError)
+var flagRestoreFrom = restoreCmd.String(flagNameFrom, "", "backup written by export to restore from, - for stdin (required)")
+var flagRestoreEmail = newStringsFlag(restoreCmd, flagNameEmail, "masked email to restore, comma separated or repeated")
+var flagRestoreDomain = restoreCmd.String(flagNameDomain, "", "restore the masked emails for this domain or its subdomains, or matching a pattern like '*.example.*'")
+var flagRestoreDryRun = restoreCmd.Bool(flagNameDryRun, false, "only show what would be restored")
+
+// runRestore brings selected masked emails of a backup back to the state
+// they had in it. Masked emails still in the account, deleted or not, are
+// enabled or disabled again and keep their address; those destroyed since
+// are recreated with a new one, like import does.
+func runRestore(client *pkg.Client, args []string) {
+	parseCommandFlags(restoreCmd, args)
+	if restoreCmd.NArg() > 0 || *flagRestoreFrom == "" || (len(*flagRestoreEmail) == 0 && *flagRestoreDomain == "") {
+		log.Fatalf("Usage: %s -%s <backup.json|%s> -%s <maskedemail>...|-%s <domain> [-%s]", actionTypeRestore, flagNameFrom, stdinArg, flagNameEmail, flagNameDomain, flagNameDryRun)
+	}
+
+	wanted := map[string]bool{}
+	for _, arg := range strings.Split(flagRestoreEmail.String(), ",") {
+		if strings.TrimSpace(arg) == "" {
+			continue
+		}
+		address, err := pkg.ParseMaskedAddress(arg)
+		if err != nil {
+			log.Fatalf("invalid -%s: %v", flagNameEmail, err)
+		}
+		wanted[address.Address] = true
+	}
+	var matchDomain func(domain string) bool
+	if *flagRestoreDomain != "" {
+		match, err := domainMatcher(*flagRestoreDomain)
+		if err != nil {
+			log.Fatalf("invalid -%s: %v", flagNameDomain, err)
+		}
+		matchDomain = match
+	}
+
+	in := os.Stdin
+	if *flagRestoreFrom != stdinArg {
+		f, err := os.Open(*flagRestoreFrom)
+		if err != nil {
+			log.Fatalf("error opening backup: %v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	backup, err := readImport(in)
+	if err != nil {
+		log.Fatalf("error reading %s: %v", *flagRestoreFrom, err)
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+	accID := accountIDOrDefault(session)
+
+	existing, err := client.GetAllMaskedEmails(session, *flagAccountID)
+	if err != nil {
+		log.Fatalf("error fetching masked emails: %v", err)
+	}
+	byAddress := map[string]*pkg.MaskedEmail{}
+	for _, email := range existing {
+		// looked up lowercased, the server's casing may differ from the file's
+		byAddress[strings.ToLower(email.Email)] = email
+	}
+
+	results := []itemResult{}
+	states := map[string]pkg.MaskedEmailState{} // by masked email ID
+	found := map[string]bool{}
+	for _, email := range backup {
+		address := strings.ToLower(strings.TrimSpace(email.Email))
+		if !wanted[address] && (matchDomain == nil || !matchDomain(email.Domain)) {
+			continue
+		}
+		found[address] = true
+		if email.State == pkg.MaskedEmailStateDeleted {
+			if wanted[address] {
+				results = append(results, newItemResult(address, actionTypeEnable, fmt.Errorf("it was deleted already in the backup")))
+			}
+			continue
+		}
+
+		// pending ones turn enabled with the first email, restore that
+		state := email.State
+		if state == pkg.MaskedEmailStatePending {
+			state = pkg.MaskedEmailStateEnabled
+		}
+		action := actionTypeEnable
+		if state == pkg.MaskedEmailStateDisabled {
+			action = actionTypeDisable
+		}
+		domain := strings.TrimSpace(email.Domain)
+		description := strings.TrimSpace(email.Description)
+
+		current, ok := byAddress[address]
+		switch {
+		case ok && current.State == state:
+			r := newItemResult(address, importActionSkip, nil)
+			r.Domain, r.Description = domain, description
+			results = append(results, r)
+		case ok && *flagRestoreDryRun:
+			fmt.Fprintf(os.Stderr, "dry run: would %s %s, it's %s\n", action, address, current.State)
+		case ok:
+			r := newItemResult(address, action, nil)
+			r.Domain, r.Description = domain, description
+			r.MaskedEmail = current
+			results = append(results, r)
+			states[current.ID] = state
+		case *flagRestoreDryRun:
+			fmt.Fprintf(os.Stderr, "dry run: would recreate %s as a new %s masked email for %s (%s)\n", address, state, domain, description)
+		default:
+			created, err := recreateMaskedEmail(client, session, accID, email)
+			if err != nil {
+				r := newItemResult(address, actionTypeCreate, err)
+				r.Domain, r.Description = domain, description
+				results = append(results, r)
+				continue
+			}
+			r := newItemResult(created.Email, actionTypeCreate, nil)
+			r.Domain, r.Description = domain, description
+			r.MaskedEmail = created
+			results = append(results, r)
+			if state == pkg.MaskedEmailStateDisabled {
+				states[created.ID] = state
+			}
+			fmt.Fprintf(os.Stderr, "%s doesn't exist anymore, recreated it as %s\n", address, created.Email)
+		}
+	}
+	for address := range wanted {
+		if !found[address] {
+			results = append(results, newItemResult(address, actionTypeEnable, fmt.Errorf("not in the backup")))
+		}
+	}
+	if len(found) == 0 {
+		log.Fatalf("no masked emails for %s in the backup", *flagRestoreDomain)
+	}
+
+	applyStates(client, session, accID, results, states)
+
+	if !jsonOutput() {
+		for i := range results {
+			results[i].MaskedEmail = nil
+		}
+	}
+	exitOnFailedResults(results, jsonOutput())
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// flags for search command
+var searchCmd = flag.NewFlagSet(actionTypeSearch, flag.ExitOnError)
+var flagSearchDomain = searchCmd.String(flagNameDomain, "", "only masked emails whose domain contains this text")
+var flagSearchDesc = searchCmd.String(flagNameDesc, "", "only masked emails whose description contains this text")
+var flagSearchRegex = searchCmd.String(flagNameRegex, "", "only masked emails whose domain or description matches this regular expression")
+var flagSearchState = searchCmd.String(flagNameState, "", "only masked emails in these states, comma separated (default: all but deleted)")
+var flagSearchAllFields = searchCmd.Bool(flagNameShowAllFields, false, "show all masked email fields (true|false) (default false)")
+
+// searchStates are the values accepted by search -state.
+var searchStates = []pkg.MaskedEmailState{
+	pkg.MaskedEmailStatePending,
+	pkg.MaskedEmailStateEnabled,
+	pkg.MaskedEmailStateDisabled,
+	pkg.MaskedEmailStateDeleted,
+}
+
+// searchQuery holds the criteria of a search. All of them have to match,
+// text is matched case-insensitively.
+type searchQuery struct {
+	domain      string
+	description string
+	regex       *regexp.Regexp
+	states      map[pkg.MaskedEmailState]bool
+	terms       []string
+}
+
+// parseStates parses a comma separated list of states. An empty list means
+// all states but deleted.
+func parseStates(list string) (map[pkg.MaskedEmailState]bool, error) {
+	states := map[pkg.MaskedEmailState]bool{}
+	if strings.TrimSpace(list) == "" {
+		for _, state := range searchStates {
+			states[state] = state != pkg.MaskedEmailStateDeleted
+		}
+		return states, nil
+	}
+
+	for _, s := range strings.Split(list, ",") {
+		state := pkg.MaskedEmailState(strings.ToLower(strings.TrimSpace(s)))
+		valid := false
+		for _, known := range searchStates {
+			if state == known {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown state %q (pending|enabled|disabled|deleted)", s)
+		}
+		states[state] = true
+	}
+	return states, nil
+}
+
+// matches reports whether the masked email meets all criteria of the query.
+// Free-text terms are looked up in the address, domain, description and url.
+func (q searchQuery) matches(email *pkg.MaskedEmail) bool {
+	if !q.states[email.State] {
+		return false
+	}
+	if q.domain != "" && !containsFold(email.Domain, q.domain) {
+		return false
+	}
+	if q.description != "" && !containsFold(email.Description, q.description) {
+		return false
+	}
+	if q.regex != nil && !regexMatches(email, q.regex) {
+		return false
+	}
+
+	url := ""
+	if email.URL != nil {
+		url = *email.URL
+	}
+	for _, term := range q.terms {
+		if !containsFold(email.Email, term) &&
+			!containsFold(email.Domain, term) &&
+			!containsFold(email.Description, term) &&
+			!containsFold(url, term) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsFold(s string, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// runSearch prints the masked emails matching the search flags and terms.
+// JMAP has no query method for masked emails, so all of them are fetched and
+// filtered locally.
+func runSearch(client *pkg.Client, args []string) {
+	parseCommandFlags(searchCmd, args)
+
+	states, err := parseStates(*flagSearchState)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	query := searchQuery{
+		domain:      strings.TrimSpace(*flagSearchDomain),
+		description: strings.TrimSpace(*flagSearchDesc),
+		states:      states,
+	}
+	if *flagSearchRegex != "" {
+		if query.regex, err = compileRegex(*flagSearchRegex); err != nil {
+			log.Fatalf("invalid -%s: %v", flagNameRegex, err)
+		}
+	}
+	for _, term := range searchCmd.Args() {
+		if term = strings.TrimSpace(term); term != "" {
+			query.terms = append(query.terms, term)
+		}
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	maskedEmails, err := client.GetAllMaskedEmails(session, *flagAccountID)
+	if err != nil {
+		log.Fatalf("error fetching masked emails: %v", err)
+	}
+
+	found := []*pkg.MaskedEmail{}
+	for _, email := range maskedEmails {
+		if query.matches(email) {
+			found = append(found, email)
+		}
+	}
+
+	if outputTemplate != nil {
+		printTemplate(found...)
+		return
+	}
+	if jsonOutput() {
+		printJSON(found)
+		return
+	}
+
+	if len(found) == 0 {
+		fmt.Fprintln(os.Stderr, "no matching masked emails")
+		return
+	}
+	writeTable(os.Stdout, found, *flagSearchAllFields, true, time.Now())
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	secretProviderExec              string = "exec"
+	secretProviderVault             string = "vault"
+	secretProviderAWSSecretsManager string = "aws-secretsmanager"
+	secretProviderKeyring           string = "keyring"
+	defaultVaultSecretField         string = "token"
+	maxSecretSize                   int64  = 1 << 20
+)
+
+// secretSource tells where to fetch a secret from instead of storing it in
+// the config file, e.g. {"provider": "vault", "path": "secret/data/fastmail"}.
+// Which fields are used depends on the provider.
+type secretSource struct {
+	Provider string `json:"provider"`
+
+	// Account is the entry in the system keyring of the keyring and oauth
+	// providers, "default" if unset.
+	Account string `json:"account,omitempty"`
+
+	// Command is run by the exec provider, its stdout is the secret.
+	Command string `json:"command,omitempty"`
+
+	// Address is the Vault server, default $VAULT_ADDR. Path is the secret
+	// to read, e.g. "secret/data/fastmail" for a KV v2 engine.
+	Address string `json:"address,omitempty"`
+	Path    string `json:"path,omitempty"`
+
+	// SecretID names the AWS Secrets Manager secret, Region defaults to
+	// $AWS_REGION. Endpoint overrides the regional endpoint.
+	SecretID string `json:"secretId,omitempty"`
+	Region   string `json:"region,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Field is the key of the secret in a JSON object secret. Vault secrets
+	// always are objects, the field defaults to "token" there.
+	Field string `json:"field,omitempty"`
+}
+
+// secretProvider fetches a secret from an external store.
+type secretProvider interface {
+	fetchSecret(ctx context.Context) (string, error)
+}
+
+// secretProviders creates the provider of each name from its source, which
+// it validates.
+var secretProviders = map[string]func(secretSource) (secretProvider, error){
+	secretProviderExec:              newExecProvider,
+	secretProviderVault:             newVaultProvider,
+	secretProviderAWSSecretsManager: newAWSSecretsManagerProvider,
+	secretProviderKeyring:           newKeyringProvider,
+	secretProviderOAuth:             newOAuthProvider,
+}
+
+// fetchSecret returns the secret of the source, giving up after timeout.
+func fetchSecret(src secretSource, timeout time.Duration) (string, error) {
+	newProvider, ok := secretProviders[src.Provider]
+	if !ok {
+		names := make([]string, 0, len(secretProviders))
+		for name := range secretProviders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("unknown secret provider %q (%s)", src.Provider, strings.Join(names, ", "))
+	}
+
+	provider, err := newProvider(src)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", src.Provider, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	secret, err := provider.fetchSecret(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", src.Provider, err)
+	}
+	secret = strings.TrimSpace(secret)
+	if secret == "" {
+		return "", fmt.Errorf("%s: the secret is empty", src.Provider)
+	}
+	return secret, nil
+}
+
+// secretField returns the field of a JSON object secret, or the whole secret
+// if no field is asked for.
+func secretField(secret string, field string) (string, error) {
+	if field == "" {
+		return secret, nil
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &object); err != nil {
+		return "", fmt.Errorf("the secret isn't a JSON object to take %q from", field)
+	}
+	return objectField(object, field)
+}
+
+func objectField(object map[string]interface{}, field string) (string, error) {
+	value, ok := object[field]
+	if !ok {
+		return "", fmt.Errorf("the secret has no field %q", field)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("field %q of the secret isn't a string", field)
+	}
+	return s, nil
+}
+
+// execProvider runs a command, e.g. a password manager's CLI.
+type execProvider struct {
+	args []string
+}
+
+func newExecProvider(src secretSource) (secretProvider, error) {
+	args, err := splitArgs(src.Command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, errors.New("command is required")
+	}
+	return &execProvider{args: args}, nil
+}
+
+func (p *execProvider) fetchSecret(ctx context.Context) (string, error) {
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.args[0], p.args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %v", p.args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+// vaultProvider reads a secret from HashiCorp Vault, authenticated with
+// $VAULT_TOKEN or the token the vault CLI stored in ~/.vault-token.
+type vaultProvider struct {
+	address string
+	path    string
+	field   string
+}
+
+func newVaultProvider(src secretSource) (secretProvider, error) {
+	p := &vaultProvider{address: src.Address, path: strings.Trim(src.Path, "/"), field: src.Field}
+	if p.address == "" {
+		p.address = os.Getenv("VAULT_ADDR")
+	}
+	if p.address == "" {
+		return nil, errors.New("address or $VAULT_ADDR is required")
+	}
+	if p.path == "" {
+		return nil, errors.New("path is required")
+	}
+	if p.field == "" {
+		p.field = defaultVaultSecretField
+	}
+	return p, nil
+}
+
+func (p *vaultProvider) fetchSecret(ctx context.Context) (string, error) {
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			data, _ := os.ReadFile(filepath.Join(home, ".vault-token"))
+			token = strings.TrimSpace(string(data))
+		}
+	}
+	if token == "" {
+		return "", errors.New("no vault token, set $VAULT_TOKEN or log in with the vault CLI")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.address, "/")+"/v1/"+p.path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	body, err := doSecretRequest(req)
+	if err != nil {
+		return "", err
+	}
+
+	var res struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return "", fmt.Errorf("decoding response: %v", err)
+	}
+
+	// KV version 2 nests the secret in data.data
+	data := res.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, isV2 := data["metadata"]; isV2 {
+			data = nested
+		}
+	}
+	return objectField(data, p.field)
+}
+
+// awsSecretsManagerProvider reads a secret from AWS Secrets Manager, with
+// the credentials in $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and
+// $AWS_SESSION_TOKEN.
+type awsSecretsManagerProvider struct {
+	secretID string
+	region   string
+	endpoint string
+	field    string
+}
+
+func newAWSSecretsManagerProvider(src secretSource) (secretProvider, error) {
+	p := &awsSecretsManagerProvider{secretID: src.SecretID, region: src.Region, endpoint: src.Endpoint, field: src.Field}
+	if p.secretID == "" {
+		return nil, errors.New("secretId is required")
+	}
+	if p.region == "" {
+		p.region = os.Getenv("AWS_REGION")
+	}
+	if p.region == "" {
+		p.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if p.region == "" {
+		return nil, errors.New("region or $AWS_REGION is required")
+	}
+	if p.endpoint == "" {
+		p.endpoint = "https://secretsmanager." + p.region + ".amazonaws.com"
+	}
+	return p, nil
+}
+
+func (p *awsSecretsManagerProvider) fetchSecret(ctx context.Context) (string, error) {
+	creds := awsCredentials{
+		accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.accessKeyID == "" || creds.secretAccessKey == "" {
+		return "", errors.New("no credentials, set $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY (or use the exec provider with the aws CLI)")
+	}
+
+	body, err := json.Marshal(map[string]string{"SecretId": p.secretID})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(p.endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signAWSRequest(req, body, creds, p.region, "secretsmanager", time.Now())
+
+	resBody, err := doSecretRequest(req)
+	if err != nil {
+		return "", err
+	}
+
+	var res struct {
+		SecretString *string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(resBody, &res); err != nil {
+		return "", fmt.Errorf("decoding response: %v", err)
+	}
+	if res.SecretString == nil {
+		return "", errors.New("the secret has no string value")
+	}
+	return secretField(*res.SecretString, p.field)
+}
+
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// signAWSRequest adds the headers of AWS Signature Version 4 to a request,
+// signing all headers set so far. A query must be in canonical order already.
+func signAWSRequest(req *http.Request, body []byte, creds awsCredentials, region string, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := []byte("AWS4" + creds.secretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKeyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// doSecretRequest sends a request to a secret store and returns the body of
+// a successful response.
+func doSecretRequest(req *http.Request) ([]byte, error) {
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxSecretSize))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
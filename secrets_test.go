@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAWSRequest(t *testing.T) {
+	// the credentials and time of the AWS Signature Version 4 test suite
+	creds := awsCredentials{accessKeyID: "AKIDEXAMPLE", secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		headers map[string]string
+		service string
+		now     time.Time
+		want    string
+	}{
+		{
+			name:    "get-vanilla",
+			method:  http.MethodGet,
+			url:     "https://example.amazonaws.com/",
+			service: "service",
+			now:     now,
+			want:    "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			// no path is signed as /, and the time is signed in UTC
+			name:    "post-vanilla",
+			method:  http.MethodPost,
+			url:     "https://example.amazonaws.com",
+			service: "service",
+			now:     now.In(eastern),
+			want:    "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			// the IAM example of the signing documentation
+			name:    "query and header",
+			method:  http.MethodGet,
+			url:     "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+			headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"},
+			service: "iam",
+			now:     now,
+			want:    "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			signAWSRequest(req, nil, creds, "us-east-1", tt.service, tt.now)
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %q, want %q", got, "20150830T123600Z")
+			}
+			if got := req.Header.Get("Authorization"); got != tt.want {
+				t.Errorf("Authorization = %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("session token", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		withToken := creds
+		withToken.sessionToken = "session-token"
+
+		signAWSRequest(req, nil, withToken, "us-east-1", "service", now)
+		if got := req.Header.Get("X-Amz-Security-Token"); got != "session-token" {
+			t.Errorf("X-Amz-Security-Token = %q, want %q", got, "session-token")
+		}
+		if got := req.Header.Get("Authorization"); !strings.Contains(got, "SignedHeaders=host;x-amz-date;x-amz-security-token,") {
+			t.Errorf("Authorization = %q, want the token signed", got)
+		}
+	})
+}
+
+func TestVaultFetchSecret(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		field    string
+		status   int
+		response string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:     "kv v2",
+			path:     "secret/data/maskedemail",
+			response: `{"data":{"data":{"token":"v2-secret"},"metadata":{"version":3}}}`,
+			want:     "v2-secret",
+		},
+		{
+			name:     "kv v1",
+			path:     "secret/maskedemail",
+			response: `{"data":{"token":"v1-secret"}}`,
+			want:     "v1-secret",
+		},
+		{
+			// only KV v2 responses with metadata are unwrapped
+			name:     "kv v1 with a data field",
+			path:     "secret/maskedemail",
+			response: `{"data":{"data":{"token":"nested"},"token":"v1-secret"}}`,
+			want:     "v1-secret",
+		},
+		{
+			name:     "other field",
+			path:     "/secret/data/maskedemail/",
+			field:    "api_key",
+			response: `{"data":{"data":{"api_key":"v2-key"},"metadata":{"version":1}}}`,
+			want:     "v2-key",
+		},
+		{
+			name:     "missing field",
+			path:     "secret/data/maskedemail",
+			response: `{"data":{"data":{"password":"x"},"metadata":{"version":1}}}`,
+			wantErr:  `the secret has no field "token"`,
+		},
+		{
+			name:     "not a string",
+			path:     "secret/maskedemail",
+			response: `{"data":{"token":42}}`,
+			wantErr:  `field "token" of the secret isn't a string`,
+		},
+		{
+			name:     "denied",
+			path:     "secret/data/maskedemail",
+			status:   http.StatusForbidden,
+			response: `{"errors":["permission denied"]}`,
+			wantErr:  `403 Forbidden: {"errors":["permission denied"]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got, want := r.URL.Path, "/v1/"+strings.Trim(tt.path, "/"); got != want {
+					t.Errorf("request path = %q, want %q", got, want)
+				}
+				if got := r.Header.Get("X-Vault-Token"); got != "vault-token" {
+					t.Errorf("X-Vault-Token = %q, want %q", got, "vault-token")
+				}
+				if got := r.Header.Get("X-Vault-Namespace"); got != "team" {
+					t.Errorf("X-Vault-Namespace = %q, want %q", got, "team")
+				}
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				fmt.Fprint(w, tt.response)
+			}))
+			defer server.Close()
+			t.Setenv("VAULT_TOKEN", "vault-token")
+			t.Setenv("VAULT_NAMESPACE", "team")
+
+			provider, err := newVaultProvider(secretSource{Provider: secretProviderVault, Address: server.URL + "/", Path: tt.path, Field: tt.field})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := provider.fetchSecret(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("fetchSecret() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetchSecret() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("fetchSecret() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
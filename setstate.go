@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// stdinArg is the argument that makes enable, disable and delete read the
+// addresses from stdin.
+const stdinArg = "-"
+
+// matchUsage is the usage of -match of enable, disable and delete.
+const matchUsage = "act on the masked email whose domain or description matches this text best, instead of an address"
+
+// regexUsage is the usage of -regex of enable, disable and delete.
+const regexUsage = "act on all masked emails whose domain or description matches this regular expression, e.g. '(?i)newsletter|promo'"
+
+// regexDryRunUsage is the usage of -dry-run of enable, disable and delete.
+const regexDryRunUsage = "with -" + flagNameRegex + ", only show the masked emails that would change"
+
+// flags for delete command
+var deleteCmd = flag.NewFlagSet(actionTypeDelete, flag.ExitOnError)
+var flagDeleteYes = deleteCmd.Bool(flagNameYes, false, "don't ask for confirmation when deleting several masked emails")
+var flagDeleteForce = deleteCmd.Bool(flagNameForce, false, "don't ask for confirmation, not even for a single masked email")
+var flagDeleteForceShort = deleteCmd.Bool(flagNameForceShort, false, "shorthand for -"+flagNameForce)
+var flagDeleteMatch = deleteCmd.String(flagNameMatch, "", matchUsage)
+var flagDeleteRegex = deleteCmd.String(flagNameRegex, "", regexUsage)
+var flagDeleteDryRun = deleteCmd.Bool(flagNameDryRun, false, regexDryRunUsage)
+
+// flags for enable command
+var enableCmd = flag.NewFlagSet(actionTypeEnable, flag.ExitOnError)
+var flagEnableFromJournal = enableCmd.String(flagNameFromJournal, "", "re-enable the masked emails the journal shows were disabled since this time (RFC3339, 2006-01-02 or a duration like 2h)")
+var flagEnableMatch = enableCmd.String(flagNameMatch, "", matchUsage)
+var flagEnableRegex = enableCmd.String(flagNameRegex, "", regexUsage)
+var flagEnableDryRun = enableCmd.Bool(flagNameDryRun, false, regexDryRunUsage)
+var flagEnableYes = enableCmd.Bool(flagNameYes, false, "with -"+flagNameRegex+", don't ask for confirmation")
+
+// flags for disable command
+var disableCmd = flag.NewFlagSet(actionTypeDisable, flag.ExitOnError)
+var flagDisableMatch = disableCmd.String(flagNameMatch, "", matchUsage)
+var flagDisableRegex = disableCmd.String(flagNameRegex, "", regexUsage)
+var flagDisableDryRun = disableCmd.Bool(flagNameDryRun, false, regexDryRunUsage)
+var flagDisableYes = disableCmd.Bool(flagNameYes, false, "with -"+flagNameRegex+", don't ask for confirmation")
+
+// deleteForced reports whether delete was told not to ask for confirmation.
+func deleteForced() bool {
+	return *flagDeleteYes || *flagDeleteForce || *flagDeleteForceShort
+}
+
+// stateCommand describes one of the commands that only change the state of
+// a masked email.
+type stateCommand struct {
+	gerund string
+	state  pkg.MaskedEmailState
+}
+
+var stateCommands = map[string]stateCommand{
+	actionTypeEnable:  {"enabling", pkg.MaskedEmailStateEnabled},
+	actionTypeDisable: {"disabling", pkg.MaskedEmailStateDisabled},
+	actionTypeDelete:  {"deleting", pkg.MaskedEmailStateDeleted},
+}
+
+// runSetState runs enable, disable or delete for the masked emails in args,
+// or for the addresses on stdin if the argument is "-".
+func runSetState(client *pkg.Client, action string, args []string) {
+	var match, regex string
+	var dryRun, yes bool
+	switch action {
+	case actionTypeDelete:
+		parseCommandFlags(deleteCmd, args)
+		args = deleteCmd.Args()
+		match = *flagDeleteMatch
+		regex = *flagDeleteRegex
+		dryRun = *flagDeleteDryRun
+		yes = deleteForced()
+	case actionTypeEnable:
+		parseCommandFlags(enableCmd, args)
+		args = enableCmd.Args()
+		match = *flagEnableMatch
+		regex = *flagEnableRegex
+		dryRun = *flagEnableDryRun
+		yes = *flagEnableYes
+		if *flagEnableFromJournal != "" {
+			if len(args) > 0 || match != "" || regex != "" {
+				log.Fatalf("-%s can't be combined with addresses, -%s or -%s", flagNameFromJournal, flagNameMatch, flagNameRegex)
+			}
+			runEnableFromJournal(client, *flagEnableFromJournal)
+			return
+		}
+	case actionTypeDisable:
+		parseCommandFlags(disableCmd, args)
+		args = disableCmd.Args()
+		match = *flagDisableMatch
+		regex = *flagDisableRegex
+		dryRun = *flagDisableDryRun
+		yes = *flagDisableYes
+	}
+	if match != "" && regex != "" {
+		log.Fatalf("-%s can't be combined with -%s", flagNameMatch, flagNameRegex)
+	}
+	if match != "" && (len(args) > 0 || *flagAccountAll) {
+		log.Fatalf("-%s can't be combined with addresses or -%s", flagNameMatch, flagNameAccountAll)
+	}
+	if regex != "" {
+		if len(args) > 0 || *flagAccountAll {
+			log.Fatalf("-%s can't be combined with addresses or -%s", flagNameRegex, flagNameAccountAll)
+		}
+		runSetStateByRegex(client, action, regex, dryRun, yes)
+		return
+	}
+	if dryRun {
+		log.Fatalf("-%s only applies to -%s", flagNameDryRun, flagNameRegex)
+	}
+
+	var arg string
+	if len(args) > 0 {
+		arg = args[0]
+	}
+	if arg == stdinArg {
+		addresses, err := readAddresses(os.Stdin)
+		if err != nil {
+			log.Fatalf("error reading stdin: %v", err)
+		}
+		if len(addresses) == 0 {
+			log.Fatalln("no masked emails on stdin")
+		}
+		runSetStates(client, nil, action, addresses, true, false)
+		return
+	}
+	if len(args) > 1 || *flagAccountAll {
+		runSetStates(client, nil, action, args, false, false)
+		return
+	}
+	var maskedemail string
+	if match == "" {
+		maskedemail = maskedEmailRefArg(arg, action+" <maskedemail|id>...|"+stdinArg+"|-"+flagNameMatch+" <text>")
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	cmd := stateCommands[action]
+	var email *pkg.MaskedEmail
+	if match != "" {
+		emails, err := client.GetAllMaskedEmails(session, *flagAccountID)
+		if err != nil {
+			log.Fatalf("error fetching masked emails: %v", err)
+		}
+		if email, err = resolveMatch(emails, match); err != nil {
+			log.Fatalln(err)
+		}
+	} else if email, err = client.ResolveMaskedEmail(session, *flagAccountID, maskedemail); err != nil {
+		log.Fatalf("error %s masked email: %v", cmd.gerund, err)
+	}
+	maskedemail = email.Email
+	if email.State == cmd.state {
+		warnStrict("%s is already %s", maskedemail, email.State)
+	}
+	// a typo away from losing an address, so ask on a terminal
+	if action == actionTypeDelete && !deleteForced() && isTerminal(os.Stdin) {
+		if !confirm(fmt.Sprintf("really delete %s?", maskedemail)) {
+			log.Fatalln("aborted")
+		}
+	}
+
+	_, notUpdated, err := client.SetMaskedEmailStates(session, *flagAccountID, []string{email.ID}, cmd.state)
+	if setErr, ok := notUpdated[email.ID]; ok {
+		err = &setErr
+	}
+	if err != nil {
+		log.Fatalf("error %s masked email: %v", cmd.gerund, err)
+	}
+
+	appendJournal(journalEntry{Action: action, AccountID: accountIDOrDefault(session), Email: maskedemail})
+
+	if jsonOutput() {
+		printResults(os.Stdout, []itemResult{resultWithMaskedEmail(client, session, maskedemail, action).withContext(email)}, true)
+		return
+	}
+
+	// success output
+	fmt.Println(newItemResult(maskedemail, action, nil).withContext(email))
+}
+
+// runSetStateByRegex runs enable, disable or delete for all masked emails
+// whose domain or description matches the regular expression, leaving out
+// the deleted ones and those already in the state. Like prune, it shows them
+// first and asks for confirmation unless yes is set; dryRun stops after
+// showing them.
+func runSetStateByRegex(client *pkg.Client, action string, pattern string, dryRun bool, yes bool) {
+	re, err := compileRegex(pattern)
+	if err != nil {
+		log.Fatalf("invalid -%s: %v", flagNameRegex, err)
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	emails, err := client.GetAllMaskedEmails(session, *flagAccountID)
+	if err != nil {
+		log.Fatalf("error fetching masked emails: %v", err)
+	}
+
+	cmd := stateCommands[action]
+	matched := []*pkg.MaskedEmail{}
+	for _, email := range emails {
+		if email.State == cmd.state || email.State == pkg.MaskedEmailStateDeleted || !regexMatches(email, re) {
+			continue
+		}
+		matched = append(matched, email)
+	}
+	if len(matched) == 0 {
+		fmt.Fprintf(os.Stderr, "no masked emails to %s match %q\n", action, pattern)
+		return
+	}
+
+	// the matches go to stderr when stdout carries the results
+	out := os.Stdout
+	if jsonOutput() {
+		out = os.Stderr
+	}
+	fmt.Fprintf(os.Stderr, "%d masked email(s) to %s match %q:\n", len(matched), action, pattern)
+	writeTable(out, matched, false, true, time.Now())
+
+	if dryRun {
+		fmt.Fprintf(os.Stderr, "dry run: would %s %d masked email(s)\n", action, len(matched))
+		return
+	}
+	if !yes {
+		if !isTerminal(os.Stdin) {
+			log.Fatalf("refusing to %s %d masked email(s) without confirmation, pass -%s", action, len(matched), flagNameYes)
+		}
+		// enabling and disabling can be undone, so y/N is enough for them
+		var ok bool
+		if action == actionTypeDelete {
+			ok = confirmMassDestruction(len(matched), action)
+		} else {
+			ok = confirm(fmt.Sprintf("really %s %d masked email(s)?", action, len(matched)))
+		}
+		if !ok {
+			log.Fatalln("aborted")
+		}
+	}
+
+	addresses := make([]string, len(matched))
+	for i, email := range matched {
+		addresses[i] = email.Email
+	}
+	runSetStates(client, session, action, addresses, false, true)
+}
+
+// readAddresses reads one address per line, skipping blank lines and
+// #-comments.
+func readAddresses(r io.Reader) ([]string, error) {
+	var addresses []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addresses = append(addresses, line)
+	}
+
+	return addresses, scanner.Err()
+}
+
+// locatedMaskedEmail is a masked email and the account it was found in.
+type locatedMaskedEmail struct {
+	email *pkg.MaskedEmail
+	accID string
+}
+
+// accountSearch finds masked emails by address in a list of accounts,
+// fetching each account only once and only when the earlier ones don't have
+// the address.
+type accountSearch struct {
+	client    *pkg.Client
+	session   *pkg.SessionResource
+	accIDs    []string
+	fetched   int
+	byAddress map[string]locatedMaskedEmail
+	byID      map[string]locatedMaskedEmail
+	accounts  map[string][]string // all accounts having the address
+}
+
+func newAccountSearch(client *pkg.Client, session *pkg.SessionResource, allAccounts bool) *accountSearch {
+	search := &accountSearch{
+		client:    client,
+		session:   session,
+		accIDs:    []string{accountIDOrDefault(session)},
+		byAddress: map[string]locatedMaskedEmail{},
+		byID:      map[string]locatedMaskedEmail{},
+		accounts:  map[string][]string{},
+	}
+
+	if allAccounts {
+		var others []string
+		for accID := range session.Accounts {
+			if accID != search.accIDs[0] && session.AccountHasCapability(accID, pkg.MaskedEmailCapabilityURI) {
+				others = append(others, accID)
+			}
+		}
+		sort.Strings(others)
+		search.accIDs = append(search.accIDs, others...)
+	}
+
+	return search
+}
+
+// find returns the masked email with the address, or the ID if ref isn't an
+// address, and its account.
+func (s *accountSearch) find(ref string) (locatedMaskedEmail, bool) {
+	index := s.byAddress
+	if pkg.IsMaskedEmailID(ref) {
+		index = s.byID
+	}
+	for {
+		if found, ok := index[ref]; ok {
+			return found, true
+		}
+		if !s.fetchNext() {
+			return locatedMaskedEmail{}, false
+		}
+	}
+}
+
+// ambiguous returns the accounts having the address if it isn't in the
+// default account but in more than one of the others, so find had to pick
+// one. It fetches all remaining accounts to tell.
+func (s *accountSearch) ambiguous(address string) []string {
+	if found, ok := s.byAddress[address]; !ok || found.accID == s.accIDs[0] {
+		return nil
+	}
+	for s.fetchNext() {
+	}
+	if len(s.accounts[address]) < 2 {
+		return nil
+	}
+	return s.accounts[address]
+}
+
+// fetchNext fetches the masked emails of the next account, it returns false
+// if all accounts are fetched already.
+func (s *accountSearch) fetchNext() bool {
+	if s.fetched == len(s.accIDs) {
+		return false
+	}
+
+	accID := s.accIDs[s.fetched]
+	emails, err := s.client.GetAllMaskedEmails(s.session, accID)
+	if err != nil {
+		log.Fatalf("error fetching masked emails of account %s: %v", accID, err)
+	}
+	s.fetched++
+
+	for _, email := range emails {
+		if _, ok := s.byAddress[email.Email]; !ok {
+			s.byAddress[email.Email] = locatedMaskedEmail{email: email, accID: accID}
+		}
+		if _, ok := s.byID[email.ID]; !ok {
+			s.byID[email.ID] = locatedMaskedEmail{email: email, accID: accID}
+		}
+		s.accounts[email.Email] = append(s.accounts[email.Email], accID)
+	}
+	return true
+}
+
+// runSetStates runs enable, disable or delete for many addresses at once.
+// The masked emails are resolved with a single fetch per account and changed
+// with as few requests as the server allows. Every address gets a result,
+// invalid or unknown ones fail without stopping the others. fromStdin tells
+// that stdin was consumed for the addresses and can't be used to confirm,
+// confirmed that the caller already asked for confirmation. session is
+// fetched if nil.
+func runSetStates(client *pkg.Client, session *pkg.SessionResource, action string, addresses []string, fromStdin bool, confirmed bool) {
+	cmd := stateCommands[action]
+
+	if session == nil {
+		var err error
+		session, err = client.Session()
+		if err != nil {
+			log.Fatalf("initializing session: %v", err)
+		}
+	}
+
+	search := newAccountSearch(client, session, *flagAccountAll)
+
+	results := []itemResult{}
+	pending := map[string]int{} // masked email ID to index in results
+	idsByAccount := map[string][]string{}
+	emails := map[string]locatedMaskedEmail{} // by masked email ID
+	count := 0
+	for _, arg := range addresses {
+		ref := strings.TrimSpace(arg)
+		if !pkg.IsMaskedEmailID(ref) {
+			address, err := pkg.ParseMaskedAddress(arg)
+			if err != nil {
+				results = append(results, newItemResult(arg, action, err))
+				continue
+			}
+			ref = address.Address
+		}
+
+		found, ok := search.find(ref)
+		if !ok {
+			err := fmt.Errorf("maskedemail %s not found", ref)
+			if pkg.IsMaskedEmailID(ref) {
+				err = fmt.Errorf("maskedemail with id %s not found, and %q is not an email address", ref, ref)
+			}
+			results = append(results, newItemResult(ref, action, err))
+			continue
+		}
+		id := found.email.ID
+		if _, ok := pending[id]; ok {
+			// listed twice, change it once
+			continue
+		}
+		address := found.email.Email
+		if accIDs := search.ambiguous(address); accIDs != nil {
+			warnStrict("%s exists in accounts %s, using %s", address, strings.Join(accIDs, ", "), found.accID)
+		}
+		if found.email.State == cmd.state {
+			warnStrict("%s is already %s", address, found.email.State)
+		}
+
+		r := newItemResult(address, action, nil).withContext(found.email)
+		if found.accID != search.accIDs[0] {
+			r.AccountID = found.accID
+		}
+		pending[id] = len(results)
+		results = append(results, r)
+		idsByAccount[found.accID] = append(idsByAccount[found.accID], id)
+		emails[id] = found
+		count++
+	}
+
+	// like a single delete, ask on a terminal only, so scripts keep working
+	// when they pass more than one address
+	if action == actionTypeDelete && count > 0 && !confirmed && !deleteForced() {
+		if fromStdin {
+			log.Fatalf("refusing to delete %d masked email(s) without confirmation, pass -%s", count, flagNameYes)
+		}
+		if isTerminal(os.Stdin) && !confirmMassDestruction(count, "delete") {
+			log.Fatalln("aborted")
+		}
+	}
+
+	for _, accID := range search.accIDs {
+		ids := idsByAccount[accID]
+		if len(ids) == 0 {
+			continue
+		}
+
+		updated, notUpdated, err := client.SetMaskedEmailStates(session, accID, ids, cmd.state)
+
+		for _, id := range updated {
+			r := &results[pending[id]]
+			appendJournal(journalEntry{Action: action, AccountID: accID, ID: id, Email: r.Address})
+
+			email := *emails[id].email
+			email.State = cmd.state
+			r.MaskedEmail = &email
+			delete(pending, id)
+		}
+		for id, setErr := range notUpdated {
+			setErr := setErr
+			r := &results[pending[id]]
+			r.OK = false
+			r.Error = setErr.Error()
+			delete(pending, id)
+		}
+		if err != nil {
+			err = fmt.Errorf("error %s masked email, it may or may not have been changed: %w", cmd.gerund, err)
+			for _, id := range ids {
+				if i, ok := pending[id]; ok {
+					results[i].OK = false
+					results[i].Error = err.Error()
+					delete(pending, id)
+				}
+			}
+		}
+	}
+
+	exitOnFailedResults(results, jsonOutput())
+}
+
+// runEnableFromJournal re-enables the masked emails whose last journal entry
+// since the given time is a disable, to roll back a bulk disable that went
+// too far. Masked emails enabled or deleted again since are left alone.
+func runEnableFromJournal(client *pkg.Client, since string) {
+	t, err := parseSince(since)
+	if err != nil {
+		log.Fatalf("-%s: %v", flagNameFromJournal, err)
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	entries, err := readJournal()
+	if err != nil {
+		log.Fatalf("error reading journal: %v", err)
+	}
+
+	accID := accountIDOrDefault(session)
+	last := map[string]string{} // address to the last action on it
+	var addresses []string
+	for _, e := range entries {
+		if e.Time.Before(t) || (!*flagAccountAll && e.AccountID != "" && e.AccountID != accID) {
+			continue
+		}
+		if _, ok := stateCommands[e.Action]; !ok {
+			continue
+		}
+		if _, ok := last[e.Email]; !ok {
+			addresses = append(addresses, e.Email)
+		}
+		last[e.Email] = e.Action
+	}
+
+	var disabled []string
+	for _, address := range addresses {
+		if last[address] == actionTypeDisable {
+			disabled = append(disabled, address)
+		}
+	}
+	if len(disabled) == 0 {
+		fmt.Fprintf(os.Stderr, "the journal has no masked emails disabled since %s\n", formatDisplayTime(&t))
+		return
+	}
+
+	runSetStates(client, session, actionTypeEnable, disabled, false, false)
+}
+
+// resultWithMaskedEmail returns the successful result of the action,
+// including the masked email as it is after the change.
+func resultWithMaskedEmail(client *pkg.Client, session pkg.Session, address string, action string) itemResult {
+	email, err := client.LookupMaskedEmail(session, *flagAccountID, address)
+	if err != nil {
+		log.Fatalf("error fetching masked email: %v", err)
+	}
+
+	r := newItemResult(address, action, nil)
+	r.MaskedEmail = email
+	return r
+}
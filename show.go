@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// flags for show command
+var showCmd = flag.NewFlagSet(actionTypeShow, flag.ExitOnError)
+var flagShowQR = showCmd.Bool(flagNameQR, false, "also print the address as a QR code, to scan it on a phone")
+var flagShowCopy = showCmd.Bool(flagNameCopy, false, "copy the address of the masked email to the clipboard")
+
+// runShow prints all fields of a single masked email.
+func runShow(client *pkg.Client, args []string) {
+	parseCommandFlags(showCmd, args)
+	args = showCmd.Args()
+
+	if len(args) == 0 || strings.TrimSpace(args[0]) == "" {
+		log.Fatalln("Usage: " + actionTypeShow + " [-" + flagNameCopy + "] [-" + flagNameQR + "] <maskedemail|id>")
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	email, err := client.ResolveMaskedEmail(session, *flagAccountID, args[0])
+	if err != nil {
+		log.Fatalf("error looking up masked email: %v", err)
+	}
+
+	if *flagShowCopy {
+		copyToClipboard(email.Email)
+	}
+	if *flagShowQR {
+		defer printQR(email.Email)
+	}
+
+	if outputTemplate != nil {
+		printTemplate(email)
+		return
+	}
+	if jsonOutput() {
+		printJSON(email)
+		return
+	}
+
+	url := ""
+	if email.URL != nil {
+		url = *email.URL
+	}
+
+	w := newTabWriter(os.Stdout, 1)
+	fmt.Fprintf(w, "Masked Email:\t%s\n", email.Email)
+	fmt.Fprintf(w, "ID:\t%s\n", email.ID)
+	fmt.Fprintf(w, "State:\t%s\n", email.State)
+	fmt.Fprintf(w, "For Domain:\t%s\n", orDash(strings.TrimSpace(email.Domain)))
+	fmt.Fprintf(w, "Description:\t%s\n", orDash(strings.TrimSpace(email.Description)))
+	fmt.Fprintf(w, "URL:\t%s\n", orDash(strings.TrimSpace(url)))
+	fmt.Fprintf(w, "Created By:\t%s\n", orDash(email.CreatedBy))
+	fmt.Fprintf(w, "Created At:\t%s\n", orDash(formatDisplayTime(&email.CreatedAt)))
+	fmt.Fprintf(w, "Last Email At:\t%s\n", orDash(formatDisplayTime(email.LastMessageAt)))
+
+	if created, err := findCreate(email); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not read journal: %v\n", err)
+	} else if created != nil {
+		// provenance Fastmail doesn't keep, from the local journal
+		origin := formatDisplayTime(&created.Time)
+		if created.Host != "" {
+			origin += " on " + created.Host
+		}
+		if created.Profile != "" {
+			origin += " with profile " + created.Profile
+		}
+		fmt.Fprintf(w, "Created Locally:\t%s\n", origin)
+		if created.Command != "" {
+			fmt.Fprintf(w, "Command:\t%s\n", created.Command)
+		}
+	}
+
+	if notes, err := loadNotes(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not read notes: %v\n", err)
+	} else if n, ok := notes[email.ID]; ok {
+		fmt.Fprintf(w, "Note:\t%s\n", n.Text)
+	}
+	w.Flush()
+}
+
+// orDash renders an unset value as "-", so every line of show has a value.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
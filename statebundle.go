@@ -0,0 +1,395 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	stateSubcommandExport string = "export"
+	stateSubcommandImport string = "import"
+
+	// bundleStatePrefix is the directory of the state files in a bundle,
+	// the config is stored next to it as configFileName.
+	bundleStatePrefix string = "state/"
+
+	// maxBundleEntrySize limits what import reads per file, so a corrupt
+	// bundle can't fill the disk.
+	maxBundleEntrySize int64 = 64 << 20
+)
+
+// flags for state command
+var stateCmd = flag.NewFlagSet(actionTypeState, flag.ExitOnError)
+var flagStateFile = stateCmd.String(flagNameFile, "", "export: write the bundle to this file instead of stdout")
+
+// runState handles `state export` and `state import`.
+func runState(args []string) {
+	usage := fmt.Sprintf("Usage: %s %s [-%s <path>] | %s %s <path>|%s", actionTypeState, stateSubcommandExport, flagNameFile, actionTypeState, stateSubcommandImport, stdinArg)
+	if len(args) == 0 {
+		log.Fatalln(usage)
+	}
+
+	parseCommandFlags(stateCmd, args[1:])
+
+	switch args[0] {
+	case stateSubcommandExport:
+		exportState(*flagStateFile)
+	case stateSubcommandImport:
+		if stateCmd.NArg() != 1 {
+			log.Fatalln(usage)
+		}
+		importState(stateCmd.Arg(0))
+	default:
+		log.Fatalln(usage)
+	}
+}
+
+// exportState writes the state directory and the config without secrets as
+// a .tar.gz bundle.
+func exportState(file string) {
+	var out io.Writer = os.Stdout
+	if file == "" {
+		if isTerminal(os.Stdout) {
+			log.Fatalf("refusing to write the bundle to a terminal, pass -%s or redirect stdout", flagNameFile)
+		}
+	} else {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+		if err != nil {
+			log.Fatalf("error creating bundle: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	add := func(name string, data []byte) {
+		hdr := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: now, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			log.Fatalf("error writing bundle: %v", err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			log.Fatalf("error writing bundle: %v", err)
+		}
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("error reading config: %v", err)
+	}
+	cfg.withoutSecrets()
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		log.Fatalf("error encoding config: %v", err)
+	}
+	add(configFileName, append(data, '\n'))
+
+	dir, err := stateDir()
+	if err != nil {
+		log.Fatalf("error finding state directory: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatalf("error reading state directory: %v", err)
+	}
+
+	files := 0
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+
+		var data []byte
+		var err error
+		// don't bundle half of a journal line being written
+		uninterrupted(func() { data, err = os.ReadFile(filepath.Join(dir, entry.Name())) })
+		if err != nil {
+			log.Fatalf("error reading %s: %v", entry.Name(), err)
+		}
+		add(bundleStatePrefix+entry.Name(), data)
+		files++
+	}
+
+	if err := tw.Close(); err != nil {
+		log.Fatalf("error writing bundle: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		log.Fatalf("error writing bundle: %v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "exported the config (without tokens) and %d state file(s)\n", files)
+}
+
+// withoutSecrets removes the tokens and address book passwords of all
+// profiles.
+func (cfg *config) withoutSecrets() {
+	cfg.profileConfig.withoutSecrets()
+	for _, p := range cfg.Profiles {
+		p.withoutSecrets()
+	}
+}
+
+func (p *profileConfig) withoutSecrets() {
+	p.Token = ""
+	if p.AddressBook != nil {
+		p.AddressBook.Password = ""
+	}
+}
+
+// importState merges a bundle written by exportState into the local config
+// and state. Nothing local is lost: journal entries are combined, the newer
+// of two notes wins, config profiles and views are only added, and other
+// state files are only written if they don't exist yet.
+func importState(file string) {
+	var in io.Reader = os.Stdin
+	if file != stdinArg {
+		f, err := os.Open(file)
+		if err != nil {
+			log.Fatalf("error opening bundle: %v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		log.Fatalf("error reading bundle: %v", err)
+	}
+	tr := tar.NewReader(gz)
+
+	var bundledConfig []byte
+	bundledState := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("error reading bundle: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := io.ReadAll(io.LimitReader(tr, maxBundleEntrySize+1))
+		if err != nil {
+			log.Fatalf("error reading bundle: %v", err)
+		}
+		if int64(len(data)) > maxBundleEntrySize {
+			log.Fatalf("%s in the bundle is too large", hdr.Name)
+		}
+
+		name := path.Clean(hdr.Name)
+		switch {
+		case name == configFileName:
+			bundledConfig = data
+		case strings.HasPrefix(name, bundleStatePrefix) && path.Dir(name)+"/" == bundleStatePrefix:
+			bundledState[path.Base(name)] = data
+		default:
+			fmt.Fprintf(os.Stderr, "warning: skipping unexpected %s in the bundle\n", hdr.Name)
+		}
+	}
+
+	if bundledConfig != nil {
+		added, err := mergeConfig(bundledConfig)
+		if err != nil {
+			log.Fatalf("error importing config: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "config: added %d profile(s) and view(s)\n", added)
+	}
+
+	dir, err := stateDir()
+	if err != nil {
+		log.Fatalf("error finding state directory: %v", err)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.Fatalf("error creating state directory: %v", err)
+	}
+
+	names := make([]string, 0, len(bundledState))
+	for name := range bundledState {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		data := bundledState[name]
+		target := filepath.Join(dir, name)
+
+		var summary string
+		var err error
+		switch name {
+		case journalFileName:
+			uninterrupted(func() { summary, err = mergeJournal(target, data) })
+		case notesFileName:
+			summary, err = mergeNotes(data)
+		default:
+			summary, err = writeIfMissing(target, data)
+		}
+		if err != nil {
+			log.Fatalf("error importing %s: %v", name, err)
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s\n", name, summary)
+	}
+
+	if bundledConfig != nil {
+		fmt.Fprintf(os.Stderr, "tokens aren't part of the bundle, set one up with `%s %s` if needed\n", defaultAppname, actionTypeInit)
+	}
+}
+
+// mergeConfig adds the profiles and views of the bundled config that don't
+// exist locally. Without a local config the bundled one is taken as is.
+func mergeConfig(data []byte) (int, error) {
+	var bundled config
+	if err := json.Unmarshal(data, &bundled); err != nil {
+		return 0, err
+	}
+	bundled.withoutSecrets()
+
+	local, err := loadConfig()
+	if err != nil {
+		return 0, err
+	}
+
+	path, err := configPath()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return len(bundled.Profiles) + len(bundled.Views), bundled.save()
+	}
+
+	added := 0
+	for name, p := range bundled.Profiles {
+		if _, ok := local.Profiles[name]; ok {
+			continue
+		}
+		local.setProfile(name, *p)
+		added++
+	}
+	for name, view := range bundled.Views {
+		if _, ok := local.Views[name]; ok {
+			continue
+		}
+		if local.Views == nil {
+			local.Views = map[string]string{}
+		}
+		local.Views[name] = view
+		added++
+	}
+
+	if added == 0 {
+		return 0, nil
+	}
+	return added, local.save()
+}
+
+// mergeJournal combines the bundled journal with the local one, dropping
+// duplicate lines and keeping the entries in time order.
+func mergeJournal(target string, data []byte) (string, error) {
+	local, err := os.ReadFile(target)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	type line struct {
+		text string
+		time time.Time
+	}
+	var lines []line
+	seen := map[string]bool{}
+	added := 0
+	for i, source := range [][]byte{local, data} {
+		scanner := bufio.NewScanner(bytes.NewReader(source))
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			text := strings.TrimSpace(scanner.Text())
+			var entry journalEntry
+			if text == "" || seen[text] || json.Unmarshal([]byte(text), &entry) != nil {
+				continue
+			}
+			seen[text] = true
+			lines = append(lines, line{text: text, time: entry.Time})
+			if i == 1 {
+				added++
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return "", err
+		}
+	}
+
+	if added == 0 {
+		return "nothing new", nil
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].time.Before(lines[j].time) })
+
+	var b bytes.Buffer
+	for _, l := range lines {
+		b.WriteString(l.text)
+		b.WriteByte('\n')
+	}
+
+	tmp := target + ".tmp"
+	if err := os.WriteFile(tmp, b.Bytes(), 0o600); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("added %d entries", added), nil
+}
+
+// mergeNotes adds the bundled notes, keeping the newer one if a masked
+// email has a note on both sides.
+func mergeNotes(data []byte) (string, error) {
+	var bundled notesStore
+	if err := json.Unmarshal(data, &bundled); err != nil {
+		return "", err
+	}
+
+	notes, err := loadNotes()
+	if err != nil {
+		return "", err
+	}
+
+	changed := 0
+	for id, n := range bundled {
+		if local, ok := notes[id]; ok && !n.UpdatedAt.After(local.UpdatedAt) {
+			continue
+		}
+		notes[id] = n
+		changed++
+	}
+
+	if changed == 0 {
+		return "nothing new", nil
+	}
+	return fmt.Sprintf("added or updated %d notes", changed), notes.save()
+}
+
+// writeIfMissing writes a state file the CLI has no merge rules for, unless
+// it exists already.
+func writeIfMissing(target string, data []byte) (string, error) {
+	if _, err := os.Stat(target); err == nil {
+		return "exists, skipped", nil
+	}
+	return "imported", os.WriteFile(target, data, 0o600)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// flags for stats command
+var statsCmd = flag.NewFlagSet(actionTypeStats, flag.ExitOnError)
+var flagStatsFormat = statsCmd.String(flagNameFormat, formatText, "output format (text|json|prometheus)")
+var flagStatsActivity = statsCmd.Bool(flagNameActivity, false, "show a histogram of when masked emails last received mail")
+var flagStatsBucket = statsCmd.String(flagNameBucket, bucketMonth, "period of the activity histogram (week|month)")
+
+// accountStats are the aggregates printed by `stats`. The JSON field names
+// are part of the output contract for dashboards, don't rename them.
+type accountStats struct {
+	Total   int            `json:"total"`
+	ByState map[string]int `json:"byState"`
+	// the rest leaves out deleted masked emails
+	NeverUsed    int                `json:"neverUsed"`
+	TopDomains   []domainCount      `json:"topDomains,omitempty"`
+	Oldest       *maskedEmailAtTime `json:"oldest,omitempty"`
+	Newest       *maskedEmailAtTime `json:"newest,omitempty"`
+	LastActivity *maskedEmailAtTime `json:"lastActivity,omitempty"`
+	Activity     *activityStats     `json:"activity,omitempty"`
+}
+
+// domainCount is the number of masked emails of a normalized domain.
+type domainCount struct {
+	Domain string `json:"domain"`
+	Count  int    `json:"count"`
+}
+
+// maskedEmailAtTime names a masked email and when it was created or last
+// received mail.
+type maskedEmailAtTime struct {
+	Email string    `json:"email"`
+	Time  time.Time `json:"time"`
+}
+
+// statsTopDomains is the number of domains in TopDomains.
+const statsTopDomains = 5
+
+func computeStats(emails []*pkg.MaskedEmail) accountStats {
+	s := accountStats{ByState: map[string]int{}}
+
+	domains := map[string]int{}
+	for _, email := range emails {
+		s.Total++
+		s.ByState[string(email.State)]++
+		if email.State == pkg.MaskedEmailStateDeleted {
+			continue
+		}
+
+		if domain := pkg.NormalizeDomain(email.Domain); domain != "" {
+			domains[domain]++
+		}
+		if s.Oldest == nil || email.CreatedAt.Before(s.Oldest.Time) {
+			s.Oldest = &maskedEmailAtTime{email.Email, email.CreatedAt.UTC()}
+		}
+		if s.Newest == nil || email.CreatedAt.After(s.Newest.Time) {
+			s.Newest = &maskedEmailAtTime{email.Email, email.CreatedAt.UTC()}
+		}
+		if email.LastMessageAt == nil || email.LastMessageAt.IsZero() {
+			s.NeverUsed++
+		} else if s.LastActivity == nil || email.LastMessageAt.After(s.LastActivity.Time) {
+			s.LastActivity = &maskedEmailAtTime{email.Email, email.LastMessageAt.UTC()}
+		}
+	}
+
+	for domain, n := range domains {
+		s.TopDomains = append(s.TopDomains, domainCount{domain, n})
+	}
+	sort.Slice(s.TopDomains, func(i, j int) bool {
+		if s.TopDomains[i].Count != s.TopDomains[j].Count {
+			return s.TopDomains[i].Count > s.TopDomains[j].Count
+		}
+		return s.TopDomains[i].Domain < s.TopDomains[j].Domain
+	})
+	if len(s.TopDomains) > statsTopDomains {
+		s.TopDomains = s.TopDomains[:statsTopDomains]
+	}
+
+	return s
+}
+
+func runStats(client *pkg.Client, args []string) {
+	parseCommandFlags(statsCmd, args)
+
+	if jsonOutput() {
+		*flagStatsFormat = formatJSON
+	}
+	if *flagStatsFormat != formatText && *flagStatsFormat != formatJSON && *flagStatsFormat != formatPrometheus {
+		log.Fatalf("unsupported format %q (text|json|prometheus)", *flagStatsFormat)
+	}
+	if *flagStatsFormat == formatPrometheus && *flagStatsActivity {
+		log.Fatalf("-%s can't be combined with -%s %s", flagNameActivity, flagNameFormat, formatPrometheus)
+	}
+	if *flagStatsBucket != bucketWeek && *flagStatsBucket != bucketMonth {
+		log.Fatalf("unsupported bucket %q (week|month)", *flagStatsBucket)
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	maskedEmails, err := client.GetAllMaskedEmails(session, *flagAccountID)
+	if err != nil {
+		log.Fatalf("error fetching masked emails: %v", err)
+	}
+
+	s := computeStats(maskedEmails)
+	if *flagStatsActivity {
+		s.Activity = computeActivity(maskedEmails, *flagStatsBucket)
+	}
+
+	if *flagStatsFormat == formatJSON {
+		printJSON(s)
+		return
+	}
+	if *flagStatsFormat == formatPrometheus {
+		printPrometheus(os.Stdout, accountIDOrDefault(session), s)
+		return
+	}
+
+	printStats(os.Stdout, s)
+}
+
+func printStats(out io.Writer, s accountStats) {
+	w := newTabWriter(out, 1)
+
+	fmt.Fprintf(w, "Total\t%d\n", s.Total)
+
+	states := make([]string, 0, len(s.ByState))
+	for state := range s.ByState {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	for _, state := range states {
+		fmt.Fprintf(w, "  %s\t%d\n", state, s.ByState[state])
+	}
+
+	fmt.Fprintf(w, "Never used\t%d\n", s.NeverUsed)
+	if s.Oldest != nil {
+		fmt.Fprintf(w, "Oldest\t%s (created %s)\n", s.Oldest.Email, formatDisplayTime(&s.Oldest.Time))
+		fmt.Fprintf(w, "Newest\t%s (created %s)\n", s.Newest.Email, formatDisplayTime(&s.Newest.Time))
+	}
+	if s.LastActivity != nil {
+		fmt.Fprintf(w, "Last email\t%s (%s)\n", s.LastActivity.Email, formatDisplayTime(&s.LastActivity.Time))
+	}
+
+	if len(s.TopDomains) > 0 {
+		fmt.Fprintln(w, "Top domains")
+		for _, d := range s.TopDomains {
+			fmt.Fprintf(w, "  %s\t%d\n", d.Domain, d.Count)
+		}
+	}
+
+	w.Flush()
+
+	if s.Activity != nil {
+		fmt.Fprintln(out)
+		printActivity(out, s.Activity)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+)
+
+const envStrictVarName string = "MASKEDEMAIL_STRICT"
+
+// flagLikeArg matches arguments that look like a flag, "-name" or
+// "--name=value", but not "-" or negative numbers.
+var flagLikeArg = regexp.MustCompile(`^--?[A-Za-z][A-Za-z0-9-]*(=.*)?$`)
+
+// warnStrict warns about something the CLI had to make an assumption about:
+// a misplaced flag, an ambiguous match, a change that changes nothing. With
+// -strict it's an error instead, so scripts fail before anything happens.
+func warnStrict(format string, a ...interface{}) {
+	if *flagStrict {
+		log.Fatalf(format+" (-%s)", append(a, flagNameStrict)...)
+	}
+	fmt.Fprintf(os.Stderr, "warning: "+format+"\n", a...)
+}
+
+// parseCommandFlags parses the flags of a command after its defaults from
+// the config. The flag package stops at the first argument, so flags after it
+// are warned about instead of being silently taken as arguments, unless they
+// follow an explicit "--".
+func parseCommandFlags(set *flag.FlagSet, args []string) {
+	if defaults := commandFlagArgs(set); len(defaults) > 0 {
+		set.Parse(defaults)
+	}
+	set.Parse(args)
+
+	rest := set.Args()
+	if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+		return
+	}
+	warnFlagLikeArgs(set.Name(), rest)
+}
+
+// warnFlagLikeArgs warns about arguments of the command that look like flags.
+func warnFlagLikeArgs(command string, args []string) {
+	for _, arg := range args {
+		if flagLikeArg.MatchString(arg) {
+			warnStrict("%s: %s after the arguments is not read as a flag, pass flags before the arguments", command, arg)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+const (
+	tagSubcommandAdd    string = "add"
+	tagSubcommandRemove string = "remove"
+	tagSubcommandList   string = "list"
+)
+
+// tagPattern matches "#tag" words in a description. Tags are embedded in
+// the description field since Fastmail has no server-side tagging.
+var tagPattern = regexp.MustCompile(`(^|\s)#([\p{L}\p{N}_-]+)`)
+
+// normalizeTag lowercases a tag and strips a leading "#".
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+// parseTags returns the normalized, de-duplicated tags of a description in
+// order of appearance.
+func parseTags(description string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, m := range tagPattern.FindAllStringSubmatch(description, -1) {
+		tag := normalizeTag(m[2])
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func hasTag(description string, tag string) bool {
+	tag = normalizeTag(tag)
+	for _, t := range parseTags(description) {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// addTags appends the tags that aren't present yet to the description.
+func addTags(description string, tags []string) string {
+	description = strings.TrimSpace(description)
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || hasTag(description, tag) {
+			continue
+		}
+		description = strings.TrimSpace(description + " #" + tag)
+	}
+	return description
+}
+
+// removeTags drops all occurrences of the tags from the description and
+// tidies up the whitespace left behind.
+func removeTags(description string, tags []string) string {
+	remove := map[string]bool{}
+	for _, tag := range tags {
+		remove[normalizeTag(tag)] = true
+	}
+
+	description = tagPattern.ReplaceAllStringFunc(description, func(match string) string {
+		m := tagPattern.FindStringSubmatch(match)
+		if remove[normalizeTag(m[2])] {
+			return m[1]
+		}
+		return match
+	})
+
+	return strings.Join(strings.Fields(description), " ")
+}
+
+// runTag handles `tag add|remove|list`.
+func runTag(client *pkg.Client, args []string) {
+	usage := fmt.Sprintf("Usage: %s <%s|%s> <maskedemail> <tag>... | %s %s [<maskedemail>]",
+		actionTypeTag, tagSubcommandAdd, tagSubcommandRemove, actionTypeTag, tagSubcommandList)
+
+	if len(args) == 0 {
+		log.Fatalln(usage)
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	switch args[0] {
+	case tagSubcommandList:
+		maskedEmails, err := client.GetAllMaskedEmails(session, *flagAccountID)
+		if err != nil {
+			log.Fatalf("error fetching masked emails: %v", err)
+		}
+
+		if len(args) > 1 {
+			for _, email := range maskedEmails {
+				if email.Email == strings.TrimSpace(args[1]) {
+					for _, tag := range parseTags(email.Description) {
+						fmt.Println(tag)
+					}
+					return
+				}
+			}
+			log.Fatalf("maskedemail %s not found", args[1])
+		}
+
+		counts := map[string]int{}
+		for _, email := range maskedEmails {
+			if email.State == pkg.MaskedEmailStateDeleted {
+				continue
+			}
+			for _, tag := range parseTags(email.Description) {
+				counts[tag]++
+			}
+		}
+
+		tags := make([]string, 0, len(counts))
+		for tag := range counts {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+
+		w := newTabWriter(os.Stdout, 1)
+		fmt.Fprintln(w, "Tag\tCount")
+		for _, tag := range tags {
+			fmt.Fprintf(w, "%s\t%d\n", tag, counts[tag])
+		}
+		w.Flush()
+
+	case tagSubcommandAdd, tagSubcommandRemove:
+		if len(args) < 3 {
+			log.Fatalln(usage)
+		}
+
+		maskedemail := maskedEmailArg(args[1], strings.TrimPrefix(usage, "Usage: "))
+		email, err := client.LookupMaskedEmail(session, *flagAccountID, maskedemail)
+		if err != nil {
+			log.Fatalf("error looking up masked email: %v", err)
+		}
+
+		var description string
+		if args[0] == tagSubcommandAdd {
+			description = addTags(email.Description, args[2:])
+		} else {
+			description = removeTags(email.Description, args[2:])
+		}
+
+		if description == strings.TrimSpace(email.Description) {
+			if *flagStrict {
+				warnStrict("tags of %s unchanged", maskedemail)
+			}
+			fmt.Printf("tags of %s unchanged\n", maskedemail)
+			return
+		}
+
+		fields := pkg.NewUpdateFields(false, "", true, description)
+		if _, err := client.UpdateMaskedEmail(session, *flagAccountID, email.ID, fields); err != nil {
+			log.Fatalf("error updating masked email: %v", err)
+		}
+
+		appendJournal(journalEntry{
+			Action:      actionTypeUpdate,
+			AccountID:   accountIDOrDefault(session),
+			ID:          email.ID,
+			Email:       maskedemail,
+			Description: description,
+		})
+
+		fmt.Printf("updated %s: %s\n", maskedemail, description)
+
+	default:
+		log.Fatalln(usage)
+	}
+}
@@ -0,0 +1,101 @@
+#!/usr/bin/env bash
+#
+# Benchmarks: runs the compiled CLI against the fake JMAP server in
+# test/fakejmap with a large synthetic account and fails if a command is
+# slower than its budget.
+#
+#   make bench                  # or: test/bench.sh
+#   BENCH_EMAILS=50000 test/bench.sh
+#
+# Each command runs BENCH_RUNS times, the median wall time counts. The
+# budgets are for a developer laptop, they include starting the CLI and the
+# round trips to the local server, not the network latency to Fastmail.
+
+set -u
+
+ROOT=$(cd "$(dirname "$0")/.." && pwd)
+WORK=$(mktemp -d)
+EMAILS=${BENCH_EMAILS:-10000}
+RUNS=${BENCH_RUNS:-5}
+FAILED=0
+SERVER_PID=""
+
+cleanup() {
+	[ -n "$SERVER_PID" ] && kill "$SERVER_PID" 2>/dev/null
+	rm -rf "$WORK"
+}
+trap cleanup EXIT
+
+go build -o "$WORK/maskedemail-cli" "$ROOT" || exit 1
+go build -o "$WORK/fakejmap" "$ROOT/test/fakejmap" || exit 1
+
+"$WORK/fakejmap" -synthetic "$EMAILS" >"$WORK/fakejmap.log" 2>&1 &
+SERVER_PID=$!
+
+SESSION_URL=""
+for _ in $(seq 50); do
+	SESSION_URL=$(head -n 1 "$WORK/fakejmap.log")
+	[ -n "$SESSION_URL" ] && break
+	sleep 0.1
+done
+if [ -z "$SESSION_URL" ]; then
+	echo "fakejmap did not start:" >&2
+	cat "$WORK/fakejmap.log" >&2
+	exit 1
+fi
+
+for name in $(env | sed -n 's/^\(MASKEDEMAIL_[A-Z_]*\)=.*/\1/p'); do
+	unset "$name"
+done
+export MASKEDEMAIL_SESSION_URL=$SESSION_URL
+export MASKEDEMAIL_TOKEN=test-token
+export MASKEDEMAIL_STATE_DIR=$WORK/state
+export MASKEDEMAIL_CONFIG=$WORK/config.json
+export NO_COLOR=1
+export LC_ALL=C
+export TZ=UTC
+
+# an address in the middle of the synthetic account, for single lookups
+ADDRESS=$(printf 'synthetic.mask%05d@fastmail.com' $((EMAILS / 2 + 1)))
+
+# bench BUDGET_MS NAME ARGS... runs the CLI with ARGS and compares the median
+# of the wall times with the budget.
+bench() {
+	local budget=$1 name=$2
+	shift 2
+
+	local times=() start end
+	for _ in $(seq "$RUNS"); do
+		start=$(date +%s%N)
+		if ! "$WORK/maskedemail-cli" "$@" >"$WORK/stdout" 2>"$WORK/stderr"; then
+			echo "FAIL $name: exit status $?" >&2
+			cat "$WORK/stderr" >&2
+			FAILED=$((FAILED + 1))
+			return
+		fi
+		end=$(date +%s%N)
+		times+=($(((end - start) / 1000000)))
+	done
+
+	local median
+	median=$(printf '%s\n' "${times[@]}" | sort -n | sed -n "$(((RUNS + 1) / 2))p")
+	if [ "$median" -le "$budget" ]; then
+		printf 'ok   %-24s %6d ms (budget %d ms)\n' "$name" "$median" "$budget"
+	else
+		printf 'SLOW %-24s %6d ms (budget %d ms)\n' "$name" "$median" "$budget"
+		FAILED=$((FAILED + 1))
+	fi
+}
+
+echo "$EMAILS masked emails, median of $RUNS runs"
+
+# --- budgets ---------------------------------------------------------------
+
+bench 1000 "list" list
+bench 1500 "list -all-fields" list -all-fields
+bench 500 "show" show "$ADDRESS"
+bench 500 "exists" exists "$ADDRESS"
+bench 500 "disable" disable "$ADDRESS"
+bench 500 "enable" enable "$ADDRESS"
+
+[ "$FAILED" -eq 0 ]
@@ -0,0 +1,2523 @@
+#!/usr/bin/env bash
+#
+# End-to-end tests: runs the compiled CLI against the fake JMAP server in
+# test/fakejmap and checks stdout, stderr and exit codes.
+#
+#   make e2e            # or: test/e2e.sh
+#   test/e2e.sh list    # only run cases whose name contains "list"
+#
+# Every case starts from the seed data in test/testdata/seed.json and an
+# empty state directory and config.
+
+set -u
+
+ROOT=$(cd "$(dirname "$0")/.." && pwd)
+WORK=$(mktemp -d)
+FILTER=${1:-}
+PASSED=0
+FAILED=0
+CASE=""
+CASE_FAILED=0
+SERVER_PID=""
+
+cleanup() {
+	[ -n "$SERVER_PID" ] && kill "$SERVER_PID" 2>/dev/null
+	rm -rf "$WORK"
+}
+trap cleanup EXIT
+
+go build -o "$WORK/maskedemail-cli" "$ROOT" || exit 1
+go build -o "$WORK/fakejmap" "$ROOT/test/fakejmap" || exit 1
+
+"$WORK/fakejmap" -seed "$ROOT/test/testdata/seed.json" -max-objects-in-set 2 >"$WORK/fakejmap.log" 2>&1 &
+SERVER_PID=$!
+
+SESSION_URL=""
+for _ in $(seq 50); do
+	SESSION_URL=$(head -n 1 "$WORK/fakejmap.log")
+	[ -n "$SESSION_URL" ] && break
+	sleep 0.1
+done
+if [ -z "$SESSION_URL" ]; then
+	echo "fakejmap did not start:" >&2
+	cat "$WORK/fakejmap.log" >&2
+	exit 1
+fi
+
+# isolate the CLI from the environment of the developer
+for name in $(env | sed -n 's/^\(MASKEDEMAIL_[A-Z_]*\)=.*/\1/p'); do
+	unset "$name"
+done
+export MASKEDEMAIL_SESSION_URL=$SESSION_URL
+export MASKEDEMAIL_TOKEN=test-token
+export MASKEDEMAIL_STATE_DIR=$WORK/state
+export MASKEDEMAIL_CONFIG=$WORK/config.json
+export NO_COLOR=1
+# text output follows the locale and time zone
+export LC_ALL=C
+export TZ=UTC
+
+# --- helpers ---------------------------------------------------------------
+
+finish_case() {
+	[ -z "$CASE" ] && return
+	if [ "$CASE_FAILED" -eq 0 ]; then
+		PASSED=$((PASSED + 1))
+		echo "ok   $CASE"
+	else
+		FAILED=$((FAILED + 1))
+		echo "FAIL $CASE"
+	fi
+	CASE=""
+}
+
+# begin NAME starts a test case and resets server data and local state. It
+# returns non-zero if the case is filtered out.
+begin() {
+	finish_case
+	case "$1" in
+	*"$FILTER"*) ;;
+	*) return 1 ;;
+	esac
+
+	CASE=$1
+	CASE_FAILED=0
+	rm -rf "$WORK/state" "$WORK/config.json"
+	curl -fsS -X POST -H "Authorization: Bearer test-token" "${SESSION_URL%/jmap/session}/reset" || CASE_FAILED=1
+}
+
+fail() {
+	CASE_FAILED=1
+	echo "     $*"
+}
+
+# run ARGS... runs the CLI, capturing stdout, stderr and the exit status.
+run() {
+	LAST_CMD="maskedemail-cli $*"
+	"$WORK/maskedemail-cli" "$@" >"$WORK/stdout" 2>"$WORK/stderr" </dev/null
+	STATUS=$?
+}
+
+# run_input ARGS... is run with the CLI reading its stdin from the caller's.
+run_input() {
+	LAST_CMD="maskedemail-cli $*"
+	"$WORK/maskedemail-cli" "$@" >"$WORK/stdout" 2>"$WORK/stderr"
+	STATUS=$?
+}
+
+expect_status() {
+	if [ "$STATUS" -ne "$1" ]; then
+		fail "$LAST_CMD: exit status $STATUS, want $1"
+		sed 's/^/       stderr: /' "$WORK/stderr"
+	fi
+}
+
+# expect_stdout compares stdout with the expected output on stdin.
+expect_stdout() {
+	if ! diff -u - "$WORK/stdout" >"$WORK/diff"; then
+		fail "$LAST_CMD: unexpected stdout"
+		sed 's/^/       /' "$WORK/diff"
+	fi
+}
+
+expect_stdout_contains() {
+	if ! grep -qF -- "$1" "$WORK/stdout"; then
+		fail "$LAST_CMD: stdout doesn't contain \"$1\""
+		sed 's/^/       stdout: /' "$WORK/stdout"
+	fi
+}
+
+expect_stdout_lacks() {
+	if grep -qF -- "$1" "$WORK/stdout"; then
+		fail "$LAST_CMD: stdout contains \"$1\""
+	fi
+}
+
+expect_stderr_contains() {
+	if ! grep -qF -- "$1" "$WORK/stderr"; then
+		fail "$LAST_CMD: stderr doesn't contain \"$1\""
+		sed 's/^/       stderr: /' "$WORK/stderr"
+	fi
+}
+
+expect_file_contains() {
+	if ! grep -qF -- "$2" "$1" 2>/dev/null; then
+		fail "$1 doesn't contain \"$2\""
+	fi
+}
+
+# --- cases -----------------------------------------------------------------
+
+if begin "version"; then
+	run -json version
+	expect_status 0
+	expect_stdout_contains '"version": "development"'
+fi
+
+if begin "session"; then
+	run session
+	expect_status 0
+	expect_stdout <<'EOF'
+primary@example.com [u1] (primary: true, enabled: true)
+shared@example.com [u2] (primary: false, enabled: true)
+EOF
+fi
+
+if begin "session json"; then
+	run -json session
+	expect_status 0
+	expect_stdout_contains '"id": "u2"'
+	expect_stdout_contains '"primary": false'
+fi
+
+if begin "list"; then
+	run list
+	expect_status 0
+	expect_stdout <<'EOF'
+Masked Email                For Domain              Description          State
+alpha.one123@fastmail.com   github.com              GitHub #dev          enabled
+beta.two456@fastmail.com    https://www.netflix.com Netflix trial        disabled
+gamma.three789@fastmail.com shop.example.com        Newsletter #shopping enabled
+EOF
+fi
+
+if begin "list show deleted"; then
+	run list -show-deleted
+	expect_status 0
+	expect_stdout_contains "delta.four000@fastmail.com"
+fi
+
+if begin "list state"; then
+	run -template '{{.Email}}' list -state disabled,deleted
+	expect_status 0
+	expect_stdout <<'EOF'
+beta.two456@fastmail.com
+delta.four000@fastmail.com
+EOF
+
+	run -template '{{.Email}}' list -state disabled -state Enabled
+	expect_status 0
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com
+beta.two456@fastmail.com
+gamma.three789@fastmail.com
+EOF
+
+	run -template '{{.Email}}' list -state disabled -show-deleted
+	expect_status 0
+	expect_stdout <<'EOF'
+beta.two456@fastmail.com
+delta.four000@fastmail.com
+EOF
+
+	run list -state gone
+	expect_status 1
+	expect_stderr_contains 'invalid -state: unknown state "gone"'
+fi
+
+if begin "list domain"; then
+	run -template '{{.Email}}' list -domain example.com
+	expect_status 0
+	expect_stdout <<'EOF'
+gamma.three789@fastmail.com
+EOF
+
+	run -template '{{.Email}}' list -domain NETFLIX.com
+	expect_status 0
+	expect_stdout <<'EOF'
+beta.two456@fastmail.com
+EOF
+
+	run -template '{{.Email}}' list -domain 'git*'
+	expect_status 0
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com
+EOF
+
+	run list -domain ample.com
+	expect_status 0
+	expect_stdout_lacks "gamma.three789@fastmail.com"
+
+	run list -domain '[x'
+	expect_status 1
+	expect_stderr_contains "invalid -domain"
+fi
+
+if begin "list desc"; then
+	run -template '{{.Email}}' list -desc NEWSLETTER
+	expect_status 0
+	expect_stdout <<'EOF'
+gamma.three789@fastmail.com
+EOF
+
+	run -template '{{.Email}}' list -desc "netflix tr"
+	expect_status 0
+	expect_stdout <<'EOF'
+beta.two456@fastmail.com
+EOF
+fi
+
+if begin "list created range"; then
+	run -template '{{.Email}}' list -show-deleted --created-since 2022-01-01 --created-before 2023-12-31
+	expect_status 0
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com
+beta.two456@fastmail.com
+EOF
+
+	run -template '{{.Email}}' list -created-since 2024-06-01T10:00:00Z
+	expect_status 0
+	expect_stdout <<'EOF'
+gamma.three789@fastmail.com
+EOF
+
+	run list -created-before yesterday
+	expect_status 1
+	expect_stderr_contains "invalid -created-before"
+fi
+
+if begin "list last activity"; then
+	run -template '{{.Email}}' list -never-used
+	expect_status 0
+	expect_stdout <<'EOF'
+beta.two456@fastmail.com
+gamma.three789@fastmail.com
+EOF
+
+	run -template '{{.Email}}' list -show-deleted -last-email-before 2022-01-01
+	expect_status 0
+	expect_stdout <<'EOF'
+delta.four000@fastmail.com
+EOF
+
+	run -template '{{.Email}}' list -state enabled -last-email-before 2025-01-01 -never-used
+	expect_status 0
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com
+gamma.three789@fastmail.com
+EOF
+fi
+
+if begin "list sort"; then
+	run -template '{{.Email}}' list -sort created
+	expect_status 0
+	expect_stdout <<'EOF'
+beta.two456@fastmail.com
+alpha.one123@fastmail.com
+gamma.three789@fastmail.com
+EOF
+
+	run -template '{{.Email}}' list -sort created -reverse
+	expect_status 0
+	expect_stdout <<'EOF'
+gamma.three789@fastmail.com
+alpha.one123@fastmail.com
+beta.two456@fastmail.com
+EOF
+
+	run -template '{{.Email}}' list -sort domain
+	expect_status 0
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com
+beta.two456@fastmail.com
+gamma.three789@fastmail.com
+EOF
+
+	run -template '{{.Email}} {{.State}}' list -show-deleted -sort state -reverse
+	expect_status 0
+	expect_stdout <<'EOF'
+delta.four000@fastmail.com deleted
+beta.two456@fastmail.com disabled
+gamma.three789@fastmail.com enabled
+alpha.one123@fastmail.com enabled
+EOF
+
+	run -template '{{.Email}}' list -sort last-email
+	expect_status 0
+	expect_stdout <<'EOF'
+beta.two456@fastmail.com
+gamma.three789@fastmail.com
+alpha.one123@fastmail.com
+EOF
+
+	run list -sort size
+	expect_status 1
+	expect_stderr_contains 'unsupported sort key "size" (created|last-email|domain|email|state|idle|age)'
+fi
+
+if begin "list columns"; then
+	run list -columns email,domain,state,lastMessageAt
+	expect_status 0
+	expect_stdout <<'EOF'
+Masked Email                For Domain              State    Last Email At
+alpha.one123@fastmail.com   github.com              enabled  2024-05-01T09:00:00Z
+beta.two456@fastmail.com    https://www.netflix.com disabled -
+gamma.three789@fastmail.com shop.example.com        enabled  -
+EOF
+
+	run list -format csv -columns ID,createdAt
+	expect_status 0
+	printf 'id,createdAt\r\nme1,2023-01-05T10:00:00Z\r\nme2,2022-03-01T10:00:00Z\r\nme3,2024-06-01T10:00:00Z\r\n' | expect_stdout
+
+	run list -columns email,size
+	expect_status 1
+	expect_stderr_contains 'invalid -columns: unknown column "size"'
+
+	run list -columns email -all-fields
+	expect_status 1
+	expect_stderr_contains "-columns can't be combined with -all-fields"
+fi
+
+if begin "list plain"; then
+	run list -plain -state enabled
+	expect_status 0
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com
+gamma.three789@fastmail.com
+EOF
+
+	run list -no-header -columns email,state
+	expect_status 0
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com   enabled
+beta.two456@fastmail.com    disabled
+gamma.three789@fastmail.com enabled
+EOF
+
+	run list -no-header -format csv -columns id
+	expect_status 0
+	printf 'me1\r\nme2\r\nme3\r\n' | expect_stdout
+
+	run list -plain -format csv
+	expect_status 1
+	expect_stderr_contains "-plain can't be combined with -format csv"
+fi
+
+if begin "list color"; then
+	run -color always list -state disabled
+	expect_status 0
+	printf '\033[01mMasked Email\033[0m             \033[01mFor Domain\033[0m              \033[01mDescription\033[0m   \033[01mState\033[0m\n\033[39mbeta.two456@fastmail.com\033[0m \033[39mhttps://www.netflix.com\033[0m \033[39mNetflix trial\033[0m \033[31mdisabled\033[0m\n' | expect_stdout
+
+	# NO_COLOR is set for all cases
+	run list -state disabled
+	expect_stdout_lacks $'\033['
+
+	run -color sometimes list
+	expect_status 1
+	expect_stderr_contains 'unsupported -color "sometimes" (auto|always|never)'
+fi
+
+if begin "list group by"; then
+	run create -domain https://github.com/login -desc "GitHub work"
+	expect_status 0
+	run list -group-by domain -show-deleted
+	expect_status 0
+	expect_stdout <<'EOF'
+github.com (2)
+  alpha.one123@fastmail.com  GitHub #dev enabled
+  auto.mask1001@fastmail.com GitHub work enabled
+example.com (1)
+  delta.four000@fastmail.com old deleted
+netflix.com (1)
+  beta.two456@fastmail.com Netflix trial disabled
+shop.example.com (1)
+  gamma.three789@fastmail.com Newsletter #shopping enabled
+EOF
+
+	run list -group-by state
+	expect_status 1
+	expect_stderr_contains 'unsupported -group-by "state" (domain)'
+
+fi
+
+if begin "list compat all fields"; then
+	run -compat 1 list -all-fields
+	expect_status 0
+	# the empty last column leaves a trailing space, keep it
+	printf '%s\n' \
+		"Masked Email                For Domain              Description          State    ID  Created At           Last Email At" \
+		"alpha.one123@fastmail.com   github.com              GitHub #dev          enabled  me1 2023-01-05T10:00:00Z 2024-05-01T09:00:00Z" \
+		"beta.two456@fastmail.com    https://www.netflix.com Netflix trial        disabled me2 2022-03-01T10:00:00Z " \
+		"gamma.three789@fastmail.com shop.example.com        Newsletter #shopping enabled  me3 2024-06-01T10:00:00Z " |
+		expect_stdout
+fi
+
+if begin "list tag"; then
+	run list -tag shopping
+	expect_status 0
+	expect_stdout_contains "gamma.three789@fastmail.com"
+	expect_stdout_lacks "alpha.one123@fastmail.com"
+fi
+
+if begin "list where"; then
+	run list -where 'state == "enabled" && lastMessageAt == null && createdAt < now()-90d'
+	expect_status 0
+	expect_stdout <<'EOF'
+Masked Email                For Domain       Description          State
+gamma.three789@fastmail.com shop.example.com Newsletter #shopping enabled
+EOF
+
+	run -template '{{.Email}}' list -show-deleted -where '(forDomain =~ "netflix" || createdAt < "2022-01-01") && !(url != null)'
+	expect_status 0
+	expect_stdout <<'EOF'
+beta.two456@fastmail.com
+delta.four000@fastmail.com
+EOF
+
+	run list -where 'state = "enabled"'
+	expect_status 1
+	expect_stderr_contains "invalid -where"
+
+	run list -where 'createdAt < 90'
+	expect_status 1
+	expect_stderr_contains "needs a unit"
+
+	run list -where 'createdAt < 7d'
+	expect_status 1
+	expect_stderr_contains "can't compare a time < a duration"
+fi
+
+if begin "list view"; then
+	cat >"$MASKEDEMAIL_CONFIG" <<'EOF'
+{
+  "views": {
+    "unused": "-where 'lastMessageAt == null' -sort \"age\" -format csv",
+    "loop": "-view unused"
+  }
+}
+EOF
+	run list -view unused
+	expect_status 0
+	expect_stdout_contains "beta.two456@fastmail.com,https://www.netflix.com"
+	expect_stdout_contains "gamma.three789@fastmail.com,shop.example.com"
+
+	# -where and -format given on the command line replace the view's
+	run -template '{{.Email}} {{.State}}' list -view unused -where 'state == "enabled"' -format text
+	expect_status 0
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com enabled
+gamma.three789@fastmail.com enabled
+EOF
+
+	run list -view stale
+	expect_status 1
+	expect_stderr_contains 'view "stale" not found (loop, unused)'
+
+	run list -view loop
+	expect_status 1
+	expect_stderr_contains "views can't use -view"
+fi
+
+if begin "list changed since"; then
+	run -template '{{.Email}}' list -changed-since 1000w
+	expect_status 0
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com
+beta.two456@fastmail.com
+gamma.three789@fastmail.com
+EOF
+
+	# nothing of the seed data was created or got mail within a day, and
+	# there's no checkpoint before the window to ask for other changes
+	run disable alpha.one123@fastmail.com
+	run list -changed-since 1d
+	expect_status 0
+	expect_stdout_lacks "alpha.one123@fastmail.com"
+
+	# pretend the lists ran long ago, the enable is a change since
+	sed -i 's/"time":"[^"]*"/"time":"2000-01-01T00:00:00Z"/' "$WORK/state/list-states/default.json"
+	run enable alpha.one123@fastmail.com
+	run -template '{{.Email}} {{.State}}' list -changed-since 1d
+	expect_status 0
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com enabled
+EOF
+
+	run list -changed-since 7
+	expect_status 1
+	expect_stderr_contains 'invalid -changed-since: "7" is not a positive duration'
+fi
+
+if begin "exists"; then
+	run exists alpha.one123@fastmail.com
+	expect_status 0
+	expect_stdout </dev/null
+
+	run exists https://www.github.com/
+	expect_status 0
+
+	# beta is disabled, delta deleted
+	run exists beta.two456@fastmail.com
+	expect_status 1
+	run exists netflix.com
+	expect_status 1
+	run exists nobody.none000@fastmail.com
+	expect_status 1
+	expect_stdout </dev/null
+
+	run exists
+	expect_status 2
+	MASKEDEMAIL_TOKEN=wrong-token run exists github.com
+	expect_status 2
+fi
+
+if begin "list json"; then
+	run -json list
+	expect_status 0
+	expect_stdout_contains '"email": "beta.two456@fastmail.com"'
+	expect_stdout_contains '"lastMessageAt": null'
+	expect_stdout_contains '"createdAt": "2023-01-05T10:00:00Z"'
+	expect_stdout_lacks "delta.four000@fastmail.com"
+fi
+
+if begin "list csv"; then
+	run update -email alpha.one123@fastmail.com -desc 'GitHub, "work" #dev'
+	run list -format csv -tag dev
+	expect_status 0
+	printf '%s\r\n' \
+		"email,forDomain,description,state,id,url,createdBy,createdAt,lastMessageAt" \
+		'alpha.one123@fastmail.com,github.com,"GitHub, ""work"" #dev",enabled,me1,https://github.com/signup,maskedemail-cli,2023-01-05T10:00:00Z,2024-05-01T09:00:00Z' |
+		expect_stdout
+fi
+
+if begin "list template"; then
+	run -template '{{.Email}} {{.State}} {{deref .URL}}|{{rfc3339 .LastMessageAt}}' list
+	expect_status 0
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com enabled https://github.com/signup|2024-05-01T09:00:00Z
+beta.two456@fastmail.com disabled |
+gamma.three789@fastmail.com enabled |
+EOF
+
+	run -template '{{.Nope}}' list
+	expect_status 1
+	expect_stderr_contains "error rendering -template"
+
+	run -template '{{.Email}}' -json list
+	expect_status 1
+fi
+
+if begin "create reuse"; then
+	run create -reuse -domain https://www.github.com/
+	expect_status 0
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com
+EOF
+	expect_stderr_contains "reused the existing masked email for github.com"
+
+	# beta is disabled, so netflix gets a new one, which is reused next time
+	run create -reuse -domain netflix.com
+	expect_status 0
+	expect_stdout_lacks "beta.two456@fastmail.com"
+	expect_stderr_contains "created a new masked email for netflix.com"
+	NEW=$(cat "$WORK/stdout")
+	run create -reuse -domain netflix.com
+	expect_status 0
+	expect_stdout_contains "$NEW"
+
+	run create -reuse
+	expect_status 1
+	expect_stderr_contains "-reuse needs a domain"
+fi
+
+if begin "create count"; then
+	# three creates need two requests with -max-objects-in-set 2
+	run create -count 3 -domain offline.example.com -desc Spare
+	expect_status 0
+	if [ "$(grep -c '@fastmail.com$' "$WORK/stdout")" -ne 3 ]; then
+		fail "$LAST_CMD: want 3 addresses, got: $(cat "$WORK/stdout")"
+	fi
+
+	run -template '{{.Email}}' list -where 'description == "Spare"'
+	expect_status 0
+	if [ "$(wc -l <"$WORK/stdout")" -ne 3 ]; then
+		fail "$LAST_CMD: want 3 masked emails for offline.example.com"
+	fi
+
+	run -json create -count 2 -domain offline.example.com
+	expect_status 0
+	expect_stdout_contains '"forDomain": "offline.example.com"'
+
+	run create -count 2 -dry-run -domain offline.example.com
+	expect_status 0
+	expect_stdout_contains '"maskedemail-cli-2"'
+
+	# shop.example.com of the seed counts towards example.com too
+	run -max-per-domain 7 create -count 2 -domain offline.example.com
+	expect_status 1
+	expect_stderr_contains "example.com already has 6, 2 more would exceed it"
+
+	run create -count 2 -copy
+	expect_status 1
+	expect_stderr_contains "-copy can't be combined with -count"
+	run create -count 0
+	expect_status 1
+fi
+
+if begin "create url"; then
+	run -template '{{.Domain}}' create -url 'https://www.shop.example.org/signup?x=1'
+	expect_status 0
+	expect_stdout <<'EOF'
+shop.example.org
+EOF
+
+	run -template '{{.Domain}}' create -url shop.example.org/signup -registrable
+	expect_status 0
+	expect_stdout <<'EOF'
+example.org
+EOF
+
+	run -template '{{.Domain}}' create -url https://shop.example.org -domain other.example
+	expect_status 0
+	expect_stdout <<'EOF'
+other.example
+EOF
+
+	run create -url 'ftp://files.example.org'
+	expect_status 1
+	expect_stderr_contains "only http and https urls have a domain"
+fi
+
+if begin "create desc template"; then
+	run -template '{{.Description}}' create -url https://shop.example.org/signup -desc-template 'Signup for {{.Domain}} via {{.URL}}'
+	expect_status 0
+	expect_stdout <<'EOF'
+Signup for shop.example.org via https://shop.example.org/signup
+EOF
+
+	run -template '{{.Description}}' create -domain example.org -desc-template '{{.Domain}} {{.Date}}'
+	expect_status 0
+	expect_stdout_contains "example.org $(date +%Y-%m-%d)"
+
+	run create -domain example.org -desc x -desc-template '{{.Domain}}'
+	expect_status 1
+	expect_stderr_contains "-desc-template can't be combined with -desc"
+
+	run create -domain example.org -desc-template '{{.Nope}}'
+	expect_status 1
+	expect_stderr_contains "invalid -desc-template"
+fi
+
+if begin "url field"; then
+	run -template '{{.Domain}} {{deref .URL}}' create -url shop.example.org/signup
+	expect_status 0
+	expect_stdout <<'EOF'
+shop.example.org https://shop.example.org/signup
+EOF
+	NEW=$(cut -d' ' -f1 "$WORK/stdout")
+
+	run update -email gamma.three789@fastmail.com -url https://shop.example.com/account
+	expect_status 0
+	run list -all-fields
+	expect_stdout_contains "URL"
+	expect_stdout_contains "https://shop.example.com/account"
+
+	run update -email alpha.one123@fastmail.com -url ''
+	expect_status 0
+	run -template '{{.Email}} {{deref .URL}}' show alpha.one123@fastmail.com
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com 
+EOF
+
+	run update -email alpha.one123@fastmail.com -url 'mailto:x@example.com'
+	expect_status 1
+	expect_stderr_contains "only http and https urls have a domain"
+fi
+
+if begin "create template"; then
+	run -template '{{.Email}} for {{.Domain}}' create -domain new.example
+	expect_status 0
+	expect_stdout <<'EOF'
+auto.mask1001@fastmail.com for new.example
+EOF
+fi
+
+if begin "list other account"; then
+	run -accountid u2 list
+	expect_status 0
+	expect_stdout_contains "shared.box555@fastmail.com"
+	expect_stdout_lacks "alpha.one123@fastmail.com"
+fi
+
+if begin "create"; then
+	run create -domain new.example -desc "New one"
+	expect_status 0
+	expect_stdout <<'EOF'
+auto.mask1001@fastmail.com
+EOF
+	expect_file_contains "$WORK/state/journal.jsonl" '"email":"auto.mask1001@fastmail.com"'
+
+	run list
+	expect_stdout_contains "auto.mask1001@fastmail.com  new.example             New one"
+fi
+
+if begin "create copy"; then
+	MASKEDEMAIL_CLIPBOARD="tee '$WORK/clipboard'" run create -domain example.net -copy
+	expect_status 0
+	expect_stdout <<'EOF'
+auto.mask1001@fastmail.com
+EOF
+	expect_stderr_contains "copied auto.mask1001@fastmail.com to the clipboard"
+	expect_file_contains "$WORK/clipboard" "auto.mask1001@fastmail.com"
+
+	MASKEDEMAIL_CLIPBOARD="false" run show -copy me1
+	expect_status 0
+	expect_stderr_contains "warning: could not copy to the clipboard"
+	expect_stdout_contains "alpha.one123@fastmail.com"
+fi
+
+if begin "qr code"; then
+	run create -domain example.net -qr
+	expect_status 0
+	expect_stdout_contains "auto.mask1001@fastmail.com"
+	expect_stdout_contains "██ ▄▄▄▄▄ █"
+
+	run -json show -qr me1
+	expect_status 0
+	expect_stdout_lacks "█"
+	expect_stderr_contains "██ ▄▄▄▄▄ █"
+fi
+
+if begin "create json"; then
+	run -json create -domain new.example -desc "New one" -enabled=false
+	expect_status 0
+	expect_stdout_contains '"email": "auto.mask1001@fastmail.com"'
+	expect_stdout_contains '"forDomain": "new.example"'
+	expect_stdout_contains '"state": "pending"'
+fi
+
+if begin "create idempotency key"; then
+	run create -domain new.example -idempotency-key job-1
+	expect_stdout <<'EOF'
+auto.mask1001@fastmail.com
+EOF
+	run create -domain new.example -idempotency-key job-1
+	expect_status 0
+	expect_stdout <<'EOF'
+auto.mask1001@fastmail.com
+EOF
+
+	# a deleted masked email isn't handed out again
+	run delete -f auto.mask1001@fastmail.com
+	run create -domain new.example -idempotency-key job-1
+	expect_status 1
+	expect_stderr_contains 'auto.mask1001@fastmail.com, created earlier with idempotency key "job-1", was deleted, pass a new key'
+
+	run destroy -yes auto.mask1001@fastmail.com
+	run create -domain new.example -idempotency-key job-1
+	expect_status 1
+	expect_stderr_contains 'auto.mask1001@fastmail.com, created earlier with idempotency key "job-1", no longer exists'
+fi
+
+if begin "create dry run"; then
+	run create -dry-run -domain new.example
+	expect_status 0
+	expect_stdout_contains '"MaskedEmail/set"'
+	expect_stderr_contains "dry run: would send to"
+
+	run list
+	expect_stdout_lacks "new.example"
+fi
+
+if begin "disable"; then
+	run disable alpha.one123@fastmail.com
+	expect_status 0
+	expect_stdout <<'EOF'
+disabled masked email: alpha.one123@fastmail.com for github.com (GitHub #dev)
+EOF
+
+	run -compat 1 disable gamma.three789@fastmail.com
+	expect_stdout <<'EOF'
+disabled masked email: gamma.three789@fastmail.com
+EOF
+	run -json delete -force beta.two456@fastmail.com
+	expect_stdout_contains '"forDomain": "https://www.netflix.com",'
+	expect_stdout_contains '"description": "Netflix trial",'
+
+	run list -tag dev
+	expect_stdout <<'EOF'
+Masked Email              For Domain Description State
+alpha.one123@fastmail.com github.com GitHub #dev disabled
+EOF
+fi
+
+if begin "enable"; then
+	run enable beta.two456@fastmail.com
+	expect_status 0
+	expect_stdout <<'EOF'
+enabled masked email: beta.two456@fastmail.com
+EOF
+fi
+
+if begin "delete"; then
+	# no answer to the confirmation
+	run delete gamma.three789@fastmail.com
+	expect_status 1
+	expect_stderr_contains "really delete gamma.three789@fastmail.com? [y/N]"
+	expect_stderr_contains "aborted"
+
+	run delete -f gamma.three789@fastmail.com
+	expect_status 0
+	expect_stdout <<'EOF'
+deleted masked email: gamma.three789@fastmail.com for shop.example.com (Newsletter #shopping)
+EOF
+
+	run list
+	expect_stdout_lacks "gamma.three789@fastmail.com"
+fi
+
+if begin "destroy"; then
+	# no answer to the confirmation
+	run destroy gamma.three789@fastmail.com
+	expect_status 1
+	expect_stderr_contains "This permanently destroys gamma.three789@fastmail.com"
+	expect_stderr_contains "aborted"
+
+	run_input destroy gamma.three789@fastmail.com <<'EOF'
+gamma.three789@fastmail.com
+EOF
+	expect_status 1
+	expect_stderr_contains "refusing to destroy gamma.three789@fastmail.com without confirmation, pass -yes"
+
+	run destroy -yes gamma.three789@fastmail.com
+	expect_status 0
+	expect_stdout <<'EOF'
+destroyed masked email: gamma.three789@fastmail.com for shop.example.com (Newsletter #shopping)
+EOF
+
+	# unlike delete, nothing is left to show
+	run list -show-deleted
+	expect_stdout_lacks "gamma.three789@fastmail.com"
+
+	run -json destroy -yes delta.four000@fastmail.com
+	expect_status 0
+	expect_stdout_contains '"action": "destroy"'
+
+	run -read-only destroy -yes alpha.one123@fastmail.com
+	expect_status 1
+	expect_stderr_contains "refusing to run destroy: read-only mode is enabled"
+fi
+
+if begin "disable stdin"; then
+	# three addresses need two requests with -max-objects-in-set 2
+	run_input disable - <<'EOF'
+alpha.one123@fastmail.com
+# comments and blank lines are skipped
+
+gamma.three789@fastmail.com
+beta.two456@fastmail.com
+nobody.none000@fastmail.com
+alpha.one123@fastmail.com
+EOF
+	expect_status 1
+	expect_stdout <<'EOF'
+disabled masked email: alpha.one123@fastmail.com for github.com (GitHub #dev)
+disabled masked email: gamma.three789@fastmail.com for shop.example.com (Newsletter #shopping)
+disabled masked email: beta.two456@fastmail.com for https://www.netflix.com (Netflix trial)
+EOF
+	expect_stderr_contains "failed to disable masked email nobody.none000@fastmail.com: maskedemail nobody.none000@fastmail.com not found"
+	expect_stderr_contains "1 of 4 items failed"
+	expect_file_contains "$MASKEDEMAIL_STATE_DIR/journal.jsonl" '"id":"me3"'
+
+	run list
+	expect_stdout_lacks "enabled"
+fi
+
+if begin "disable several"; then
+	run disable alpha.one123@fastmail.com gamma.three789@fastmail.com beta.two456@fastmail.com
+	expect_status 0
+	expect_stdout <<'EOF'
+disabled masked email: alpha.one123@fastmail.com for github.com (GitHub #dev)
+disabled masked email: gamma.three789@fastmail.com for shop.example.com (Newsletter #shopping)
+disabled masked email: beta.two456@fastmail.com for https://www.netflix.com (Netflix trial)
+EOF
+
+	# no answer to the confirmation
+	run delete alpha.one123@fastmail.com gamma.three789@fastmail.com
+	expect_status 1
+	expect_stderr_contains "really delete 2 masked email(s)? [y/N]"
+	expect_stderr_contains "aborted"
+
+	run delete -yes alpha.one123@fastmail.com gamma.three789@fastmail.com
+	expect_status 0
+	expect_stdout_contains "deleted masked email: gamma.three789@fastmail.com"
+
+	# without a terminal several addresses are deleted without asking, like
+	# a single one
+	echo | run_input delete beta.two456@fastmail.com delta.four000@fastmail.com
+	expect_status 0
+	expect_stdout_contains "deleted masked email: beta.two456@fastmail.com"
+	expect_stdout_contains "deleted masked email: delta.four000@fastmail.com"
+fi
+
+if begin "disable all accounts"; then
+	run disable shared.box555@fastmail.com
+	expect_status 1
+	expect_stderr_contains "maskedemail shared.box555@fastmail.com not found"
+
+	run -account-all disable shared.box555@fastmail.com alpha.one123@fastmail.com
+	expect_status 0
+	expect_stdout <<'EOF'
+disabled masked email: shared.box555@fastmail.com (account u2) for example.org (Shared account)
+disabled masked email: alpha.one123@fastmail.com for github.com (GitHub #dev)
+EOF
+	expect_file_contains "$MASKEDEMAIL_STATE_DIR/journal.jsonl" '"accountId":"u2"'
+
+	run -accountid u2 list
+	expect_stdout_contains "disabled"
+
+	run -account-all -accountid u1 disable alpha.one123@fastmail.com
+	expect_status 1
+	expect_stderr_contains "-account-all can't be combined with -accountid"
+
+	run -account-all list
+	expect_status 1
+	expect_stderr_contains "list doesn't support -account-all"
+fi
+
+if begin "delete stdin"; then
+	run_input delete - <<'EOF'
+alpha.one123@fastmail.com
+EOF
+	expect_status 1
+	expect_stderr_contains "refusing to delete 1 masked email(s) without confirmation, pass -yes"
+
+	run_input -json delete -yes - <<'EOF'
+alpha.one123@fastmail.com
+EOF
+	expect_status 0
+	expect_stdout_contains '"state": "deleted"'
+fi
+
+if begin "disable json"; then
+	run -json disable alpha.one123@fastmail.com
+	expect_status 0
+	expect_stdout_contains '"action": "disable"'
+	expect_stdout_contains '"ok": true'
+	expect_stdout_contains '"state": "disabled"'
+fi
+
+if begin "update"; then
+	run update -email alpha.one123@fastmail.com -desc "GitHub main"
+	expect_status 0
+	expect_stdout <<'EOF'
+updated alpha.one123@fastmail.com
+EOF
+
+	run list
+	expect_stdout_contains "GitHub main"
+fi
+
+if begin "rename"; then
+	run rename alpha.one123@fastmail.com GitHub main account
+	expect_status 0
+	expect_stdout <<'EOF'
+renamed alpha.one123@fastmail.com
+EOF
+
+	run -template '{{.Description}}' show alpha.one123@fastmail.com
+	expect_stdout <<'EOF'
+GitHub main account
+EOF
+
+	run rename alpha.one123@fastmail.com
+	expect_status 1
+	expect_stderr_contains "Usage: rename <maskedemail> <new description>"
+fi
+
+if begin "prune"; then
+	run prune -unused-for 36500d
+	expect_status 0
+	expect_stderr_contains "no masked emails unused for 36500d"
+
+	run -template '{{.Email}}' prune -unused-for 1d -dry-run
+	expect_status 1
+	expect_stderr_contains "prune doesn't support -template"
+
+	run prune -unused-for 1d -dry-run
+	expect_status 0
+	expect_stdout_contains "alpha.one123@fastmail.com"
+	expect_stdout_contains "gamma.three789@fastmail.com"
+	expect_stdout_lacks "beta.two456@fastmail.com"
+	expect_stderr_contains "dry run: would disable 2 masked email(s)"
+
+	run_input prune -unused-for 1d <<'EOF'
+y
+EOF
+	expect_status 1
+	expect_stderr_contains "refusing to disable 2 masked email(s) without confirmation, pass -yes"
+
+	run prune -unused-for 1d -yes
+	expect_status 0
+	expect_stdout_contains "disabled masked email: alpha.one123@fastmail.com"
+	expect_stdout_contains "disabled masked email: gamma.three789@fastmail.com"
+
+	run prune -unused-for 1d -action delete -dry-run
+	expect_status 0
+	expect_stderr_contains "dry run: would delete 3 masked email(s)"
+
+	run prune -unused-for 1d -action destroy
+	expect_status 1
+	expect_stderr_contains 'unsupported -action "destroy"'
+fi
+
+if begin "update prefix"; then
+	run update -email alpha.one123@fastmail.com -prefix shop
+	expect_status 1
+	expect_stderr_contains "emailPrefix is immutable"
+
+	run update -email alpha.one123@fastmail.com -prefix "Not Valid"
+	expect_status 1
+	expect_stderr_contains "may only contain a-z, 0-9 and _"
+fi
+
+if begin "search"; then
+	run search -domain NETFLIX
+	expect_status 0
+	expect_stdout <<'EOF'
+Masked Email             For Domain              Description   State
+beta.two456@fastmail.com https://www.netflix.com Netflix trial disabled
+EOF
+
+	run search -state enabled shop
+	expect_status 0
+	expect_stdout_contains "gamma.three789@fastmail.com"
+	expect_stdout_lacks "alpha.one123@fastmail.com"
+
+	run -template '{{.Email}}' search -state deleted
+	expect_status 0
+	expect_stdout <<'EOF'
+delta.four000@fastmail.com
+EOF
+
+	run search -state archived
+	expect_status 1
+	expect_stderr_contains 'unknown state "archived"'
+fi
+
+if begin "show"; then
+	run show alpha.one123@fastmail.com
+	expect_status 0
+	expect_stdout <<'EOF'
+Masked Email:  alpha.one123@fastmail.com
+ID:            me1
+State:         enabled
+For Domain:    github.com
+Description:   GitHub #dev
+URL:           https://github.com/signup
+Created By:    maskedemail-cli
+Created At:    2023-01-05T10:00:00Z
+Last Email At: 2024-05-01T09:00:00Z
+EOF
+fi
+
+if begin "show relative time"; then
+	run -relative-time show alpha.one123@fastmail.com
+	expect_status 0
+	expect_stdout_contains " years ago"
+	expect_stdout_lacks "2023-01-05"
+
+	MASKEDEMAIL_RELATIVE_TIME=1 run list -columns email,lastMessageAt
+	expect_status 0
+	expect_stdout_contains "ago"
+
+	run -relative-time -json show alpha.one123@fastmail.com
+	expect_stdout_contains '"createdAt": "2023-01-05T10:00:00Z"'
+fi
+
+if begin "show by id"; then
+	run annotate beta.two456@fastmail.com "cancelled in March"
+	run show me2
+	expect_status 0
+	expect_stdout_contains "Masked Email:  beta.two456@fastmail.com"
+	expect_stdout_contains "Last Email At: -"
+	expect_stdout_contains "Note:          cancelled in March"
+
+	run -json show me2
+	expect_status 0
+	expect_stdout_contains '"lastMessageAt": null'
+
+	run -template '{{.ID}} {{.State}}' show gamma.three789@fastmail.com
+	expect_status 0
+	expect_stdout <<'EOF'
+me3 enabled
+EOF
+fi
+
+if begin "show unknown"; then
+	run show me999
+	expect_status 1
+	expect_stderr_contains "maskedemail with id me999 not found"
+fi
+
+if begin "transfer"; then
+	run transfer -to u2 -yes alpha.one123@fastmail.com
+	expect_status 0
+	expect_stdout <<'EOF'
+created masked email: auto.mask1001@fastmail.com (account u2)
+disabled masked email: alpha.one123@fastmail.com (account u1)
+EOF
+	expect_stderr_contains "the address itself can't move"
+
+	run -accountid u2 -template '{{.Email}} {{.Domain}} {{.Description}}' list
+	expect_stdout_contains "auto.mask1001@fastmail.com github.com GitHub #dev"
+
+	run transfer -to u1 alpha.one123@fastmail.com
+	expect_status 1
+	expect_stderr_contains "already in account u1"
+
+	run transfer -to u2 alpha.one123@fastmail.com
+	expect_status 1
+	expect_stderr_contains "aborted"
+fi
+
+if begin "invalid address"; then
+	run disable not-an-address
+	expect_status 1
+	expect_stderr_contains "is not an email address"
+fi
+
+if begin "copied addresses"; then
+	run -template '{{.ID}}' show "mailto:Alpha.One123@fastmail.com?subject=Hello"
+	expect_status 0
+	expect_stdout <<'EOF'
+me1
+EOF
+
+	run disable '"GitHub" <alpha.one123@fastmail.com>' mailto:gamma.three789%40fastmail.com
+	expect_status 0
+	expect_stdout_contains "disabled masked email: alpha.one123@fastmail.com"
+	expect_stdout_contains "disabled masked email: gamma.three789@fastmail.com"
+
+	run update -email '<beta.two456@fastmail.com>' -desc "copied"
+	expect_status 0
+	run -template '{{.Description}}' show me2
+	expect_stdout <<'EOF'
+copied
+EOF
+fi
+
+if begin "unknown masked email"; then
+	run disable nobody.here1@fastmail.com
+	expect_status 1
+	expect_stderr_contains "maskedemail nobody.here1@fastmail.com not found"
+fi
+
+if begin "read only"; then
+	run -read-only disable alpha.one123@fastmail.com
+	expect_status 1
+	expect_stderr_contains "read-only mode is enabled"
+
+	run -read-only list
+	expect_status 0
+
+	# dry runs change nothing, so they're allowed
+	run -read-only create -dry-run -domain example.org
+	expect_status 0
+	expect_stdout_contains "MaskedEmail/set"
+
+	run -read-only prune -unused-for 30d -dry-run
+	expect_status 0
+	expect_stderr_contains "dry run: would disable"
+
+	run -read-only create -dry-run=false -domain example.org
+	expect_status 1
+	expect_stderr_contains "refusing to run create: read-only mode is enabled"
+
+	# -dry-run as the value of another flag isn't a dry run
+	run -read-only create -desc -dry-run -domain example.org
+	expect_status 1
+	expect_stderr_contains "refusing to run create: read-only mode is enabled"
+
+	# a dry run from the commandFlags of the config counts, unless the
+	# command line turns it off
+	printf '{"commandFlags": {"create": "-dry-run"}}\n' >"$MASKEDEMAIL_CONFIG"
+	run -read-only create -domain example.org
+	expect_status 0
+	expect_stdout_contains "MaskedEmail/set"
+
+	run -read-only create -dry-run=false -domain example.org
+	expect_status 1
+	expect_stderr_contains "refusing to run create: read-only mode is enabled"
+	rm "$MASKEDEMAIL_CONFIG"
+
+	run list -plain -state enabled
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com
+gamma.three789@fastmail.com
+EOF
+fi
+
+if begin "bad token"; then
+	run -token wrong list
+	expect_status 1
+	expect_stderr_contains "401"
+fi
+
+if begin "unsupported output format"; then
+	run -output yaml list
+	expect_status 1
+	expect_stderr_contains 'unsupported output format "yaml"'
+fi
+
+if begin "count"; then
+	run count
+	expect_status 0
+	expect_stdout <<'EOF'
+3
+EOF
+
+	run count -state enabled -domain github.com
+	expect_stdout <<'EOF'
+1
+EOF
+
+	run count -show-deleted
+	expect_stdout <<'EOF'
+4
+EOF
+
+	run count -never-used
+	expect_stdout <<'EOF'
+2
+EOF
+
+	run count -state bogus
+	expect_status 1
+
+	run count extra
+	expect_status 1
+	expect_stderr_contains "Usage: count"
+fi
+
+if begin "dupes"; then
+	run dupes
+	expect_status 0
+	expect_stdout </dev/null
+	expect_stderr_contains "no domain has more than one active masked email"
+
+	run create -domain https://www.github.com/login -desc "second"
+	expect_status 0
+	run create -domain netflix.com -desc "again"
+	expect_status 0
+
+	run dupes
+	expect_status 0
+	expect_stdout_contains "github.com (2)"
+	expect_stdout_contains "  alpha.one123@fastmail.com"
+	expect_stdout_contains "second"
+	expect_stdout_lacks "netflix.com"
+
+	run -output json dupes
+	expect_status 0
+	expect_stdout_contains '"domain": "github.com"'
+
+	run dupes extra
+	expect_status 1
+	expect_stderr_contains "Usage: dupes"
+fi
+
+if begin "find for domain"; then
+	run find-for-domain https://www.github.com/login
+	expect_status 0
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com
+EOF
+
+	# beta is the only one for netflix and disabled, still better than none
+	run find-for-domain netflix.com
+	expect_status 0
+	expect_stdout <<'EOF'
+beta.two456@fastmail.com
+EOF
+
+	# a new enabled one wins over the disabled one
+	run create -domain netflix.com
+	expect_status 0
+	NEW=$(cat "$WORK/stdout")
+	run find-for-domain netflix.com
+	expect_stdout_contains "$NEW"
+
+	# delta is deleted
+	run find-for-domain example.com
+	expect_status 1
+	expect_stdout </dev/null
+	expect_stderr_contains "no masked email for example.com"
+
+	run -output json find-for-domain github.com
+	expect_status 0
+	expect_stdout_contains '"email": "alpha.one123@fastmail.com"'
+
+	run find-for-domain
+	expect_status 1
+	expect_stderr_contains "Usage: find-for-domain <domain>"
+fi
+
+if begin "match"; then
+	run disable -match xyzzy
+	expect_status 1
+	expect_stderr_contains 'no masked email matches "xyzzy"'
+
+	# the letters in order are enough
+	run enable -match ntflx
+	expect_status 0
+	expect_stdout_contains "beta.two456@fastmail.com"
+
+	run disable -match "github #dev"
+	expect_status 0
+	expect_stdout_contains "alpha.one123@fastmail.com"
+
+	# example.com is delta's domain, but delta is deleted, shop.example.com is next
+	run disable -match example.com
+	expect_status 0
+	expect_stdout_contains "gamma.three789@fastmail.com"
+
+	run create -domain github.com -desc "GitHub work"
+	expect_status 0
+	run disable -match github
+	expect_status 1
+	expect_stderr_contains '2 masked emails match "github"'
+	expect_stderr_contains "alpha.one123@fastmail.com"
+
+	# an exact domain is better than a description containing the text
+	run create -domain hub.com -desc "other"
+	expect_status 0
+	NEW=$(cat "$WORK/stdout")
+	run delete -force -match hub.com
+	expect_status 0
+	expect_stdout_contains "$NEW"
+
+	run disable -match github alpha.one123@fastmail.com
+	expect_status 1
+	expect_stderr_contains "-match can't be combined with addresses"
+fi
+
+if begin "ids"; then
+	run disable me1
+	expect_status 0
+	expect_stdout_contains "alpha.one123@fastmail.com"
+	run show me1
+	expect_stdout_contains "disabled"
+
+	run enable me1 beta.two456@fastmail.com
+	expect_status 0
+	expect_stdout_contains "alpha.one123@fastmail.com"
+	expect_stdout_contains "beta.two456@fastmail.com"
+
+	run update -email me3 -desc "by id"
+	expect_status 0
+	expect_stdout <<'EOF'
+updated gamma.three789@fastmail.com
+EOF
+	run show gamma.three789@fastmail.com
+	expect_stdout_contains "by id"
+
+	run delete -force me2
+	expect_status 0
+	expect_stdout_contains "beta.two456@fastmail.com"
+
+	run disable me999
+	expect_status 1
+	expect_stderr_contains "maskedemail with id me999 not found"
+
+	run disable me999 me1
+	expect_status 1
+	expect_stderr_contains "maskedemail with id me999 not found"
+	expect_stdout_contains "alpha.one123@fastmail.com"
+fi
+
+if begin "regex"; then
+	run list -plain -regex '^(github|shop)\.'
+	expect_status 0
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com
+gamma.three789@fastmail.com
+EOF
+
+	run count -regex '(?i)^github #'
+	expect_stdout <<'EOF'
+1
+EOF
+
+	run search -regex 'netflix' -state disabled
+	expect_status 0
+	expect_stdout_contains "beta.two456@fastmail.com"
+	expect_stdout_lacks "alpha.one123@fastmail.com"
+
+	run list -regex '('
+	expect_status 1
+	expect_stderr_contains "invalid -regex"
+
+	# beta is disabled already and delta deleted, so only alpha and gamma change
+	run disable -dry-run -regex '.'
+	expect_status 0
+	expect_stderr_contains '2 masked email(s) to disable match ".":'
+	expect_stderr_contains "dry run: would disable 2 masked email(s)"
+	expect_stdout_contains "alpha.one123@fastmail.com"
+	expect_stdout_contains "gamma.three789@fastmail.com"
+	expect_stdout_lacks "beta.two456@fastmail.com"
+
+	run_input disable -regex '.' <<'EOF'
+y
+EOF
+	expect_status 1
+	expect_stderr_contains "refusing to disable 2 masked email(s) without confirmation, pass -yes"
+
+	run list -plain -state disabled
+	expect_stdout <<'EOF'
+beta.two456@fastmail.com
+EOF
+
+	run disable -yes -regex '.'
+	expect_status 0
+	expect_stdout_contains "alpha.one123@fastmail.com"
+	expect_stdout_contains "gamma.three789@fastmail.com"
+	expect_stdout_lacks "beta.two456@fastmail.com"
+	expect_stdout_lacks "delta.four000@fastmail.com"
+
+	run disable -regex '.'
+	expect_status 0
+	expect_stderr_contains 'no masked emails to disable match "."'
+
+	# enabling can be undone, so it never asks to type the count
+	run -confirm-threshold 0 enable -regex '.'
+	expect_status 1
+	expect_stderr_contains "really enable 3 masked email(s)? [y/N]"
+	expect_stderr_contains "aborted"
+
+	run_input delete -regex 'example' <<'EOF'
+y
+EOF
+	expect_status 1
+	expect_stderr_contains "refusing to delete 1 masked email(s) without confirmation, pass -yes"
+
+	run delete -yes -regex 'example'
+	expect_status 0
+	expect_stdout_contains "gamma.three789@fastmail.com"
+
+	run enable -regex '.' alpha.one123@fastmail.com
+	expect_status 1
+	expect_stderr_contains "-regex can't be combined with addresses"
+
+	run enable -dry-run alpha.one123@fastmail.com
+	expect_status 1
+	expect_stderr_contains "-dry-run only applies to -regex"
+fi
+
+if begin "list jsonl"; then
+	run list -format jsonl -sort email
+	expect_status 0
+	if [ "$(wc -l <"$WORK/stdout")" -ne 3 ]; then
+		fail "list -format jsonl: want 3 lines"
+	fi
+	head -n 1 "$WORK/stdout" | grep -q '^{"id":"me1",.*"email":"alpha.one123@fastmail.com".*}$' || fail "list -format jsonl: first line isn't alpha as compact JSON"
+	expect_stdout_lacks "["
+
+	# without -sort the masked emails are streamed in the order of the server
+	run list -format jsonl
+	expect_status 0
+	[ "$(sed -n 's/^{"id":"\([^"]*\)".*/\1/p' "$WORK/stdout" | tr '\n' ' ')" = "me1 me2 me3 " ] ||
+		fail "list -format jsonl: want me1 me2 me3 in server order"
+
+	# sorting buffers them all first
+	run list -format jsonl -reverse
+	expect_status 0
+	head -n 1 "$WORK/stdout" | grep -q '^{"id":"me3",' || fail "list -format jsonl -reverse: first line isn't me3"
+
+	run -json list -format jsonl -state disabled
+	expect_status 0
+	expect_stdout_contains '"email":"beta.two456@fastmail.com"'
+
+	run list -format jsonl -columns email
+	expect_status 1
+	expect_stderr_contains "-columns can't be combined with -format jsonl"
+fi
+
+if begin "export"; then
+	run export -file "$WORK/backup.json"
+	expect_status 0
+	expect_stdout </dev/null
+	expect_stderr_contains "exported 4 masked email(s) of u1 to $WORK/backup.json"
+	expect_file_contains "$WORK/backup.json" '"version": 1'
+	expect_file_contains "$WORK/backup.json" '"accountId": "u1"'
+	expect_file_contains "$WORK/backup.json" '"count": 4'
+	expect_file_contains "$WORK/backup.json" '"email": "delta.four000@fastmail.com"'
+	[ ! -e "$WORK/backup.json.tmp" ] || fail "export left the temporary file"
+	# oldest first
+	grep -o '"email": "[a-z]*' "$WORK/backup.json" | head -n 1 | grep -q delta || fail "export: delta isn't first"
+
+	run export
+	expect_status 0
+	expect_stdout_contains '"maskedEmails": ['
+
+	run export extra
+	expect_status 1
+	expect_stderr_contains "Usage: export [-file <path>]"
+fi
+
+if begin "import"; then
+	run export -file "$WORK/backup.json"
+	expect_status 0
+
+	# into the same account everything exists already
+	run import -file "$WORK/backup.json"
+	expect_status 0
+	expect_stdout_contains "skipped masked email: alpha.one123@fastmail.com for github.com (GitHub #dev)"
+	expect_stdout_lacks "created"
+	expect_stdout_lacks "delta.four000@fastmail.com"
+	expect_stderr_contains "created 0, skipped 3 existing masked email(s)"
+
+	run -accountid u2 import -file "$WORK/backup.json" -dry-run
+	expect_status 0
+	expect_stderr_contains "dry run: would create a disabled masked email for https://www.netflix.com"
+	run -accountid u2 count
+	expect_stdout <<'EOF'
+1
+EOF
+
+	run -accountid u2 import -file "$WORK/backup.json"
+	expect_status 0
+	expect_stdout_contains "created masked email: auto.mask1002@fastmail.com for github.com (GitHub #dev)"
+	expect_stderr_contains "created 3, skipped 0 existing masked email(s)"
+	run -accountid u2 -template '{{.Domain}} {{.State}}' list -state disabled
+	expect_stdout <<'EOF'
+https://www.netflix.com disabled
+EOF
+
+	# running it again creates nothing, the domains and descriptions match
+	run -accountid u2 import -file "$WORK/backup.json"
+	expect_status 0
+	expect_stderr_contains "created 0, skipped 3 existing masked email(s)"
+
+	cat >"$WORK/aliases.csv" <<'EOF'
+email,domain,desc,state
+,csv.example.com,From CSV,pending
+old.one@fastmail.com,github.com,GitHub #dev,enabled
+EOF
+	run -json import -file "$WORK/aliases.csv"
+	expect_status 0
+	expect_stdout_contains '"action": "create"'
+	expect_stdout_contains '"forDomain": "csv.example.com"'
+	expect_stdout_contains '"action": "skip"'
+
+	# addresses match whatever casing the server and the file use, the
+	# domain alone wouldn't
+	cat >"$WORK/cased-seed.json" <<'EOF'
+{"u1": [{"id": "me1", "email": "Mixed.Case123@fastmail.com", "state": "enabled", "forDomain": "example.com", "createdAt": "2023-01-05T10:00:00Z"}]}
+EOF
+	"$WORK/fakejmap" -seed "$WORK/cased-seed.json" >"$WORK/fakejmap2.log" 2>&1 &
+	SERVER2_PID=$!
+	sleep 0.5
+	cat >"$WORK/cased.csv" <<'EOF'
+email,domain,desc,state
+mixed.CASE123@fastmail.com,renamed.example.com,Renamed,enabled
+EOF
+	MASKEDEMAIL_SESSION_URL=$(head -n 1 "$WORK/fakejmap2.log") run import -file "$WORK/cased.csv"
+	expect_status 0
+	expect_stderr_contains "created 0, skipped 1 existing masked email(s)"
+	kill "$SERVER2_PID"
+
+	echo '{"version": 99, "maskedEmails": []}' >"$WORK/future.json"
+	run import -file "$WORK/future.json"
+	expect_status 1
+	expect_stderr_contains "unsupported backup version 99"
+
+	run import
+	expect_status 1
+	expect_stderr_contains "Usage: import -file"
+fi
+
+if begin "restore"; then
+	run export -file "$WORK/backup.json"
+	expect_status 0
+
+	run delete -yes alpha.one123@fastmail.com beta.two456@fastmail.com
+	expect_status 0
+	run destroy -yes gamma.three789@fastmail.com
+	expect_status 0
+
+	run restore -from "$WORK/backup.json" -email alpha.one123@fastmail.com -dry-run
+	expect_status 0
+	expect_stderr_contains "dry run: would enable alpha.one123@fastmail.com, it's deleted"
+	run show alpha.one123@fastmail.com
+	expect_stdout_contains "deleted"
+
+	run restore -from "$WORK/backup.json" -email alpha.one123@fastmail.com,beta.two456@fastmail.com
+	expect_status 0
+	expect_stdout <<'EOF'
+disabled masked email: beta.two456@fastmail.com for https://www.netflix.com (Netflix trial)
+enabled masked email: alpha.one123@fastmail.com for github.com (GitHub #dev)
+EOF
+	run show beta.two456@fastmail.com
+	expect_stdout_contains "disabled"
+
+	# gamma is gone, so it's recreated with a new address
+	run restore -from "$WORK/backup.json" -domain example.com
+	expect_status 0
+	expect_stdout_contains "created masked email: auto.mask1001@fastmail.com for shop.example.com"
+	expect_stderr_contains "gamma.three789@fastmail.com doesn't exist anymore, recreated it as auto.mask1001@fastmail.com"
+
+	run restore -from "$WORK/backup.json" -email alpha.one123@fastmail.com
+	expect_status 0
+	expect_stdout_contains "skipped masked email: alpha.one123@fastmail.com"
+
+	run restore -from "$WORK/backup.json" -email delta.four000@fastmail.com -email nope.x1@fastmail.com
+	expect_status 1
+	expect_stderr_contains "delta.four000@fastmail.com: it was deleted already in the backup"
+	expect_stderr_contains "nope.x1@fastmail.com: not in the backup"
+
+	run restore -from "$WORK/backup.json" -domain nowhere.example
+	expect_status 1
+	expect_stderr_contains "no masked emails for nowhere.example in the backup"
+
+	run restore -from "$WORK/backup.json"
+	expect_status 1
+	expect_stderr_contains "Usage: restore -from"
+
+	# the server's casing of an address doesn't matter either
+	cat >"$WORK/cased-seed.json" <<'EOF'
+{"u1": [{"id": "me1", "email": "Mixed.Case123@fastmail.com", "state": "disabled", "forDomain": "example.com", "createdAt": "2023-01-05T10:00:00Z"}]}
+EOF
+	"$WORK/fakejmap" -seed "$WORK/cased-seed.json" >"$WORK/fakejmap2.log" 2>&1 &
+	SERVER2_PID=$!
+	sleep 0.5
+	SESSION2_URL=$(head -n 1 "$WORK/fakejmap2.log")
+	MASKEDEMAIL_SESSION_URL=$SESSION2_URL run export -file "$WORK/cased.json"
+	expect_status 0
+	MASKEDEMAIL_SESSION_URL=$SESSION2_URL run restore -from "$WORK/cased.json" -email mixed.case123@fastmail.com
+	expect_status 0
+	expect_stdout_contains "skipped masked email"
+	expect_stdout_lacks "created"
+	kill "$SERVER2_PID"
+fi
+
+if begin "stats"; then
+	run stats
+	expect_status 0
+	expect_stdout <<'EOF'
+Total      4
+  deleted  1
+  disabled 1
+  enabled  2
+Never used 2
+Oldest     beta.two456@fastmail.com (created 2022-03-01T10:00:00Z)
+Newest     gamma.three789@fastmail.com (created 2024-06-01T10:00:00Z)
+Last email alpha.one123@fastmail.com (2024-05-01T09:00:00Z)
+Top domains
+  github.com       1
+  netflix.com      1
+  shop.example.com 1
+EOF
+
+	run stats -format json
+	expect_stdout_contains '"neverUsed": 2'
+	expect_stdout_contains '"domain": "github.com"'
+	expect_stdout_contains '"time": "2024-05-01T09:00:00Z"'
+
+fi
+
+if begin "stats json"; then
+	run stats -format json
+	expect_status 0
+	expect_stdout_contains '"total": 4'
+	expect_stdout_contains '"enabled": 2'
+fi
+
+if begin "stats prometheus"; then
+	run stats -format prometheus
+	expect_status 0
+	expect_stdout <<'EOF'
+# HELP maskedemail_total Number of masked emails by state.
+# TYPE maskedemail_total gauge
+maskedemail_total{account="u1",state="deleted"} 1
+maskedemail_total{account="u1",state="disabled"} 1
+maskedemail_total{account="u1",state="enabled"} 2
+maskedemail_total{account="u1",state="pending"} 0
+EOF
+
+	run stats -format prometheus -activity
+	expect_status 1
+fi
+
+if begin "sync contacts"; then
+	run sync-contacts
+	expect_status 1
+	expect_stderr_contains 'no address book configured for profile default'
+
+	cat >"$MASKEDEMAIL_CONFIG" <<EOF
+{"addressBook": {"url": "${SESSION_URL%/jmap/session}/carddav/book", "username": "test", "password": "test-password"}}
+EOF
+	run sync-contacts
+	expect_status 0
+	expect_stderr_contains "contacts: 2 created, 0 updated, 0 unchanged, 0 removed"
+
+	run update -email alpha.one123@fastmail.com -desc 'GitHub, "work"; #dev'
+	run sync-contacts
+	expect_status 0
+	expect_stderr_contains "contacts: 0 created, 1 updated, 1 unchanged, 0 removed"
+
+	# the contact of a deleted masked email stays until -prune, the friend
+	# of the address book isn't one of ours and is never removed
+	run delete -f gamma.three789@fastmail.com
+	run sync-contacts
+	expect_stderr_contains "contacts: 0 created, 0 updated, 1 unchanged, 0 removed"
+	run sync-contacts -prune -dry-run
+	expect_status 0
+	expect_stdout <<'EOF'
+dry run: would remove gamma.three789@fastmail.com
+EOF
+	run sync-contacts -prune
+	expect_status 0
+	expect_stderr_contains "contacts: 0 created, 0 updated, 1 unchanged, 1 removed"
+	run sync-contacts -prune
+	expect_stderr_contains "contacts: 0 created, 0 updated, 1 unchanged, 0 removed"
+
+	printf '{"addressBook": {"url": "%s/carddav/book/", "username": "test", "password": "wrong"}}\n' "${SESSION_URL%/jmap/session}" >"$MASKEDEMAIL_CONFIG"
+	run sync-contacts
+	expect_status 1
+	expect_stderr_contains "401 Unauthorized"
+fi
+
+if begin "debug bundle"; then
+	run disable alpha.one123@fastmail.com
+	run create -domain secret-shop.example -desc "Private hobby"
+	expect_status 0
+	run -token wrong list
+	expect_status 1
+	run debug-bundle -file "$WORK/debug.tar.gz"
+	expect_status 0
+	expect_stderr_contains "wrote version.json, session.json, journal.jsonl, last-failure.json, config.json"
+	tar -xzOf "$WORK/debug.tar.gz" last-failure.json >"$WORK/last-failure.json"
+	tar -xzOf "$WORK/debug.tar.gz" journal.jsonl >"$WORK/journal.jsonl"
+	expect_file_contains "$WORK/last-failure.json" '"status": "401 Unauthorized"'
+	expect_file_contains "$WORK/journal.jsonl" '"email":"redacted@fastmail.com"'
+	expect_file_contains "$WORK/journal.jsonl" '"command":"maskedemail-cli create"'
+	if tar -xzOf "$WORK/debug.tar.gz" | grep -q -e alpha.one123 -e test-token; then
+		fail "debug bundle contains an address or token"
+	fi
+	if tar -xzOf "$WORK/debug.tar.gz" | grep -q -e secret-shop -e "Private hobby"; then
+		fail "debug bundle contains a domain or description"
+	fi
+fi
+
+if begin "state bundle"; then
+	cat >"$MASKEDEMAIL_CONFIG" <<'EOF'
+{
+  "token": "test-token",
+  "profiles": {"work": {"token": "work-token", "accountId": "u2"}},
+  "views": {"unused": "-where 'lastMessageAt == null'"}
+}
+EOF
+	run disable alpha.one123@fastmail.com
+	run annotate alpha.one123@fastmail.com "old note"
+	run state export -file "$WORK/bundle.tar.gz"
+	expect_status 0
+	expect_stderr_contains "exported the config (without tokens) and 2 state file(s)"
+	if tar -xzOf "$WORK/bundle.tar.gz" config.json | grep -q token; then
+		fail "bundle contains a token"
+	fi
+
+	# a different machine with its own history
+	rm -rf "$MASKEDEMAIL_STATE_DIR" "$MASKEDEMAIL_CONFIG"
+	run enable beta.two456@fastmail.com
+	run annotate alpha.one123@fastmail.com "newer note"
+	printf '{"token": "local-token", "views": {"unused": "-sort age"}}\n' >"$MASKEDEMAIL_CONFIG"
+
+	run state import "$WORK/bundle.tar.gz"
+	expect_status 0
+	expect_stderr_contains "config: added 1 profile(s) and view(s)"
+	expect_stderr_contains "journal.jsonl: added 1 entries"
+	expect_stderr_contains "notes.json: nothing new"
+	expect_file_contains "$MASKEDEMAIL_CONFIG" '"token": "local-token"'
+	expect_file_contains "$MASKEDEMAIL_CONFIG" '"accountId": "u2"'
+	expect_file_contains "$MASKEDEMAIL_CONFIG" '"unused": "-sort age"'
+	expect_file_contains "$MASKEDEMAIL_STATE_DIR/journal.jsonl" '"action":"disable"'
+	expect_file_contains "$MASKEDEMAIL_STATE_DIR/journal.jsonl" '"action":"enable"'
+
+	run -token test-token annotate alpha.one123@fastmail.com
+	expect_stdout <<'EOF'
+newer note
+EOF
+
+	run state import "$WORK/bundle.tar.gz"
+	expect_stderr_contains "journal.jsonl: nothing new"
+fi
+
+if begin "tags"; then
+	run tag add alpha.one123@fastmail.com work
+	expect_status 0
+
+	run tag list alpha.one123@fastmail.com
+	expect_stdout <<'EOF'
+dev
+work
+EOF
+fi
+
+if begin "strict"; then
+	run enable alpha.one123@fastmail.com
+	expect_status 0
+	expect_stderr_contains "warning: alpha.one123@fastmail.com is already enabled"
+
+	run -strict enable alpha.one123@fastmail.com
+	expect_status 1
+	expect_stderr_contains "alpha.one123@fastmail.com is already enabled (-strict)"
+
+	run -strict disable alpha.one123@fastmail.com beta.two456@fastmail.com
+	expect_status 1
+	expect_stderr_contains "beta.two456@fastmail.com is already disabled (-strict)"
+	run show alpha.one123@fastmail.com
+	expect_stdout_contains "enabled"
+
+	run -strict show alpha.one123@fastmail.com -qr
+	expect_status 1
+	expect_stderr_contains "show: -qr after the arguments is not read as a flag"
+
+	run show -- alpha.one123@fastmail.com
+	expect_status 0
+
+	run -strict update -email alpha.one123@fastmail.com
+	expect_status 1
+	expect_stderr_contains "nothing to update for alpha.one123@fastmail.com"
+
+	run -strict tag add alpha.one123@fastmail.com dev
+	expect_status 1
+	expect_stderr_contains "tags of alpha.one123@fastmail.com unchanged (-strict)"
+fi
+
+if begin "config defaults"; then
+	cat >"$MASKEDEMAIL_CONFIG" <<'EOF'
+{
+  "accountId": "u2",
+  "output": "json",
+  "flags": "-template '{{.Email}}'"
+}
+EOF
+	MASKEDEMAIL_ACCOUNTID=u1 run list
+	expect_status 0
+	expect_stdout <<'EOF'
+shared.box555@fastmail.com
+EOF
+
+	run -accountid u1 -output text list
+	expect_status 0
+	expect_stdout_contains "alpha.one123@fastmail.com"
+
+	printf '{"output": "json"}\n' >"$MASKEDEMAIL_CONFIG"
+	run session
+	expect_stdout_contains '"id": "u1"'
+	run -output text session
+	expect_stdout_lacks '"id"'
+
+	printf '{"flags": "-timeout 1m list"}\n' >"$MASKEDEMAIL_CONFIG"
+	run session
+	expect_status 1
+	expect_stderr_contains 'error in flags of config: unexpected argument "list"'
+fi
+
+if begin "command defaults"; then
+	cat >"$MASKEDEMAIL_CONFIG" <<'EOF'
+{
+  "commandFlags": {
+    "list": "-view unused -format csv"
+  },
+  "views": {
+    "unused": "-where 'lastMessageAt == null'"
+  }
+}
+EOF
+	run list
+	expect_status 0
+	expect_stdout_contains "beta.two456@fastmail.com,https://www.netflix.com"
+	expect_stdout_lacks "alpha.one123@fastmail.com"
+
+	# the command line wins over the defaults and the view
+	run list -format text -where 'state == "disabled"'
+	expect_status 0
+	expect_stdout <<'EOF'
+Masked Email             For Domain              Description   State
+beta.two456@fastmail.com https://www.netflix.com Netflix trial disabled
+EOF
+
+	printf '{"commandFlags": {"list": "-sort idle alpha"}}\n' >"$MASKEDEMAIL_CONFIG"
+	run list
+	expect_status 1
+	expect_stderr_contains 'error in commandFlags.list of config: unexpected argument "alpha"'
+
+	printf '{"commandFlags": {"list": "-nope"}}\n' >"$MASKEDEMAIL_CONFIG"
+	run list
+	expect_status 1
+	expect_stderr_contains "error in commandFlags.list of config: flag provided but not defined: -nope"
+fi
+
+if begin "secret providers"; then
+	printf '{"tokenFrom": {"provider": "exec", "command": "echo test-token"}}\n' >"$MASKEDEMAIL_CONFIG"
+	MASKEDEMAIL_TOKEN=wrong-token run show me1
+	expect_status 0
+	expect_stdout_contains "alpha.one123@fastmail.com"
+
+	printf '{"tokenFrom": {"provider": "exec", "command": "false"}}\n' >"$MASKEDEMAIL_CONFIG"
+	run show me1
+	expect_status 1
+	expect_stderr_contains "error fetching token: exec: false: exit status 1"
+
+	printf '{"tokenFrom": {"provider": "vault"}}\n' >"$MASKEDEMAIL_CONFIG"
+	VAULT_ADDR= run show me1
+	expect_status 1
+	expect_stderr_contains "error fetching token: vault: address or \$VAULT_ADDR is required"
+
+	printf '{"tokenFrom": {"provider": "keychain"}}\n' >"$MASKEDEMAIL_CONFIG"
+	run show me1
+	expect_status 1
+	expect_stderr_contains 'unknown secret provider "keychain" (aws-secretsmanager, exec, keyring, oauth, vault)'
+fi
+
+if begin "create from message"; then
+	run_input -json create -from-message <<'EOF'
+Return-Path: <bounce@mail.example.co.uk>
+From: "Example Team" <hello@mail.example.co.uk>
+To: someone@fastmail.com
+Subject: =?UTF-8?Q?Re:_Willkommen_bei_Example_=E2=9C=93?=
+
+Thanks for signing up!
+EOF
+	expect_status 0
+	expect_stdout_contains '"email": "auto.mask1001@fastmail.com"'
+	expect_stdout_contains '"forDomain": "example.co.uk"'
+	expect_stdout_contains '"description": "re: Willkommen bei Example ✓"'
+
+	run_input create -from-message -desc "newsletter" <<'EOF'
+From: news@example.org
+EOF
+	expect_status 0
+	run show auto.mask1002@fastmail.com
+	expect_stdout_contains "example.org"
+	expect_stdout_contains "newsletter"
+
+	run_input create -from-message <<'EOF'
+Subject: no sender
+EOF
+	expect_status 1
+	expect_stderr_contains "error reading message: the message has no From header"
+fi
+
+if begin "profiles"; then
+	cat >"$MASKEDEMAIL_CONFIG" <<'EOF'
+{
+  "accountId": "u1",
+  "profiles": {
+    "work": {"accountId": "u2"},
+    "broken": {"token": "wrong-token"}
+  }
+}
+EOF
+	run -template '{{.Email}}' -profile work list
+	expect_status 0
+	expect_stdout <<'EOF'
+shared.box555@fastmail.com
+EOF
+
+	MASKEDEMAIL_PROFILE=work run -template '{{.Email}}' list
+	expect_stdout <<'EOF'
+shared.box555@fastmail.com
+EOF
+
+	MASKEDEMAIL_PROFILE=work run -template '{{.Email}}' -profile "" list
+	expect_stdout_contains "alpha.one123@fastmail.com"
+
+	run -profile broken list
+	expect_status 1
+
+	run -profile home list
+	expect_status 1
+	expect_stderr_contains 'profile "home" not found in config (broken, work)'
+fi
+
+# use_fake_keyring puts a stand-in for secret-tool keeping the secrets in
+# files first in PATH, until PATH is restored from OLD_PATH.
+use_fake_keyring() {
+	mkdir -p "$WORK/bin" "$WORK/keyring"
+	cat >"$WORK/bin/secret-tool" <<'EOF'
+#!/bin/sh
+case "$1" in
+store) cat >"$FAKE_KEYRING/$7" ;;
+lookup) cat "$FAKE_KEYRING/$5" 2>/dev/null || exit 1 ;;
+clear) rm "$FAKE_KEYRING/$5" ;;
+esac
+EOF
+	chmod +x "$WORK/bin/secret-tool"
+	export FAKE_KEYRING=$WORK/keyring
+	OLD_PATH=$PATH
+	PATH=$WORK/bin:$PATH
+}
+
+if begin "keyring"; then
+	use_fake_keyring
+
+	printf '{"token": "test-token", "profiles": {"work": {"accountId": "u2"}}}\n' >"$MASKEDEMAIL_CONFIG"
+	run_input -profile work auth set-token <<'EOF'
+test-token
+EOF
+	expect_status 0
+	expect_stdout <<'EOF'
+stored the token of profile work in the keyring
+EOF
+	expect_file_contains "$WORK/keyring/work" "test-token"
+	expect_file_contains "$MASKEDEMAIL_CONFIG" '"provider": "keyring"'
+
+	MASKEDEMAIL_TOKEN=wrong-token run -profile work -template '{{.Email}}' list
+	expect_status 0
+	expect_stdout <<'EOF'
+shared.box555@fastmail.com
+EOF
+
+	run_input auth set-token <<'EOF'
+wrong-token
+EOF
+	expect_status 1
+	expect_file_contains "$MASKEDEMAIL_CONFIG" '"token": "test-token"'
+
+	run_input auth set-token <<'EOF'
+test-token
+EOF
+	expect_stdout_contains "removed the token from the config file"
+
+	run -profile work auth clear
+	expect_status 0
+	expect_stdout <<'EOF'
+removed the token of profile work from the keyring
+EOF
+	if [ -e "$WORK/keyring/work" ]; then
+		fail "token still in the keyring"
+	fi
+	run -profile work auth clear
+	expect_stdout <<'EOF'
+no token of profile work in the keyring
+EOF
+
+	run list
+	expect_status 0
+	rm "$WORK/keyring/default"
+	run list
+	expect_status 1
+	expect_stderr_contains 'no token for "default" in the keyring, store one with `maskedemail-cli auth set-token`'
+
+	PATH=$OLD_PATH
+fi
+
+if begin "show creation context"; then
+	run -token test-token create -domain example.net -desc "it's new"
+	expect_status 0
+	run show auto.mask1001@fastmail.com
+	expect_status 0
+	expect_stdout_contains "Created Locally:"
+	expect_stdout_contains " on $(hostname)"
+	expect_stdout_contains "Command:         maskedemail-cli -token '***' create -domain example.net -desc 'it'\\''s new'"
+	expect_file_contains "$MASKEDEMAIL_STATE_DIR/journal.jsonl" '"host":'
+	if grep -q "test-token" "$MASKEDEMAIL_STATE_DIR/journal.jsonl"; then
+		fail "journal contains the token"
+	fi
+
+	run show alpha.one123@fastmail.com
+	expect_stdout_lacks "Created Locally:"
+fi
+
+# watch_changes URL starts watch against the session URL, creates and
+# disables masked emails and leaves what watch printed in stdout.
+watch_changes() {
+	MASKEDEMAIL_SESSION_URL=$1 "$WORK/maskedemail-cli" watch -interval 1s >"$WORK/watch.out" 2>"$WORK/watch.err" </dev/null &
+	WATCH_PID=$!
+	sleep 1
+	MASKEDEMAIL_SESSION_URL=$1 run create -domain example.net
+	expect_status 0
+	MASKEDEMAIL_SESSION_URL=$1 run disable alpha.one123@fastmail.com
+	expect_status 0
+	sleep 2.5
+	kill "$WATCH_PID"
+	wait "$WATCH_PID" 2>/dev/null
+	mv "$WORK/watch.out" "$WORK/stdout"
+	mv "$WORK/watch.err" "$WORK/stderr"
+	LAST_CMD="maskedemail-cli watch -interval 1s"
+}
+
+if begin "digest"; then
+	run create -domain new.example.com -desc "New shop"
+	NEW=$(cat "$WORK/stdout")
+	run disable alpha.one123@fastmail.com
+	run destroy -yes gamma.three789@fastmail.com
+
+	run digest -since 1d
+	expect_status 0
+	expect_stdout_contains "Masked emails since "
+	expect_stdout_contains "New (1)"
+	expect_stdout_contains "  $NEW new.example.com New shop enabled"
+	expect_stdout_contains "Received mail (0)"
+	expect_stdout_contains "Changed (2)"
+	expect_stdout_contains "  alpha.one123@fastmail.com   github.com GitHub #dev disabled"
+	expect_stdout_contains "  gamma.three789@fastmail.com -          -           destroyed"
+
+	# alpha got its last mail in 2024-05, the seed data was created before
+	run digest -since 2024-04-01 -format html
+	expect_status 0
+	expect_stdout_contains "<h4>Received mail (1)</h4>"
+	expect_stdout_contains "<li><code>alpha.one123@fastmail.com</code> github.com (GitHub #dev): disabled</li>"
+
+	run digest -format markdown
+	expect_status 1
+	expect_stderr_contains 'unsupported format "markdown"'
+fi
+
+if begin "watch"; then
+	run watch -interval 10ms
+	expect_status 1
+	expect_stderr_contains "-interval must be at least 1s"
+
+	watch_changes "$SESSION_URL"
+	expect_stdout_contains " created auto.mask1001@fastmail.com"
+	expect_stdout_contains ' updated alpha.one123@fastmail.com state: "enabled" -> "disabled"'
+fi
+
+if begin "watch without changes method"; then
+	"$WORK/fakejmap" -seed "$ROOT/test/testdata/seed.json" -no-changes >"$WORK/fakejmap2.log" 2>&1 &
+	SERVER2_PID=$!
+	sleep 0.5
+	SESSION2_URL=$(head -n 1 "$WORK/fakejmap2.log")
+
+	watch_changes "$SESSION2_URL"
+	expect_stderr_contains "warning: the server doesn't support MaskedEmail/changes, comparing all masked emails instead"
+	expect_stdout_contains " created auto.mask1001@fastmail.com"
+	expect_stdout_contains ' updated alpha.one123@fastmail.com state: "enabled" -> "disabled"'
+
+	kill "$SERVER2_PID"
+fi
+
+if begin "login and logout"; then
+	use_fake_keyring
+	rm -f "$WORK/keyring/"*
+
+	printf '{"appname": "my-app"}\n' >"$MASKEDEMAIL_CONFIG"
+	run_input login <<'EOF'
+test-token
+2
+EOF
+	expect_status 0
+	expect_stdout <<EOF
+logged in to account shared@example.com (u2) of profile default, token stored in $MASKEDEMAIL_CONFIG
+EOF
+	expect_file_contains "$MASKEDEMAIL_CONFIG" '"token": "test-token"'
+	expect_file_contains "$MASKEDEMAIL_CONFIG" '"accountId": "u2"'
+	expect_file_contains "$MASKEDEMAIL_CONFIG" '"appname": "my-app"'
+
+	run_input login <<'EOF'
+wrong-token
+EOF
+	expect_status 1
+	expect_stderr_contains "verifying token"
+
+	run_input -profile work login -keyring -account u1 <<'EOF'
+test-token
+EOF
+	expect_status 0
+	expect_stdout_contains "token stored in the keyring"
+	expect_file_contains "$WORK/keyring/work" "test-token"
+	MASKEDEMAIL_TOKEN=wrong-token run -profile work -template '{{.Email}}' list
+	expect_status 0
+	expect_stdout_contains "alpha.one123@fastmail.com"
+
+	run -profile work logout
+	expect_status 0
+	expect_stdout <<'EOF'
+logged out of profile work
+EOF
+	if [ -e "$WORK/keyring/work" ]; then
+		fail "token still in the keyring"
+	fi
+	run logout
+	expect_stdout <<'EOF'
+logged out of profile default
+EOF
+	expect_stderr_contains "warning: MASKEDEMAIL_TOKEN is still set in the environment"
+	run logout
+	expect_stdout <<'EOF'
+no token stored for profile default
+EOF
+	expect_file_contains "$MASKEDEMAIL_CONFIG" '"appname": "my-app"'
+
+	PATH=$OLD_PATH
+fi
+
+if begin "token sources"; then
+	printf 'test-token\n' >"$WORK/token"
+	chmod 600 "$WORK/token"
+	MASKEDEMAIL_TOKEN=wrong-token run -token-file "$WORK/token" -template '{{.Email}}' show me1
+	expect_status 0
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com
+EOF
+
+	chmod 644 "$WORK/token"
+	MASKEDEMAIL_TOKEN=wrong-token run -token-file "$WORK/token" show me1
+	expect_status 0
+	expect_stderr_contains "is accessible by other users"
+
+	MASKEDEMAIL_TOKEN=wrong-token run_input -token-stdin -template '{{.Email}}' show me1 <<'EOF'
+test-token
+EOF
+	expect_status 0
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com
+EOF
+
+	# the rest of stdin is left for the command
+	MASKEDEMAIL_TOKEN=wrong-token run_input -token-stdin disable - <<'EOF'
+test-token
+alpha.one123@fastmail.com
+EOF
+	expect_status 0
+	run -template '{{.State}}' show me1
+	expect_stdout <<'EOF'
+disabled
+EOF
+
+	MASKEDEMAIL_TOKEN=wrong-token run_input -token-stdin create -from-message -dry-run <<'EOF'
+test-token
+From: news@example.org
+EOF
+	expect_status 0
+	expect_stdout_contains "example.org"
+
+	MASKEDEMAIL_TOKEN=wrong-token run -token-cmd "echo test-token" -template '{{.Email}}' show me1
+	expect_status 0
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com
+EOF
+
+	run -token-file "$WORK/token" -token-cmd "echo test-token" show me1
+	expect_status 1
+	expect_stderr_contains "-token-file and -token-cmd can't be combined"
+
+	run -token-cmd "true" show me1
+	expect_status 1
+	expect_stderr_contains "the secret is empty"
+fi
+
+# run_in_locale LOCALE ARGS... is run with LC_ALL set for the CLI only, bash
+# warns about locales that aren't installed.
+run_in_locale() {
+	locale=$1
+	shift
+	LAST_CMD="LC_ALL=$locale maskedemail-cli $*"
+	env LC_ALL="$locale" "$WORK/maskedemail-cli" "$@" >"$WORK/stdout" 2>"$WORK/stderr" </dev/null
+	STATUS=$?
+}
+
+if begin "timestamps"; then
+	export TZ=Asia/Tokyo
+
+	run_in_locale de_DE.UTF-8 show me1
+	expect_stdout_contains "Created At:    05.01.2023 19:00 JST"
+	run_in_locale en_US.UTF-8 show me1
+	expect_stdout_contains "Created At:    01/05/2023 7:00 PM JST"
+	run_in_locale en_GB.UTF-8 show me1
+	expect_stdout_contains "Created At:    05/01/2023 19:00 JST"
+	run_in_locale de_DE.UTF-8 -compat 1 show me1
+	expect_stdout_contains "Created At:    2023-01-05T10:00:00Z"
+
+	# machine formats don't follow the display settings
+	run_in_locale de_DE.UTF-8 list -format csv
+	expect_stdout_contains "2023-01-05T10:00:00Z,2024-05-01T09:00:00Z"
+	run_in_locale de_DE.UTF-8 -json show me1
+	expect_stdout_contains '"createdAt": "2023-01-05T10:00:00Z"'
+	expect_stdout_contains '"lastMessageAt": "2024-05-01T09:00:00Z"'
+	run_in_locale de_DE.UTF-8 -template '{{rfc3339 .CreatedAt}}' show me1
+	expect_stdout <<'EOF'
+2023-01-05T10:00:00Z
+EOF
+
+	export TZ=UTC
+fi
+
+if begin "oauth"; then
+	use_fake_keyring
+	rm -f "$WORK/keyring/"*
+	export MASKEDEMAIL_OAUTH_ISSUER=${SESSION_URL%/jmap/session}
+
+	run login -oauth
+	expect_status 1
+	expect_stderr_contains "-oauth needs a client id registered with Fastmail"
+
+	# the browser follows the redirect back to the CLI, approving right away,
+	# after the first browser of the list failed
+	BROWSER="false:curl -fsSL -o /dev/null %s" run auth login -oauth -client-id test-client -account u1
+	expect_status 0
+	expect_stdout <<'EOF'
+logged in to account primary@example.com (u1) of profile default, token stored in the keyring
+EOF
+	expect_file_contains "$WORK/keyring/default" '"refreshToken":"oauth-refresh-'
+	expect_file_contains "$MASKEDEMAIL_CONFIG" '"provider": "oauth"'
+	expect_stderr_contains "Open this URL to approve the access:"
+
+	# -print-url only prints the URL, here it's opened by hand
+	printf '#!/bin/sh\ntouch "%s/browser-opened"\n' "$WORK" >"$WORK/browser"
+	chmod +x "$WORK/browser"
+	LAST_CMD="maskedemail-cli -profile headless login -oauth -print-url"
+	BROWSER=$WORK/browser "$WORK/maskedemail-cli" -profile headless login -oauth -print-url -client-id test-client -account u1 \
+		>"$WORK/stdout" 2>"$WORK/stderr" </dev/null &
+	LOGIN_PID=$!
+	AUTH_URL=""
+	for _ in $(seq 50); do
+		AUTH_URL=$(grep '^http' "$WORK/stderr")
+		[ -n "$AUTH_URL" ] && break
+		sleep 0.1
+	done
+	curl -fsSL -o /dev/null "$AUTH_URL" || fail "$LAST_CMD: no URL to approve the access printed"
+	wait "$LOGIN_PID"
+	STATUS=$?
+	expect_status 0
+	expect_stdout_contains "logged in to account primary@example.com (u1) of profile headless"
+	if [ -e "$WORK/browser-opened" ]; then
+		fail "$LAST_CMD: a browser was opened"
+	fi
+
+	run login -print-url
+	expect_status 1
+	expect_stderr_contains "-device and -print-url need -oauth"
+
+	# the access tokens expire within a minute, so each run refreshes them
+	for _ in 1 2; do
+		MASKEDEMAIL_TOKEN=wrong-token run -template '{{.Email}}' show me1
+		expect_status 0
+		expect_stdout <<'EOF'
+alpha.one123@fastmail.com
+EOF
+	done
+
+	run -profile other login -oauth -device -client-id test-client -account u2
+	expect_status 0
+	expect_stderr_contains "enter the code WDJB-MJHT"
+	MASKEDEMAIL_TOKEN=wrong-token run -profile other -template '{{.Email}}' list
+	expect_status 0
+	expect_stdout <<'EOF'
+shared.box555@fastmail.com
+EOF
+
+	run logout
+	expect_status 0
+	if [ -e "$WORK/keyring/default" ]; then
+		fail "credentials still in the keyring"
+	fi
+
+	unset MASKEDEMAIL_OAUTH_ISSUER
+	PATH=$OLD_PATH
+fi
+
+# complete_bash WORDS... prints the bash completions of the last word.
+complete_bash() {
+	bash -c 'source /dev/stdin; COMP_WORDS=("$@"); COMP_CWORD=$(($# - 1)); _maskedemail_cli; echo "${COMPREPLY[*]}"' \
+		maskedemail-cli "$@" <"$WORK/completion.bash"
+}
+
+if begin "completion"; then
+	run completion bash
+	expect_status 0
+	cp "$WORK/stdout" "$WORK/completion.bash"
+	[ "$(complete_bash maskedemail-cli wat)" = "watch" ] || fail "bash: wat doesn't complete to watch"
+	[ "$(complete_bash maskedemail-cli -profile work auth "")" = "set-token clear login" ] || fail "bash: auth doesn't complete its subcommands"
+	[ "$(complete_bash maskedemail-cli login -o)" = "-oauth" ] || fail "bash: login doesn't complete -oauth"
+
+	run completion powershell
+	expect_status 0
+	expect_stdout_contains "Register-ArgumentCompleter -Native -CommandName 'maskedemail-cli', 'maskedemail-cli.exe'"
+	expect_stdout_contains "'state' = @('-file', 'export', 'import')"
+
+	HOME=$WORK/home XDG_CONFIG_HOME= run completion install -shell pwsh -yes
+	expect_status 0
+	expect_file_contains "$WORK/home/.config/powershell/maskedemail-cli.ps1" "Register-ArgumentCompleter"
+fi
+
+if begin "address completion"; then
+	run __complete-addresses disable
+	expect_status 0
+	expect_stdout <<'EOF'
+alpha.one123@fastmail.com
+gamma.three789@fastmail.com
+EOF
+	expect_file_contains "$WORK/state/completion/default" "beta.two456@fastmail.com	disabled"
+
+	# the cache is used until it's ten minutes old
+	run disable alpha.one123@fastmail.com
+	expect_status 0
+	run __complete-addresses disable
+	expect_stdout_contains "alpha.one123@fastmail.com"
+	touch -d "-11 minutes" "$WORK/state/completion/default"
+	run __complete-addresses disable
+	expect_stdout <<'EOF'
+gamma.three789@fastmail.com
+EOF
+
+	run completion bash
+	expect_status 0
+	cp "$WORK/stdout" "$WORK/completion.bash"
+	OLD_PATH=$PATH
+	PATH=$WORK:$PATH
+	[ "$(complete_bash maskedemail-cli enable "")" = "-dry-run -from-journal -match -regex -yes alpha.one123@fastmail.com beta.two456@fastmail.com" ] || fail "bash: enable doesn't complete disabled addresses"
+	[ "$(complete_bash maskedemail-cli update -email g)" = "gamma.three789@fastmail.com" ] || fail "bash: update -email doesn't complete addresses"
+	[ "$(complete_bash maskedemail-cli update -d)" = "-desc -domain" ] || fail "bash: update completes addresses for flags"
+	PATH=$OLD_PATH
+
+	run __complete-addresses list
+	expect_status 1
+fi
+
+if begin "enable from journal"; then
+	run enable -from-journal 1h
+	expect_status 0
+	expect_stderr_contains "the journal has no masked emails disabled since"
+
+	# gamma is deleted after the disable, it's left alone
+	run disable alpha.one123@fastmail.com gamma.three789@fastmail.com
+	expect_status 0
+	run delete -yes gamma.three789@fastmail.com
+	expect_status 0
+
+	run enable -from-journal 1h
+	expect_status 0
+	expect_stdout <<'EOF'
+enabled masked email: alpha.one123@fastmail.com
+EOF
+
+	run enable -from-journal 1h alpha.one123@fastmail.com
+	expect_status 1
+	run enable -from-journal yesterday
+	expect_status 1
+	expect_stderr_contains "is not a time"
+fi
+
+if begin "tui"; then
+	run tui
+	expect_status 1
+	expect_stderr_contains "tui needs a terminal"
+fi
+
+if begin "tui read-only"; then
+	# script(1) gives the TUI a terminal; once it's up, try to enable the
+	# selected masked email, then quit
+	LAST_CMD="maskedemail-cli -read-only tui"
+	(sleep 1; printf 'e'; sleep 0.5; printf 'q') |
+		timeout 10 script -qec "'$WORK/maskedemail-cli' -read-only tui" /dev/null >"$WORK/stdout" 2>"$WORK/stderr"
+	STATUS=$?
+	expect_status 0
+	expect_stdout_contains "read-only  j/k move"
+	expect_stdout_contains "read-only mode is enabled, masked emails can't be changed"
+
+	run list -plain -state disabled
+	expect_stdout <<'EOF'
+beta.two456@fastmail.com
+EOF
+fi
+
+finish_case
+
+echo
+echo "$PASSED passed, $FAILED failed"
+[ "$FAILED" -eq 0 ]
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// benchEmails is the size of the synthetic account, as in test/bench.sh.
+const benchEmails = 10000
+
+// The budgets of test/bench.sh. They include starting the CLI, so a single
+// operation in process has to stay well below them.
+const (
+	budgetList   = 1000 * time.Millisecond
+	budgetLookup = 500 * time.Millisecond
+	budgetUpdate = 500 * time.Millisecond
+)
+
+// benchAddress is a masked email in the middle of the synthetic account.
+var benchAddress = fmt.Sprintf("synthetic.mask%05d@fastmail.com", benchEmails/2+1)
+
+// newBenchClient starts the fake server with the synthetic account and
+// returns a client with the session already fetched.
+func newBenchClient(b *testing.B) (*pkg.Client, *pkg.SessionResource) {
+	b.Helper()
+
+	s, err := newServer("test-token", 0, 0, false, "", benchEmails)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	b.Cleanup(ts.Close)
+
+	client := pkg.NewClient("test-token", "maskedemail-cli", "bench")
+	client.SetSessionEndpoint(ts.URL + "/jmap/session")
+	session, err := client.Session()
+	if err != nil {
+		b.Fatal(err)
+	}
+	return client, session
+}
+
+// checkBudget fails the benchmark if an operation took longer than budget
+// on average since the timer was started.
+func checkBudget(b *testing.B, start time.Time, budget time.Duration) {
+	b.Helper()
+
+	if perOp := time.Since(start) / time.Duration(b.N); perOp > budget {
+		b.Errorf("%v per operation, budget %v", perOp, budget)
+	}
+}
+
+func BenchmarkDecodeGetAll(b *testing.B) {
+	list, err := json.Marshal(syntheticEmails(benchEmails))
+	if err != nil {
+		b.Fatal(err)
+	}
+	body := []byte(`{"methodResponses":[["MaskedEmail/get",{"accountId":"u1","state":"1","notFound":[],"list":` + string(list) + `},"0"]],"sessionState":"session-1"}`)
+
+	b.SetBytes(int64(len(body)))
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		var res pkg.APIResponse
+		if err := json.Unmarshal(body, &res); err != nil {
+			b.Fatal(err)
+		}
+		var pl pkg.MethodResponseGetAll
+		if err := json.Unmarshal(res.MethodResponsesParsed[0].Payload.(json.RawMessage), &pl); err != nil {
+			b.Fatal(err)
+		}
+		if len(pl.List) != benchEmails {
+			b.Fatalf("decoded %d masked emails, want %d", len(pl.List), benchEmails)
+		}
+	}
+	b.StopTimer()
+	checkBudget(b, start, budgetList)
+}
+
+func BenchmarkList(b *testing.B) {
+	client, session := newBenchClient(b)
+
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		emails, err := client.GetAllMaskedEmails(session, "")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(emails) != benchEmails {
+			b.Fatalf("got %d masked emails, want %d", len(emails), benchEmails)
+		}
+	}
+	b.StopTimer()
+	checkBudget(b, start, budgetList)
+}
+
+func BenchmarkLookup(b *testing.B) {
+	client, session := newBenchClient(b)
+
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.LookupMaskedEmailID(session, "", benchAddress); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	checkBudget(b, start, budgetLookup)
+}
+
+func BenchmarkResolve(b *testing.B) {
+	client, session := newBenchClient(b)
+
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		email, err := client.ResolveMaskedEmail(session, "", benchAddress)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if email.Email != benchAddress {
+			b.Fatalf("resolved %s, want %s", email.Email, benchAddress)
+		}
+	}
+	b.StopTimer()
+	checkBudget(b, start, budgetLookup)
+}
+
+func BenchmarkDisableEnable(b *testing.B) {
+	client, session := newBenchClient(b)
+
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.DisableMaskedEmail(session, "", benchAddress); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := client.EnableMaskedEmail(session, "", benchAddress); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	checkBudget(b, start, 2*budgetUpdate)
+}
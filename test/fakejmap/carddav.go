@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// The fake CardDAV server has a single address book at cardDAVBookPath,
+// with basic auth instead of the bearer token.
+const (
+	cardDAVBookPath = "/carddav/book/"
+	cardDAVUsername = "test"
+	cardDAVPassword = "test-password"
+)
+
+// cardDAVSeed is a contact that isn't a masked email, which sync-contacts
+// must leave alone.
+var cardDAVSeed = map[string]string{
+	cardDAVBookPath + "friend.vcf": "BEGIN:VCARD\r\nVERSION:3.0\r\nUID:friend\r\nFN:A Friend\r\nEMAIL:friend@example.com\r\nEND:VCARD\r\n",
+}
+
+// handleCardDAV serves the address book, it returns false for other paths.
+// Only what sync-contacts uses is implemented: an addressbook-query REPORT
+// of all cards, PUT and DELETE.
+func (s *server) handleCardDAV(w http.ResponseWriter, r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, cardDAVBookPath) {
+		return false
+	}
+	if user, password, ok := r.BasicAuth(); !ok || user != cardDAVUsername || password != cardDAVPassword {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.Method == "REPORT" && r.URL.Path == cardDAVBookPath:
+		paths := make([]string, 0, len(s.cards))
+		for path := range s.cards {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?>`+"\n"+`<D:multistatus xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:carddav">`+"\n")
+		for _, path := range paths {
+			fmt.Fprintf(w, "<D:response><D:href>%s</D:href><D:propstat><D:prop><C:address-data>", path)
+			xml.EscapeText(w, []byte(s.cards[path]))
+			fmt.Fprint(w, "</C:address-data></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>\n")
+		}
+		fmt.Fprint(w, "</D:multistatus>\n")
+	case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, ".vcf"):
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return true
+		}
+		if _, ok := s.cards[r.URL.Path]; ok {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusCreated)
+		}
+		s.cards[r.URL.Path] = string(data)
+	case r.Method == http.MethodDelete:
+		if _, ok := s.cards[r.URL.Path]; !ok {
+			http.NotFound(w, r)
+			return true
+		}
+		delete(s.cards, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+	return true
+}
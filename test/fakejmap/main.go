@@ -0,0 +1,43 @@
+// Command fakejmap is an in-memory JMAP server implementing the parts of the
+// Fastmail API used by maskedemail-cli, for the end-to-end tests in test/.
+//
+// It prints the session URL on the first line of stdout once it's
+// listening. Point the CLI at it with MASKEDEMAIL_SESSION_URL. POST /reset
+// restores the seed data. It's also an OAuth authorization server granting
+// every request, for MASKEDEMAIL_OAUTH_ISSUER, and has a CardDAV address
+// book at /carddav/book/.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:0", "address to listen on")
+	token := flag.String("token", "test-token", "bearer token clients must send")
+	seed := flag.String("seed", "", "JSON file with the initial masked emails by account id")
+	quota := flag.Int("max-masked-emails", 0, "reject creating more than this many masked emails per account with overQuota, 0 for no limit")
+	maxObjectsInSet := flag.Int("max-objects-in-set", 0, "announce and enforce this limit of objects per /set call, 0 for none")
+	synthetic := flag.Int("synthetic", 0, "add this many generated masked emails to the primary account, for benchmarks")
+	noChanges := flag.Bool("no-changes", false, "reject MaskedEmail/changes as an unknown method, like a server not implementing it")
+	flag.Parse()
+
+	s, err := newServer(*token, *quota, *maxObjectsInSet, *noChanges, *seed, *synthetic)
+	if err != nil {
+		log.Fatalf("loading seed: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("listening: %v", err)
+	}
+
+	fmt.Fprintf(os.Stdout, "http://%s/jmap/session\n", listener.Addr())
+
+	log.Fatal(http.Serve(listener, s))
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// oauthExpiresIn is the lifetime of issued access tokens in seconds, short
+// enough for clients to refresh them on every run.
+const oauthExpiresIn = 30
+
+// oauthGrant is an authorization code waiting to be exchanged.
+type oauthGrant struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+}
+
+// oauthState holds the codes and tokens of the fake authorization server.
+// Authorization is granted without asking, like a user who's logged in and
+// approves right away.
+type oauthState struct {
+	codes         map[string]oauthGrant
+	devicePolls   map[string]int // device code to number of polls
+	accessTokens  map[string]bool
+	refreshTokens map[string]string // refresh token to client ID
+	nextToken     int
+}
+
+func (s *server) handleOAuth(w http.ResponseWriter, r *http.Request) bool {
+	switch r.URL.Path {
+	case "/.well-known/oauth-authorization-server":
+		base := "http://" + r.Host
+		writeJSON(w, map[string]interface{}{
+			"issuer":                           base,
+			"authorization_endpoint":           base + "/oauth/authorize",
+			"token_endpoint":                   base + "/oauth/token",
+			"device_authorization_endpoint":    base + "/oauth/device",
+			"code_challenge_methods_supported": []string{"S256"},
+		})
+	case "/oauth/authorize":
+		s.handleAuthorize(w, r)
+	case "/oauth/device":
+		s.handleDevice(w, r)
+	case "/oauth/token":
+		s.handleToken(w, r)
+	default:
+		return false
+	}
+	return true
+}
+
+// authorized reports whether the bearer token is the configured one or an
+// issued access token.
+func (s *server) authorized(r *http.Request) bool {
+	if r.Header.Get("Authorization") == "Bearer "+s.token {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	return len(header) > len(prefix) && s.oauth.accessTokens[header[len(prefix):]]
+}
+
+func (s *server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.oauth.nextToken++
+	code := fmt.Sprintf("code-%d", s.oauth.nextToken)
+	s.oauth.codes[code] = oauthGrant{clientID: q.Get("client_id"), redirectURI: redirectURI.String(), challenge: q.Get("code_challenge")}
+	s.mu.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *server) handleDevice(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.oauth.nextToken++
+	deviceCode := fmt.Sprintf("device-%d", s.oauth.nextToken)
+	s.oauth.devicePolls[deviceCode] = 0
+	writeJSON(w, map[string]interface{}{
+		"device_code":      deviceCode,
+		"user_code":        "WDJB-MJHT",
+		"verification_uri": "http://" + r.Host + "/oauth/activate",
+		"expires_in":       300,
+		"interval":         1,
+	})
+}
+
+// handleToken exchanges codes, approved device codes and refresh tokens. A
+// device code is approved on its second poll.
+func (s *server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, "invalid_request")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clientID := r.PostForm.Get("client_id")
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		grant, ok := s.oauth.codes[r.PostForm.Get("code")]
+		delete(s.oauth.codes, r.PostForm.Get("code"))
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || grant.clientID != clientID || grant.redirectURI != r.PostForm.Get("redirect_uri") ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+			oauthError(w, "invalid_grant")
+			return
+		}
+
+	case "urn:ietf:params:oauth:grant-type:device_code":
+		polls, ok := s.oauth.devicePolls[r.PostForm.Get("device_code")]
+		if !ok {
+			oauthError(w, "expired_token")
+			return
+		}
+		s.oauth.devicePolls[r.PostForm.Get("device_code")] = polls + 1
+		if polls == 0 {
+			oauthError(w, "authorization_pending")
+			return
+		}
+		delete(s.oauth.devicePolls, r.PostForm.Get("device_code"))
+
+	case "refresh_token":
+		owner, ok := s.oauth.refreshTokens[r.PostForm.Get("refresh_token")]
+		if !ok || owner != clientID {
+			oauthError(w, "invalid_grant")
+			return
+		}
+		delete(s.oauth.refreshTokens, r.PostForm.Get("refresh_token"))
+
+	default:
+		oauthError(w, "unsupported_grant_type")
+		return
+	}
+
+	s.oauth.nextToken++
+	access := fmt.Sprintf("oauth-access-%d", s.oauth.nextToken)
+	refresh := fmt.Sprintf("oauth-refresh-%d", s.oauth.nextToken)
+	s.oauth.accessTokens[access] = true
+	s.oauth.refreshTokens[refresh] = clientID
+	writeJSON(w, map[string]interface{}{
+		"access_token":  access,
+		"token_type":    "Bearer",
+		"expires_in":    oauthExpiresIn,
+		"refresh_token": refresh,
+	})
+}
+
+func oauthError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(w, "{\"error\": %q}\n", code)
+}
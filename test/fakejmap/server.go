@@ -0,0 +1,592 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maskedEmailCapabilityURI = "https://www.fastmail.com/dev/maskedemail"
+
+// sessionState is the state of the session resource. The accounts and
+// capabilities never change, unlike the state of the masked emails.
+const sessionState = "session-1"
+
+// account is one of the fixed accounts of the fake session.
+type account struct {
+	ID      string
+	Name    string
+	Primary bool
+}
+
+var accounts = []account{
+	{ID: "u1", Name: "primary@example.com", Primary: true},
+	{ID: "u2", Name: "shared@example.com"},
+}
+
+// maskedEmail is the stored form of a masked email, in the API's format.
+type maskedEmail struct {
+	ID            string  `json:"id"`
+	Email         string  `json:"email"`
+	State         string  `json:"state"`
+	ForDomain     string  `json:"forDomain"`
+	Description   string  `json:"description"`
+	URL           *string `json:"url"`
+	CreatedBy     string  `json:"createdBy"`
+	CreatedAt     string  `json:"createdAt"`
+	LastMessageAt *string `json:"lastMessageAt"`
+}
+
+// change records that a masked email was created, updated or destroyed
+// when the server moved to state.
+type change struct {
+	state int
+	accID string
+	id    string
+	kind  string
+}
+
+// server is an in-memory JMAP server with the session endpoint and the
+// MaskedEmail/get, MaskedEmail/set and MaskedEmail/changes methods.
+type server struct {
+	token           string
+	quota           int
+	maxObjectsInSet int
+	noChanges       bool
+	seedPath        string
+	synthetic       int
+
+	mu      sync.Mutex
+	emails  map[string][]*maskedEmail // by account ID
+	nextID  int
+	state   int
+	changes []change
+	cards   map[string]string // vCards by path
+	oauth   oauthState
+}
+
+func newServer(token string, quota int, maxObjectsInSet int, noChanges bool, seedPath string, synthetic int) (*server, error) {
+	s := &server{token: token, quota: quota, maxObjectsInSet: maxObjectsInSet, noChanges: noChanges, seedPath: seedPath, synthetic: synthetic}
+	s.oauth = oauthState{
+		codes:         map[string]oauthGrant{},
+		devicePolls:   map[string]int{},
+		accessTokens:  map[string]bool{},
+		refreshTokens: map[string]string{},
+	}
+	if err := s.reset(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reset replaces all data with the seed file and the synthetic masked
+// emails, so test cases can start from a known state.
+func (s *server) reset() error {
+	emails := map[string][]*maskedEmail{}
+	if s.seedPath != "" {
+		data, err := os.ReadFile(s.seedPath)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &emails); err != nil {
+			return fmt.Errorf("parsing %s: %w", s.seedPath, err)
+		}
+	}
+	if s.synthetic > 0 {
+		primary, _ := s.account("")
+		emails[primary] = append(emails[primary], syntheticEmails(s.synthetic)...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.emails = emails
+	s.nextID = 1000
+	s.state = 0
+	s.changes = nil
+	s.cards = map[string]string{}
+	for path, card := range cardDAVSeed {
+		s.cards[path] = card
+	}
+	return nil
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.handleOAuth(w, r) || s.handleCardDAV(w, r) {
+		return
+	}
+	if !s.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/jmap/session":
+		s.handleSession(w, r)
+	case r.Method == http.MethodPost && r.URL.Path == "/jmap/api/":
+		s.handleAPI(w, r)
+	case r.Method == http.MethodPost && r.URL.Path == "/reset":
+		if err := s.reset(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *server) handleSession(w http.ResponseWriter, r *http.Request) {
+	// like Fastmail, the limit of masked emails isn't announced
+	capability := map[string]interface{}{}
+
+	sessionAccounts := map[string]interface{}{}
+	primary := ""
+	for _, acc := range accounts {
+		sessionAccounts[acc.ID] = map[string]interface{}{
+			"name":                acc.Name,
+			"accountCapabilities": map[string]interface{}{maskedEmailCapabilityURI: capability},
+		}
+		if acc.Primary {
+			primary = acc.ID
+		}
+	}
+
+	core := map[string]interface{}{}
+	if s.maxObjectsInSet > 0 {
+		core["maxObjectsInSet"] = s.maxObjectsInSet
+	}
+
+	base := "http://" + r.Host
+	writeJSON(w, map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"urn:ietf:params:jmap:core": core,
+			maskedEmailCapabilityURI:    map[string]interface{}{},
+		},
+		"accounts":        sessionAccounts,
+		"primaryAccounts": map[string]string{maskedEmailCapabilityURI: primary},
+		"username":        accounts[0].Name,
+		"apiUrl":          base + "/jmap/api/",
+		"eventSourceUrl":  base + "/jmap/eventsource/",
+		"state":           sessionState,
+	})
+}
+
+// methodCall is a JMAP invocation: [name, arguments, call id].
+type methodCall [3]json.RawMessage
+
+func (s *server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MethodCalls []methodCall `json:"methodCalls"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	responses := [][]interface{}{}
+	for _, call := range req.MethodCalls {
+		var name, callID string
+		json.Unmarshal(call[0], &name)
+		json.Unmarshal(call[2], &callID)
+
+		var res interface{}
+		switch name {
+		case "MaskedEmail/get":
+			res = s.get(call[1])
+		case "MaskedEmail/set":
+			res = s.set(call[1])
+		case "MaskedEmail/changes":
+			if s.noChanges {
+				res = methodError("unknownMethod")
+			} else {
+				res = s.changesSince(call[1])
+			}
+		default:
+			res = methodError("unknownMethod")
+		}
+
+		if e, ok := res.(errorResponse); ok {
+			responses = append(responses, []interface{}{"error", e, callID})
+		} else {
+			responses = append(responses, []interface{}{name, res, callID})
+		}
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"methodResponses": responses,
+		"sessionState":    sessionState,
+	})
+}
+
+type errorResponse map[string]interface{}
+
+func methodError(errorType string) errorResponse {
+	return errorResponse{"type": errorType}
+}
+
+func setError(errorType string, description string, properties ...string) map[string]interface{} {
+	e := map[string]interface{}{"type": errorType, "description": description}
+	if len(properties) > 0 {
+		e["properties"] = properties
+	}
+	return e
+}
+
+func (s *server) account(accountID string) (string, bool) {
+	if accountID == "" {
+		for _, acc := range accounts {
+			if acc.Primary {
+				return acc.ID, true
+			}
+		}
+	}
+	for _, acc := range accounts {
+		if acc.ID == accountID {
+			return acc.ID, true
+		}
+	}
+	return "", false
+}
+
+func (s *server) get(rawArgs json.RawMessage) interface{} {
+	var args struct {
+		AccountID  string    `json:"accountId"`
+		IDs        *[]string `json:"ids"`
+		Properties []string  `json:"properties"`
+	}
+	if err := json.Unmarshal(rawArgs, &args); err != nil {
+		return methodError("invalidArguments")
+	}
+
+	accID, ok := s.account(args.AccountID)
+	if !ok {
+		return methodError("accountNotFound")
+	}
+
+	wanted := map[string]bool{}
+	if args.IDs != nil {
+		for _, id := range *args.IDs {
+			wanted[id] = true
+		}
+	}
+
+	list := []interface{}{}
+	notFound := []string{}
+	found := map[string]bool{}
+	for _, email := range s.emails[accID] {
+		if args.IDs != nil && !wanted[email.ID] {
+			continue
+		}
+		found[email.ID] = true
+		list = append(list, withProperties(email, args.Properties))
+	}
+	for id := range wanted {
+		if !found[id] {
+			notFound = append(notFound, id)
+		}
+	}
+	sort.Strings(notFound)
+
+	return map[string]interface{}{
+		"accountId": accID,
+		"state":     fmt.Sprint(s.state),
+		"list":      list,
+		"notFound":  notFound,
+	}
+}
+
+// withProperties returns the masked email reduced to the requested
+// properties (plus id), or all of them if none are requested.
+func withProperties(email *maskedEmail, properties []string) interface{} {
+	if len(properties) == 0 {
+		return email
+	}
+
+	var all map[string]interface{}
+	data, _ := json.Marshal(email)
+	json.Unmarshal(data, &all)
+
+	reduced := map[string]interface{}{"id": email.ID}
+	for _, p := range properties {
+		if v, ok := all[p]; ok {
+			reduced[p] = v
+		}
+	}
+	return reduced
+}
+
+func validState(state string) bool {
+	switch state {
+	case "pending", "enabled", "disabled", "deleted":
+		return true
+	}
+	return false
+}
+
+func (s *server) set(rawArgs json.RawMessage) interface{} {
+	var args struct {
+		AccountID string                                `json:"accountId"`
+		Create    map[string]map[string]json.RawMessage `json:"create"`
+		Update    map[string]map[string]json.RawMessage `json:"update"`
+		Destroy   []string                              `json:"destroy"`
+	}
+	if err := json.Unmarshal(rawArgs, &args); err != nil {
+		return methodError("invalidArguments")
+	}
+
+	accID, ok := s.account(args.AccountID)
+	if !ok {
+		return methodError("accountNotFound")
+	}
+
+	if s.maxObjectsInSet > 0 && len(args.Create)+len(args.Update)+len(args.Destroy) > s.maxObjectsInSet {
+		return methodError("requestTooLarge")
+	}
+
+	oldState := s.state
+	created := map[string]interface{}{}
+	notCreated := map[string]interface{}{}
+	updated := map[string]interface{}{}
+	notUpdated := map[string]interface{}{}
+	destroyed := []string{}
+	notDestroyed := map[string]interface{}{}
+
+	for key, props := range args.Create {
+		email, err := s.create(accID, props)
+		if err != nil {
+			notCreated[key] = err
+			continue
+		}
+		created[key] = map[string]interface{}{
+			"id":        email.ID,
+			"email":     email.Email,
+			"createdAt": email.CreatedAt,
+			"createdBy": email.CreatedBy,
+		}
+	}
+
+	for id, props := range args.Update {
+		email := s.find(accID, id)
+		if email == nil {
+			notUpdated[id] = setError("notFound", "no masked email with id "+id)
+			continue
+		}
+		if err := update(email, props); err != nil {
+			notUpdated[id] = err
+			continue
+		}
+		updated[id] = nil
+	}
+
+	for _, id := range args.Destroy {
+		if s.find(accID, id) == nil {
+			notDestroyed[id] = setError("notFound", "no masked email with id "+id)
+			continue
+		}
+		s.remove(accID, id)
+		destroyed = append(destroyed, id)
+	}
+
+	if len(created)+len(updated)+len(destroyed) > 0 {
+		s.state++
+		for _, c := range created {
+			s.changes = append(s.changes, change{s.state, accID, c.(map[string]interface{})["id"].(string), "created"})
+		}
+		for id := range updated {
+			s.changes = append(s.changes, change{s.state, accID, id, "updated"})
+		}
+		for _, id := range destroyed {
+			s.changes = append(s.changes, change{s.state, accID, id, "destroyed"})
+		}
+	}
+
+	return map[string]interface{}{
+		"accountId":    accID,
+		"oldState":     fmt.Sprint(oldState),
+		"newState":     fmt.Sprint(s.state),
+		"created":      created,
+		"notCreated":   notCreated,
+		"updated":      updated,
+		"notUpdated":   notUpdated,
+		"destroyed":    destroyed,
+		"notDestroyed": notDestroyed,
+	}
+}
+
+func (s *server) changesSince(rawArgs json.RawMessage) interface{} {
+	var args struct {
+		AccountID  string `json:"accountId"`
+		SinceState string `json:"sinceState"`
+	}
+	if err := json.Unmarshal(rawArgs, &args); err != nil {
+		return methodError("invalidArguments")
+	}
+
+	accID, ok := s.account(args.AccountID)
+	if !ok {
+		return methodError("accountNotFound")
+	}
+
+	var since int
+	if _, err := fmt.Sscan(args.SinceState, &since); err != nil || since < 0 || since > s.state {
+		return methodError("cannotCalculateChanges")
+	}
+
+	// a masked email is reported once: created wins over updated, and
+	// destroyed wins over both
+	kinds := map[string]string{}
+	var order []string
+	for _, c := range s.changes {
+		if c.state <= since || c.accID != accID {
+			continue
+		}
+		previous, seen := kinds[c.id]
+		if !seen {
+			order = append(order, c.id)
+		}
+		switch {
+		case previous == "created" && c.kind == "destroyed":
+			// created and destroyed in between, nothing to tell
+			delete(kinds, c.id)
+		case previous == "created":
+		default:
+			kinds[c.id] = c.kind
+		}
+	}
+
+	created, updated, destroyed := []string{}, []string{}, []string{}
+	for _, id := range order {
+		switch kinds[id] {
+		case "created":
+			created = append(created, id)
+		case "updated":
+			updated = append(updated, id)
+		case "destroyed":
+			destroyed = append(destroyed, id)
+		}
+	}
+
+	return map[string]interface{}{
+		"accountId":      accID,
+		"oldState":       args.SinceState,
+		"newState":       fmt.Sprint(s.state),
+		"hasMoreChanges": false,
+		"created":        created,
+		"updated":        updated,
+		"destroyed":      destroyed,
+	}
+}
+
+func (s *server) find(accID string, id string) *maskedEmail {
+	for _, email := range s.emails[accID] {
+		if email.ID == id {
+			return email
+		}
+	}
+	return nil
+}
+
+func (s *server) remove(accID string, id string) {
+	emails := s.emails[accID][:0]
+	for _, email := range s.emails[accID] {
+		if email.ID != id {
+			emails = append(emails, email)
+		}
+	}
+	s.emails[accID] = emails
+}
+
+func (s *server) create(accID string, props map[string]json.RawMessage) (*maskedEmail, map[string]interface{}) {
+	var p struct {
+		State       string  `json:"state"`
+		ForDomain   string  `json:"forDomain"`
+		Description string  `json:"description"`
+		URL         *string `json:"url"`
+		EmailPrefix string  `json:"emailPrefix"`
+	}
+	data, _ := json.Marshal(props)
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, setError("invalidProperties", err.Error())
+	}
+
+	if p.State == "" {
+		p.State = "pending"
+	}
+	if !validState(p.State) || p.State == "deleted" {
+		return nil, setError("invalidProperties", "invalid state "+p.State, "state")
+	}
+
+	if s.quota > 0 {
+		active := 0
+		for _, email := range s.emails[accID] {
+			if email.State != "deleted" {
+				active++
+			}
+		}
+		if active >= s.quota {
+			return nil, setError("overQuota", fmt.Sprintf("at most %d masked emails allowed", s.quota))
+		}
+	}
+
+	prefix := "auto"
+	if p.EmailPrefix != "" {
+		prefix = strings.ToLower(p.EmailPrefix)
+	}
+
+	s.nextID++
+	email := &maskedEmail{
+		ID:          fmt.Sprintf("me%d", s.nextID),
+		Email:       fmt.Sprintf("%s.mask%d@fastmail.com", prefix, s.nextID),
+		State:       p.State,
+		ForDomain:   p.ForDomain,
+		Description: p.Description,
+		URL:         p.URL,
+		CreatedBy:   "fakejmap",
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+	}
+	s.emails[accID] = append(s.emails[accID], email)
+
+	return email, nil
+}
+
+func update(email *maskedEmail, props map[string]json.RawMessage) map[string]interface{} {
+	changed := *email
+	for name, raw := range props {
+		var err error
+		switch name {
+		case "state":
+			err = json.Unmarshal(raw, &changed.State)
+			if err == nil && !validState(changed.State) {
+				return setError("invalidProperties", "invalid state "+changed.State, name)
+			}
+		case "forDomain":
+			err = json.Unmarshal(raw, &changed.ForDomain)
+		case "description":
+			err = json.Unmarshal(raw, &changed.Description)
+		case "url":
+			err = json.Unmarshal(raw, &changed.URL)
+		case "emailPrefix", "email", "id", "createdAt", "createdBy", "lastMessageAt":
+			return setError("invalidProperties", name+" is immutable", name)
+		default:
+			return setError("invalidProperties", "unknown property "+name, name)
+		}
+		if err != nil {
+			return setError("invalidProperties", err.Error(), name)
+		}
+	}
+
+	*email = changed
+	return nil
+}
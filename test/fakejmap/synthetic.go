@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// syntheticEpoch is the creation time of the first synthetic masked email.
+var syntheticEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// syntheticEmails generates n masked emails for benchmarks, the same on
+// every run: a mix of states, domains shared by several masked emails, and
+// about half of them with mail received.
+func syntheticEmails(n int) []*maskedEmail {
+	emails := make([]*maskedEmail, 0, n)
+	for i := 1; i <= n; i++ {
+		email := &maskedEmail{
+			ID:          fmt.Sprintf("syn%d", i),
+			Email:       fmt.Sprintf("synthetic.mask%05d@fastmail.com", i),
+			State:       "enabled",
+			ForDomain:   fmt.Sprintf("site%d.example.com", i%2000),
+			Description: fmt.Sprintf("Synthetic account %d #bench", i),
+			CreatedBy:   "fakejmap",
+			CreatedAt:   syntheticEpoch.Add(time.Duration(i) * time.Hour).Format(time.RFC3339),
+		}
+		switch {
+		case i%25 == 0:
+			email.State = "deleted"
+		case i%10 == 0:
+			email.State = "disabled"
+		}
+		if i%2 == 0 {
+			lastMessageAt := syntheticEpoch.Add(time.Duration(i+n) * time.Hour).Format(time.RFC3339)
+			email.LastMessageAt = &lastMessageAt
+		}
+		if i%3 == 0 {
+			url := fmt.Sprintf("https://%s/signup", email.ForDomain)
+			email.URL = &url
+		}
+		emails = append(emails, email)
+	}
+	return emails
+}
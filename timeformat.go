@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// displayLayouts are the date formats of human output by language or
+// language_TERRITORY of the locale, the more specific entry wins.
+var displayLayouts = map[string]string{
+	"en":    "01/02/2006 3:04 PM MST",
+	"en_AU": "02/01/2006 15:04 MST",
+	"en_GB": "02/01/2006 15:04 MST",
+	"en_IE": "02/01/2006 15:04 MST",
+	"en_IN": "02/01/2006 15:04 MST",
+	"en_NZ": "02/01/2006 15:04 MST",
+	"en_ZA": "02/01/2006 15:04 MST",
+	"de":    "02.01.2006 15:04 MST",
+	"fr":    "02/01/2006 15:04 MST",
+	"es":    "02/01/2006 15:04 MST",
+	"it":    "02/01/2006 15:04 MST",
+	"pt":    "02/01/2006 15:04 MST",
+	"nl":    "02-01-2006 15:04 MST",
+	"ja":    "2006/01/02 15:04 MST",
+	"zh":    "2006/01/02 15:04 MST",
+	"ko":    "2006. 01. 02. 15:04 MST",
+}
+
+// defaultDisplayLayout is used for locales without an entry in
+// displayLayouts.
+const defaultDisplayLayout = "2006-01-02 15:04 MST"
+
+// timeLocale returns the locale for dates the way setlocale resolves
+// LC_TIME, e.g. "de_DE" for "de_DE.UTF-8@euro", or "" for C and POSIX.
+func timeLocale() string {
+	locale := ""
+	for _, name := range []string{"LC_ALL", "LC_TIME", "LANG"} {
+		if locale = os.Getenv(name); locale != "" {
+			break
+		}
+	}
+	if i := strings.IndexAny(locale, ".@"); i >= 0 {
+		locale = locale[:i]
+	}
+	if locale == "C" || locale == "POSIX" {
+		return ""
+	}
+	return locale
+}
+
+// formatDisplayTime renders a timestamp for people reading text output: in
+// the local time zone and the date format of the locale, or relative to now
+// with -relative-time. Without a locale, and in compat mode, it's the RFC3339
+// UTC format of machine output, which has to be used for CSV, JSON and
+// everything else parsed by programs.
+func formatDisplayTime(t *time.Time) string {
+	if t == nil || t.IsZero() || compatMode(compatV1) {
+		return formatTimestamp(t)
+	}
+	if *flagRelativeTime {
+		return formatRelativeTime(*t, time.Now())
+	}
+
+	locale := timeLocale()
+	if locale == "" {
+		return formatTimestamp(t)
+	}
+
+	layout, ok := displayLayouts[locale]
+	if !ok {
+		language := strings.SplitN(locale, "_", 2)[0]
+		if layout, ok = displayLayouts[language]; !ok {
+			layout = defaultDisplayLayout
+		}
+	}
+	return t.Local().Format(layout)
+}
+
+// relativeUnits are the units of formatRelativeTime, largest first. Months
+// and years are approximate, which is fine at that scale.
+var relativeUnits = []struct {
+	name string
+	size time.Duration
+}{
+	{"year", 365 * 24 * time.Hour},
+	{"month", 30 * 24 * time.Hour},
+	{"week", 7 * 24 * time.Hour},
+	{"day", 24 * time.Hour},
+	{"hour", time.Hour},
+	{"minute", time.Minute},
+}
+
+// formatRelativeTime renders t relative to now in the largest whole unit,
+// e.g. "3 days ago" or "in 2 hours".
+func formatRelativeTime(t time.Time, now time.Time) string {
+	d := now.Sub(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+
+	for _, unit := range relativeUnits {
+		n := int(d / unit.size)
+		if n < 1 {
+			continue
+		}
+		amount := fmt.Sprintf("%d %s", n, unit.name)
+		if n > 1 {
+			amount += "s"
+		}
+		if future {
+			return "in " + amount
+		}
+		return amount + " ago"
+	}
+	return "just now"
+}
+
+// parseSince parses a point in time given on the command line: an RFC3339
+// timestamp, a 2006-01-02 date (midnight in the local time zone) or a
+// duration like 90m or 2h that far in the past.
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("%q is a negative duration", s)
+		}
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a time (RFC3339, 2006-01-02 or a duration like 2h)", s)
+}
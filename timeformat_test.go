@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// eastern is a non-UTC zone whose timestamps fall on the next day in UTC.
+var eastern = time.FixedZone("EST", -5*60*60)
+
+// withDisplayLocale makes text output use the de_DE date format in CEST,
+// where formatDisplayTime and formatTimestamp differ.
+func withDisplayLocale(t *testing.T) {
+	t.Helper()
+
+	t.Setenv("LC_ALL", "de_DE.UTF-8")
+	local := time.Local
+	time.Local = time.FixedZone("CEST", 2*60*60)
+	t.Cleanup(func() { time.Local = local })
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	utc := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	nonUTC := time.Date(2024, 5, 1, 20, 30, 0, 0, eastern)
+	zero := time.Time{}
+
+	tests := []struct {
+		name string
+		t    *time.Time
+		want string
+	}{
+		{"nil", nil, ""},
+		{"zero", &zero, ""},
+		{"utc", &utc, "2024-05-01T09:00:00Z"},
+		{"non-utc", &nonUTC, "2024-05-02T01:30:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatTimestamp(tt.t); got != tt.want {
+				t.Errorf("formatTimestamp() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatDisplayTime(t *testing.T) {
+	withDisplayLocale(t)
+
+	nonUTC := time.Date(2024, 5, 1, 20, 30, 0, 0, eastern)
+	zero := time.Time{}
+
+	if got, want := formatDisplayTime(&nonUTC), "02.05.2024 03:30 CEST"; got != want {
+		t.Errorf("formatDisplayTime() = %q, want %q", got, want)
+	}
+	// unset timestamps stay empty instead of showing year 1
+	if got := formatDisplayTime(nil); got != "" {
+		t.Errorf("formatDisplayTime(nil) = %q, want \"\"", got)
+	}
+	if got := formatDisplayTime(&zero); got != "" {
+		t.Errorf("formatDisplayTime(zero) = %q, want \"\"", got)
+	}
+
+	t.Run("compat", func(t *testing.T) {
+		*flagCompat = compatV1
+		defer func() { *flagCompat = compatLatest }()
+
+		if got, want := formatDisplayTime(&nonUTC), "2024-05-02T01:30:00Z"; got != want {
+			t.Errorf("formatDisplayTime() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("c locale", func(t *testing.T) {
+		t.Setenv("LC_ALL", "C")
+
+		if got, want := formatDisplayTime(&nonUTC), "2024-05-02T01:30:00Z"; got != want {
+			t.Errorf("formatDisplayTime() = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestFormatDisplayTimeLayouts(t *testing.T) {
+	withDisplayLocale(t)
+
+	// the 2nd of May in CEST, which tells day-first and month-first apart
+	nonUTC := time.Date(2024, 5, 1, 20, 30, 0, 0, eastern)
+
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{"en_US.UTF-8", "05/02/2024 3:30 AM CEST"},
+		{"en", "05/02/2024 3:30 AM CEST"},
+		{"en_CA.UTF-8", "05/02/2024 3:30 AM CEST"},
+		{"en_GB.UTF-8", "02/05/2024 03:30 CEST"},
+		{"en_AU.UTF-8", "02/05/2024 03:30 CEST"},
+		{"fr_FR.UTF-8", "02/05/2024 03:30 CEST"},
+		{"sv_SE.UTF-8", "2024-05-02 03:30 CEST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			t.Setenv("LC_ALL", tt.locale)
+
+			if got := formatDisplayTime(&nonUTC); got != tt.want {
+				t.Errorf("formatDisplayTime() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDateTimeZone(t *testing.T) {
+	local := time.Local
+	time.Local = eastern
+	defer func() { time.Local = local }()
+
+	// a date is midnight local time for the flags and for -where alike
+	want := time.Date(2024, 5, 1, 0, 0, 0, 0, eastern)
+	since, err := parseSince("2024-05-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	where, err := parseWhereTime("2024-05-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !since.Equal(want) {
+		t.Errorf("parseSince() = %v, want %v", since, want)
+	}
+	if !where.Equal(want) {
+		t.Errorf("parseWhereTime() = %v, want %v", where, want)
+	}
+}
+
+func TestWriteCSVTimestamps(t *testing.T) {
+	// machine output ignores the locale and the local time zone
+	withDisplayLocale(t)
+
+	lastMessageAt := time.Date(2024, 5, 1, 20, 30, 0, 0, eastern)
+	emails := []*pkg.MaskedEmail{
+		{Email: "never.used@fastmail.com", CreatedAt: time.Date(2023, 12, 31, 22, 0, 0, 0, eastern)},
+		{Email: "no.created@fastmail.com", LastMessageAt: &lastMessageAt},
+	}
+
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, emails, []string{"email", "createdAt", "lastMessageAt"}, true, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "email,createdAt,lastMessageAt\r\n" +
+		"never.used@fastmail.com,2024-01-01T03:00:00Z,\r\n" +
+		"no.created@fastmail.com,,2024-05-02T01:30:00Z\r\n"
+	if got := buf.String(); got != want {
+		t.Errorf("writeCSV() = %q, want %q", got, want)
+	}
+}
+
+func TestMaskedEmailJSONTimestamps(t *testing.T) {
+	withDisplayLocale(t)
+
+	lastMessageAt := time.Date(2024, 5, 1, 20, 30, 0, 0, eastern)
+	emails := []*pkg.MaskedEmail{
+		{Email: "never.used@fastmail.com", CreatedAt: time.Date(2023, 12, 31, 22, 0, 0, 0, eastern)},
+		{Email: "no.created@fastmail.com", LastMessageAt: &lastMessageAt},
+	}
+
+	b, err := json.Marshal(emails)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		index    int
+		property string
+		want     interface{}
+	}{
+		{0, "createdAt", "2024-01-01T03:00:00Z"},
+		{0, "lastMessageAt", nil},
+		{1, "createdAt", nil},
+		{1, "lastMessageAt", "2024-05-02T01:30:00Z"},
+	}
+	for _, tt := range tests {
+		value, ok := got[tt.index][tt.property]
+		if !ok {
+			t.Errorf("%s of %s is missing", tt.property, emails[tt.index].Email)
+			continue
+		}
+		if value != tt.want {
+			t.Errorf("%s of %s = %v, want %v", tt.property, emails[tt.index].Email, value, tt.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// tokenFromFlags returns the token given with -token-file, -token-stdin or
+// -token-cmd, and "" if none of them is used. These keep the token out of
+// the process arguments and the environment, where other users and child
+// processes can see it. explicit are the flags given on the command line,
+// of which only one token flag may be.
+func tokenFromFlags(explicit map[string]bool) (string, error) {
+	var sources []string
+	for _, name := range []string{flagNameToken, flagNameTokenFile, flagNameTokenStdin, flagNameTokenCmd} {
+		if explicit[name] {
+			sources = append(sources, "-"+name)
+		}
+	}
+	if len(sources) > 1 {
+		return "", fmt.Errorf("%s can't be combined", strings.Join(sources, " and "))
+	}
+	if explicit[flagNameToken] {
+		return "", nil
+	}
+
+	var token string
+	switch {
+	case *flagTokenFile != "":
+		b, err := os.ReadFile(*flagTokenFile)
+		if err != nil {
+			return "", err
+		}
+		if info, err := os.Stat(*flagTokenFile); err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+			fmt.Fprintf(os.Stderr, "warning: token file %s is accessible by other users, restrict it with chmod 600\n", *flagTokenFile)
+		}
+		token = string(b)
+
+	case *flagTokenStdin:
+		// only the first line, so the rest of stdin stays for the command
+		var err error
+		if isTerminal(os.Stdin) {
+			token, err = readSecret("Token: ")
+		} else {
+			token, err = readLineUnbuffered(os.Stdin)
+			if token != "" {
+				err = nil
+			}
+		}
+		if err != nil {
+			return "", fmt.Errorf("reading token from stdin: %v", err)
+		}
+
+	case *flagTokenCmd != "":
+		var err error
+		token, err = fetchSecret(secretSource{Provider: secretProviderExec, Command: *flagTokenCmd}, *flagTimeout)
+		if err != nil {
+			return "", err
+		}
+
+	default:
+		return "", nil
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", errors.New("the token is empty")
+	}
+	return token, nil
+}
+
+// readLineUnbuffered reads up to and including the next newline one byte at
+// a time. Unlike stdinReader it reads nothing past the line, so commands
+// reading os.Stdin directly get the rest.
+func readLineUnbuffered(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			line = append(line, b[0])
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// flags for transfer command
+var transferCmd = flag.NewFlagSet(actionTypeTransfer, flag.ExitOnError)
+var flagTransferTo = transferCmd.String(flagNameTo, "", "account id to move the masked email to (required)")
+var flagTransferYes = transferCmd.Bool(flagNameYes, false, "don't ask for confirmation")
+
+// runTransfer moves a masked email to another account of the token. An
+// address can't change its account, so the masked email is recreated with
+// the same domain and description in the target account, which gets a new
+// address, and the original is disabled.
+func runTransfer(client *pkg.Client, args []string) {
+	parseCommandFlags(transferCmd, args)
+
+	usage := fmt.Sprintf("%s -%s <accountid> [-%s] <maskedemail>", actionTypeTransfer, flagNameTo, flagNameYes)
+	maskedemail := maskedEmailArg(transferCmd.Arg(0), usage)
+	if *flagTransferTo == "" {
+		log.Fatalln("Usage: " + usage)
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	fromAccID := accountIDOrDefault(session)
+	toAccID := *flagTransferTo
+	if toAccID == fromAccID {
+		log.Fatalf("%s is already in account %s", maskedemail, toAccID)
+	}
+	if !session.AccountHasCapability(toAccID, pkg.MaskedEmailCapabilityURI) {
+		log.Fatalf("account %s not found or has no access to Masked Email", toAccID)
+	}
+
+	source, err := client.LookupMaskedEmail(session, fromAccID, maskedemail)
+	if err != nil {
+		log.Fatalf("error looking up masked email: %v", err)
+	}
+	if source.State == pkg.MaskedEmailStateDeleted {
+		log.Fatalf("%s is deleted, nothing to transfer", maskedemail)
+	}
+
+	domain := strings.TrimSpace(source.Domain)
+	description := strings.TrimSpace(source.Description)
+	url := ""
+	if source.URL != nil {
+		url = *source.URL
+	}
+
+	fmt.Fprintf(os.Stderr, "Transferring %s from %s to %s [%s].\n", maskedemail, session.Accounts[fromAccID].Name, session.Accounts[toAccID].Name, toAccID)
+	fmt.Fprintln(os.Stderr, "Note: the address itself can't move between accounts. A new masked email with the same domain,")
+	fmt.Fprintln(os.Stderr, "description and url is created in the target account and the original one is disabled, so update")
+	fmt.Fprintln(os.Stderr, "the sites using it to the new address.")
+	if !*flagTransferYes && !confirm("continue?") {
+		log.Fatalln("aborted")
+	}
+
+	startedAt := time.Now()
+	enabled := source.State != pkg.MaskedEmailStatePending
+	created, err := client.CreateMaskedEmail(session, toAccID, domain, enabled, description, url)
+	if err != nil && isAmbiguousError(err) {
+		created, err = recoverAmbiguousCreate(client, session, toAccID, startedAt, domain, description, err)
+	}
+	if err != nil {
+		log.Fatalf("error creating masked email in %s: %v", toAccID, err)
+	}
+	created = completeCreated(created, domain, description, url, enabled)
+
+	appendJournal(journalEntry{
+		Action:      actionTypeCreate,
+		AccountID:   toAccID,
+		ID:          created.ID,
+		Email:       created.Email,
+		Domain:      domain,
+		Description: description,
+	})
+
+	copyNote(source, created)
+
+	wasDisabled := source.State == pkg.MaskedEmailStateDisabled
+	if !wasDisabled {
+		if _, err := client.DisableMaskedEmail(session, fromAccID, maskedemail); err != nil {
+			log.Fatalf("created %s in %s, but disabling %s failed: %v", created.Email, toAccID, maskedemail, err)
+		}
+		appendJournal(journalEntry{Action: actionTypeDisable, AccountID: fromAccID, ID: source.ID, Email: maskedemail})
+		source.State = pkg.MaskedEmailStateDisabled
+	}
+
+	if jsonOutput() {
+		createResult := newItemResult(created.Email, actionTypeCreate, nil)
+		createResult.MaskedEmail = created
+		disableResult := newItemResult(maskedemail, actionTypeDisable, nil)
+		disableResult.MaskedEmail = source
+		printResults(os.Stdout, []itemResult{createResult, disableResult}, true)
+		return
+	}
+
+	fmt.Printf("created masked email: %s (account %s)\n", created.Email, toAccID)
+	if wasDisabled {
+		fmt.Printf("masked email %s (account %s) was already disabled\n", maskedemail, fromAccID)
+	} else {
+		fmt.Printf("disabled masked email: %s (account %s)\n", maskedemail, fromAccID)
+	}
+}
+
+// copyNote carries the local note of a transferred masked email over to its
+// replacement. Failures only warn, the transfer itself succeeded.
+func copyNote(from *pkg.MaskedEmail, to *pkg.MaskedEmail) {
+	notes, err := loadNotes()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not read notes: %v\n", err)
+		return
+	}
+
+	n, ok := notes[from.ID]
+	if !ok {
+		return
+	}
+
+	notes[to.ID] = note{Email: to.Email, Text: n.Text, UpdatedAt: time.Now().UTC()}
+	if err := notes.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not copy note: %v\n", err)
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// flags for tui command
+var tuiCmd = flag.NewFlagSet(actionTypeTUI, flag.ExitOnError)
+var flagTUIShowDeleted = tuiCmd.Bool(flagNameShowDeleted, false, "include deleted masked emails")
+
+// tuiMode is what keys typed in the TUI go to.
+type tuiMode int
+
+const (
+	tuiBrowse tuiMode = iota
+	tuiSearch
+	tuiEditDescription
+	tuiEditDomain
+	tuiConfirmDelete
+)
+
+const tuiHelp = "j/k move  / search  e enable  d disable  x delete  u description  U domain  c copy  r reload  q quit"
+
+// tuiReadOnlyHelp is the help in read-only mode, without the keys changing
+// masked emails.
+const tuiReadOnlyHelp = "read-only  j/k move  / search  c copy  r reload  q quit"
+
+// tuiReadOnlyKeys are the keys that change masked emails, ignored in
+// read-only mode.
+var tuiReadOnlyKeys = map[string]bool{"e": true, "d": true, "x": true, "u": true, "U": true}
+
+// tui is a full-screen list of the masked emails of an account. The terminal
+// is switched to raw mode with stty, like readSecret does for hidden input,
+// and drawn with plain ANSI escapes, so there's no dependency on a terminal
+// library; it needs stty, which Windows doesn't have.
+type tui struct {
+	client   *pkg.Client
+	session  *pkg.SessionResource
+	all      []*pkg.MaskedEmail
+	shown    []*pkg.MaskedEmail
+	selected int
+	offset   int
+	mode     tuiMode
+	search   string
+	input    string
+	status   string
+}
+
+func runTUI(client *pkg.Client, args []string) {
+	parseCommandFlags(tuiCmd, args)
+
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		log.Fatalf("%s needs a terminal", actionTypeTUI)
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	t := &tui{client: client, session: session}
+	if err := t.reload(); err != nil {
+		log.Fatalf("error getting masked emails: %v", err)
+	}
+
+	saved, err := sttyState()
+	if err != nil {
+		log.Fatalf("%s needs stty to control the terminal: %v", actionTypeTUI, err)
+	}
+	if err := stty("raw", "-echo"); err != nil {
+		log.Fatalf("%s needs stty to control the terminal: %v", actionTypeTUI, err)
+	}
+	// alternate screen, so the shell's scrollback is back afterwards
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	restore := func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		stty(saved)
+	}
+	remove := onInterrupt(restore)
+	defer func() {
+		remove()
+		restore()
+	}()
+
+	buf := make([]byte, 64)
+	for {
+		t.draw()
+
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		if !t.handleKey(string(buf[:n])) {
+			return
+		}
+	}
+}
+
+// sttyState returns the terminal settings in the form stty takes to restore
+// them.
+func sttyState() (string, error) {
+	cmd := exec.Command("stty", "-g")
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// terminalSize returns the rows and columns of the terminal, 24x80 if stty
+// can't tell.
+func terminalSize() (int, int) {
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	if err == nil {
+		fields := strings.Fields(string(out))
+		if len(fields) == 2 {
+			rows, rowsErr := strconv.Atoi(fields[0])
+			cols, colsErr := strconv.Atoi(fields[1])
+			if rowsErr == nil && colsErr == nil && rows > 0 && cols > 0 {
+				return rows, cols
+			}
+		}
+	}
+	return 24, 80
+}
+
+// reload fetches the masked emails again and keeps the selection on the same
+// one if it's still shown.
+func (t *tui) reload() error {
+	emails, err := t.client.GetAllMaskedEmails(t.session, *flagAccountID)
+	if err != nil {
+		return err
+	}
+
+	t.all = t.all[:0]
+	for _, email := range emails {
+		if *flagTUIShowDeleted || email.State != pkg.MaskedEmailStateDeleted {
+			t.all = append(t.all, email)
+		}
+	}
+	sort.SliceStable(t.all, func(i, j int) bool { return t.all[i].CreatedAt.After(t.all[j].CreatedAt) })
+	t.filter()
+	return nil
+}
+
+// filter applies the search to the list, the words of which all have to be
+// in the address, domain, description or url like with `search`.
+func (t *tui) filter() {
+	var current string
+	if email := t.current(); email != nil {
+		current = email.ID
+	}
+
+	query := searchQuery{states: map[pkg.MaskedEmailState]bool{}, terms: strings.Fields(t.search)}
+	for _, state := range searchStates {
+		query.states[state] = true
+	}
+
+	t.shown = t.shown[:0]
+	t.selected = 0
+	for _, email := range t.all {
+		if query.matches(email) {
+			if email.ID == current {
+				t.selected = len(t.shown)
+			}
+			t.shown = append(t.shown, email)
+		}
+	}
+}
+
+func (t *tui) current() *pkg.MaskedEmail {
+	if t.selected < 0 || t.selected >= len(t.shown) {
+		return nil
+	}
+	return t.shown[t.selected]
+}
+
+// handleKey acts on a key press and returns false to quit.
+func (t *tui) handleKey(key string) bool {
+	if key == "\x03" {
+		// raw mode delivers Ctrl-C as a key instead of SIGINT
+		return false
+	}
+
+	switch t.mode {
+	case tuiSearch:
+		switch key {
+		case "\r", "\x1b":
+			t.mode = tuiBrowse
+		case "\x7f", "\b":
+			t.search = dropLastRune(t.search)
+			t.filter()
+		default:
+			if isPrintable(key) {
+				t.search += key
+				t.filter()
+			}
+		}
+		return true
+
+	case tuiEditDescription, tuiEditDomain:
+		switch key {
+		case "\r":
+			t.update(t.mode, t.input)
+			t.mode = tuiBrowse
+		case "\x1b":
+			t.mode = tuiBrowse
+		case "\x7f", "\b":
+			t.input = dropLastRune(t.input)
+		default:
+			if isPrintable(key) {
+				t.input += key
+			}
+		}
+		return true
+
+	case tuiConfirmDelete:
+		t.mode = tuiBrowse
+		if key == "y" || key == "Y" {
+			t.setState(actionTypeDelete)
+		} else {
+			t.status = "not deleted"
+		}
+		return true
+	}
+
+	t.status = ""
+	if *flagReadOnly && tuiReadOnlyKeys[key] {
+		t.status = "read-only mode is enabled, masked emails can't be changed"
+		return true
+	}
+	switch key {
+	case "q":
+		return false
+	case "j", "\x1b[B", "\x0e":
+		t.move(1)
+	case "k", "\x1b[A", "\x10":
+		t.move(-1)
+	case "\x1b[6~", " ":
+		t.move(t.pageSize())
+	case "\x1b[5~":
+		t.move(-t.pageSize())
+	case "g", "\x1b[H":
+		t.selected = 0
+	case "G", "\x1b[F":
+		t.selected = len(t.shown) - 1
+	case "/":
+		t.mode = tuiSearch
+	case "\x1b":
+		t.search = ""
+		t.filter()
+	case "e":
+		t.setState(actionTypeEnable)
+	case "d":
+		t.setState(actionTypeDisable)
+	case "x":
+		if email := t.current(); email != nil {
+			t.mode = tuiConfirmDelete
+		}
+	case "u":
+		if email := t.current(); email != nil {
+			t.mode, t.input = tuiEditDescription, email.Description
+		}
+	case "U":
+		if email := t.current(); email != nil {
+			t.mode, t.input = tuiEditDomain, email.Domain
+		}
+	case "c":
+		if email := t.current(); email != nil {
+			if err := writeClipboard(email.Email); err != nil {
+				t.status = "could not copy: " + err.Error()
+			} else {
+				t.status = "copied " + email.Email
+			}
+		}
+	case "r":
+		if err := t.reload(); err != nil {
+			t.status = "error getting masked emails: " + err.Error()
+		} else {
+			t.status = "reloaded"
+		}
+	}
+	return true
+}
+
+func (t *tui) move(by int) {
+	t.selected += by
+	if t.selected >= len(t.shown) {
+		t.selected = len(t.shown) - 1
+	}
+	if t.selected < 0 {
+		t.selected = 0
+	}
+}
+
+// pageSize is the number of list rows on the screen.
+func (t *tui) pageSize() int {
+	rows, _ := terminalSize()
+	if rows < 5 {
+		return 1
+	}
+	return rows - 4
+}
+
+// setState enables, disables or deletes the selected masked email and
+// journals it like the commands of the same name.
+func (t *tui) setState(action string) {
+	email := t.current()
+	if email == nil {
+		return
+	}
+	cmd := stateCommands[action]
+	if email.State == cmd.state {
+		t.status = fmt.Sprintf("%s is already %s", email.Email, email.State)
+		return
+	}
+
+	accID := accountIDOrDefault(t.session)
+	_, notUpdated, err := t.client.SetMaskedEmailStates(t.session, accID, []string{email.ID}, cmd.state)
+	if setErr, ok := notUpdated[email.ID]; ok {
+		err = &setErr
+	}
+	if err != nil {
+		t.status = fmt.Sprintf("error %s masked email: %v", cmd.gerund, err)
+		return
+	}
+
+	appendJournal(journalEntry{Action: action, AccountID: accID, ID: email.ID, Email: email.Email})
+	email.State = cmd.state
+	t.status = newItemResult(email.Email, action, nil).withContext(email).String()
+	if email.State == pkg.MaskedEmailStateDeleted && !*flagTUIShowDeleted {
+		t.all = removeMaskedEmail(t.all, email)
+		t.filter()
+	}
+}
+
+// update sets the description or domain of the selected masked email.
+func (t *tui) update(mode tuiMode, value string) {
+	email := t.current()
+	if email == nil {
+		return
+	}
+	value = strings.TrimSpace(value)
+
+	fields := pkg.NewUpdateFields(mode == tuiEditDomain, value, mode == tuiEditDescription, value)
+	accID := accountIDOrDefault(t.session)
+	if _, err := t.client.UpdateMaskedEmail(t.session, accID, email.ID, fields); err != nil {
+		t.status = fmt.Sprintf("error updating masked email: %v", err)
+		return
+	}
+
+	entry := journalEntry{Action: actionTypeUpdate, AccountID: accID, ID: email.ID, Email: email.Email}
+	if mode == tuiEditDomain {
+		email.Domain, entry.Domain = value, value
+	} else {
+		email.Description, entry.Description = value, value
+	}
+	appendJournal(entry)
+	t.status = "updated " + email.Email
+}
+
+func removeMaskedEmail(emails []*pkg.MaskedEmail, email *pkg.MaskedEmail) []*pkg.MaskedEmail {
+	for i, e := range emails {
+		if e == email {
+			return append(emails[:i], emails[i+1:]...)
+		}
+	}
+	return emails
+}
+
+// draw renders the whole screen: a header with the search, the list and a
+// status line with the help or the prompt of the current mode.
+func (t *tui) draw() {
+	rows, cols := terminalSize()
+	page := t.pageSize()
+	if t.selected < t.offset {
+		t.offset = t.selected
+	}
+	if t.selected >= t.offset+page {
+		t.offset = t.selected - page + 1
+	}
+	if t.offset < 0 {
+		t.offset = 0
+	}
+
+	var b bytes.Buffer
+	b.WriteString("\x1b[H\x1b[2J")
+
+	header := fmt.Sprintf("%s: %d of %d masked emails", defaultAppname, len(t.shown), len(t.all))
+	if t.search != "" || t.mode == tuiSearch {
+		header += "  /" + t.search
+	}
+	writeTUILine(&b, "\x1b[1m"+truncate(header, cols)+"\x1b[0m")
+	b.WriteString("\r\n")
+
+	width := 0
+	for _, email := range t.shown {
+		if n := utf8.RuneCountInString(email.Email); n > width {
+			width = n
+		}
+	}
+	for i := t.offset; i < len(t.shown) && i < t.offset+page; i++ {
+		email := t.shown[i]
+		line := fmt.Sprintf("%-*s  %-8s  %s  %s", width, email.Email, email.State, email.Domain, email.Description)
+		line = truncate(line, cols)
+		if i == t.selected {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		}
+		writeTUILine(&b, line)
+	}
+
+	fmt.Fprintf(&b, "\x1b[%d;1H", rows-1)
+	writeTUILine(&b, truncate(t.status, cols))
+	switch t.mode {
+	case tuiSearch:
+		b.WriteString(truncate("search: "+t.search, cols))
+	case tuiEditDescription:
+		b.WriteString(truncate("description: "+t.input, cols))
+	case tuiEditDomain:
+		b.WriteString(truncate("domain: "+t.input, cols))
+	case tuiConfirmDelete:
+		b.WriteString(truncate(fmt.Sprintf("really delete %s? [y/N]", t.current().Email), cols))
+	case tuiBrowse:
+		if *flagReadOnly {
+			b.WriteString(truncate(tuiReadOnlyHelp, cols))
+		} else {
+			b.WriteString(truncate(tuiHelp, cols))
+		}
+	}
+
+	os.Stdout.Write(b.Bytes())
+}
+
+func writeTUILine(b *bytes.Buffer, line string) {
+	b.WriteString(line)
+	b.WriteString("\x1b[K\r\n")
+}
+
+// truncate shortens s to at most n runes.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+func dropLastRune(s string) string {
+	_, size := utf8.DecodeLastRuneInString(s)
+	return s[:len(s)-size]
+}
+
+// isPrintable reports whether a key is text to insert rather than a control
+// key or escape sequence.
+func isPrintable(key string) bool {
+	if key == "" || !utf8.ValidString(key) {
+		return false
+	}
+	for _, r := range key {
+		if r < ' ' || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// viewArgs returns the list arguments of the named view in the config.
+func viewArgs(name string) ([]string, error) {
+	view, ok := userConfig.Views[name]
+	if !ok {
+		names := make([]string, 0, len(userConfig.Views))
+		for n := range userConfig.Views {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return nil, fmt.Errorf("view %q not found, the config has no views", name)
+		}
+		return nil, fmt.Errorf("view %q not found (%s)", name, strings.Join(names, ", "))
+	}
+
+	args, err := splitArgs(view)
+	if err != nil {
+		return nil, fmt.Errorf("view %q: %v", name, err)
+	}
+
+	for _, arg := range args {
+		flagName := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		if strings.HasPrefix(arg, "-") && flagName == flagNameView {
+			return nil, fmt.Errorf("view %q: views can't use -%s", name, flagNameView)
+		}
+	}
+
+	return args, nil
+}
+
+// splitArgs splits a command line into arguments like a POSIX shell does,
+// without expansions: words are separated by whitespace, 'single quotes'
+// keep everything literally, "double quotes" and a backslash escape.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated ' in %q", s)
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) >= 0 {
+					i++
+				}
+				word.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated \" in %q", s)
+			}
+			inWord = true
+
+		case c == '\\':
+			if i+1 < len(s) {
+				i++
+				word.WriteByte(s[i])
+			}
+			inWord = true
+
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
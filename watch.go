@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+const (
+	changeCreated   string = "created"
+	changeUpdated   string = "updated"
+	changeDestroyed string = "destroyed"
+)
+
+// minWatchInterval keeps -interval from hammering the server.
+const minWatchInterval = time.Second
+
+// watchSessionTTL is how long watch uses a session before fetching it
+// again, so a moved API endpoint is picked up. It's fetched earlier when the
+// server reports a new session state.
+const watchSessionTTL = time.Hour
+
+// flags for watch command
+var watchCmd = flag.NewFlagSet(actionTypeWatch, flag.ExitOnError)
+var flagWatchInterval = watchCmd.Duration(flagNameInterval, 30*time.Second, "time between two polls for changes")
+var flagWatchMaxInterval = watchCmd.Duration(flagNameMaxInterval, 10*time.Minute, "longest time between two polls while backing off after errors")
+
+// watchEvent is a change printed by watch. With -json, each event is a line
+// of JSON; the field names are part of the output contract.
+type watchEvent struct {
+	Time        time.Time        `json:"time"`
+	Change      string           `json:"change"`
+	Fields      []string         `json:"fields,omitempty"`
+	MaskedEmail *pkg.MaskedEmail `json:"maskedEmail"`
+	previous    *pkg.MaskedEmail
+}
+
+// watcher polls an account for changes to its masked emails. It asks for
+// them with MaskedEmail/changes, and compares full lists on servers that
+// don't implement it.
+type watcher struct {
+	client   *pkg.Client
+	session  *pkg.SessionResource
+	state    string
+	known    map[string]*pkg.MaskedEmail
+	diffOnly bool
+}
+
+func runWatch(client *pkg.Client, args []string) {
+	parseCommandFlags(watchCmd, args)
+
+	if *flagWatchInterval < minWatchInterval {
+		log.Fatalf("-%s must be at least %s", flagNameInterval, minWatchInterval)
+	}
+	if *flagWatchMaxInterval < *flagWatchInterval {
+		*flagWatchMaxInterval = *flagWatchInterval
+	}
+
+	session, err := client.Session()
+	if err != nil {
+		log.Fatalf("initializing session: %v", err)
+	}
+
+	w := &watcher{client: client, session: session}
+	emails, state, err := client.GetAllMaskedEmailsAndState(session, *flagAccountID)
+	if err != nil {
+		log.Fatalf("error getting masked emails: %v", err)
+	}
+	w.reset(emails, state)
+
+	wait := *flagWatchInterval
+	for {
+		time.Sleep(jitter(wait))
+
+		events, err := w.poll()
+		if err != nil {
+			// back off, so a struggling server isn't polled as often
+			wait *= 2
+			if wait > *flagWatchMaxInterval {
+				wait = *flagWatchMaxInterval
+			}
+			fmt.Fprintf(os.Stderr, "warning: polling for changes: %v, next poll in %s\n", err, wait)
+			continue
+		}
+		wait = *flagWatchInterval
+
+		for _, event := range events {
+			printWatchEvent(event)
+		}
+	}
+}
+
+// jitter spreads d by up to a tenth either way, so many watchers started at
+// once don't poll in lockstep.
+func jitter(d time.Duration) time.Duration {
+	spread := int64(d / 10)
+	if spread == 0 {
+		return d
+	}
+	return d - time.Duration(spread) + time.Duration(rand.Int63n(2*spread))
+}
+
+// reset makes emails the known state of the account.
+func (w *watcher) reset(emails []*pkg.MaskedEmail, state string) {
+	w.state = state
+	w.known = make(map[string]*pkg.MaskedEmail, len(emails))
+	for _, email := range emails {
+		w.known[email.ID] = email
+	}
+}
+
+// poll returns the changes since the last poll.
+func (w *watcher) poll() ([]watchEvent, error) {
+	if w.session.IsStale(watchSessionTTL) {
+		session, err := w.client.Session()
+		if err != nil {
+			return nil, err
+		}
+		w.session = session
+	}
+
+	if w.diffOnly {
+		return w.diff()
+	}
+
+	var created, updated, destroyed []string
+	since := w.state
+	for {
+		res, err := w.client.MaskedEmailChanges(w.session, *flagAccountID, since)
+		var methodErr *pkg.MethodError
+		if errors.As(err, &methodErr) {
+			switch methodErr.Type {
+			case "unknownMethod":
+				fmt.Fprintf(os.Stderr, "warning: the server doesn't support MaskedEmail/changes, comparing all masked emails instead\n")
+				w.diffOnly = true
+				return w.diff()
+			case "cannotCalculateChanges":
+				return w.diff()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		created = append(created, res.Created...)
+		updated = append(updated, res.Updated...)
+		destroyed = append(destroyed, res.Destroyed...)
+		since = res.NewState
+		if !res.HasMoreChanges {
+			break
+		}
+	}
+
+	now := time.Now().UTC()
+	var events []watchEvent
+
+	if ids := append(created, updated...); len(ids) > 0 {
+		emails, err := w.client.GetMaskedEmails(w.session, *flagAccountID, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, email := range emails {
+			if event, ok := w.compare(now, email); ok {
+				events = append(events, event)
+			}
+		}
+	}
+	for _, id := range destroyed {
+		if email, ok := w.known[id]; ok {
+			events = append(events, watchEvent{Time: now, Change: changeDestroyed, MaskedEmail: email})
+			delete(w.known, id)
+		}
+	}
+
+	w.state = since
+	return events, nil
+}
+
+// diff fetches all masked emails and compares them with the known ones.
+func (w *watcher) diff() ([]watchEvent, error) {
+	emails, state, err := w.client.GetAllMaskedEmailsAndState(w.session, *flagAccountID)
+	if err != nil {
+		return nil, err
+	}
+	if state != "" && state == w.state {
+		return nil, nil
+	}
+
+	now := time.Now().UTC()
+	var events []watchEvent
+	seen := make(map[string]bool, len(emails))
+	for _, email := range emails {
+		seen[email.ID] = true
+		if event, ok := w.compare(now, email); ok {
+			events = append(events, event)
+		}
+	}
+	for id, email := range w.known {
+		if !seen[id] {
+			events = append(events, watchEvent{Time: now, Change: changeDestroyed, MaskedEmail: email})
+		}
+	}
+
+	w.reset(emails, state)
+	return events, nil
+}
+
+// compare returns the event for email against the known version of it, and
+// false if nothing changed.
+func (w *watcher) compare(now time.Time, email *pkg.MaskedEmail) (watchEvent, bool) {
+	previous, ok := w.known[email.ID]
+	w.known[email.ID] = email
+	if !ok {
+		return watchEvent{Time: now, Change: changeCreated, MaskedEmail: email}, true
+	}
+
+	fields := changedFields(previous, email)
+	if len(fields) == 0 {
+		return watchEvent{}, false
+	}
+	return watchEvent{Time: now, Change: changeUpdated, Fields: fields, MaskedEmail: email, previous: previous}, true
+}
+
+// changedFields returns the API names of the properties that differ.
+func changedFields(a, b *pkg.MaskedEmail) []string {
+	var fields []string
+	if a.State != b.State {
+		fields = append(fields, "state")
+	}
+	if a.Domain != b.Domain {
+		fields = append(fields, "forDomain")
+	}
+	if a.Description != b.Description {
+		fields = append(fields, "description")
+	}
+	if derefString(a.URL) != derefString(b.URL) {
+		fields = append(fields, "url")
+	}
+	if formatTimestamp(a.LastMessageAt) != formatTimestamp(b.LastMessageAt) {
+		fields = append(fields, "lastMessageAt")
+	}
+	return fields
+}
+
+// fieldValue returns the value of the property named by changedFields.
+func fieldValue(email *pkg.MaskedEmail, field string) string {
+	switch field {
+	case "state":
+		return string(email.State)
+	case "forDomain":
+		return email.Domain
+	case "description":
+		return email.Description
+	case "url":
+		return derefString(email.URL)
+	case "lastMessageAt":
+		return formatDisplayTime(email.LastMessageAt)
+	}
+	return ""
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// printWatchEvent prints an event as soon as it happens: a line of JSON with
+// -json, otherwise "<time> <change> <email>" followed by what changed.
+func printWatchEvent(event watchEvent) {
+	if jsonOutput() {
+		if err := json.NewEncoder(os.Stdout).Encode(event); err != nil {
+			log.Fatalf("error encoding output: %v", err)
+		}
+		return
+	}
+
+	line := formatDisplayTime(&event.Time) + " " + event.Change + " " + event.MaskedEmail.Email
+	for _, field := range event.Fields {
+		line += fmt.Sprintf(" %s: %q -> %q", field, fieldValue(event.previous, field), fieldValue(event.MaskedEmail, field))
+	}
+	fmt.Println(strings.TrimSpace(line))
+}
@@ -0,0 +1,654 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// The -where expression language filters masked emails by their fields:
+//
+//	state == "enabled" && lastMessageAt == null && createdAt < now()-90d
+//
+// Operands are fields (id, email, state, forDomain, description, createdBy,
+// createdAt, lastMessageAt, url), "strings", durations with a unit (30s, 15m,
+// 12h, 90d, 2w), null, true, false and now(). Operators, from lowest to
+// highest precedence: ||, &&, !, the comparisons == != < <= > >= and the
+// regex matches =~ !~, and + - to shift a time by a duration. A time is
+// compared with a string by parsing it as RFC3339 or a 2006-01-02 date, which
+// is midnight local time. Ordering null with anything is false.
+
+// whereFields are the masked email fields usable in -where, by name.
+var whereFields = map[string]func(email *pkg.MaskedEmail) interface{}{
+	"id":          func(email *pkg.MaskedEmail) interface{} { return email.ID },
+	"email":       func(email *pkg.MaskedEmail) interface{} { return email.Email },
+	"state":       func(email *pkg.MaskedEmail) interface{} { return string(email.State) },
+	"forDomain":   func(email *pkg.MaskedEmail) interface{} { return strings.TrimSpace(email.Domain) },
+	"description": func(email *pkg.MaskedEmail) interface{} { return strings.TrimSpace(email.Description) },
+	"createdBy":   func(email *pkg.MaskedEmail) interface{} { return email.CreatedBy },
+	"createdAt": func(email *pkg.MaskedEmail) interface{} {
+		if email.CreatedAt.IsZero() {
+			return nil
+		}
+		return email.CreatedAt
+	},
+	"lastMessageAt": func(email *pkg.MaskedEmail) interface{} {
+		if email.LastMessageAt == nil || email.LastMessageAt.IsZero() {
+			return nil
+		}
+		return *email.LastMessageAt
+	},
+	"url": func(email *pkg.MaskedEmail) interface{} {
+		if email.URL == nil {
+			return nil
+		}
+		return *email.URL
+	},
+}
+
+// whereFilter is a compiled -where expression.
+type whereFilter struct {
+	expr whereExpr
+	now  time.Time
+}
+
+// compileWhere parses a -where expression. now() evaluates to now for every
+// masked email, so all of them are compared against the same instant.
+func compileWhere(src string, now time.Time) (*whereFilter, error) {
+	tokens, err := lexWhere(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &whereParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != whereTokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos+1)
+	}
+
+	return &whereFilter{expr: expr, now: now}, nil
+}
+
+// match evaluates the expression for a masked email.
+func (f *whereFilter) match(email *pkg.MaskedEmail) (bool, error) {
+	v, err := f.expr.eval(&whereEnv{email: email, now: f.now})
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression is %s, not a condition", whereTypeName(v))
+	}
+	return b, nil
+}
+
+// --- lexer ---
+
+type whereTokenKind int
+
+const (
+	whereTokenEOF whereTokenKind = iota
+	whereTokenIdent
+	whereTokenString
+	whereTokenDuration
+	whereTokenOp
+	whereTokenLParen
+	whereTokenRParen
+)
+
+type whereToken struct {
+	kind whereTokenKind
+	text string
+	pos  int
+	// value is the parsed string or duration literal
+	value interface{}
+}
+
+// whereOps are the operators, two-character ones first so they win.
+var whereOps = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "+", "-"}
+
+var whereDurationUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+func lexWhere(src string) ([]whereToken, error) {
+	var tokens []whereToken
+
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '(' || c == ')':
+			kind := whereTokenLParen
+			if c == ')' {
+				kind = whereTokenRParen
+			}
+			tokens = append(tokens, whereToken{kind: kind, text: string(c), pos: i})
+			i++
+
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(src) && src[end] != byte(c) {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("unterminated string at position %d", i+1)
+			}
+			text := src[i : end+1]
+			value := text[1 : len(text)-1]
+			if c == '"' {
+				unquoted, err := strconv.Unquote(text)
+				if err != nil {
+					return nil, fmt.Errorf("invalid string %s at position %d", text, i+1)
+				}
+				value = unquoted
+			}
+			tokens = append(tokens, whereToken{kind: whereTokenString, text: text, pos: i, value: value})
+			i = end + 1
+
+		case unicode.IsDigit(c):
+			end := i
+			for end < len(src) && unicode.IsDigit(rune(src[end])) {
+				end++
+			}
+			n, _ := strconv.Atoi(src[i:end])
+			unitEnd := end
+			for unitEnd < len(src) && unicode.IsLetter(rune(src[unitEnd])) {
+				unitEnd++
+			}
+			unit, ok := whereDurationUnits[src[end:unitEnd]]
+			if !ok {
+				return nil, fmt.Errorf("number %s at position %d needs a unit (s, m, h, d, w)", src[i:unitEnd], i+1)
+			}
+			tokens = append(tokens, whereToken{kind: whereTokenDuration, text: src[i:unitEnd], pos: i, value: time.Duration(n) * unit})
+			i = unitEnd
+
+		case unicode.IsLetter(c) || c == '_':
+			end := i
+			for end < len(src) && (unicode.IsLetter(rune(src[end])) || unicode.IsDigit(rune(src[end])) || src[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, whereToken{kind: whereTokenIdent, text: src[i:end], pos: i})
+			i = end
+
+		default:
+			op := ""
+			for _, candidate := range whereOps {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at position %d", c, i+1)
+			}
+			tokens = append(tokens, whereToken{kind: whereTokenOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, whereToken{kind: whereTokenEOF, text: "end of expression", pos: len(src)}), nil
+}
+
+// --- parser ---
+
+type whereParser struct {
+	tokens []whereToken
+	pos    int
+}
+
+func (p *whereParser) peek() whereToken {
+	return p.tokens[p.pos]
+}
+
+func (p *whereParser) next() whereToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != whereTokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// acceptOp consumes the next token if it's one of the operators.
+func (p *whereParser) acceptOp(ops ...string) (string, bool) {
+	tok := p.peek()
+	if tok.kind != whereTokenOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			p.next()
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *whereParser) parseOr() (whereExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOp("||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = whereLogical{op: "||", left: left, right: right}
+	}
+}
+
+func (p *whereParser) parseAnd() (whereExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOp("&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = whereLogical{op: "&&", left: left, right: right}
+	}
+}
+
+func (p *whereParser) parseNot() (whereExpr, error) {
+	if _, ok := p.acceptOp("!"); ok {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return whereNot{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *whereParser) parseComparison() (whereExpr, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+
+	op, ok := p.acceptOp("==", "!=", "<", "<=", ">", ">=", "=~", "!~")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+
+	if op == "=~" || op == "!~" {
+		lit, ok := right.(whereLiteral)
+		pattern, isString := lit.value.(string)
+		if !ok || !isString {
+			return nil, fmt.Errorf("%s needs a string pattern on the right", op)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		return whereMatch{negate: op == "!~", operand: left, re: re}, nil
+	}
+
+	return whereComparison{op: op, left: left, right: right}, nil
+}
+
+func (p *whereParser) parseSum() (whereExpr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.acceptOp("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		left = whereArithmetic{op: op, left: left, right: right}
+	}
+}
+
+func (p *whereParser) parsePrimary() (whereExpr, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case whereTokenString, whereTokenDuration:
+		return whereLiteral{value: tok.value}, nil
+
+	case whereTokenLParen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != whereTokenRParen {
+			return nil, fmt.Errorf("expected ) at position %d, got %q", closing.pos+1, closing.text)
+		}
+		return expr, nil
+
+	case whereTokenIdent:
+		switch tok.text {
+		case "null":
+			return whereLiteral{value: nil}, nil
+		case "true":
+			return whereLiteral{value: true}, nil
+		case "false":
+			return whereLiteral{value: false}, nil
+		case "now":
+			if open := p.next(); open.kind != whereTokenLParen {
+				return nil, fmt.Errorf("expected now() at position %d", tok.pos+1)
+			}
+			if closing := p.next(); closing.kind != whereTokenRParen {
+				return nil, fmt.Errorf("now() takes no arguments, position %d", closing.pos+1)
+			}
+			return whereNow{}, nil
+		}
+
+		field, ok := whereFields[tok.text]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q at position %d", tok.text, tok.pos+1)
+		}
+		return whereField{name: tok.text, get: field}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos+1)
+}
+
+// --- evaluation ---
+
+type whereEnv struct {
+	email *pkg.MaskedEmail
+	now   time.Time
+}
+
+// whereExpr is a node of a parsed expression. Values are string, bool,
+// time.Time, time.Duration or nil for null.
+type whereExpr interface {
+	eval(env *whereEnv) (interface{}, error)
+}
+
+type whereLiteral struct {
+	value interface{}
+}
+
+func (e whereLiteral) eval(env *whereEnv) (interface{}, error) {
+	return e.value, nil
+}
+
+type whereNow struct{}
+
+func (e whereNow) eval(env *whereEnv) (interface{}, error) {
+	return env.now, nil
+}
+
+type whereField struct {
+	name string
+	get  func(email *pkg.MaskedEmail) interface{}
+}
+
+func (e whereField) eval(env *whereEnv) (interface{}, error) {
+	return e.get(env.email), nil
+}
+
+type whereLogical struct {
+	op          string
+	left, right whereExpr
+}
+
+func (e whereLogical) eval(env *whereEnv) (interface{}, error) {
+	left, err := evalBool(e.left, env, e.op)
+	if err != nil {
+		return nil, err
+	}
+	// short-circuit like in Go
+	if e.op == "&&" && !left || e.op == "||" && left {
+		return left, nil
+	}
+	return evalBool(e.right, env, e.op)
+}
+
+type whereNot struct {
+	operand whereExpr
+}
+
+func (e whereNot) eval(env *whereEnv) (interface{}, error) {
+	v, err := evalBool(e.operand, env, "!")
+	if err != nil {
+		return nil, err
+	}
+	return !v, nil
+}
+
+func evalBool(expr whereExpr, env *whereEnv, op string) (bool, error) {
+	v, err := expr.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s needs conditions, got %s", op, whereTypeName(v))
+	}
+	return b, nil
+}
+
+type whereMatch struct {
+	negate  bool
+	operand whereExpr
+	re      *regexp.Regexp
+}
+
+func (e whereMatch) eval(env *whereEnv) (interface{}, error) {
+	v, err := e.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return e.negate, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("can't match %s against a pattern", whereTypeName(v))
+	}
+	return e.re.MatchString(s) != e.negate, nil
+}
+
+type whereArithmetic struct {
+	op          string
+	left, right whereExpr
+}
+
+func (e whereArithmetic) eval(env *whereEnv) (interface{}, error) {
+	left, err := e.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	if left == nil || right == nil {
+		return nil, nil
+	}
+
+	switch l := left.(type) {
+	case time.Time:
+		switch r := right.(type) {
+		case time.Duration:
+			if e.op == "-" {
+				return l.Add(-r), nil
+			}
+			return l.Add(r), nil
+		case time.Time:
+			if e.op == "-" {
+				return l.Sub(r), nil
+			}
+		}
+	case time.Duration:
+		if r, ok := right.(time.Duration); ok {
+			if e.op == "-" {
+				return l - r, nil
+			}
+			return l + r, nil
+		}
+	}
+
+	return nil, fmt.Errorf("can't compute %s %s %s", whereTypeName(left), e.op, whereTypeName(right))
+}
+
+type whereComparison struct {
+	op          string
+	left, right whereExpr
+}
+
+func (e whereComparison) eval(env *whereEnv) (interface{}, error) {
+	left, err := e.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	if left == nil || right == nil {
+		switch e.op {
+		case "==":
+			return left == nil && right == nil, nil
+		case "!=":
+			return (left == nil) != (right == nil), nil
+		}
+		return false, nil
+	}
+
+	left, right, err = coerceTimes(left, right)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmp int
+	switch l := left.(type) {
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			break
+		}
+		cmp = strings.Compare(l, r)
+		return compareResult(e.op, cmp), nil
+	case time.Time:
+		r, ok := right.(time.Time)
+		if !ok {
+			break
+		}
+		if l.Before(r) {
+			cmp = -1
+		} else if l.After(r) {
+			cmp = 1
+		}
+		return compareResult(e.op, cmp), nil
+	case time.Duration:
+		r, ok := right.(time.Duration)
+		if !ok {
+			break
+		}
+		if l < r {
+			cmp = -1
+		} else if l > r {
+			cmp = 1
+		}
+		return compareResult(e.op, cmp), nil
+	case bool:
+		r, ok := right.(bool)
+		if !ok || (e.op != "==" && e.op != "!=") {
+			break
+		}
+		return (l == r) == (e.op == "=="), nil
+	}
+
+	return nil, fmt.Errorf("can't compare %s %s %s", whereTypeName(left), e.op, whereTypeName(right))
+}
+
+func compareResult(op string, cmp int) bool {
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+// coerceTimes parses a string compared with a time as a time, so
+// `createdAt < "2024-01-01"` works.
+func coerceTimes(left interface{}, right interface{}) (interface{}, interface{}, error) {
+	_, leftTime := left.(time.Time)
+	_, rightTime := right.(time.Time)
+
+	var err error
+	if s, ok := left.(string); ok && rightTime {
+		left, err = parseWhereTime(s)
+	}
+	if s, ok := right.(string); ok && leftTime {
+		right, err = parseWhereTime(s)
+	}
+	return left, right, err
+}
+
+func parseWhereTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	// midnight in the local time zone, like the dates of parseSince
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a time (RFC3339 or 2006-01-02)", s)
+}
+
+func whereTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case bool:
+		return "a condition"
+	case time.Time:
+		return "a time"
+	case time.Duration:
+		return "a duration"
+	}
+	return fmt.Sprintf("%T", v)
+}